@@ -0,0 +1,152 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// capAccountRegistration is the capability which allows registering
+// services accounts directly with the REGISTER and VERIFY commands.
+const capAccountRegistration = "draft/account-registration"
+
+// registerTimeout is how long Client.Register() and Client.Verify() will wait
+// for a response from the server.
+const registerTimeout = 30 * time.Second
+
+// ErrNoResponse is returned when the server did not respond to a request
+// within the expected amount of time.
+var ErrNoResponse = errors.New("no response received from server")
+
+// ErrStandardReply is returned when the server responds with an IRCv3 FAIL
+// standard reply. See https://ircv3.net/specs/extensions/standard-replies.
+type ErrStandardReply struct {
+	// Command is the command which failed, e.g. "REGISTER". May be "*" if
+	// the failure isn't related to a specific command.
+	Command string
+	// Code is the machine-readable reason for the failure, e.g.
+	// "ACCOUNT_EXISTS" or "WEAK_PASSWORD".
+	Code string
+	// Context are any additional parameters supplied by the server.
+	Context []string
+	// Description is the human-readable description of the failure.
+	Description string
+}
+
+func (e *ErrStandardReply) Error() string {
+	return strings.ToLower(e.Command) + " failed (" + e.Code + "): " + e.Description
+}
+
+// parseStandardReply converts a FAIL, WARN or NOTE event into an
+// ErrStandardReply. Returns nil if the event isn't a standard reply.
+func parseStandardReply(e *Event) *ErrStandardReply {
+	if (e.Command != FAIL && e.Command != WARN && e.Command != NOTE) || len(e.Params) < 2 {
+		return nil
+	}
+
+	return &ErrStandardReply{
+		Command:     e.Params[0],
+		Code:        e.Params[1],
+		Context:     e.Params[2:],
+		Description: e.Trailing,
+	}
+}
+
+// Register attempts to register a services account with the server, using
+// the draft/account-registration extension. If account is empty, the current
+// nickname is used as the account name. If email is empty, no email address
+// is supplied (some servers may require one).
+//
+// verify will be true if the server requires the account to be verified
+// (e.g. with a code sent via email) before it can be used. See
+// Client.Verify().
+//
+// If the server rejects the registration, an ErrStandardReply is returned.
+// If the capability isn't enabled (which is always the case if tracking is
+// disabled), ErrCapNotEnabled is returned.
+func (c *Client) Register(account, email, password string) (verify bool, err error) {
+	if account == "" {
+		account = "*"
+	}
+
+	if email == "" {
+		email = "*"
+	}
+
+	e, err := c.accountRequest(&Event{
+		Command:   REGISTER,
+		Params:    []string{account, email},
+		Trailing:  password,
+		Sensitive: true,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return len(e.Params) > 0 && e.Params[0] == "VERIFICATION_REQUIRED", nil
+}
+
+// Verify completes the registration of a services account (see
+// Client.Register()), using the verification code supplied by the server
+// (e.g. via email). If the server rejects the code, an ErrStandardReply is
+// returned. See Client.Register() for the other errors.
+func (c *Client) Verify(account, code string) error {
+	_, err := c.accountRequest(&Event{
+		Command:   VERIFY,
+		Params:    []string{account},
+		Trailing:  code,
+		Sensitive: true,
+	})
+
+	return err
+}
+
+// accountRequest sends an account registration related command, and waits
+// for the matching response (or failure) from the server.
+func (c *Client) accountRequest(req *Event) (*Event, error) {
+	if !c.HasCapability(capAccountRegistration) {
+		return nil, &ErrCapNotEnabled{Cap: capAccountRegistration}
+	}
+
+	if !c.IsConnected() {
+		return nil, ErrNotConnected
+	}
+
+	result := make(chan *Event, 1)
+	_, done := c.Handlers.AddTmp(ALL_EVENTS, registerTimeout, func(c *Client, e Event) bool {
+		if (e.Command != req.Command && e.Command != FAIL) || len(e.Params) < 1 {
+			return false
+		}
+
+		if e.Command == FAIL && e.Params[0] != req.Command {
+			return false
+		}
+
+		select {
+		case result <- &e:
+		default:
+		}
+
+		return true
+	})
+
+	c.Send(req)
+	<-done
+
+	var e *Event
+	select {
+	case e = <-result:
+	default:
+		return nil, ErrNoResponse
+	}
+
+	if fail := parseStandardReply(e); fail != nil {
+		return nil, fail
+	}
+
+	return e, nil
+}
@@ -0,0 +1,59 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import "strings"
+
+// aliasNick is replaced with the current nickname of the client when
+// expanding an alias, see Config.Aliases.
+const aliasNick = "$nick"
+
+// expandAlias expands the command of raw with the matching alias from
+// Config.Aliases, if any. Only the command is expanded, the remainder of raw
+// is appended to the expansion, and expansions aren't expanded again.
+func (c *Client) expandAlias(raw string) string {
+	if len(c.Config.Aliases) == 0 || (len(raw) > 0 && raw[0] == messagePrefix) {
+		return raw
+	}
+
+	name, rest := raw, ""
+	if i := strings.IndexByte(raw, eventSpace); i > -1 {
+		name, rest = raw[:i], strings.TrimLeft(raw[i+1:], " ")
+	}
+
+	var expansion string
+	var ok bool
+	for alias := range c.Config.Aliases {
+		if strings.EqualFold(alias, name) {
+			expansion, ok = c.Config.Aliases[alias], true
+			break
+		}
+	}
+
+	if !ok {
+		return raw
+	}
+
+	if strings.Contains(expansion, aliasNick) {
+		nick := c.Identity().Nick
+		if !c.Config.disableTracking {
+			nick = c.GetNick()
+		}
+
+		expansion = strings.Replace(expansion, aliasNick, nick, -1)
+	}
+
+	if rest == "" {
+		return strings.TrimRight(expansion, " ")
+	}
+
+	// Expansions which end with the start of the trailing param (e.g.
+	// "PRIVMSG ChanServ :") are directly followed by the remainder.
+	if strings.HasSuffix(expansion, " ") || strings.HasSuffix(expansion, ":") {
+		return expansion + rest
+	}
+
+	return expansion + " " + rest
+}
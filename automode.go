@@ -0,0 +1,251 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"sync"
+	"time"
+)
+
+// AutoMode automatically gives users channel modes (e.g. voice or op) when
+// they join a channel where the client has the permissions to do so, if
+// they match one of the configured rules. Mode changes for users joining in
+// quick succession (e.g. after a netsplit) are batched together. See
+// Config.AutoMode. Tracking must be enabled for this to work.
+//
+// AutoMode is enabled for all channels by default, see AutoMode.Disable()
+// and AutoMode.Enable() to change this at runtime.
+type AutoMode struct {
+	// Rules are the rules which users are matched against. A user may match
+	// multiple rules, in which case all of the matching modes are applied.
+	Rules []AutoModeRule
+	// Delay is how long to wait after a user joins before applying modes,
+	// during which other joins are batched together. This also gives the
+	// WHO query sent on join time to populate the users account and host.
+	// Defaults to 2 seconds.
+	Delay time.Duration
+
+	mu       sync.Mutex
+	disabled map[string]bool
+	pending  map[string][]string
+}
+
+// AutoModeRule is a rule used by AutoMode, which gives users a mode when
+// they match any of the masks or accounts.
+type AutoModeRule struct {
+	// Mode is the mode to give (e.g. 'v' for voice, or 'o' for op).
+	Mode byte
+	// Masks are "nick!user@host" masks, which may contain globs (see
+	// Glob()).
+	Masks []string
+	// Accounts are the account names the user may be authenticated as.
+	Accounts []string
+	// Channels, if supplied, limits the rule to the specified channels.
+	Channels []string
+}
+
+// defaultAutoModeDelay is the default for AutoMode.Delay.
+const defaultAutoModeDelay = 2 * time.Second
+
+// Enable enables applying modes in channel, for the client using this
+// AutoMode (which is needed to compare channel names according to the
+// server's CASEMAPPING). This is the default.
+func (a *AutoMode) Enable(c *Client, channel string) {
+	key := c.fold(channel)
+
+	a.mu.Lock()
+	delete(a.disabled, key)
+	a.mu.Unlock()
+}
+
+// Disable disables applying modes in channel, until it is re-enabled with
+// AutoMode.Enable().
+func (a *AutoMode) Disable(c *Client, channel string) {
+	key := c.fold(channel)
+
+	a.mu.Lock()
+	if a.disabled == nil {
+		a.disabled = make(map[string]bool)
+	}
+	a.disabled[key] = true
+	a.mu.Unlock()
+}
+
+// Enabled returns true if modes are applied in channel.
+func (a *AutoMode) Enabled(c *Client, channel string) bool {
+	key := c.fold(channel)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return !a.disabled[key]
+}
+
+// queue queues nick to be checked once the delay has passed, batching all
+// of the users who joined channel in the meantime.
+func (a *AutoMode) queue(c *Client, channel, nick string) {
+	key := c.fold(channel)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.pending == nil {
+		a.pending = make(map[string][]string)
+	}
+
+	a.pending[key] = append(a.pending[key], nick)
+	if len(a.pending[key]) > 1 {
+		// Already scheduled.
+		return
+	}
+
+	delay := a.Delay
+	if delay <= 0 {
+		delay = defaultAutoModeDelay
+	}
+
+	time.AfterFunc(delay, func() { a.flush(c, channel) })
+}
+
+// flush applies the modes for all of the users queued for channel.
+func (a *AutoMode) flush(c *Client, channel string) {
+	key := c.fold(channel)
+
+	a.mu.Lock()
+	nicks := a.pending[key]
+	delete(a.pending, key)
+	disabled := a.disabled[key]
+	a.mu.Unlock()
+
+	if disabled || !c.IsConnected() {
+		return
+	}
+
+	modes := c.Cmd.Modes(channel)
+
+	c.state.RLock()
+	var ours Perms
+	if self := c.state.lookupUser(c.state.nick); self != nil {
+		ours, _ = self.Perms.Lookup(channel)
+	}
+
+	for _, nick := range nicks {
+		user := c.state.lookupUser(nick)
+		if user == nil || !user.InChannel(channel) {
+			// Already left.
+			continue
+		}
+
+		perms, _ := user.Perms.Lookup(channel)
+		mask := (&Source{Name: user.Nick, Ident: user.Ident, Host: user.Host}).String()
+
+		given := make(map[byte]bool)
+		for _, rule := range a.Rules {
+			if given[rule.Mode] || hasPermMode(perms, rule.Mode) || !canSetPermMode(ours, rule.Mode) {
+				continue
+			}
+
+			if rule.matches(c, channel, mask, user.Extras.Account) {
+				modes.Add(rule.Mode, user.Nick)
+				given[rule.Mode] = true
+			}
+		}
+	}
+	c.state.RUnlock()
+
+	if modes.Len() > 0 {
+		modes.Send()
+	}
+}
+
+// matches returns true if the rule applies to channel, and the user (with
+// mask and account) matches the rule. This should be called with the state
+// lock held.
+func (r *AutoModeRule) matches(c *Client, channel, mask, account string) bool {
+	if len(r.Channels) > 0 {
+		var found bool
+		for _, ch := range r.Channels {
+			if c.state.fold(ch) == c.state.fold(channel) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	for _, m := range r.Masks {
+		if Glob(c.state.fold(mask), c.state.fold(m)) {
+			return true
+		}
+	}
+
+	if account != "" {
+		for _, acct := range r.Accounts {
+			if c.state.fold(acct) == c.state.fold(account) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// hasPermMode returns true if perms already has the permission for the
+// given user mode (e.g. 'o' or 'v').
+func hasPermMode(perms Perms, mode byte) bool {
+	switch string(mode) {
+	case ModeOwner:
+		return perms.Owner
+	case ModeAdmin:
+		return perms.Admin
+	case ModeOperator:
+		return perms.Op
+	case ModeHalfOperator:
+		return perms.HalfOp
+	case ModeVoice:
+		return perms.Voice
+	}
+
+	return false
+}
+
+// canSetPermMode returns true if a user with perms would generally be able
+// to give others the given user mode. Half-ops can only give voice, ops can
+// give anything up to op, and admins/owners can give anything.
+func canSetPermMode(perms Perms, mode byte) bool {
+	switch string(mode) {
+	case ModeVoice:
+		return perms.IsAdmin() || perms.HalfOp
+	case ModeOwner, ModeAdmin:
+		return perms.Owner || perms.Admin
+	}
+
+	return perms.IsAdmin()
+}
+
+// handleAutoMode queues users joining a channel to be checked against
+// Config.AutoMode.
+func handleAutoMode(c *Client, e Event) {
+	am := c.Config.AutoMode
+	if am == nil || e.Source == nil || c.Equal(e.Source.Name, c.GetNick()) {
+		return
+	}
+
+	var channel string
+	if len(e.Params) > 0 {
+		channel = e.Params[0]
+	} else {
+		channel = e.Trailing
+	}
+
+	if channel == "" || !am.Enabled(c, channel) {
+		return
+	}
+
+	am.queue(c, channel, e.Source.Name)
+}
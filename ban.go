@@ -0,0 +1,190 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"net"
+	"strings"
+	"time"
+)
+
+// defaultBanRefreshTimeout is the default for Config.BanRefreshTimeout.
+const defaultBanRefreshTimeout = 5 * time.Second
+
+// BanMaskStyle is the style of the masks generated by User.BanMask().
+type BanMaskStyle int
+
+const (
+	// BanMaskHost matches the host, e.g. "*!*@host.example.com". This is
+	// the style used by Commands.Ban().
+	BanMaskHost BanMaskStyle = iota
+	// BanMaskIdentHost matches the ident on the host, e.g.
+	// "*!ident@host.example.com".
+	BanMaskIdentHost
+	// BanMaskDomain matches the domain of the host (or the network, for IP
+	// addresses), e.g. "*!*@*.example.com" or "*!*@192.0.2.*".
+	BanMaskDomain
+	// BanMaskIdentDomain matches the ident on the domain of the host, e.g.
+	// "*!ident@*.example.com".
+	BanMaskIdentDomain
+	// BanMaskNick matches the nickname, e.g. "nick!*@*".
+	BanMaskNick
+)
+
+// BanMask returns a mask matching the user, in the given style (see
+// BanMaskStyle). The "~" servers prefix the ident with (for users without
+// identd) is replaced with "*", so the mask still matches if it goes away.
+// If the host of the user isn't known, a BanMaskNick mask is returned. See
+// Glob() to match masks against users.
+func (u *User) BanMask(style BanMaskStyle) string {
+	if u.Host == "" || style == BanMaskNick {
+		return u.Nick + "!*@*"
+	}
+
+	ident := "*"
+	if (style == BanMaskIdentHost || style == BanMaskIdentDomain) && u.Ident != "" {
+		ident = u.Ident
+		if ident[0] == '~' {
+			ident = "*" + ident[1:]
+		}
+	}
+
+	host := u.Host
+	if style == BanMaskDomain || style == BanMaskIdentDomain {
+		host = domainMask(host)
+	}
+
+	return "*!" + ident + "@" + host
+}
+
+// domainMask returns a mask matching the domain of host, or the network if
+// it is an IP address. Cloaks (e.g. "user/nick") and short hosts are
+// returned as is.
+func domainMask(host string) string {
+	if ip := net.ParseIP(host); ip != nil {
+		sep := "."
+		if ip.To4() == nil {
+			sep = ":"
+		}
+
+		return host[:strings.LastIndex(host, sep)+1] + "*"
+	}
+
+	if strings.IndexByte(host, '/') > -1 {
+		return host
+	}
+
+	labels := strings.Split(host, ".")
+	if len(labels) < 3 {
+		return host
+	}
+
+	return "*." + strings.Join(labels[1:], ".")
+}
+
+// Ban bans nick from channel, using a "*!*@host" mask built from the users
+// tracked host. If the host is unknown (or tracking is disabled), a
+// "nick!*@*" mask is used instead. See Config.BanRefreshAge to refresh
+// stale hosts before banning, in which case this may block until the WHO
+// query completes (or times out), and should only be called from background
+// handlers (see Caller.AddBg()), as the response can't be processed while a
+// regular handler is blocked.
+func (cmd *Commands) Ban(channel, nick string) error {
+	if !IsValidChannel(channel) {
+		return &ErrInvalidTarget{Target: channel}
+	}
+
+	if !IsValidNick(nick) {
+		return &ErrInvalidTarget{Target: nick}
+	}
+
+	cmd.c.Send(&Event{Command: MODE, Params: []string{channel, "+b", cmd.c.banMask(nick)}})
+	return nil
+}
+
+// Unban removes mask from the ban list of channel.
+func (cmd *Commands) Unban(channel, mask string) error {
+	if !IsValidChannel(channel) {
+		return &ErrInvalidTarget{Target: channel}
+	}
+
+	cmd.c.Send(&Event{Command: MODE, Params: []string{channel, "-b", mask}})
+	return nil
+}
+
+// KickBan bans nick from channel (see Commands.Ban()), and then kicks them
+// with reason. If reason is blank, one will not be sent to the server.
+func (cmd *Commands) KickBan(channel, nick, reason string) error {
+	if err := cmd.Ban(channel, nick); err != nil {
+		return err
+	}
+
+	return cmd.Kick(channel, nick, reason)
+}
+
+// banMask returns the mask used to ban nick, refreshing their host first if
+// needed (see Config.BanRefreshAge).
+func (c *Client) banMask(nick string) string {
+	if c.Config.disableTracking {
+		return nick + "!*@*"
+	}
+
+	if c.Config.BanRefreshAge > 0 {
+		c.state.RLock()
+		var updated time.Time
+		if user := c.state.lookupUser(nick); user != nil && user.Host != "" {
+			updated = user.hostUpdated
+		}
+		c.state.RUnlock()
+
+		if time.Since(updated) > c.Config.BanRefreshAge {
+			c.refreshHost(nick)
+		}
+	}
+
+	c.state.RLock()
+	defer c.state.RUnlock()
+
+	if user := c.state.lookupUser(nick); user != nil {
+		return user.BanMask(BanMaskHost)
+	}
+
+	return nick + "!*@*"
+}
+
+// refreshHost sends a WHO query for nick, and waits for the response (which
+// is used to update state by the builtin WHO handler), or until
+// Config.BanRefreshTimeout has passed.
+func (c *Client) refreshHost(nick string) {
+	if !c.IsConnected() {
+		return
+	}
+
+	timeout := c.Config.BanRefreshTimeout
+	if timeout <= 0 {
+		timeout = defaultBanRefreshTimeout
+	}
+
+	done := make(chan struct{}, 1)
+	cuid := c.Handlers.Add(RPL_ENDOFWHO, func(c *Client, e Event) {
+		if len(e.Params) < 2 || !c.Equal(e.Params[1], nick) {
+			return
+		}
+
+		select {
+		case done <- struct{}{}:
+		default:
+		}
+	})
+	defer c.Handlers.Remove(cuid)
+
+	c.Send(&Event{Command: WHO, Params: []string{nick, "%tacuhnr," + whoxTrackingToken}})
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		c.logger.Warn("timed out refreshing host", "nick", nick)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// defaultSource is the public git location of this library, used in
+// response to CTCP SOURCE when BotInfo.Source isn't set.
+const defaultSource = "https://github.com/lrstanley/girc"
+
+// BotInfo describes the application using the client, so other users (and
+// network staff) can identify it, and who runs it. It is used in one place
+// for all identifying strings the client sends: CTCP VERSION and SOURCE
+// replies, the default quit message, and the default realname. See
+// Config.BotInfo.
+type BotInfo struct {
+	// Name is the name of the application (e.g. "mybot").
+	Name string `json:"name"`
+	// Version is the version of the application (e.g. "1.2.0").
+	Version string `json:"version"`
+	// Contact is how the operator of the application can be reached (e.g.
+	// an email address, nickname or URL).
+	Contact string `json:"contact"`
+	// Source is the location of the source code of the application, sent
+	// in response to CTCP SOURCE. Defaults to the girc repository.
+	Source string `json:"source"`
+}
+
+// String returns the application name, version and contact, e.g.
+// "mybot v1.2.0 (contact: admin@example.com)", or an empty string if Name
+// isn't set.
+func (b BotInfo) String() string {
+	if b.Name == "" {
+		return ""
+	}
+
+	out := b.Name
+	if b.Version != "" {
+		out += " v" + b.Version
+	}
+	if b.Contact != "" {
+		out += " (contact: " + b.Contact + ")"
+	}
+
+	return out
+}
+
+// versionReply returns the reply to CTCP VERSION. Config.Version takes
+// precedence, followed by Config.BotInfo, followed by the library name, Go
+// version, os type (darwin, linux, windows, etc) and architecture type
+// (x86, arm, etc).
+func (c *Client) versionReply() string {
+	if c.Config.Version != "" {
+		return c.Config.Version
+	}
+
+	lib := fmt.Sprintf("girc (github.com/lrstanley/girc) using %s (%s, %s)", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	if info := c.Config.BotInfo.String(); info != "" {
+		return info + ", " + lib
+	}
+
+	return lib
+}
+
+// sourceReply returns the reply to CTCP SOURCE.
+func (c *Client) sourceReply() string {
+	if c.Config.BotInfo.Source != "" {
+		return c.Config.BotInfo.Source
+	}
+
+	return defaultSource
+}
+
+// quitMessage returns the default quit message, see Commands.Quit().
+func (c *Client) quitMessage() string {
+	return c.Config.BotInfo.String()
+}
+
+// defaultName returns the realname used when none is configured: the
+// BotInfo if set, otherwise user.
+func (c *Client) defaultName(user string) string {
+	if info := c.Config.BotInfo.String(); info != "" {
+		return info
+	}
+
+	return user
+}
@@ -0,0 +1,141 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"sort"
+	"strings"
+)
+
+// capBouncerNetworks is the soju extension which allows a single bouncer
+// connection to enumerate (and bind to) the upstream networks of the user.
+const capBouncerNetworks = "soju.im/bouncer-networks"
+
+// BouncerNetwork represents an upstream network which is configured on a
+// bouncer supporting the soju.im/bouncer-networks extension.
+type BouncerNetwork struct {
+	// ID is the bouncer-assigned, opaque identifier for the network. This is
+	// what should be used with Config.BouncerNetwork, or Client.BindNetwork().
+	ID string `json:"id"`
+	// Attrs are the attributes of the network, e.g. "name", "host", "state",
+	// "nickname", etc.
+	Attrs map[string]string `json:"attrs"`
+}
+
+// Name returns the human-readable name of the network, if the bouncer has
+// provided one.
+func (n BouncerNetwork) Name() string {
+	return n.Attrs["name"]
+}
+
+// State returns the connection state of the network, from the bouncer to
+// the upstream server. This is one of "connected", "connecting", or
+// "disconnected".
+func (n BouncerNetwork) State() string {
+	return n.Attrs["state"]
+}
+
+// Copy returns a deep copy of the network.
+func (n *BouncerNetwork) Copy() *BouncerNetwork {
+	nn := &BouncerNetwork{ID: n.ID, Attrs: make(map[string]string, len(n.Attrs))}
+	for k, v := range n.Attrs {
+		nn.Attrs[k] = v
+	}
+
+	return nn
+}
+
+// handleBOUNCER handles the BOUNCER NETWORK notifications sent by the
+// bouncer, either in response to LISTNETWORKS, or when a network changes
+// (with soju.im/bouncer-networks-notify).
+func handleBOUNCER(c *Client, e Event) {
+	if len(e.Params) < 2 || e.Params[0] != "NETWORK" {
+		return
+	}
+
+	id := e.Params[1]
+
+	var attrs string
+	if len(e.Params) > 2 {
+		attrs = e.Params[2]
+	} else {
+		attrs = e.Trailing
+	}
+
+	c.state.Lock()
+	if attrs == "*" {
+		// Network has been removed.
+		delete(c.state.bouncerNetworks, id)
+		c.state.Unlock()
+		c.state.notify(c, UPDATE_GENERAL)
+		return
+	}
+
+	network, ok := c.state.bouncerNetworks[id]
+	if !ok {
+		network = &BouncerNetwork{ID: id, Attrs: make(map[string]string)}
+		c.state.bouncerNetworks[id] = network
+	}
+
+	for _, attr := range strings.Split(attrs, ";") {
+		if attr == "" {
+			continue
+		}
+
+		var key, value string
+		if i := strings.IndexByte(attr, '='); i > -1 {
+			key, value = attr[:i], tagDecoder.Replace(attr[i+1:])
+		} else {
+			key = attr
+		}
+
+		// An empty value means the attribute has been removed.
+		if value == "" {
+			delete(network.Attrs, key)
+			continue
+		}
+
+		network.Attrs[key] = value
+	}
+	c.state.Unlock()
+
+	c.state.notify(c, UPDATE_GENERAL)
+}
+
+// BouncerNetworks returns the networks which the bouncer has told us about,
+// sorted by their ID. Use Commands.ListNetworks() to request the list from
+// the bouncer. Empty if tracking is disabled.
+func (c *Client) BouncerNetworks() []BouncerNetwork {
+	c.state.RLock()
+	networks := make([]BouncerNetwork, 0, len(c.state.bouncerNetworks))
+	for _, network := range c.state.bouncerNetworks {
+		networks = append(networks, *network.Copy())
+	}
+	c.state.RUnlock()
+
+	sort.Slice(networks, func(i, j int) bool {
+		return networks[i].ID < networks[j].ID
+	})
+
+	return networks
+}
+
+// BindNetwork returns a new client, with the same configuration as the
+// existing client, which will bind to the provided bouncer network ID during
+// registration. Handlers are not copied, and the returned client must be
+// connected separately.
+func (c *Client) BindNetwork(id string) *Client {
+	conf := c.config()
+	conf.BouncerNetwork = id
+
+	return New(conf)
+}
+
+// ListNetworks requests the list of networks from the bouncer. The bouncer
+// will respond with BOUNCER NETWORK events, which are tracked and can be
+// retrieved with Client.BouncerNetworks().
+func (cmd *Commands) ListNetworks() {
+	cmd.c.Send(&Event{Command: BOUNCER, Params: []string{"LISTNETWORKS"}})
+}
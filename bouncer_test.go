@@ -0,0 +1,45 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import "testing"
+
+func TestBouncerNetworks(t *testing.T) {
+	c := New(Config{Server: "irc.example.com", Nick: "test", User: "user"})
+
+	c.RunHandlers(ParseEvent(":bnc.example.com BOUNCER NETWORK 42 name=Libera\\sChat;state=connected"))
+	c.RunHandlers(ParseEvent(":bnc.example.com BOUNCER NETWORK 7 name=OFTC;state=disconnected"))
+	c.RunHandlers(ParseEvent(":bnc.example.com BOUNCER NETWORK 42 state=connecting;nickname="))
+
+	networks := c.BouncerNetworks()
+	if len(networks) != 2 {
+		t.Fatalf("Client.BouncerNetworks() returned %d networks, wanted 2", len(networks))
+	}
+
+	if networks[0].ID != "42" || networks[0].Name() != "Libera Chat" || networks[0].State() != "connecting" {
+		t.Fatalf("Client.BouncerNetworks()[0] == %#v, unexpected", networks[0])
+	}
+
+	c.RunHandlers(ParseEvent(":bnc.example.com BOUNCER NETWORK 42 *"))
+
+	networks = c.BouncerNetworks()
+	if len(networks) != 1 || networks[0].ID != "7" {
+		t.Fatalf("Client.BouncerNetworks() == %#v, wanted only network 7", networks)
+	}
+
+	bound := c.BindNetwork("7")
+	if bound.Config.BouncerNetwork != "7" || bound.Config.Server != c.Config.Server {
+		t.Fatalf("Client.BindNetwork() returned unexpected config: %#v", bound.Config)
+	}
+
+	// The config may be updated concurrently.
+	updated := make(chan struct{})
+	go func() {
+		_ = c.UpdateConfig(func(conf *RuntimeConfig) { conf.Nick = "other" })
+		close(updated)
+	}()
+	c.BindNetwork("42")
+	<-updated
+}
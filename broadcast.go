@@ -0,0 +1,162 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BroadcastOptions are the options used with Client.Broadcast().
+type BroadcastOptions struct {
+	// Privmsg sends the message as a PRIVMSG, rather than a NOTICE.
+	Privmsg bool
+	// Interval is the delay between each line sent to the server, on top of
+	// the standard client rate limiting. Defaults to 2 seconds.
+	Interval time.Duration
+	// Progress, if supplied, is called after each line has been sent, with
+	// the amount of targets sent to so far, and the total amount of targets.
+	Progress func(sent, total int)
+	// Context can be used to cancel the broadcast. Targets which have not
+	// been sent to yet will be skipped.
+	Context context.Context
+}
+
+// Broadcast sends text to many targets (channels or users), pacing each
+// line, and grouping targets together as the server allows (see ISUPPORT
+// TARGMAX and MAXTARGETS). By default, the message is sent as a NOTICE.
+//
+// All targets are validated before anything is sent. If the broadcast is
+// cancelled, the context error is returned. This blocks until all targets
+// have been sent to.
+func (c *Client) Broadcast(targets []string, text string, opts BroadcastOptions) error {
+	for i := 0; i < len(targets); i++ {
+		if !IsValidNick(targets[i]) && !IsValidChannel(targets[i]) {
+			return &ErrInvalidTarget{Target: targets[i]}
+		}
+	}
+
+	if !c.IsConnected() {
+		return ErrNotConnected
+	}
+
+	command := NOTICE
+	if opts.Privmsg {
+		command = PRIVMSG
+	}
+
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = 2 * time.Second
+	}
+
+	groups := c.groupTargets(command, targets, text)
+
+	var sent int
+	for i := 0; i < len(groups); i++ {
+		if i > 0 {
+			select {
+			case <-time.After(interval):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		c.Send(&Event{Command: command, Params: []string{strings.Join(groups[i], ",")}, Trailing: text})
+		sent += len(groups[i])
+
+		if opts.Progress != nil {
+			opts.Progress(sent, len(targets))
+		}
+	}
+
+	return nil
+}
+
+// groupTargets splits targets into groups which can be sent with command
+// in a single line, respecting the maximum amount of targets the server
+// supports, as well as the maximum line length.
+func (c *Client) groupTargets(command string, targets []string, text string) (groups [][]string) {
+	max := c.targetMax(command)
+	room := maxLength - (&Event{Command: command, Params: []string{""}, Trailing: text}).Len()
+
+	var group []string
+	var length int
+	for i := 0; i < len(targets); i++ {
+		if len(group) > 0 && ((max > 0 && len(group) >= max) || length+1+len(targets[i]) > room) {
+			groups = append(groups, group)
+			group = nil
+			length = 0
+		}
+
+		if len(group) > 0 {
+			length++
+		}
+
+		group = append(group, targets[i])
+		length += len(targets[i])
+	}
+
+	if len(group) > 0 {
+		groups = append(groups, group)
+	}
+
+	return groups
+}
+
+// targetMax returns the maximum amount of targets the server allows for
+// command, using ISUPPORT TARGMAX (or MAXTARGETS). 0 means there is no
+// limit. If the server doesn't advertise either, 1 is returned.
+func (c *Client) targetMax(command string) int {
+	if c.Config.disableTracking {
+		return 1
+	}
+
+	c.state.RLock()
+	targmax, hasTargmax := c.state.serverOptions["TARGMAX"]
+	maxtargets, hasMaxtargets := c.state.serverOptions["MAXTARGETS"]
+	c.state.RUnlock()
+
+	if hasTargmax {
+		for _, limit := range strings.Split(targmax, ",") {
+			i := strings.IndexByte(limit, ':')
+			if i < 0 || !strings.EqualFold(limit[:i], command) {
+				continue
+			}
+
+			if limit[i+1:] == "" {
+				return 0
+			}
+
+			if max, err := strconv.Atoi(limit[i+1:]); err == nil && max > 0 {
+				return max
+			}
+		}
+
+		// Commands not listed in TARGMAX only support a single target.
+		return 1
+	}
+
+	if hasMaxtargets {
+		if max, err := strconv.Atoi(maxtargets); err == nil && max > 0 {
+			return max
+		}
+	}
+
+	return 1
+}
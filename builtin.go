@@ -5,6 +5,7 @@
 package girc
 
 import (
+	"strconv"
 	"strings"
 	"time"
 )
@@ -12,19 +13,43 @@ import (
 // registerBuiltin sets up built-in handlers, based on client
 // configuration.
 func (c *Client) registerBuiltins() {
-	c.debug.Print("registering built-in handlers")
+	c.logger.Debug("registering built-in handlers")
 	c.Handlers.mu.Lock()
 
 	// Built-in things that should always be supported.
-	c.Handlers.register(true, RPL_WELCOME, HandlerFunc(func(c *Client, e Event) {
-		go handleConnect(c, e)
-	}))
+	c.Handlers.register(true, RPL_WELCOME, HandlerFunc(handleConnect))
 	c.Handlers.register(true, PING, HandlerFunc(handlePING))
 	c.Handlers.register(true, PONG, HandlerFunc(handlePONG))
+	c.Handlers.register(true, KILL, HandlerFunc(handleKILL))
+	c.Handlers.register(true, PONG, HandlerFunc(handleRateFeedback))
+	c.Handlers.register(true, RPL_TRYAGAIN, HandlerFunc(handleRateFeedback))
+	c.Handlers.register(true, ERR_TARGETTOOFAST, HandlerFunc(handleRateFeedback))
+	c.Handlers.register(true, ERR_TARGCHANGE, HandlerFunc(handleRateFeedback))
+	c.Handlers.register(true, RPL_ENDOFMOTD, HandlerFunc(handleLegacyRegistered))
+	c.Handlers.register(true, ERR_NOMOTD, HandlerFunc(handleLegacyRegistered))
+	c.Handlers.register(true, PRIVMSG, HandlerFunc(handleURLs))
+	c.Handlers.register(true, PRIVMSG, HandlerFunc(handleServices))
+	c.Handlers.register(true, NOTICE, HandlerFunc(handleServices))
+	c.Handlers.register(true, NOTICE, HandlerFunc(handleNickServPrompt))
 
 	if !c.Config.disableTracking {
 		// Joins/parts/anything that may add/remove/rename users.
 		c.Handlers.register(true, JOIN, HandlerFunc(handleJOIN))
+		c.Handlers.register(true, JOIN, HandlerFunc(handleAutoMode))
+		c.Handlers.register(true, JOIN, HandlerFunc(handleRejoin))
+		c.Handlers.register(true, CONNECTED, HandlerFunc(handleRejoin))
+		c.Handlers.register(true, ERR_UNAVAILRESOURCE, HandlerFunc(handleRejoin))
+		c.Handlers.register(true, PART, HandlerFunc(handleRejoin))
+		c.Handlers.register(true, KICK, HandlerFunc(handleRejoin))
+		c.Handlers.register(true, MODE, HandlerFunc(handleRejoin))
+		c.Handlers.register(true, CONNECTED, HandlerFunc(handleReclaim))
+		c.Handlers.register(true, RPL_MONOFFLINE, HandlerFunc(handleReclaim))
+		c.Handlers.register(true, KICK, HandlerFunc(handleKickRejoin))
+		c.Handlers.register(true, JOIN, HandlerFunc(handleKickRejoin))
+		c.Handlers.register(true, ERR_CHANNELISFULL, HandlerFunc(handleKickRejoin))
+		c.Handlers.register(true, ERR_INVITEONLYCHAN, HandlerFunc(handleKickRejoin))
+		c.Handlers.register(true, ERR_BANNEDFROMCHAN, HandlerFunc(handleKickRejoin))
+		c.Handlers.register(true, ERR_BADCHANNELKEY, HandlerFunc(handleKickRejoin))
 		c.Handlers.register(true, PART, HandlerFunc(handlePART))
 		c.Handlers.register(true, KICK, HandlerFunc(handleKICK))
 		c.Handlers.register(true, QUIT, HandlerFunc(handleQUIT))
@@ -34,14 +59,26 @@ func (c *Client) registerBuiltins() {
 		// Modes.
 		c.Handlers.register(true, MODE, HandlerFunc(handleMODE))
 		c.Handlers.register(true, RPL_CHANNELMODEIS, HandlerFunc(handleMODE))
+		c.Handlers.register(true, MODE, HandlerFunc(handleUserMODE))
+		c.Handlers.register(true, RPL_UMODEIS, HandlerFunc(handleUserMODE))
+		c.Handlers.register(true, MODE, HandlerFunc(handleReOp))
+		c.Handlers.register(true, JOIN, HandlerFunc(handleReOp))
+		c.Handlers.register(true, RPL_ENDOFNAMES, HandlerFunc(handleReOp))
 
 		// WHO/WHOX responses.
 		c.Handlers.register(true, RPL_WHOREPLY, HandlerFunc(handleWHO))
 		c.Handlers.register(true, RPL_WHOSPCRPL, HandlerFunc(handleWHO))
+		c.Handlers.register(true, ALL_EVENTS, HandlerFunc(handleENDOFWHO))
+
+		// Channel priming, see Config.Priming.
+		c.Handlers.register(true, primingNumerics, HandlerFunc(handlePriming))
+		c.Handlers.register(true, ERR_CHANOPRIVSNEEDED, HandlerFunc(handlePriming))
 
 		// Other misc. useful stuff.
 		c.Handlers.register(true, TOPIC, HandlerFunc(handleTOPIC))
 		c.Handlers.register(true, RPL_TOPIC, HandlerFunc(handleTOPIC))
+		c.Handlers.register(true, RPL_TOPICWHOTIME, HandlerFunc(handleTOPIC))
+		c.Handlers.register(true, RPL_NOTOPIC, HandlerFunc(handleTOPIC))
 		c.Handlers.register(true, RPL_MYINFO, HandlerFunc(handleMYINFO))
 		c.Handlers.register(true, RPL_ISUPPORT, HandlerFunc(handleISUPPORT))
 		c.Handlers.register(true, RPL_MOTDSTART, HandlerFunc(handleMOTD))
@@ -59,6 +96,8 @@ func (c *Client) registerBuiltins() {
 		c.Handlers.register(true, CAP_AWAY, HandlerFunc(handleAWAY))
 		c.Handlers.register(true, CAP_ACCOUNT, HandlerFunc(handleACCOUNT))
 		c.Handlers.register(true, ALL_EVENTS, HandlerFunc(handleTags))
+		c.Handlers.register(true, BATCH, HandlerFunc(handleBATCH))
+		c.Handlers.register(true, BOUNCER, HandlerFunc(handleBOUNCER))
 
 		// SASL IRCv3 support.
 		c.Handlers.register(true, AUTHENTICATE, HandlerFunc(handleSASL))
@@ -68,6 +107,9 @@ func (c *Client) registerBuiltins() {
 		c.Handlers.register(true, ERR_SASLTOOLONG, HandlerFunc(handleSASLError))
 		c.Handlers.register(true, ERR_SASLABORTED, HandlerFunc(handleSASLError))
 		c.Handlers.register(true, RPL_SASLMECHS, HandlerFunc(handleSASLError))
+	} else {
+		// Our own nickname is still kept up to date, see Client.GetNick().
+		c.Handlers.register(true, NICK, HandlerFunc(handleOwnNICK))
 	}
 
 	// Nickname collisions.
@@ -80,8 +122,6 @@ func (c *Client) registerBuiltins() {
 
 // handleConnect is a helper function which lets the client know that enough
 // time has passed and now they can send commands.
-//
-// Should always run in separate thread due to blocking delay.
 func handleConnect(c *Client, e Event) {
 	// This should be the nick that the server gives us. 99% of the time, it's
 	// the one we supplied during connection, but some networks will rename
@@ -94,13 +134,36 @@ func handleConnect(c *Client, e Event) {
 		c.state.notify(c, UPDATE_GENERAL)
 	}
 
-	time.Sleep(2 * time.Second)
-	c.RunHandlers(&Event{Command: CONNECTED, Trailing: c.Server()})
+	c.setStatus(StatusReady)
+
+	// The delay runs separately, so our nickname is updated before any
+	// other events are handled.
+	go func() {
+		time.Sleep(2 * time.Second)
+		c.RunHandlers(&Event{Command: CONNECTED, Trailing: c.Server()})
+	}()
 }
 
 // nickCollisionHandler helps prevent the client from having conflicting
 // nicknames with another bot, user, etc.
 func nickCollisionHandler(c *Client, e Event) {
+	// ERR_UNAVAILRESOURCE is also used for channels.
+	if len(e.Params) > 1 && IsValidChannel(e.Params[1]) {
+		return
+	}
+
+	// Failed attempts to reclaim our nickname once connected shouldn't
+	// result in us changing nickname again.
+	if c.Config.NickReclaim != nil && len(e.Params) > 1 && c.Equal(e.Params[1], c.Identity().Nick) {
+		c.state.RLock()
+		registered := c.state.nick != ""
+		c.state.RUnlock()
+
+		if registered {
+			return
+		}
+	}
+
 	if c.Config.HandleNickCollide == nil {
 		c.Cmd.Nick(c.GetNick() + "_")
 		return
@@ -156,6 +219,12 @@ func handleJOIN(c *Client, e Event) {
 
 	channel.addUser(user.Nick)
 	user.addChannel(channel.Name)
+	user.setHost(e.Source.Ident, e.Source.Host)
+
+	if c.state.fold(user.Nick) != c.state.fold(c.state.nick) {
+		channel.recordMember(memberJoined, user.Nick, "")
+	}
+	c.state.addDelta(StateDelta{Type: DeltaJoin, Channel: channel.Name, Nick: user.Nick})
 
 	// Assume extended-join (ircv3).
 	if len(e.Params) == 2 {
@@ -169,13 +238,17 @@ func handleJOIN(c *Client, e Event) {
 	}
 	c.state.Unlock()
 
-	if e.Source.Name == c.GetNick() {
-		// If it's us, don't just add our user to the list. Run a WHO which
-		// will tell us who exactly is in the entire channel.
-		c.Send(&Event{Command: WHO, Params: []string{channelName, "%tacuhnr,1"}})
+	if c.Equal(e.Source.Name, c.GetNick()) {
+		if c.Config.Priming != nil {
+			c.Config.Priming.start(c, channelName)
+		} else {
+			// If it's us, don't just add our user to the list. Run a WHO
+			// which will tell us who exactly is in the entire channel.
+			c.Send(&Event{Command: WHO, Params: []string{channelName, "%tacuhnr," + whoxTrackingToken}})
 
-		// Also send a MODE to obtain the list of channel modes.
-		c.Send(&Event{Command: MODE, Params: []string{channelName}})
+			// Also send a MODE to obtain the list of channel modes.
+			c.Send(&Event{Command: MODE, Params: []string{channelName}})
+		}
 
 		// Update our ident and host too, in state -- since there is no
 		// cleaner method to do this.
@@ -187,7 +260,7 @@ func handleJOIN(c *Client, e Event) {
 	}
 
 	// Only WHO the user, which is more efficient.
-	c.Send(&Event{Command: WHO, Params: []string{e.Source.Name, "%tacuhnr,1"}})
+	c.Send(&Event{Command: WHO, Params: []string{e.Source.Name, "%tacuhnr," + whoxTrackingToken}})
 }
 
 // handlePART ensures that the state is clean of old user and channel entries.
@@ -209,8 +282,11 @@ func handlePART(c *Client, e Event) {
 
 	defer c.state.notify(c, UPDATE_STATE)
 
-	if e.Source.Name == c.GetNick() {
+	if c.Equal(e.Source.Name, c.GetNick()) {
 		c.state.Lock()
+		if ch := c.state.lookupChannel(channel); ch != nil {
+			c.state.addDelta(StateDelta{Type: DeltaPart, Channel: ch.Name, Nick: e.Source.Name})
+		}
 		c.state.deleteChannel(channel)
 		c.state.Unlock()
 		return
@@ -222,7 +298,7 @@ func handlePART(c *Client, e Event) {
 }
 
 // handleTOPIC handles incoming TOPIC events and keeps channel tracking info
-// updated with the latest channel topic.
+// updated with the latest channel topic, as well as who set it, and when.
 func handleTOPIC(c *Client, e Event) {
 	var name string
 	switch len(e.Params) {
@@ -231,7 +307,11 @@ func handleTOPIC(c *Client, e Event) {
 	case 1:
 		name = e.Params[0]
 	default:
-		name = e.Params[len(e.Params)-1]
+		name = e.Params[1]
+	}
+
+	if e.Command == TOPIC {
+		name = e.Params[0]
 	}
 
 	c.state.Lock()
@@ -241,15 +321,63 @@ func handleTOPIC(c *Client, e Event) {
 		return
 	}
 
-	channel.Topic = e.Trailing
+	old := channel.Topic
+
+	switch e.Command {
+	case RPL_TOPICWHOTIME:
+		// RPL_TOPICWHOTIME <nick> <channel> <setter> <unix time>
+		if len(e.Params) < 4 {
+			break
+		}
+
+		channel.TopicSetBy = e.Params[2]
+		if ts, err := strconv.ParseInt(e.Params[3], 10, 64); err == nil {
+			channel.TopicSetAt = time.Unix(ts, 0)
+		}
+	case RPL_NOTOPIC:
+		channel.Topic = ""
+		channel.TopicSetBy = ""
+		channel.TopicSetAt = time.Time{}
+	case RPL_TOPIC:
+		channel.Topic = e.Trailing
+	case TOPIC:
+		channel.Topic = e.Trailing
+		channel.TopicSetAt = time.Now()
+		if ts, ok := e.Timestamp(); ok {
+			channel.TopicSetAt = ts
+		}
+
+		if e.Source != nil {
+			channel.TopicSetBy = e.Source.Name
+		}
+	}
+
+	name = channel.Name
+	setter := channel.TopicSetBy
+	current := channel.Topic
+	if old != current {
+		c.state.addDelta(StateDelta{Type: DeltaTopic, Channel: name, Topic: current})
+	}
 	c.state.Unlock()
 	c.state.notify(c, UPDATE_STATE)
+
+	if e.Command == TOPIC && old != current {
+		c.RunHandlers(&Event{Command: TOPIC_CHANGED, Params: []string{name, setter, old}, Trailing: current})
+	}
+}
+
+// whoReply is a single WHO/WHOX reply, which is buffered until the end of
+// the WHO response, see handleWHO().
+type whoReply struct {
+	ident, host, nick, account, realname string
 }
 
-// handlWHO updates our internal tracking of users/channels with WHO/WHOX
-// information.
+// handleWHO buffers WHO/WHOX information, which is committed to state in one
+// go once the end of the WHO response is received (see handleENDOFWHO).
+// This prevents large channels (with tens of thousands of users) from
+// stalling other goroutines waiting on the state lock while joining.
 func handleWHO(c *Client, e Event) {
-	var ident, host, nick, account, realname string
+	var reply whoReply
 
 	// Assume WHOX related.
 	if e.Command == RPL_WHOSPCRPL {
@@ -258,38 +386,63 @@ func handleWHO(c *Client, e Event) {
 			return
 		}
 
-		if e.Params[1] != "1" {
-			// We should always be sending 1, and we should receive 1. If this
-			// is anything but, then we didn't send the request and we can
-			// ignore it.
+		if e.Params[1] != whoxTrackingToken {
+			// We should always be sending whoxTrackingToken, and receive it
+			// back. If this is anything but, then we didn't send the request
+			// and we can ignore it.
 			return
 		}
 
-		ident, host, nick, account = e.Params[3], e.Params[4], e.Params[5], e.Params[6]
-		realname = e.Trailing
+		reply.ident, reply.host, reply.nick, reply.account = e.Params[3], e.Params[4], e.Params[5], e.Params[6]
+		reply.realname = e.Trailing
 	} else {
 		// Assume RPL_WHOREPLY.
-		ident, host, nick = e.Params[2], e.Params[3], e.Params[5]
+		if len(e.Params) < 6 {
+			return
+		}
+
+		reply.ident, reply.host, reply.nick = e.Params[2], e.Params[3], e.Params[5]
 		if len(e.Trailing) > 2 {
-			realname = e.Trailing[2:]
+			reply.realname = e.Trailing[2:]
 		}
 	}
 
-	c.state.Lock()
-	user := c.state.lookupUser(nick)
-	if user == nil {
-		c.state.Unlock()
+	c.state.whoMu.Lock()
+	c.state.whoReplies = append(c.state.whoReplies, reply)
+	c.state.whoMu.Unlock()
+}
+
+// handleENDOFWHO commits all buffered WHO/WHOX replies to state, in a single
+// locked operation. This is registered as an ALL_EVENTS handler, so the
+// state is updated before any RPL_ENDOFWHO handlers are executed.
+func handleENDOFWHO(c *Client, e Event) {
+	if e.Command != RPL_ENDOFWHO {
 		return
 	}
 
-	user.Host = host
-	user.Ident = ident
-	user.Extras.Name = realname
+	c.state.whoMu.Lock()
+	replies := c.state.whoReplies
+	c.state.whoReplies = nil
+	c.state.whoMu.Unlock()
 
-	if account != "0" {
-		user.Extras.Account = account
+	if len(replies) == 0 {
+		return
 	}
 
+	c.state.Lock()
+	for i := 0; i < len(replies); i++ {
+		user := c.state.lookupUser(replies[i].nick)
+		if user == nil {
+			continue
+		}
+
+		user.setHost(replies[i].ident, replies[i].host)
+		user.Extras.Name = replies[i].realname
+
+		if replies[i].account != "0" {
+			user.Extras.Account = replies[i].account
+		}
+	}
 	c.state.Unlock()
 	c.state.notify(c, UPDATE_STATE)
 }
@@ -304,8 +457,11 @@ func handleKICK(c *Client, e Event) {
 
 	defer c.state.notify(c, UPDATE_STATE)
 
-	if e.Params[1] == c.GetNick() {
+	if c.Equal(e.Params[1], c.GetNick()) {
 		c.state.Lock()
+		if ch := c.state.lookupChannel(e.Params[0]); ch != nil {
+			c.state.addDelta(StateDelta{Type: DeltaPart, Channel: ch.Name, Nick: e.Params[1]})
+		}
 		c.state.deleteChannel(e.Params[0])
 		c.state.Unlock()
 		return
@@ -324,15 +480,53 @@ func handleNICK(c *Client, e Event) {
 		return
 	}
 
+	nick := e.Trailing
+	if len(e.Params) == 1 {
+		nick = e.Params[0]
+	}
+
+	if nick == "" {
+		return
+	}
+
 	c.state.Lock()
+	self := c.state.fold(e.Source.Name) == c.state.fold(c.state.nick)
 	// renameUser updates the LastActive time automatically.
+	c.state.renameUser(e.Source.Name, nick)
+	c.state.Unlock()
+	c.state.notify(c, UPDATE_STATE)
+
+	if self {
+		nickReclaimed(c, e.Source.Name, nick)
+	}
+}
+
+// handleOwnNICK keeps our own nickname up to date when tracking is
+// disabled, see Client.GetNick().
+func handleOwnNICK(c *Client, e Event) {
+	if e.Source == nil {
+		return
+	}
+
+	nick := e.Trailing
 	if len(e.Params) == 1 {
-		c.state.renameUser(e.Source.Name, e.Params[0])
-	} else if len(e.Trailing) > 0 {
-		c.state.renameUser(e.Source.Name, e.Trailing)
+		nick = e.Params[0]
+	}
+
+	if nick == "" {
+		return
+	}
+
+	c.state.Lock()
+	self := c.state.fold(e.Source.Name) == c.state.fold(c.state.nick)
+	if self {
+		c.state.nick = nick
 	}
 	c.state.Unlock()
-	c.state.notify(c, UPDATE_STATE)
+
+	if self {
+		c.state.notify(c, UPDATE_GENERAL)
+	}
 }
 
 // handleQUIT handles users that are quitting from the network.
@@ -341,7 +535,7 @@ func handleQUIT(c *Client, e Event) {
 		return
 	}
 
-	if e.Source.Name == c.GetNick() {
+	if c.Equal(e.Source.Name, c.GetNick()) {
 		return
 	}
 
@@ -399,6 +593,10 @@ func handleISUPPORT(c *Client, e Event) {
 		name := e.Params[i][0:j]
 		val := e.Params[i][j+1:]
 		c.state.serverOptions[name] = val
+
+		if name == "CASEMAPPING" {
+			c.state.casemapping = strings.ToLower(val)
+		}
 	}
 	c.state.Unlock()
 	c.state.notify(c, UPDATE_GENERAL)
@@ -413,18 +611,14 @@ func handleMOTD(c *Client, e Event) {
 
 	// Beginning of the MOTD.
 	if e.Command == RPL_MOTDSTART {
-		c.state.motd = ""
+		c.state.motd = nil
 
 		c.state.Unlock()
 		return
 	}
 
 	// Otherwise, assume we're getting sent the MOTD line-by-line.
-	if len(c.state.motd) != 0 {
-		e.Trailing = "\n" + e.Trailing
-	}
-
-	c.state.motd += e.Trailing
+	c.state.motd = append(c.state.motd, e.Trailing)
 	c.state.Unlock()
 }
 
@@ -479,12 +673,7 @@ func handleNAMES(c *Client, e Event) {
 		channel.addUser(nick)
 
 		// Add necessary userhost-in-names data into the user.
-		if host != "" {
-			user.Host = host
-		}
-		if ident != "" {
-			user.Ident = ident
-		}
+		user.setHost(ident, host)
 
 		// Don't append modes, overwrite them.
 		perms, _ := user.Perms.Lookup(channel.Name)
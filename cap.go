@@ -20,19 +20,42 @@ var possibleCap = map[string][]string{
 	"batch":             nil,
 	"cap-notify":        nil,
 	"chghost":           nil,
+	"draft/relaymsg":    nil,
 	"extended-join":     nil,
 	"invite-notify":     nil,
+	"labeled-response":  nil,
 	"message-tags":      nil,
 	"multi-prefix":      nil,
+	"server-time":       nil,
 	"userhost-in-names": nil,
+
+	"draft/account-registration":      nil,
+	"soju.im/bouncer-networks":        nil,
+	"soju.im/bouncer-networks-notify": nil,
+	"znc.in/self-message":             nil,
 }
 
+// capRelayMsg is the capability (and tag) used by servers which support
+// relayed messages. See Commands.RelayMessage() and Event.RelayedBy().
+const capRelayMsg = "draft/relaymsg"
+
 func (c *Client) listCAP() {
 	if !c.Config.disableTracking {
 		c.write(&Event{Command: CAP, Params: []string{CAP_LS, "302"}})
 	}
 }
 
+// endCAP lets the server know that we're done with capability negotiation,
+// so registration can complete.
+func (c *Client) endCAP() {
+	// Bouncer networks must be bound before registration has completed.
+	if c.Config.BouncerNetwork != "" && c.HasCapability(capBouncerNetworks) {
+		c.write(&Event{Command: BOUNCER, Params: []string{"BIND", c.Config.BouncerNetwork}})
+	}
+
+	c.write(&Event{Command: CAP, Params: []string{CAP_END}})
+}
+
 func possibleCapList(c *Client) map[string][]string {
 	out := make(map[string][]string)
 
@@ -40,9 +63,11 @@ func possibleCapList(c *Client) map[string][]string {
 		out["sasl"] = nil
 	}
 
+	c.confMu.RLock()
 	for k := range c.Config.SupportedCaps {
 		out[k] = c.Config.SupportedCaps[k]
 	}
+	c.confMu.RUnlock()
 
 	for k := range possibleCap {
 		out[k] = possibleCap[k]
@@ -85,7 +110,7 @@ func handleCAP(c *Client, e Event) {
 	// We can assume there was a failure attempting to enable a capability.
 	if len(e.Params) == 2 && e.Params[1] == CAP_NAK {
 		// Let the server know that we're done.
-		c.write(&Event{Command: CAP, Params: []string{CAP_END}})
+		c.endCAP()
 		return
 	}
 
@@ -97,6 +122,8 @@ func handleCAP(c *Client, e Event) {
 		caps := parseCap(e.Trailing)
 
 		for k := range caps {
+			c.state.serverCaps[k] = caps[k]
+
 			if _, ok := possible[k]; !ok {
 				continue
 			}
@@ -131,7 +158,7 @@ func handleCAP(c *Client, e Event) {
 		if len(e.Params) == 2 {
 			// If we support no caps, just ack the CAP message and END.
 			if len(c.state.tmpCap) == 0 {
-				c.write(&Event{Command: CAP, Params: []string{CAP_END}})
+				c.endCAP()
 				return
 			}
 
@@ -167,11 +194,19 @@ func handleCAP(c *Client, e Event) {
 		}
 
 		// Let the server know that we're done.
-		c.write(&Event{Command: CAP, Params: []string{CAP_END}})
+		c.endCAP()
 		return
 	}
 }
 
+// ErrCapNotEnabled is returned when a method relies on an IRCv3 capability
+// which has not been negotiated with the server.
+type ErrCapNotEnabled struct {
+	Cap string // Cap is the capability which is required.
+}
+
+func (e *ErrCapNotEnabled) Error() string { return "capability not enabled: " + e.Cap }
+
 // SASLMech is an representation of what a SASL mechanism should support.
 // See SASLExternal and SASLPlain for implementations of this.
 type SASLMech interface {
@@ -246,7 +281,7 @@ const saslChunkSize = 400
 func handleSASL(c *Client, e Event) {
 	if e.Command == RPL_SASLSUCCESS || e.Command == ERR_SASLALREADY {
 		// Let the server know that we're done.
-		c.write(&Event{Command: CAP, Params: []string{CAP_END}})
+		c.endCAP()
 		return
 	}
 
@@ -258,10 +293,10 @@ func handleSASL(c *Client, e Event) {
 		// some reason. The SASL spec and IRCv3 spec do not define a clear
 		// way to abort a SASL exchange, other than to disconnect, or proceed
 		// with CAP END.
-		c.rx <- &Event{Command: ERROR, Trailing: fmt.Sprintf(
+		c.receive(&Event{Command: ERROR, Trailing: fmt.Sprintf(
 			"closing connection: invalid %s SASL configuration provided: %s",
 			c.Config.SASL.Method(), e.Trailing,
-		)}
+		)})
 		return
 	}
 
@@ -289,14 +324,14 @@ func handleSASL(c *Client, e Event) {
 
 func handleSASLError(c *Client, e Event) {
 	if c.Config.SASL == nil {
-		c.write(&Event{Command: CAP, Params: []string{CAP_END}})
+		c.endCAP()
 		return
 	}
 
 	// Authentication failed. The SASL spec and IRCv3 spec do not define a
 	// clear way to abort a SASL exchange, other than to disconnect, or
 	// proceed with CAP END.
-	c.rx <- &Event{Command: ERROR, Trailing: "closing connection: " + e.Trailing}
+	c.receive(&Event{Command: ERROR, Trailing: "closing connection: " + e.Trailing})
 }
 
 // handleCHGHOST handles incoming IRCv3 hostname change events. CHGHOST is
@@ -311,8 +346,7 @@ func handleCHGHOST(c *Client, e Event) {
 	c.state.Lock()
 	user := c.state.lookupUser(e.Source.Name)
 	if user != nil {
-		user.Ident = e.Params[0]
-		user.Host = e.Params[1]
+		user.setHost(e.Params[0], e.Params[1])
 	}
 	c.state.Unlock()
 	c.state.notify(c, UPDATE_STATE)
@@ -374,6 +408,61 @@ func handleTags(c *Client, e Event) {
 	c.state.notify(c, UPDATE_STATE)
 }
 
+// replayBatches are the batch types which are known to contain history,
+// rather than live traffic.
+var replayBatches = map[string]bool{
+	"chathistory":       true,
+	"draft/chathistory": true,
+	"znc.in/playback":   true,
+}
+
+// handleBATCH keeps track of IRCv3 batches which have been opened by the
+// server, so events within them can be identified (e.g. as being replayed
+// history).
+func handleBATCH(c *Client, e Event) {
+	if len(e.Params) < 1 || len(e.Params[0]) < 2 {
+		return
+	}
+
+	ref := e.Params[0][1:]
+
+	c.state.Lock()
+	switch e.Params[0][0] {
+	case '+':
+		if len(e.Params) < 2 {
+			break
+		}
+
+		// Batches nested within a replayed batch are also replayed.
+		c.state.batches[ref] = batchInfo{
+			kind:     e.Params[1],
+			replayed: e.Replayed || replayBatches[e.Params[1]],
+		}
+	case '-':
+		delete(c.state.batches, ref)
+	}
+	c.state.Unlock()
+}
+
+// isReplayed checks to see if the event is within a batch which contains
+// replayed history (e.g. chathistory, or bouncer playback).
+func (c *Client) isReplayed(e *Event) (replayed bool) {
+	if c.Config.disableTracking || len(e.Tags) == 0 {
+		return false
+	}
+
+	ref, ok := e.Tags.Get("batch")
+	if !ok {
+		return false
+	}
+
+	c.state.RLock()
+	replayed = c.state.batches[ref].replayed
+	c.state.RUnlock()
+
+	return replayed
+}
+
 const (
 	prefixTag      byte = 0x40 // @
 	prefixTagValue byte = 0x3D // =
@@ -105,3 +105,44 @@ func TestTagGetSetCount(t *testing.T) {
 		t.Fatal("tag set of invalid value should have returned error")
 	}
 }
+
+func TestReplayedBatch(t *testing.T) {
+	c := New(Config{Server: "irc.example.com", Nick: "test", User: "user"})
+
+	var all, live int
+	c.Handlers.Add(PRIVMSG, func(c *Client, e Event) { all++ })
+	c.Handlers.AddLive(PRIVMSG, func(c *Client, e Event) { live++ })
+
+	c.RunHandlers(ParseEvent(":irc.example.com BATCH +abc chathistory #test"))
+	c.RunHandlers(ParseEvent("@batch=abc :nick!user@host PRIVMSG #test :old message"))
+	c.RunHandlers(ParseEvent(":irc.example.com BATCH -abc"))
+	c.RunHandlers(ParseEvent(":nick!user@host PRIVMSG #test :new message"))
+
+	if all != 2 {
+		t.Fatalf("Caller.Add() handler executed %d times, wanted 2", all)
+	}
+
+	if live != 1 {
+		t.Fatalf("Caller.AddLive() handler executed %d times, wanted 1", live)
+	}
+}
+
+func TestParseStandardReply(t *testing.T) {
+	fail := parseStandardReply(ParseEvent("FAIL REGISTER WEAK_PASSWORD test :Password is too weak"))
+	if fail == nil {
+		t.Fatal("parseStandardReply() returned nil, wanted ErrStandardReply")
+	}
+
+	want := &ErrStandardReply{Command: REGISTER, Code: "WEAK_PASSWORD", Context: []string{"test"}, Description: "Password is too weak"}
+	if !reflect.DeepEqual(fail, want) {
+		t.Fatalf("parseStandardReply() == %#v, wanted %#v", fail, want)
+	}
+
+	if fail.Error() != "register failed (WEAK_PASSWORD): Password is too weak" {
+		t.Fatalf("ErrStandardReply.Error() == %q, unexpected", fail.Error())
+	}
+
+	if parseStandardReply(ParseEvent("REGISTER SUCCESS test :Account created")) != nil {
+		t.Fatal("parseStandardReply() returned non-nil for non-standard reply")
+	}
+}
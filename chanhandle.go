@@ -0,0 +1,150 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import "fmt"
+
+// ChannelHandle is a channel-scoped view of the client, see Client.Channel().
+// Handlers registered through the handle only receive events for its
+// channel, and are all removed with ChannelHandle.Close(), which allows
+// large applications to hand each feature a handle to only the channel it
+// owns, rather than the whole client.
+type ChannelHandle struct {
+	c     *Client
+	name  string
+	group *HandlerGroup
+}
+
+// Channel returns a new handle scoped to channel. Each handle is isolated,
+// i.e. closing one doesn't affect other handles for the same channel. Note
+// that the handle doesn't join the channel, see ChannelHandle.Join().
+func (c *Client) Channel(name string) *ChannelHandle {
+	_, uid := c.Handlers.cuid(name, 10)
+
+	return &ChannelHandle{
+		c:     c,
+		name:  name,
+		group: c.Handlers.Group("channel " + name + " " + uid),
+	}
+}
+
+// Name returns the name of the channel.
+func (h *ChannelHandle) Name() string {
+	return h.name
+}
+
+// Subscribe registers the handler function for the given event, only
+// executing it for events which target the channel (i.e. with the channel
+// as the first param, like PRIVMSG, JOIN, PART, KICK, MODE or TOPIC). Events
+// which aren't sent to a specific channel (e.g. QUIT and NICK) are not
+// received. cuid is the handler uid which can be used to remove the handler
+// with Caller.Remove().
+func (h *ChannelHandle) Subscribe(cmd string, handler func(client *Client, event Event)) (cuid string) {
+	return h.group.AddMatch(Match{Commands: []string{cmd}, Target: h.name}, handler)
+}
+
+// OnJoin registers the handler function for when the client has joined the
+// channel. Tracking must be enabled.
+func (h *ChannelHandle) OnJoin(handler func(client *Client, event Event)) (cuid string) {
+	return h.group.AddMatch(Match{
+		Commands: []string{JOIN},
+		Target:   h.name,
+		Func: func(e Event) bool {
+			return e.Source != nil && h.c.Equal(e.Source.Name, h.c.GetNick())
+		},
+	}, handler)
+}
+
+// OnLeave registers the handler function for when the client has left the
+// channel, either because it parted or was kicked. Tracking must be
+// enabled.
+func (h *ChannelHandle) OnLeave(handler func(client *Client, event Event)) (cuid string) {
+	return h.group.AddMatch(Match{
+		Commands: []string{PART, KICK},
+		Target:   h.name,
+		Func: func(e Event) bool {
+			if e.Command == KICK {
+				return len(e.Params) > 1 && h.c.Equal(e.Params[1], h.c.GetNick())
+			}
+
+			return e.Source != nil && h.c.Equal(e.Source.Name, h.c.GetNick())
+		},
+	}, handler)
+}
+
+// Close removes all handlers registered through the handle, returning the
+// amount of handlers which were removed. The handle may still be used to
+// send messages.
+func (h *ChannelHandle) Close() int {
+	return h.group.Clear()
+}
+
+// Joined returns true if the client is in the channel. Always false if
+// tracking is disabled.
+func (h *ChannelHandle) Joined() bool {
+	return h.c.IsInChannel(h.name)
+}
+
+// Members returns the users in the channel, sorted by nickname, or nil if
+// the client isn't in the channel, or tracking is disabled.
+func (h *ChannelHandle) Members() []*User {
+	channel := h.c.LookupChannel(h.name)
+	if channel == nil {
+		return nil
+	}
+
+	return channel.Members(h.c, SortByNick)
+}
+
+// Topic returns the topic of the channel, or an empty string if the client
+// isn't in the channel, or tracking is disabled.
+func (h *ChannelHandle) Topic() string {
+	channel := h.c.LookupChannel(h.name)
+	if channel == nil {
+		return ""
+	}
+
+	return channel.Topic
+}
+
+// Join joins the channel. See Commands.Join().
+func (h *ChannelHandle) Join() error {
+	return h.c.Cmd.Join(h.name)
+}
+
+// Part leaves the channel with an optional reason.
+func (h *ChannelHandle) Part(reason string) error {
+	if !IsValidChannel(h.name) {
+		return &ErrInvalidTarget{Target: h.name}
+	}
+
+	h.c.Send(&Event{Command: PART, Params: []string{h.name}, Trailing: reason})
+	return nil
+}
+
+// Send sends a PRIVMSG to the channel. See Commands.Message().
+func (h *ChannelHandle) Send(message string) error {
+	return h.c.Cmd.Message(h.name, message)
+}
+
+// Sendf sends a formatted PRIVMSG to the channel. See Commands.Message().
+func (h *ChannelHandle) Sendf(format string, a ...interface{}) error {
+	return h.c.Cmd.Message(h.name, fmt.Sprintf(format, a...))
+}
+
+// Notice sends a NOTICE to the channel. See Commands.Notice().
+func (h *ChannelHandle) Notice(message string) error {
+	return h.c.Cmd.Notice(h.name, message)
+}
+
+// Action sends a CTCP ACTION to the channel. See Commands.Action().
+func (h *ChannelHandle) Action(message string) error {
+	return h.c.Cmd.Action(h.name, message)
+}
+
+// SetTopic sets the topic of the channel. See Commands.Topic().
+func (h *ChannelHandle) SetTopic(topic string) {
+	h.c.Cmd.Topic(h.name, topic)
+}
@@ -0,0 +1,79 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestChannelHandle(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test"})
+
+	ops := c.Channel("#ops")
+	other := c.Channel("#ops")
+
+	var lifecycle, messages []string
+	ops.OnJoin(func(c *Client, e Event) { lifecycle = append(lifecycle, "joined") })
+	ops.OnLeave(func(c *Client, e Event) { lifecycle = append(lifecycle, "left "+e.Command) })
+	ops.Subscribe(PRIVMSG, func(c *Client, e Event) { messages = append(messages, e.Trailing) })
+
+	var otherMessages int
+	other.Subscribe(PRIVMSG, func(c *Client, e Event) { otherMessages++ })
+
+	c.RunHandlers(ParseEvent(":test!user@host JOIN #ops"))
+	c.RunHandlers(ParseEvent(":nick!user@host JOIN #OPS"))
+	c.RunHandlers(ParseEvent(":nick!user@host PRIVMSG #ops :hello"))
+	c.RunHandlers(ParseEvent(":nick!user@host PRIVMSG #other :elsewhere"))
+
+	if !ops.Joined() {
+		t.Fatal("ChannelHandle.Joined() == false after joining")
+	}
+
+	var nicks []string
+	for _, user := range ops.Members() {
+		nicks = append(nicks, user.Nick)
+	}
+	if want := []string{"nick", "test"}; !reflect.DeepEqual(nicks, want) {
+		t.Fatalf("ChannelHandle.Members() == %v, want %v", nicks, want)
+	}
+
+	for len(c.tx) > 0 {
+		<-c.tx
+	}
+
+	if err := ops.Send("hi"); err != nil {
+		t.Fatalf("ChannelHandle.Send() = %v", err)
+	}
+	if got := (<-c.tx).event.String(); got != "PRIVMSG #ops :hi" {
+		t.Fatalf("ChannelHandle.Send() sent %q", got)
+	}
+
+	if err := ops.Part("bye"); err != nil {
+		t.Fatalf("ChannelHandle.Part() = %v", err)
+	}
+	if got := (<-c.tx).event.String(); got != "PART #ops :bye" {
+		t.Fatalf("ChannelHandle.Part() sent %q", got)
+	}
+
+	c.RunHandlers(ParseEvent(":op!user@host KICK #ops test :go away"))
+
+	if want := []string{"joined", "left KICK"}; !reflect.DeepEqual(lifecycle, want) {
+		t.Fatalf("lifecycle handlers executed for %v, want %v", lifecycle, want)
+	}
+
+	if want := []string{"hello"}; !reflect.DeepEqual(messages, want) {
+		t.Fatalf("subscribed handler received %v, want %v", messages, want)
+	}
+
+	if n := ops.Close(); n != 3 {
+		t.Fatalf("ChannelHandle.Close() removed %d handlers, want 3", n)
+	}
+
+	c.RunHandlers(ParseEvent(":nick!user@host PRIVMSG #ops :again"))
+	if len(messages) != 1 || otherMessages != 2 {
+		t.Fatalf("after Close(), handle received %d messages and other handle %d, want 1 and 2", len(messages), otherMessages)
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// listRetries is how many times Commands.ListWait() retries the LIST when
+// the server is too busy (RPL_TRYAGAIN), before giving up.
+const listRetries = 3
+
+// ListEntry is a single channel returned by LIST, see Commands.ListWait().
+type ListEntry struct {
+	// Channel is the name of the channel.
+	Channel string `json:"channel"`
+	// Users is the amount of (visible) users in the channel.
+	Users int `json:"users"`
+	// Topic is the topic of the channel, which may include the channel
+	// modes on some networks (e.g. "[+nt] topic").
+	Topic string `json:"topic"`
+}
+
+// ListOptions are the options for Commands.ListWait(). Filters are sent to
+// the server if it supports them (via ISUPPORT ELIST), and are always
+// applied to the results, so they work on any server.
+type ListOptions struct {
+	// Masks, if supplied, limits the results to channels matching any of
+	// the masks, which may contain globs (see Glob()), e.g. "#girc*".
+	Masks []string
+	// MinUsers, if non-zero, is the minimum amount of users of channels to
+	// return.
+	MinUsers int
+	// MaxUsers, if non-zero, is the maximum amount of users of channels to
+	// return.
+	MaxUsers int
+	// Callback, if supplied, is called with each channel as it is received,
+	// rather than collecting the channels, which is useful for networks with
+	// tens of thousands of channels. Callback is called from a handler, and
+	// must not block.
+	Callback func(entry ListEntry)
+	// RetryDelay is how long to wait before retrying the LIST if the server
+	// asks us to try again later (RPL_TRYAGAIN), which it may do when it is
+	// busy. Defaults to 5 seconds.
+	RetryDelay time.Duration
+}
+
+// match returns true if entry matches the filters of the options. Masks
+// are compared using the server's CASEMAPPING.
+func (opts *ListOptions) match(c *Client, entry ListEntry) bool {
+	if opts.MinUsers > 0 && entry.Users < opts.MinUsers {
+		return false
+	}
+
+	if opts.MaxUsers > 0 && entry.Users > opts.MaxUsers {
+		return false
+	}
+
+	if len(opts.Masks) == 0 {
+		return true
+	}
+
+	for _, mask := range opts.Masks {
+		if Glob(c.fold(entry.Channel), c.fold(mask)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// listParams returns the LIST parameters for opts, only including the
+// filters which the server supports.
+func (c *Client) listParams(opts *ListOptions) []string {
+	elist, _ := c.GetServerOption("ELIST")
+	elist = strings.ToUpper(elist)
+
+	var filters []string
+
+	for _, mask := range opts.Masks {
+		if strings.ContainsAny(mask, "*?") && !strings.Contains(elist, "M") {
+			// Without mask support, we need the full list to filter it
+			// ourselves.
+			filters = nil
+			break
+		}
+
+		filters = append(filters, mask)
+	}
+
+	if strings.Contains(elist, "U") {
+		if opts.MinUsers > 0 {
+			filters = append(filters, ">"+strconv.Itoa(opts.MinUsers-1))
+		}
+
+		if opts.MaxUsers > 0 {
+			filters = append(filters, "<"+strconv.Itoa(opts.MaxUsers+1))
+		}
+	}
+
+	if len(filters) == 0 {
+		return nil
+	}
+
+	return []string{strings.Join(filters, ",")}
+}
+
+// ListWait sends a LIST query, and waits for the server to respond (or ctx
+// to be cancelled), returning the channels which match the filters of opts
+// (which may be nil). If opts.Callback is supplied, channels are passed to
+// it instead, and nil is returned. If the server is busy (RPL_TRYAGAIN), the
+// LIST is retried a few times, after which an ErrEvent is returned. This
+// should not be called from non-background handlers, as the response can't
+// be processed until they return.
+func (cmd *Commands) ListWait(ctx context.Context, opts *ListOptions) ([]ListEntry, error) {
+	if opts == nil {
+		opts = &ListOptions{}
+	}
+
+	delay := opts.RetryDelay
+	if delay <= 0 {
+		delay = 5 * time.Second
+	}
+
+	params := cmd.c.listParams(opts)
+
+	for attempt := 0; ; attempt++ {
+		events, err := cmd.c.Do(ctx, &Event{Command: LIST, Params: params},
+			func(e *Event) bool {
+				return e.Command == RPL_LISTEND ||
+					(e.Command == RPL_TRYAGAIN && len(e.Params) > 1 && e.Params[1] == LIST)
+			},
+			func(e *Event) bool {
+				entry, ok := parseListEntry(e)
+				if !ok || !opts.match(cmd.c, entry) {
+					return false
+				}
+
+				// Entries are passed to the callback as they're received.
+				if opts.Callback != nil {
+					opts.Callback(entry)
+					return false
+				}
+
+				return true
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if last := events[len(events)-1]; last.Command == RPL_TRYAGAIN {
+			if attempt >= listRetries {
+				return nil, &ErrEvent{Event: last}
+			}
+
+			cmd.c.logger.Info("server is busy, retrying LIST", "delay", delay)
+
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+
+			continue
+		}
+
+		var entries []ListEntry
+		for _, e := range events[:len(events)-1] {
+			entry, _ := parseListEntry(e)
+			entries = append(entries, entry)
+		}
+
+		return entries, nil
+	}
+}
+
+// parseListEntry returns the channel of a RPL_LIST reply.
+func parseListEntry(e *Event) (entry ListEntry, ok bool) {
+	if e.Command != RPL_LIST || len(e.Params) < 3 {
+		return entry, false
+	}
+
+	entry = ListEntry{Channel: e.Params[1], Topic: e.Trailing}
+	entry.Users, _ = strconv.Atoi(e.Params[2])
+
+	return entry, true
+}
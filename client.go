@@ -5,15 +5,15 @@
 package girc
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"log"
-	"runtime"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -23,12 +23,37 @@ import (
 type Client struct {
 	// Config represents the configuration. Please take extra caution in that
 	// entries in this are not edited while the client is connected, to prevent
-	// data races. This is NOT concurrent safe to update.
+	// data races. This is NOT concurrent safe to update, see
+	// Client.UpdateConfig() for the entries which can be changed safely.
 	Config Config
-	// rx is a buffer of events waiting to be processed.
+	// confMu guards the entries of Config which can be changed with
+	// Client.UpdateConfig().
+	confMu sync.RWMutex
+	// rx is a buffer of events waiting to be processed, see
+	// Config.EventQueueSize.
 	rx chan *Event
+	// status is the state of the connection, see Client.Status().
+	status connStatus
+	// closed is closed once the current connection has been cleaned up,
+	// see Client.Shutdown().
+	closed chan struct{}
+	// bgRunning is the amount of background handlers which are running (or
+	// queued), and bgIdle is closed once there are none. Both are guarded by
+	// mu, see Client.Shutdown().
+	bgRunning int
+	bgIdle    chan struct{}
+	// rxq is the state of the events which didn't fit in rx, see
+	// Config.EventQueuePolicy.
+	rxq eventQueue
 	// tx is a buffer of events waiting to be sent.
-	tx chan *Event
+	tx chan *outgoing
+	// txControl is a buffer of control events (see isControlEvent()) waiting
+	// to be sent, which are sent before those in tx.
+	txControl chan *outgoing
+	// sendq tracks the events waiting to be sent, see Client.SendQueue().
+	sendq *sendQueue
+	// stats are counters of the activity of the client, see Client.Stats().
+	stats *stats
 	// state represents the throw-away state for the irc session.
 	state *state
 	// initTime represents the creation time of the client.
@@ -39,6 +64,15 @@ type Client struct {
 	CTCP *CTCP
 	// Cmd contains various helper methods to interact with the server.
 	Cmd *Commands
+	// Services contains helper methods to interact with network services,
+	// like NickServ and ChanServ.
+	Services *Services
+	// DCC manages direct connections with other users (e.g. DCC CHAT), see
+	// Config.DCC.
+	DCC *DCC
+	// Session is a key-value store for connection-scoped state, which is
+	// cleared each time the client connects.
+	Session *Session
 	// mu is the mux used for connections/disconnections from the server,
 	// so multiple threads aren't trying to connect at the same time, and
 	// vice versa.
@@ -51,8 +85,36 @@ type Client struct {
 	// means we're either connected, connecting, or cleaning up. This should
 	// be guarded with Client.mu.
 	conn *ircConn
-	// debug is used if a writer is supplied for Client.Config.Debugger.
-	debug *log.Logger
+	// logger receives the logs of the client, see Config.Logger and
+	// Config.Debug.
+	logger Logger
+	// shed is the load shedding state, see Config.LoadShedding.
+	shed shedder
+	// attempts is the amount of connection attempts made, used to rotate
+	// identities. This should be guarded with Client.mu.
+	attempts int
+	// identity is the identity used for the current (or last) connection
+	// attempt. This should be guarded with Client.mu.
+	identity Identity
+	// serverErr is the reason the server closed the current (or last)
+	// connection, see Client.LastServerError(). This should be guarded with
+	// Client.mu.
+	serverErr *ServerError
+	// rejoin is used to rejoin channels after reconnecting, see
+	// Config.Rejoin.
+	rejoin rejoiner
+	// whoxToken is used to allocate WHOX query tokens, see
+	// Client.WhoxToken(). This should be accessed atomically.
+	whoxToken uint32
+	// sendHooks are executed for each event before it's written, see
+	// Client.OnSend(). This should be guarded with Client.mu.
+	sendHooks []func(client *Client, event *Event) bool
+	// bgWorkers limits the amount of background handlers running at once,
+	// see Config.HandlerWorkers. nil if unlimited.
+	bgWorkers *workerPool
+	// traces are the handler execution traces of recent events, see
+	// Config.TraceHandlers. nil if tracing is disabled.
+	traces *traceLog
 }
 
 // Config contains configuration options for an IRC client
@@ -60,11 +122,15 @@ type Config struct {
 	// Server is a host/ip of the server you want to connect to. This only
 	// has an affect during the dial process
 	Server string
-	// ServerPass is the server password used to authenticate. This only has
-	// an affect during the dial process.
+	// ServerPass is the server password, sent with PASS before registering.
+	// This is the password of the connection itself (e.g. for bouncers, or
+	// private servers), and not of your account -- use SASL (or
+	// NickServPassword) to identify with services. This only has an affect
+	// during the dial process.
 	ServerPass string
-	// Port is the port that will be used during server connection. This only
-	// has an affect during the dial process.
+	// Port is the port that will be used during server connection. If 0, it
+	// defaults to 6697 if SSL is enabled, and 6667 otherwise. This only has
+	// an affect during the dial process.
 	Port int
 	// Nick is an rfc-valid nickname used during connection. This only has an
 	// affect during the dial process.
@@ -73,7 +139,8 @@ type Config struct {
 	// server is used. This only has an affect during the dial process.
 	User string
 	// Name is the "realname" that's used during connection. This only has an
-	// affect during the dial process.
+	// affect during the dial process. Defaults to BotInfo if set, otherwise
+	// to User.
 	Name string
 	// SASL contains the necessary authentication data to authenticate
 	// with SASL. See the documentation for SASLMech for what is currently
@@ -86,9 +153,17 @@ type Config struct {
 	// you can simply use a IPv4/IPv6 address directly. This only has an
 	// affect during the dial process and will not work with DialerConnect().
 	Bind string
+	// ParallelDial, if greater than 1, dials up to that many of the
+	// addresses the server resolves to at once (alternating between IPv6
+	// and IPv4), keeping the first connection which succeeds and cancelling
+	// the rest. This significantly reduces the time to connect when an
+	// address family or endpoint is silently blackholed. This only has an
+	// affect during the dial process and will not work with DialerConnect().
+	ParallelDial int
 	// SSL allows dialing via TLS. See TLSConfig to set your own TLS
-	// configuration (e.g. to not force hostname checking). This only has an
-	// affect during the dial process.
+	// configuration (e.g. to not force hostname checking). If Port isn't
+	// supplied, this also selects the standard TLS port (6697). This only has
+	// an affect during the dial process.
 	SSL bool
 	// TLSConfig is an optional user-supplied tls configuration, used during
 	// socket creation to the server. SSL must be enabled for this to be used.
@@ -97,6 +172,37 @@ type Config struct {
 	// AllowFlood allows the client to bypass the rate limit of outbound
 	// messages.
 	AllowFlood bool
+	// AdaptiveRate, if true, slows down the rate limit of outbound messages
+	// when the server indicates we are sending too quickly (e.g. "target
+	// change too fast", RPL_TRYAGAIN, or growing lag to PING), and
+	// gradually recovers once it stops doing so. A RATE_ADJUSTED event is
+	// emitted for each adjustment. This has no effect with AllowFlood.
+	AdaptiveRate bool
+	// TargetRate, if supplied, additionally limits the rate of messages sent
+	// to each channel or user, so a busy target can't starve the others. See
+	// TargetRate for more information. This applies regardless of
+	// AllowFlood.
+	TargetRate *TargetRate
+	// DropQueueOnDisconnect, if true, drops the events still waiting to be
+	// sent when the client disconnects, rather than sending a stale backlog
+	// once it reconnects. See also Client.DropQueue().
+	DropQueueOnDisconnect bool
+	// MaxSendQueue is the maximum amount of events waiting to be sent (see
+	// Client.SendQueue()). Once it's reached, new events are rejected with
+	// ErrQueueFull: Client.SendCtx() returns it, and it's passed to
+	// HandleError and emitted as SEND_FAILED, like other errors sending
+	// events. Control traffic (see Client.Send()) is always queued, as it's
+	// written right away. Defaults to 1000, use -1 for no limit.
+	MaxSendQueue int
+	// LegacyCompat enables a compatibility mode for ancient or embedded
+	// servers: lines with extra spaces between params, numerics which
+	// aren't zero-padded, and RPL_NAMREPLY without the channel type are
+	// parsed into the normal event model, unparseable lines are skipped
+	// instead of causing a disconnect, and the end of the MOTD is treated as
+	// the end of registration if RPL_WELCOME was never sent. Servers which
+	// don't send RPL_ISUPPORT (005) are supported regardless, using RFC1459
+	// defaults.
+	LegacyCompat bool
 	// GlobalFormat enables passing through all events which have trailing
 	// text through the color Fmt() function, so you don't have to wrap
 	// every response in the Fmt() method.
@@ -104,23 +210,219 @@ type Config struct {
 	// Note that this only actually applies to PRIVMSG, NOTICE and TOPIC
 	// events, to ensure it doesn't clobber unwanted events.
 	GlobalFormat bool
+	// Truncate determines what happens to PRIVMSG and NOTICE messages which
+	// are too long to be relayed by the server in full (taking into account
+	// the source the server prepends), see TruncatePolicy. Defaults to
+	// TruncateNone, leaving it up to the server. With TruncateReject,
+	// Commands.Message(), Commands.Notice() (and the helpers using them)
+	// return an ErrMessageTooLong.
+	Truncate TruncatePolicy
+	// Strict enables validation of all events sent with Client.Send() (and
+	// Commands) against the limits of the protocol: valid commands, no
+	// spaces, NUL, CR or LF in params, valid tags which aren't too long, the
+	// maximum line length, and valid UTF-8 if the server advertises UTF8ONLY.
+	// Events which don't conform are rejected with an ErrProtocolViolation,
+	// rather than being silently altered or sent as is.
+	Strict bool
 	// Debug is an optional, user supplied location to log the raw lines
-	// sent from the server, or other useful debug logs. Defaults to
-	// ioutil.Discard. For quick debugging, this could be set to os.Stdout.
+	// sent from the server, or other useful debug logs, as text. Defaults
+	// to ioutil.Discard. For quick debugging, this could be set to
+	// os.Stdout. This is ignored if Logger is supplied.
 	Debug io.Writer
+	// Logger, if supplied, receives the logs of the client (the same as
+	// Debug), with structured fields, so they can be routed into the
+	// logging of the application. A *slog.Logger can be used as is, and
+	// other structured loggers (e.g. logrus) can be adapted with LogFunc.
+	Logger Logger
+	// Metrics, if supplied, receives the activity of the client as it
+	// happens (lines read and written, events dispatched, handler execution
+	// times and connections), e.g. to build a Prometheus collector. See
+	// also Client.Stats().
+	Metrics MetricsHook
+	// Tracer, if supplied, traces the processing of each event, from being
+	// read from the server to being processed by its handlers, e.g. to
+	// create OpenTelemetry spans, so slow handlers can be found. See
+	// TraceFunc for a simple callback, and TraceHandlers for keeping the
+	// traces of recent events in the client instead.
+	Tracer Tracer
 	// Out is used to write out a prettified version of incoming events. For
 	// example, channel JOIN/PART, PRIVMSG/NOTICE, KICk, etc. Useful to get
 	// a brief output of the activity of the client. If you are looking to
 	// log raw messages, look at a handler and girc.ALLEVENTS and the relevant
 	// Event.Bytes() or Event.String() methods.
 	Out io.Writer
+	// OnRaw, if supplied, is called with the exact lines read from (in is
+	// true) and written to the server, without the line ending, e.g. for
+	// protocol captures, audits or troubleshooting. Unlike Debug, these are
+	// the lines as they are on the wire, and nothing else. Lines written
+	// for sensitive events (e.g. PASS, or identifying with NickServ, see
+	// Event.Sensitive) are redacted, unless RawSensitive is true. OnRaw is
+	// called from the read and write loops, so it should return quickly.
+	OnRaw func(in bool, line string)
+	// RawSensitive disables the redaction of sensitive lines passed to
+	// OnRaw. Be careful, as these contain passwords.
+	RawSensitive bool
+	// TraceHandlers, if greater than 0, records which handlers processed
+	// each event, in which order, how long they took and their outcome,
+	// keeping the traces of the last TraceHandlers events. The traces can be
+	// retrieved by sequence number or msgid (see Client.Trace(),
+	// Client.TraceMsgID() and Client.Traces()), which is useful to debug why
+	// a handler didn't fire (or fired twice) in complex bots.
+	TraceHandlers int
+	// HandlerWorkers, if greater than 0, limits the amount of background
+	// handlers (see Caller.AddBg() and CTCP.SetBg()) running at once, so a
+	// flood of events can't spawn an unbounded amount of goroutines. Once
+	// the limit is reached, further background handlers are queued until
+	// one returns, without holding up the processing of events. Temporary
+	// handlers (see Caller.AddTmp(), which Client.Do() and e.g.
+	// Commands.WhoisWait() use) don't count towards the limit, as they
+	// wait for later events.
+	HandlerWorkers int
 	// RecoverFunc is called when a handler throws a panic. If RecoverFunc is
 	// set, the panic will be considered recovered, otherwise the client will
 	// panic. Set this to DefaultRecoverHandler if you don't want the client
 	// to panic, however you don't want to handle the panic yourself.
-	// DefaultRecoverHandler will log the panic to Debug or os.Stdout if
-	// Debug is unset.
+	// DefaultRecoverHandler will log the panic (with the event which
+	// triggered it, and the call trace) to Debug or os.Stdout if Debug is
+	// unset. This covers handlers, middleware (see Caller.Use()) and CTCP
+	// handlers. To re-raise the panic after handling it (e.g. to crash and
+	// be restarted), panic from within RecoverFunc.
 	RecoverFunc func(c *Client, e *HandlerError)
+	// HandleError, if supplied, is called with the errors which occur while
+	// sending events (see Client.Send()), as an *ErrSendFailed, including
+	// events rejected by Config.Strict, and errors writing to the server
+	// (which also cause the client to disconnect). It is called from the
+	// goroutine sending the event, so it should not block. It's also called
+	// with a *ServerError when the server closes the connection with an
+	// ERROR (e.g. when banned or throttled), or kills the client, from the
+	// goroutine handling events.
+	HandleError func(c *Client, err error)
+	// NoReconnect are the categories of ServerError (e.g. ServerErrorBanned
+	// and ServerErrorKilled) after which the client must not be
+	// reconnected automatically, see Client.ShouldReconnect(). Pool follows
+	// this, and so should any other loop reconnecting the client. Defaults
+	// to always reconnecting.
+	NoReconnect []string
+	// LoadShedding, if supplied, allows the client to temporarily drop
+	// low-value events (JOIN/PART/QUIT during netsplits, MOTD lines, etc)
+	// when the incoming event queue stays backed up. See LoadShedding for
+	// more information, and Client.ShedStats() for counters.
+	LoadShedding *LoadShedding
+	// EventQueueSize is the amount of incoming events which can be queued
+	// while handlers are busy, before EventQueuePolicy is applied. Defaults
+	// to 25. This is fixed once the client has been created.
+	EventQueueSize int
+	// EventQueuePolicy determines what happens once the incoming event
+	// queue is full, i.e. when handlers can't keep up with the server:
+	// reading from the server is blocked (QueueBlock, the default, which may
+	// cause the connection to time out), the oldest events are dropped
+	// (QueueDropOldest), or the events spill over into a buffer which grows
+	// as needed (QueueSpill). See Client.EventQueue() for the state of the
+	// queue.
+	EventQueuePolicy EventQueuePolicy
+	// ReplyMode determines whether replies sent with Commands.Reply() and
+	// Commands.ReplyTo() (and by extension, the cmdhandler package) use
+	// PRIVMSG or NOTICE. Defaults to ReplyPrivmsg. Replies to a NOTICE are
+	// always sent as a NOTICE.
+	ReplyMode ReplyMode
+	// ReplyModeTargets allows overriding ReplyMode for specific channels or
+	// users, keyed by the channel or nickname.
+	ReplyModeTargets map[string]ReplyMode
+	// BouncerNetwork is the soju bouncer network ID which the connection
+	// should be bound to during registration (see the
+	// soju.im/bouncer-networks extension). If empty, the bouncer's default
+	// behavior applies. See Client.BouncerNetworks() to enumerate networks,
+	// and Client.BindNetwork() to create a client for each of them.
+	BouncerNetwork string
+	// Identities, if supplied, are rotated through on each successive
+	// connection attempt (e.g. when reconnecting after Connect() returns),
+	// starting with the first. Empty fields fall back to Nick, User and Name.
+	// The identity being used is reported via the CONNECTING event, and
+	// Client.Identity().
+	Identities []Identity
+	// IdentityFunc, if supplied, is called before each connection attempt
+	// with the attempt number (starting at 0), and returns the identity to
+	// use. This takes precedence over Identities. Empty fields fall back to
+	// Nick, User and Name.
+	IdentityFunc func(attempt int) Identity
+	// BanRefreshAge, if set, causes Commands.Ban() and Commands.KickBan() to
+	// refresh the target's host with a WHO query before building the ban
+	// mask, if the tracked host is older than BanRefreshAge (or unknown).
+	// This prevents bans being built from stale hosts (e.g. before the user
+	// was cloaked), which would never match. Tracking must be enabled.
+	BanRefreshAge time.Duration
+	// BanRefreshTimeout is how long to wait for the WHO response when
+	// refreshing hosts (see BanRefreshAge), after which the tracked host is
+	// used as-is. Defaults to 5 seconds.
+	BanRefreshTimeout time.Duration
+	// AutoMode, if supplied, automatically gives users channel modes (e.g.
+	// voice or op) when they join channels where we have the permissions to
+	// do so, if they match its rules. See AutoMode for more information.
+	AutoMode *AutoMode
+	// Rejoin, if true, remembers the channels the client was in (and their
+	// keys, if known), and rejoins them once reconnected (see the CONNECTED
+	// event). Channels which are temporarily unavailable (e.g. due to
+	// netsplits) are retried with backoff. Keys are forgotten after parting
+	// the channel, being kicked from it, or the key being removed (-k).
+	// Tracking must be enabled.
+	Rejoin bool
+	// ExtractURLs, if true, emits a URL_SEEN event for each PRIVMSG which
+	// contains http(s) URLs, with the cleaned URLs (see ExtractURLs()), the
+	// source of the message and the target. This works even if tracking is
+	// disabled.
+	ExtractURLs bool
+	// KickRejoin, if supplied, automatically rejoins channels after the
+	// client is kicked from them, emitting a KICKED_REJOINING event before
+	// each attempt. Tracking must be enabled.
+	KickRejoin *KickRejoin
+	// StateStream, if supplied, streams changes to the tracked channel
+	// state as they happen (e.g. as JSON, to feed a live dashboard). See
+	// StateStream for more information. Tracking must be enabled.
+	StateStream *StateStream
+	// ReOp, if supplied, requests channel operator status back (e.g. from
+	// ChanServ) when the client loses it in one of the managed channels,
+	// or joins one without it. See ReOp for more information. Tracking must
+	// be enabled.
+	ReOp *ReOp
+	// Priming, if supplied, configures the queries sent after joining a
+	// channel to populate its tracked state (e.g. WHO, MODE, the ban list),
+	// emitting a CHANNEL_READY event once all replies have been received.
+	// See Priming for more information. Tracking must be enabled.
+	Priming *Priming
+	// DCC, if supplied, enables DCC (Direct Client-to-Client) support,
+	// handling offers from other users, and allowing sessions to be offered
+	// to them. See DCC and DCCConfig for more information.
+	DCC *DCCConfig
+	// Aliases are shortcuts for the commands of raw lines sent with
+	// Commands.SendRaw() or Commands.SendRawBatch(), keyed by the
+	// (case-insensitive) alias, e.g. "CS" to "PRIVMSG ChanServ :", or
+	// "UMODE" to "MODE $nick". "$nick" is replaced with the current
+	// nickname, and the remainder of the line is appended to the expansion.
+	// This is useful when girc is used as the backend of interactive
+	// clients or ops tooling.
+	Aliases map[string]string
+	// ServiceMasks are additional "nick!user@host" masks (which may contain
+	// globs, see Glob()) of network services pseudo-clients, for networks
+	// where the builtin detection doesn't work. See Client.IsService().
+	ServiceMasks []string
+	// Store, if supplied, is used to persist runtime lists across restarts,
+	// like the keys used to join channels (see Commands.JoinKey(), which
+	// are reused by Commands.Join() and Rejoin, if enabled). See FileStore
+	// for a file-backed implementation.
+	Store Store
+	// NickReclaim, if supplied, periodically attempts to reclaim the
+	// configured nickname (see Nick and Identities) once connected, if it was
+	// in use when connecting, emitting a NICK_RECLAIMED event once it has
+	// been reclaimed. See NickReclaim for more information. Tracking must be
+	// enabled.
+	NickReclaim *NickReclaim
+	// NickServPassword, if supplied, is used to automatically identify with
+	// NickServ when it asks us to (e.g. "This nickname is registered"), for
+	// networks which don't support SASL. NickServAccount is the account
+	// to identify as, and defaults to the current nickname. See
+	// Client.Services for other services helpers.
+	NickServPassword string
+	NickServAccount  string
 	// SupportedCaps are the IRCv3 capabilities you would like the client to
 	// support on top of the ones which the client already supports (see
 	// cap.go for which ones the client enables by default). Only use this
@@ -129,8 +431,27 @@ type Config struct {
 	SupportedCaps map[string][]string
 	// Version is the application version information that will be used in
 	// response to a CTCP VERSION, if default CTCP replies have not been
-	// overwritten or a VERSION handler was already supplied.
+	// overwritten or a VERSION handler was already supplied. Takes
+	// precedence over BotInfo.
 	Version string
+	// BotInfo identifies the application using the client (name, version
+	// and contact), and is used for the CTCP VERSION and SOURCE replies, the
+	// default quit message, and the default realname. Many networks require
+	// bots to be identifiable.
+	BotInfo BotInfo
+	// DisableCTCPDefaults, if true, doesn't register the default CTCP
+	// handlers (e.g. VERSION and TIME), nor reply to unknown CTCP queries
+	// with ERRMSG, e.g. for privacy. Individual default handlers can instead
+	// be overridden with CTCP.Set(), removed with CTCP.Clear(), and restored
+	// with CTCP.Reset().
+	DisableCTCPDefaults bool
+	// CTCPLimit, if greater than 0, limits the CTCP queries handled per
+	// source to CTCPLimit within each CTCPWindow (defaults to 10 seconds),
+	// so a flood of CTCP queries can't be used to make the client flood the
+	// server with replies. Queries beyond the limit are ignored, and a
+	// THROTTLED event is emitted with "ctcp" as the name (see Throttle()).
+	CTCPLimit  int
+	CTCPWindow time.Duration
 	// PingDelay is the frequency between when the client sends a keep-alive
 	// PING to the server, and awaits a response (and times out if the server
 	// doesn't respond in time). This should be between 20-600 seconds. See
@@ -157,36 +478,243 @@ type Config struct {
 // is invalid.
 type ErrInvalidConfig struct {
 	Conf Config // Conf is the configuration that was not valid.
-	err  error
+	// Errs are all of the problems found with the configuration, each
+	// usually a *ConfigError. To find a specific problem, check each of
+	// them, e.g. with a type assertion.
+	Errs []error
 }
 
-func (e ErrInvalidConfig) Error() string { return "invalid configuration: " + e.err.Error() }
+func (e ErrInvalidConfig) Error() string {
+	var buf bytes.Buffer
+	buf.WriteString("invalid configuration: ")
+
+	for i := 0; i < len(e.Errs); i++ {
+		if i > 0 {
+			buf.WriteString("; ")
+		}
+
+		buf.WriteString(e.Errs[i].Error())
+	}
+
+	return buf.String()
+}
+
+// ConfigError is a problem with a single option of a Config, see
+// ErrInvalidConfig.
+type ConfigError struct {
+	// Field is the name of the option, e.g. "Nick" or "SASL".
+	Field string
+	// Reason describes the problem, e.g. `bad nickname specified: "1nick"`.
+	Reason string
+}
+
+func (e *ConfigError) Error() string { return e.Reason }
+
+// Validate checks the configuration for problems which would prevent the
+// client from connecting (or contradictory options, which would silently be
+// ignored), returning an *ErrInvalidConfig describing all of the problems
+// found (each as a *ConfigError), or nil. This is called by Connect() and
+// DialerConnect(), but can be used to check a configuration beforehand.
+//
+// As the NICKLEN of the network isn't known before connecting, nicknames
+// are validated with IsValidNick(). Client.UpdateConfig() also checks their
+// length, see Client.IsValidNick().
+func (conf *Config) Validate() error {
+	return conf.validate(0)
+}
+
+// validate is Validate(), also rejecting nicknames longer than nickLen
+// (unless nickLen is 0), see Client.nickLen().
+func (conf *Config) validate(nickLen int) error {
+	var errs []error
+	invalid := func(field, format string, args ...interface{}) {
+		errs = append(errs, &ConfigError{Field: field, Reason: fmt.Sprintf(format, args...)})
+	}
 
-// isValid checks some basic settings to ensure the config is valid.
-func (conf *Config) isValid() error {
 	if conf.Server == "" {
-		return &ErrInvalidConfig{Conf: *conf, err: errors.New("empty server")}
+		invalid("Server", "empty server")
+	}
+
+	// A port of 0 defaults to 6667, or 6697 with SSL (see port()).
+	if conf.Port != 0 && (conf.Port < 21 || conf.Port > 65535) {
+		invalid("Port", "port %d outside valid range (21-65535)", conf.Port)
 	}
 
-	// Default port to 6667 (the standard IRC port).
-	if conf.Port == 0 {
-		conf.Port = 6667
+	if strings.ContainsAny(conf.Server, " \r\n") {
+		invalid("Server", "bad server specified: %q", conf.Server)
 	}
 
-	if conf.Port < 21 || conf.Port > 65535 {
-		return &ErrInvalidConfig{Conf: *conf, err: errors.New("port outside valid range (21-65535)")}
+	if strings.ContainsAny(conf.ServerPass, " \r\n") {
+		invalid("ServerPass", "ServerPass must not contain spaces or line breaks")
 	}
 
-	if !IsValidNick(conf.Nick) {
-		return &ErrInvalidConfig{Conf: *conf, err: errors.New("bad nickname specified")}
+	if !isValidNick(conf.Nick, nickLen) {
+		invalid("Nick", "bad nickname specified: %q", conf.Nick)
 	}
 	if !IsValidUser(conf.User) {
-		return &ErrInvalidConfig{Conf: *conf, err: errors.New("bad user/ident specified")}
+		invalid("User", "bad user/ident specified: %q", conf.User)
+	}
+	if strings.ContainsAny(conf.Name, "\r\n") {
+		invalid("Name", "bad realname specified: %q", conf.Name)
+	}
+
+	for i := 0; i < len(conf.Identities); i++ {
+		if conf.Identities[i].Nick != "" && !isValidNick(conf.Identities[i].Nick, nickLen) {
+			invalid("Identities", "bad nickname in identity %d: %q", i, conf.Identities[i].Nick)
+		}
+		if conf.Identities[i].User != "" && !IsValidUser(conf.Identities[i].User) {
+			invalid("Identities", "bad user/ident in identity %d: %q", i, conf.Identities[i].User)
+		}
+	}
+
+	switch sasl := conf.SASL.(type) {
+	case *SASLPlain:
+		if sasl.User == "" || sasl.Pass == "" {
+			invalid("SASL", "SASL PLAIN requires both a user and password")
+		}
+	case *SASLExternal:
+		if !conf.SSL {
+			invalid("SASL", "SASL EXTERNAL requires SSL (with a client certificate)")
+		}
+	}
+
+	if conf.TLSConfig != nil && !conf.SSL {
+		invalid("TLSConfig", "TLSConfig is ignored unless SSL is enabled")
+	}
+
+	if conf.disableTracking {
+		var needs []string
+
+		// SASL and capabilities are negotiated by the handlers used for
+		// tracking.
+		if conf.SASL != nil {
+			needs = append(needs, "SASL")
+		}
+		if len(conf.SupportedCaps) > 0 {
+			needs = append(needs, "SupportedCaps")
+		}
+		if conf.BouncerNetwork != "" {
+			needs = append(needs, "BouncerNetwork")
+		}
+		if conf.Rejoin {
+			needs = append(needs, "Rejoin")
+		}
+		if conf.KickRejoin != nil {
+			needs = append(needs, "KickRejoin")
+		}
+		if conf.AutoMode != nil {
+			needs = append(needs, "AutoMode")
+		}
+		if conf.ReOp != nil {
+			needs = append(needs, "ReOp")
+		}
+		if conf.Priming != nil {
+			needs = append(needs, "Priming")
+		}
+		if conf.StateStream != nil {
+			needs = append(needs, "StateStream")
+		}
+		if conf.NickReclaim != nil {
+			needs = append(needs, "NickReclaim")
+		}
+		if conf.BanRefreshAge > 0 {
+			needs = append(needs, "BanRefreshAge")
+		}
+
+		for _, option := range needs {
+			invalid(option, "%s requires tracking, which is disabled", option)
+		}
+	}
+
+	for alias := range conf.Aliases {
+		if alias == "" || strings.ContainsAny(alias, " \r\n") {
+			invalid("Aliases", "bad alias specified: %q", alias)
+		}
+	}
+
+	if conf.TraceHandlers < 0 {
+		invalid("TraceHandlers", "TraceHandlers must not be negative")
+	}
+
+	if conf.ParallelDial < 0 {
+		invalid("ParallelDial", "ParallelDial must not be negative")
+	}
+
+	if conf.HandlerWorkers < 0 {
+		invalid("HandlerWorkers", "HandlerWorkers must not be negative")
+	}
+
+	if conf.ReOp != nil && !conf.ReOp.ChanServ && conf.ReOp.Func == nil {
+		invalid("ReOp", "ReOp requires either ChanServ or Func")
+	}
+
+	if conf.NickReclaim != nil && conf.NickReclaim.Ghost && conf.NickReclaim.Password == "" {
+		invalid("NickReclaim", "NickReclaim.Ghost requires NickReclaim.Password")
+	}
+
+	if conf.NickServAccount != "" && conf.NickServPassword == "" {
+		invalid("NickServAccount", "NickServAccount requires NickServPassword")
+	}
+
+	if conf.EventQueueSize < 0 {
+		invalid("EventQueueSize", "EventQueueSize must not be negative")
+	}
+
+	if conf.MaxSendQueue < -1 {
+		invalid("MaxSendQueue", "MaxSendQueue must be -1 (no limit), or not negative")
+	}
+
+	if conf.EventQueuePolicy < QueueBlock || conf.EventQueuePolicy > QueueSpill {
+		invalid("EventQueuePolicy", "unknown EventQueuePolicy %d", conf.EventQueuePolicy)
+	}
+
+	queueSize := conf.EventQueueSize
+	if queueSize == 0 {
+		queueSize = defaultEventQueueSize
+	}
+
+	if conf.LoadShedding != nil && conf.LoadShedding.Threshold > queueSize {
+		invalid("LoadShedding", "LoadShedding.Threshold %d is larger than the event queue (%d)", conf.LoadShedding.Threshold, queueSize)
+	}
+
+	for _, category := range conf.NoReconnect {
+		if !isServerErrorCategory(category) {
+			invalid("NoReconnect", "unknown ServerError category %q", category)
+		}
+	}
+
+	if len(errs) > 0 {
+		return &ErrInvalidConfig{Conf: *conf, Errs: errs}
 	}
 
 	return nil
 }
 
+// isValid validates the config (see Validate()), and applies defaults.
+func (conf *Config) isValid() error {
+	if err := conf.Validate(); err != nil {
+		return err
+	}
+
+	conf.Port = conf.port()
+
+	return nil
+}
+
+// port returns the port to connect to, which is Config.Port if supplied, or
+// otherwise the standard IRC port (6697 with SSL, and 6667 without).
+func (conf *Config) port() int {
+	if conf.Port != 0 {
+		return conf.Port
+	}
+
+	if conf.SSL {
+		return 6697
+	}
+
+	return 6667
+}
+
 // ErrNotConnected is returned if a method is used when the client isn't
 // connected.
 var ErrNotConnected = errors.New("client is not connected to server")
@@ -206,14 +734,32 @@ func (e *ErrInvalidTarget) Error() string { return "invalid target: " + e.Target
 // New creates a new IRC client with the specified server, name and config.
 func New(config Config) *Client {
 	c := &Client{
-		Config:   config,
-		rx:       make(chan *Event, 25),
-		tx:       make(chan *Event, 25),
-		CTCP:     newCTCP(),
-		initTime: time.Now(),
+		Config:    config,
+		rx:        make(chan *Event, defaultEventQueueSize),
+		tx:        make(chan *outgoing, 25),
+		txControl: make(chan *outgoing, 25),
+		sendq:     newSendQueue(),
+		stats:     newStats(config.Metrics),
+		CTCP:      newCTCP(),
+		initTime:  time.Now(),
 	}
 
 	c.Cmd = &Commands{c: c}
+	c.Services = newServices(c)
+	c.DCC = newDCC(c)
+	c.Session = &Session{}
+
+	if c.Config.EventQueueSize > 0 {
+		c.rx = make(chan *Event, c.Config.EventQueueSize)
+	}
+
+	if c.Config.HandlerWorkers > 0 {
+		c.bgWorkers = &workerPool{limit: c.Config.HandlerWorkers}
+	}
+
+	if c.Config.TraceHandlers > 0 {
+		c.traces = &traceLog{traces: make([]*eventTrace, c.Config.TraceHandlers)}
+	}
 
 	if c.Config.PingDelay >= 0 && c.Config.PingDelay < (20*time.Second) {
 		c.Config.PingDelay = 20 * time.Second
@@ -221,25 +767,39 @@ func New(config Config) *Client {
 		c.Config.PingDelay = 600 * time.Second
 	}
 
-	if c.Config.Debug == nil {
-		c.debug = log.New(ioutil.Discard, "", 0)
-	} else {
-		c.debug = log.New(c.Config.Debug, "debug:", log.Ltime|log.Lshortfile)
-		c.debug.Print("initializing debugging")
+	switch {
+	case c.Config.Logger != nil:
+		c.logger = c.Config.Logger
+	case c.Config.Debug != nil:
+		c.logger = newWriterLogger(c.Config.Debug)
+		c.logger.Debug("initializing debugging")
+	default:
+		c.logger = nopLogger{}
 	}
 
 	// Setup the caller.
-	c.Handlers = newCaller(c.debug)
+	c.Handlers = newCaller(c.logger)
 
 	// Give ourselves a new state.
-	c.state = &state{}
+	c.state = &state{streamDeltas: config.StateStream != nil}
 	c.state.reset()
 
+	// Restore any persisted channel keys.
+	if c.Config.Rejoin {
+		c.rejoin.keys = c.load(storeChannelKeys)
+	}
+
 	// Register builtin handlers.
 	c.registerBuiltins()
 
 	// Register default CTCP responses.
+	c.CTCP.noDefaults = c.Config.DisableCTCPDefaults
 	c.CTCP.addDefaultHandlers()
+	c.CTCP.limit(c.Config.CTCPLimit, c.Config.CTCPWindow)
+
+	if c.Config.DCC != nil {
+		c.CTCP.Set(CTCP_DCC, handleCTCPDCC)
+	}
 
 	return c
 }
@@ -256,19 +816,75 @@ func (c *Client) String() string {
 // Close closes the network connection to the server, and sends a STOPPED
 // event. This should cause Connect() to return with nil. This should be
 // safe to call multiple times. See Connect()'s documentation on how
-// handlers and goroutines are handled when disconnected from the server.
+// handlers and goroutines are handled when disconnected from the server,
+// and Client.Shutdown() to disconnect gracefully.
 func (c *Client) Close() {
 	c.mu.RLock()
 	if c.stop != nil {
-		c.debug.Print("requesting client to stop")
+		c.logger.Debug("requesting client to stop")
 		c.stop()
 	}
 	c.mu.RUnlock()
 }
 
+// Shutdown gracefully disconnects from the server: it sends a QUIT (with
+// reason, or the default quit message, see Commands.Quit()), waits for the
+// server to close the connection, for the handlers of the remaining events
+// (including DISCONNECTED) to be executed, and for background handlers
+// (see Caller.AddBg()) to return. This returns once Connect() has returned.
+// If ctx is done before the server closes the connection, the connection is
+// closed (see Client.Close()), and the context error is returned, as it is
+// if background handlers are still running. ErrNotConnected is returned if
+// the client isn't connected. This must not be called from any handler
+// (including background handlers), as it waits for handlers to return; use
+// "go c.Shutdown(ctx, reason)" there instead.
+func (c *Client) Shutdown(ctx context.Context, reason string) error {
+	c.mu.RLock()
+	closed := c.closed
+	c.mu.RUnlock()
+
+	if closed == nil || !c.IsConnected() {
+		return ErrNotConnected
+	}
+
+	c.logger.Debug("shutting down")
+
+	if reason == "" {
+		reason = c.quitMessage()
+	}
+
+	quit := &Event{Command: QUIT}
+	if reason != "" {
+		quit.Trailing = reason
+	}
+
+	if err := c.SendCtx(ctx, quit); err == nil {
+		select {
+		case <-closed:
+		case <-ctx.Done():
+		}
+	}
+
+	// The server didn't close the connection in time (or the QUIT couldn't
+	// be sent), so close it ourselves.
+	select {
+	case <-closed:
+	default:
+		c.Close()
+		<-closed
+	}
+
+	select {
+	case <-c.bgWait():
+	case <-ctx.Done():
+	}
+
+	return ctx.Err()
+}
+
 // ErrEvent is an error returned when the server (or library) sends an ERROR
 // message response. The string returned contains the trailing text from the
-// message.
+// message. See Event.ServerError() for the structured form of the ERROR.
 type ErrEvent struct {
 	Event *Event
 }
@@ -282,8 +898,8 @@ func (e *ErrEvent) Error() string {
 }
 
 func (c *Client) execLoop(ctx context.Context, errs chan error, wg *sync.WaitGroup) {
-	c.debug.Print("starting execLoop")
-	defer c.debug.Print("closing execLoop")
+	c.logger.Debug("starting execLoop")
+	defer c.logger.Debug("closing execLoop")
 
 	var event *Event
 
@@ -293,11 +909,22 @@ func (c *Client) execLoop(ctx context.Context, errs chan error, wg *sync.WaitGro
 			// We've been told to exit, however we shouldn't bail on the
 			// current events in the queue that should be processed, as one
 			// may want to handle an ERROR, QUIT, etc.
-			c.debug.Printf("received signal to close, flushing %d events and executing", len(c.rx))
+			c.logger.Debug("received signal to close, flushing events", "queued", c.queuedEvents())
 			for {
 				select {
 				case event = <-c.rx:
+					c.dequeued()
 					c.RunHandlers(event)
+
+					if event != nil && event.Command == ERROR {
+						serr, _ := event.ServerError()
+						c.serverError(serr)
+
+						select {
+						case errs <- &ErrEvent{Event: event}:
+						default:
+						}
+					}
 				default:
 					goto done
 				}
@@ -307,6 +934,8 @@ func (c *Client) execLoop(ctx context.Context, errs chan error, wg *sync.WaitGro
 			wg.Done()
 			return
 		case event = <-c.rx:
+			c.dequeued()
+
 			if event != nil && event.Command == ERROR {
 				// Handles incoming ERROR responses. These are only ever sent
 				// by the server (with the exception that this library may use
@@ -316,10 +945,20 @@ func (c *Client) execLoop(ctx context.Context, errs chan error, wg *sync.WaitGro
 				// some reason the server doesn't disconnect the client, or
 				// if this library is the source of the error, this should
 				// signal back up to the main connect loop, to disconnect.
+				//
+				// The handlers are executed first, so they're guaranteed to
+				// see the ERROR before the connection is torn down.
+				c.RunHandlers(event)
+
+				serr, _ := event.ServerError()
+				c.serverError(serr)
+
 				errs <- &ErrEvent{Event: event}
+				continue
+			}
 
-				// Make sure to not actually exit, so we can let any handlers
-				// actually handle the ERROR event.
+			if c.shedEvent(event) {
+				continue
 			}
 
 			c.RunHandlers(event)
@@ -331,7 +970,7 @@ func (c *Client) execLoop(ctx context.Context, errs chan error, wg *sync.WaitGro
 // all internal handlers. Useful for highly embedded scripts with single
 // purposes. This cannot be un-done on a client.
 func (c *Client) DisableTracking() {
-	c.debug.Print("disabling tracking")
+	c.logger.Debug("disabling tracking")
 	c.Config.disableTracking = true
 	c.Handlers.clearInternal()
 
@@ -344,8 +983,10 @@ func (c *Client) DisableTracking() {
 }
 
 // Server returns the string representation of host+port pair for net.Conn.
+// If Config.Port isn't supplied, this includes the default port (see
+// Config.Port).
 func (c *Client) Server() string {
-	return fmt.Sprintf("%s:%d", c.Config.Server, c.Config.Port)
+	return fmt.Sprintf("%s:%d", c.Config.Server, c.Config.port())
 }
 
 // Lifetime returns the amount of time that has passed since the client was
@@ -386,7 +1027,8 @@ func (c *Client) ConnSince() (since *time.Duration, err error) {
 	return &timeSince, nil
 }
 
-// IsConnected returns true if the client is connected to the server.
+// IsConnected returns true if the client is connected to the server. See
+// Client.Status() for the state of the connection in more detail.
 func (c *Client) IsConnected() (connected bool) {
 	c.mu.RLock()
 	if c.conn == nil {
@@ -402,43 +1044,40 @@ func (c *Client) IsConnected() (connected bool) {
 	return connected
 }
 
-// GetNick returns the current nickname of the active connection. Panics if
-// tracking is disabled.
+// GetNick returns the current nickname of the active connection, or the
+// one being registered with. This works when tracking is disabled, however
+// nickname changes are only known once registration has completed.
 func (c *Client) GetNick() string {
-	c.panicIfNotTracking()
-
 	c.state.RLock()
-	defer c.state.RUnlock()
+	nick := c.state.nick
+	c.state.RUnlock()
 
-	if c.state.nick == "" {
-		return c.Config.Nick
+	if nick == "" {
+		return c.Identity().Nick
 	}
 
-	return c.state.nick
+	return nick
 }
 
-// GetIdent returns the current ident of the active connection. Panics if
-// tracking is disabled. May be empty, as this is obtained from when we join
-// a channel, as there is no other more efficient method to return this info.
+// GetIdent returns the current ident of the active connection. If unknown
+// (e.g. before joining a channel, or when tracking is disabled), this is the
+// one being registered with.
 func (c *Client) GetIdent() string {
-	c.panicIfNotTracking()
-
 	c.state.RLock()
-	defer c.state.RUnlock()
+	ident := c.state.ident
+	c.state.RUnlock()
 
-	if c.state.ident == "" {
-		return c.Config.User
+	if ident == "" {
+		return c.Identity().User
 	}
 
-	return c.state.ident
+	return ident
 }
 
-// GetHost returns the current host of the active connection. Panics if
-// tracking is disabled. May be empty, as this is obtained from when we join
-// a channel, as there is no other more efficient method to return this info.
+// GetHost returns the current host of the active connection. May be empty,
+// as this is obtained from when we join a channel, as there is no other more
+// efficient method to return this info, or if tracking is disabled.
 func (c *Client) GetHost() string {
-	c.panicIfNotTracking()
-
 	c.state.RLock()
 	defer c.state.RUnlock()
 
@@ -446,10 +1085,8 @@ func (c *Client) GetHost() string {
 }
 
 // Channels returns the active list of channels that the client is in.
-// Panics if tracking is disabled.
+// Empty if tracking is disabled, see Client.IsTracking().
 func (c *Client) Channels() []string {
-	c.panicIfNotTracking()
-
 	c.state.RLock()
 	channels := make([]string, len(c.state.channels))
 	var i int
@@ -464,10 +1101,8 @@ func (c *Client) Channels() []string {
 }
 
 // Users returns the active list of users that the client is tracking across
-// all files. Panics if tracking is disabled.
+// all files. Empty if tracking is disabled, see Client.IsTracking().
 func (c *Client) Users() []string {
-	c.panicIfNotTracking()
-
 	c.state.RLock()
 	users := make([]string, len(c.state.users))
 	var i int
@@ -482,9 +1117,10 @@ func (c *Client) Users() []string {
 }
 
 // LookupChannel looks up a given channel in state. If the channel doesn't
-// exist, nil is returned. Panics if tracking is disabled.
+// exist, nil is returned. The returned channel is a copy, and is safe to
+// read and modify without affecting the state. Always nil if tracking is
+// disabled.
 func (c *Client) LookupChannel(name string) *Channel {
-	c.panicIfNotTracking()
 	if name == "" {
 		return nil
 	}
@@ -501,9 +1137,10 @@ func (c *Client) LookupChannel(name string) *Channel {
 }
 
 // LookupUser looks up a given user in state. If the user doesn't exist, nil
-// is returned. Panics if tracking is disabled.
+// is returned. The returned user is a copy (including their channels and
+// permissions), and is safe to read and modify without affecting the state.
+// Always nil if tracking is disabled.
 func (c *Client) LookupUser(nick string) *User {
-	c.panicIfNotTracking()
 	if nick == "" {
 		return nil
 	}
@@ -519,27 +1156,84 @@ func (c *Client) LookupUser(nick string) *User {
 	return user.Copy()
 }
 
-// IsInChannel returns true if the client is in channel. Panics if tracking
-// is disabled.
+// IsInChannel returns true if the client is in channel. Always false if
+// tracking is disabled.
 func (c *Client) IsInChannel(channel string) bool {
-	c.panicIfNotTracking()
-
 	c.state.RLock()
-	_, inChannel := c.state.channels[ToRFC1459(channel)]
+	_, inChannel := c.state.channels[c.state.fold(channel)]
 	c.state.RUnlock()
 
 	return inChannel
 }
 
+// Equal compares two nicknames or channels, using the casemapping that the
+// server supports (via ISUPPORT CASEMAPPING), falling back to rfc1459.
+func (c *Client) Equal(a, b string) bool {
+	c.state.RLock()
+	defer c.state.RUnlock()
+
+	return c.state.fold(a) == c.state.fold(b)
+}
+
+// fold returns name casefolded according to the server's CASEMAPPING, for
+// use as a key, see Client.Equal().
+func (c *Client) fold(name string) string {
+	c.state.RLock()
+	defer c.state.RUnlock()
+
+	return c.state.fold(name)
+}
+
+// UserModes returns the user modes which are set on the client, e.g. "iwx".
+// Empty if tracking is disabled.
+func (c *Client) UserModes() (modes string) {
+	c.state.RLock()
+	modes = c.state.userModes
+	c.state.RUnlock()
+
+	return modes
+}
+
+// HasUserMode checks to see if the given user mode is set on the client,
+// e.g. "o" (see UserModeOperator). Always false if tracking is disabled.
+func (c *Client) HasUserMode(mode string) bool {
+	return mode != "" && strings.Contains(c.UserModes(), mode)
+}
+
+// IsValidNick validates a nickname like IsValidNick(), also rejecting
+// nicknames longer than the NICKLEN (or MAXNICKLEN) supported by the server.
+// Without tracking, or before the server has advertised it, this is the same
+// as IsValidNick().
+func (c *Client) IsValidNick(nick string) bool {
+	return isValidNick(nick, c.nickLen())
+}
+
+// nickLen returns the maximum nickname length supported by the server, or 0
+// if unknown. See Client.IsValidNick().
+func (c *Client) nickLen() int {
+	c.state.RLock()
+	value, ok := c.state.serverOptions["NICKLEN"]
+	if !ok {
+		value = c.state.serverOptions["MAXNICKLEN"]
+	}
+	c.state.RUnlock()
+
+	// Invalid (or missing) lengths are treated as unknown.
+	max, err := strconv.Atoi(value)
+	if err != nil || max < 0 {
+		return 0
+	}
+
+	return max
+}
+
 // GetServerOption retrieves a server capability setting that was retrieved
 // during client connection. This is also known as ISUPPORT (or RPL_PROTOCTL).
-// Will panic if used when tracking has been disabled. Examples of usage:
+// ok is always false if tracking is disabled. Examples of usage:
 //
 //   nickLen, success := GetServerOption("MAXNICKLEN")
 //
 func (c *Client) GetServerOption(key string) (result string, ok bool) {
-	c.panicIfNotTracking()
-
 	c.state.RLock()
 	result, ok = c.state.serverOptions[key]
 	c.state.RUnlock()
@@ -547,12 +1241,40 @@ func (c *Client) GetServerOption(key string) (result string, ok bool) {
 	return result, ok
 }
 
+// ServerOptions returns a copy of all server capability settings (see
+// GetServerOption()), e.g. for use with ParseModeChanges(). Empty if
+// tracking is disabled.
+func (c *Client) ServerOptions() map[string]string {
+	c.state.RLock()
+	options := make(map[string]string, len(c.state.serverOptions))
+	for k, v := range c.state.serverOptions {
+		options[k] = v
+	}
+	c.state.RUnlock()
+
+	return options
+}
+
+// HasCapability checks to see if the client has negotiated the given IRCv3
+// capability with the server (e.g. "message-tags"). Always false if
+// tracking is disabled, as capabilities aren't negotiated.
+func (c *Client) HasCapability(name string) (has bool) {
+	c.state.RLock()
+	for i := 0; i < len(c.state.enabledCap); i++ {
+		if c.state.enabledCap[i] == name {
+			has = true
+			break
+		}
+	}
+	c.state.RUnlock()
+
+	return has
+}
+
 // NetworkName returns the network identifier. E.g. "EsperNet", "ByteIRC".
 // May be empty if the server does not support RPL_ISUPPORT (or RPL_PROTOCTL).
-// Will panic if used when tracking has been disabled.
+// Empty if tracking is disabled.
 func (c *Client) NetworkName() (name string) {
-	c.panicIfNotTracking()
-
 	name, _ = c.GetServerOption("NETWORK")
 
 	return name
@@ -560,28 +1282,37 @@ func (c *Client) NetworkName() (name string) {
 
 // ServerVersion returns the server software version, if the server has
 // supplied this information during connection. May be empty if the server
-// does not support RPL_MYINFO. Will panic if used when tracking has been
-// disabled.
+// does not support RPL_MYINFO, or if tracking is disabled.
 func (c *Client) ServerVersion() (version string) {
-	c.panicIfNotTracking()
-
 	version, _ = c.GetServerOption("VERSION")
 
 	return version
 }
 
 // ServerMOTD returns the servers message of the day, if the server has sent
-// it upon connect. Will panic if used when tracking has been disabled.
+// it upon connect. Empty if tracking is disabled.
 func (c *Client) ServerMOTD() (motd string) {
-	c.panicIfNotTracking()
-
 	c.state.RLock()
-	motd = c.state.motd
+	motd = strings.Join(c.state.motd, "\n")
 	c.state.RUnlock()
 
 	return motd
 }
 
+// ServerMOTDLines is much like ServerMOTD, however returns each line of the
+// message of the day separately, as the server sent them. nil if tracking
+// is disabled.
+func (c *Client) ServerMOTDLines() []string {
+	c.state.RLock()
+	defer c.state.RUnlock()
+
+	if c.state.motd == nil {
+		return nil
+	}
+
+	return append([]string(nil), c.state.motd...)
+}
+
 // Lag is the latency between the server and the client. This is measured by
 // determining the difference in time between when we ping the server, and
 // when we receive a pong.
@@ -599,17 +1330,13 @@ func (c *Client) Lag() time.Duration {
 	return delta
 }
 
-// panicIfNotTracking will throw a panic when it's called, and tracking is
-// disabled. Adds useful info like what function specifically, and where it
-// was called from.
-func (c *Client) panicIfNotTracking() {
-	if !c.Config.disableTracking {
-		return
-	}
-
-	pc, _, _, _ := runtime.Caller(1)
-	fn := runtime.FuncForPC(pc)
-	_, file, line, _ := runtime.Caller(2)
+// ErrTrackingDisabled is returned by methods which require tracking when it
+// has been disabled, see Client.DisableTracking().
+var ErrTrackingDisabled = errors.New("tracking is disabled")
 
-	panic(fmt.Sprintf("%s used when tracking is disabled (caller %s:%d)", fn.Name(), file, line))
+// IsTracking returns false if tracking has been disabled (see
+// Client.DisableTracking()), in which case methods which query the tracked
+// state (e.g. Client.Channels() or Client.LookupUser()) return nothing.
+func (c *Client) IsTracking() bool {
+	return !c.Config.disableTracking
 }
@@ -5,7 +5,18 @@
 package girc
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -28,6 +39,27 @@ func TestDisableTracking(t *testing.T) {
 		t.Fatal("Client.Handlers contains capability tracking handlers, though disabled")
 	}
 
+	// Query methods don't panic, and the nickname is still known.
+	if client.IsTracking() || client.Channels() == nil || client.LookupUser("test") != nil || client.IsInChannel("#channel") {
+		t.Fatal("query methods returned tracked state, though disabled")
+	}
+	if _, ok := client.GetServerOption("NETWORK"); ok {
+		t.Fatal("Client.GetServerOption() returned ok, though tracking is disabled")
+	}
+	if _, err := client.Diagnose(context.Background()); err != ErrTrackingDisabled {
+		t.Fatalf("Client.Diagnose() returned %v, want ErrTrackingDisabled", err)
+	}
+
+	client.RunHandlers(ParseEvent(":dummy.int 001 renamed :Welcome"))
+	client.RunHandlers(ParseEvent(":other!user@host NICK :other2"))
+	if nick := client.GetNick(); nick != "renamed" {
+		t.Fatalf("Client.GetNick() = %q after RPL_WELCOME, want %q", nick, "renamed")
+	}
+	client.RunHandlers(ParseEvent(":renamed!user@host NICK :changed"))
+	if nick := client.GetNick(); nick != "changed" {
+		t.Fatalf("Client.GetNick() = %q after NICK, want %q", nick, "changed")
+	}
+
 	client.state.Lock()
 	defer client.state.Unlock()
 
@@ -64,6 +96,15 @@ func TestConfigValid(t *testing.T) {
 	if conf.Port != 6667 {
 		t.Fatal("irc port was not defaulted to 6667")
 	}
+	conf.Port = 0
+	conf.SSL = true
+	if err = conf.isValid(); err != nil {
+		t.Fatalf("valid default failed validation check: %s", err)
+	}
+	if conf.Port != 6697 {
+		t.Fatal("irc port was not defaulted to 6697 with SSL")
+	}
+	conf.SSL = false
 
 	conf.Nick = "invalid nick"
 	if err = conf.isValid(); err == nil {
@@ -78,6 +119,118 @@ func TestConfigValid(t *testing.T) {
 	conf.User = "test"
 }
 
+func TestClientServer(t *testing.T) {
+	tests := []struct {
+		port int
+		ssl  bool
+		want string
+	}{
+		{0, false, "irc.example.com:6667"},
+		{0, true, "irc.example.com:6697"},
+		{7000, true, "irc.example.com:7000"},
+	}
+
+	for _, tt := range tests {
+		c := New(Config{Server: "irc.example.com", Port: tt.port, SSL: tt.ssl, Nick: "test", User: "test"})
+		if got := c.Server(); got != tt.want {
+			t.Errorf("Server() with port %d and ssl %v = %q, want %q", tt.port, tt.ssl, got, tt.want)
+		}
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	conf := Config{
+		Server: "irc.example.com", Nick: "test", User: "test",
+		SASL:            &SASLPlain{User: "test"},
+		Rejoin:          true,
+		NickReclaim:     &NickReclaim{Ghost: true},
+		NickServAccount: "test",
+		disableTracking: true,
+	}
+
+	err := conf.Validate()
+	if err == nil {
+		t.Fatal("Config.Validate() returned nil for invalid config")
+	}
+
+	want := []string{
+		"SASL PLAIN requires both a user and password",
+		"SASL requires tracking, which is disabled",
+		"Rejoin requires tracking, which is disabled",
+		"NickReclaim requires tracking, which is disabled",
+		"NickReclaim.Ghost requires NickReclaim.Password",
+		"NickServAccount requires NickServPassword",
+	}
+
+	var got []string
+	for _, err := range err.(*ErrInvalidConfig).Errs {
+		got = append(got, err.Error())
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Config.Validate() = %q, want %q", got, want)
+	}
+
+	if !strings.HasPrefix(err.Error(), "invalid configuration: SASL PLAIN requires both a user and password; SASL") {
+		t.Fatalf("ErrInvalidConfig.Error() = %q", err)
+	}
+
+	if confErr, ok := err.(*ErrInvalidConfig).Errs[0].(*ConfigError); !ok || confErr.Field != "SASL" {
+		t.Fatalf("ErrInvalidConfig.Errs[0] = %#v, want *ConfigError for SASL", err.(*ErrInvalidConfig).Errs[0])
+	}
+
+	err = (&Config{
+		Server: "irc.example.com ", ServerPass: "a b", Nick: "test", User: "test", Name: "a\nb",
+		NoReconnect: []string{ServerErrorBanned, "k-lined"},
+	}).Validate()
+	var fields []string
+	for _, err := range err.(*ErrInvalidConfig).Errs {
+		fields = append(fields, err.(*ConfigError).Field)
+	}
+	if want := []string{"Server", "ServerPass", "Name", "NoReconnect"}; !reflect.DeepEqual(fields, want) {
+		t.Fatalf("Config.Validate() returned errors for %q, want %q", fields, want)
+	}
+
+	// The client shouldn't attempt to connect with an invalid config.
+	c := New(conf)
+	if err = c.Connect(); err == nil {
+		t.Fatal("Client.Connect() with invalid config returned nil")
+	}
+}
+
+func TestClientIsValidNick(t *testing.T) {
+	c := New(Config{Server: "irc.example.com", Nick: "test", User: "test"})
+
+	// Before the server has advertised NICKLEN, only the syntax is checked.
+	for nick, want := range map[string]bool{"toolongnick": true, "te!st": false} {
+		if got := c.IsValidNick(nick); got != want {
+			t.Errorf("Client.IsValidNick(%q) = %v, want %v", nick, got, want)
+		}
+	}
+
+	c.state.Lock()
+	c.state.serverOptions["NICKLEN"] = "8"
+	c.state.Unlock()
+
+	for nick, want := range map[string]bool{"toolongnick": false, "te!st": false, "nick[]": true} {
+		if got := c.IsValidNick(nick); got != want {
+			t.Errorf("Client.IsValidNick(%q) with NICKLEN=8 = %v, want %v", nick, got, want)
+		}
+	}
+
+	err := c.UpdateConfig(func(conf *RuntimeConfig) { conf.Nick = "toolongnick" })
+	if _, ok := err.(*ErrInvalidConfig); !ok {
+		t.Fatalf("UpdateConfig() with a nick longer than NICKLEN returned %v, want ErrInvalidConfig", err)
+	}
+	if c.Config.Nick != "test" {
+		t.Fatalf("UpdateConfig() changed Config.Nick to %q", c.Config.Nick)
+	}
+
+	if _, ok := c.Cmd.Nick("toolongnick").(*ErrInvalidTarget); !ok {
+		t.Fatal("Commands.Nick() didn't reject a nick longer than NICKLEN")
+	}
+}
+
 func TestClientLifetime(t *testing.T) {
 	client := New(Config{
 		Server: "dummy.int",
@@ -198,3 +351,1777 @@ func TestClientClose(t *testing.T) {
 	case <-done:
 	}
 }
+
+func TestServerError(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test", NoReconnect: []string{ServerErrorBanned}})
+
+	// Writing may also fail once the server has closed the connection.
+	handled := make(chan *ServerError, 2)
+	handleError := func(c *Client, err error) {
+		if serr, ok := err.(*ServerError); ok {
+			handled <- serr
+		}
+	}
+	c.Config.HandleError = handleError
+
+	var handlersDone int32
+	c.Handlers.Add(ERROR, func(c *Client, e Event) {
+		// Handlers should be able to take their time, before the connection
+		// is torn down.
+		time.Sleep(50 * time.Millisecond)
+		atomic.StoreInt32(&handlersDone, 1)
+	})
+
+	conn, errchan := mockServer(t, c, nil)
+	defer c.Close()
+
+	fmt.Fprint(conn, "ERROR :Closing Link: host.example.com (K-Lined)\r\n")
+	conn.Close()
+
+	select {
+	case err := <-errchan:
+		e, ok := err.(*ErrEvent)
+		if !ok {
+			t.Fatalf("connect returned %v, want ErrEvent", err)
+		}
+
+		if atomic.LoadInt32(&handlersDone) != 1 {
+			t.Fatal("connect returned before ERROR handlers were executed")
+		}
+
+		serr, ok := e.Event.ServerError()
+		if !ok || serr.Category != ServerErrorBanned || serr.Host != "host.example.com" {
+			t.Fatalf("ServerError() = %#v, want banned from host.example.com", serr)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for ERROR to close the connection")
+	}
+
+	if err := <-handled; err.Category != ServerErrorBanned {
+		t.Fatalf("HandleError() called with %#v, want banned ServerError", err)
+	}
+
+	if c.LastServerError() == nil || c.ShouldReconnect() {
+		t.Fatal("ShouldReconnect() returned true after being banned")
+	}
+
+	// A KILL is surfaced once, even though an ERROR follows.
+	killed := New(Config{
+		Server:      "dummy.int",
+		Nick:        "test",
+		User:        "test",
+		NoReconnect: []string{ServerErrorBanned},
+		HandleError: handleError,
+	})
+
+	killedConn, killedDone := mockServer(t, killed, nil)
+	fmt.Fprint(killedConn, ":oper!~oper@oper.host KILL test :spamming\r\n")
+	fmt.Fprint(killedConn, "ERROR :Closing Link: host.example.com (Killed (oper (spamming)))\r\n")
+	killedConn.Close()
+
+	if err := <-killedDone; err == nil {
+		t.Fatal("connect returned nil after being killed")
+	}
+
+	if err := <-handled; err.KilledBy != "oper" || err.Error() != "killed by oper: spamming" {
+		t.Fatalf("HandleError() called with %#v, want KILL by oper", err)
+	}
+
+	select {
+	case err := <-handled:
+		t.Fatalf("HandleError() called again with %#v", err)
+	default:
+	}
+
+	if !killed.ShouldReconnect() {
+		t.Fatal("ShouldReconnect() returned false after being killed, which isn't in NoReconnect")
+	}
+}
+
+func TestClientSendConfirmed(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test"})
+
+	// Act as a server which doesn't support any capabilities, and only
+	// responds to pings (and messages to #closed, with an error).
+	mockServer(t, c, func(e *Event, w io.Writer) {
+		switch {
+		case e.Command == PING:
+			fmt.Fprintf(w, ":dummy.int PONG dummy.int :%s\r\n", e.Params[0])
+		case e.Command == PRIVMSG && e.Params[0] == "#closed":
+			fmt.Fprint(w, ":dummy.int 404 test #closed :Cannot send to channel\r\n")
+		}
+	})
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := c.SendConfirmed(ctx, &Event{Command: PRIVMSG, Params: []string{"#open"}, Trailing: "test"}); err != nil {
+		t.Fatalf("Client.SendConfirmed() returned error: %s", err)
+	}
+
+	err := c.SendConfirmed(ctx, &Event{Command: PRIVMSG, Params: []string{"#closed"}, Trailing: "test"})
+	if _, ok := err.(*ErrEvent); !ok {
+		t.Fatalf("Client.SendConfirmed() returned %v, wanted *ErrEvent", err)
+	}
+}
+
+func TestClientDo(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test", AllowFlood: true})
+
+	mockServer(t, c, func(e *Event, w io.Writer) {
+		if e.Command == LINKS {
+			fmt.Fprint(w, ":dummy.int 364 test dummy.int dummy.int :0 Dummy Server\r\n")
+			fmt.Fprint(w, ":dummy.int NOTICE test :unrelated\r\n")
+			fmt.Fprint(w, ":dummy.int 364 test leaf.int dummy.int :1 Leaf Server\r\n")
+			fmt.Fprint(w, ":dummy.int 365 test * :End of /LINKS list.\r\n")
+		}
+	})
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	handlers := c.Handlers.Len()
+
+	events, err := c.Do(ctx, &Event{Command: LINKS},
+		func(e *Event) bool { return e.Command == RPL_ENDOFLINKS },
+		func(e *Event) bool { return e.Command == RPL_LINKS },
+	)
+	if err != nil {
+		t.Fatalf("Client.Do() returned error: %s", err)
+	}
+
+	var got []string
+	for _, e := range events {
+		got = append(got, e.Command+" "+e.Trailing)
+	}
+
+	want := []string{"364 0 Dummy Server", "364 1 Leaf Server", "365 End of /LINKS list."}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Client.Do() = %q, want %q", got, want)
+	}
+
+	// Cancelling the context should stop waiting.
+	cctx, ccancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer ccancel()
+
+	if _, err = c.Do(cctx, &Event{Command: PING, Params: []string{"test"}}, func(e *Event) bool { return false }, nil); err != context.DeadlineExceeded {
+		t.Fatalf("Client.Do() with cancelled context returned %v", err)
+	}
+
+	if n := c.Handlers.Len(); n != handlers {
+		t.Fatalf("Client.Do() left %d handlers behind", n-handlers)
+	}
+
+	if _, err = c.Do(ctx, &Event{Command: PING, Params: []string{"test"}}, nil, nil); err != ErrNilDone {
+		t.Fatalf("Client.Do() with nil done filter returned %v, want ErrNilDone", err)
+	}
+}
+
+func TestRecordedHandler(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test"})
+
+	var live []string
+	cuid := c.Handlers.AddRecorded("greeter", 2, PRIVMSG, func(c *Client, e Event) {
+		live = append(live, e.Trailing)
+	})
+
+	for _, text := range []string{"one", "two", "three"} {
+		c.RunHandlers(ParseEvent(":nick!user@host PRIVMSG #channel :" + text))
+	}
+	c.Handlers.Remove(cuid)
+
+	if !reflect.DeepEqual(live, []string{"one", "two", "three"}) {
+		t.Fatalf("recorded handler received %v", live)
+	}
+
+	// Only the last two events should be replayed, in order, even though the
+	// handler has been removed.
+	var replayed []string
+	n := c.Handlers.Replay("greeter", c, func(c *Client, e Event) {
+		replayed = append(replayed, e.Source.Name+": "+e.Trailing)
+	})
+
+	if want := []string{"nick: two", "nick: three"}; n != 2 || !reflect.DeepEqual(replayed, want) {
+		t.Fatalf("Caller.Replay() = %d, %v, want %v", n, replayed, want)
+	}
+
+	c.Handlers.ClearRecorded("greeter")
+	if events := c.Handlers.Recorded("greeter"); len(events) != 0 {
+		t.Fatalf("Caller.Recorded() after clearing = %v", events)
+	}
+
+	if n := c.Handlers.Replay("missing", c, func(c *Client, e Event) {}); n != 0 {
+		t.Fatalf("Caller.Replay() for unknown recording = %d", n)
+	}
+}
+
+func TestAddTmp(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test"})
+
+	closed := func(done chan struct{}, wait time.Duration) bool {
+		select {
+		case <-done:
+			return true
+		case <-time.After(wait):
+			return false
+		}
+	}
+
+	received := make(chan string, 10)
+	_, done := c.Handlers.AddTmp("privmsg", 0, func(c *Client, e Event) bool {
+		received <- e.Trailing
+		return e.Trailing == "stop"
+	})
+
+	c.RunHandlers(ParseEvent(":nick!user@host PRIVMSG #channel :one"))
+	if closed(done, 50*time.Millisecond) {
+		t.Fatal("temporary handler removed before returning true")
+	}
+
+	c.RunHandlers(ParseEvent(":nick!user@host PRIVMSG #channel :stop"))
+	if !closed(done, 5*time.Second) {
+		t.Fatal("temporary handler not removed after returning true")
+	}
+
+	c.RunHandlers(ParseEvent(":nick!user@host PRIVMSG #channel :after"))
+	time.Sleep(50 * time.Millisecond)
+	for len(received) > 0 {
+		if text := <-received; text == "after" {
+			t.Fatal("temporary handler executed after being removed")
+		}
+	}
+
+	// Events received at once don't run the handler again once it returned
+	// true.
+	var runs int32
+	_, done = c.Handlers.AddTmp("TEST", 0, func(c *Client, e Event) bool {
+		atomic.AddInt32(&runs, 1)
+		time.Sleep(10 * time.Millisecond)
+		return true
+	})
+	for i := 0; i < 5; i++ {
+		c.RunHandlers(&Event{Command: "TEST"})
+	}
+	if !closed(done, 5*time.Second) {
+		t.Fatal("temporary handler not removed after returning true")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if runs := atomic.LoadInt32(&runs); runs != 1 {
+		t.Fatalf("temporary handler ran %d times, want 1", runs)
+	}
+
+	// Deadline.
+	_, done = c.Handlers.AddTmp(PING, 10*time.Millisecond, func(c *Client, e Event) bool { return false })
+	if !closed(done, 5*time.Second) {
+		t.Fatal("temporary handler not removed after deadline")
+	}
+
+	// Manual removal, and clearing.
+	cuid, done := c.Handlers.AddTmp(PING, time.Minute, func(c *Client, e Event) bool { return false })
+	if !c.Handlers.Remove(cuid) || !closed(done, 5*time.Second) {
+		t.Fatal("temporary handler done not closed after Caller.Remove()")
+	}
+	if c.Handlers.Remove(cuid) {
+		t.Fatal("temporary handler removed twice")
+	}
+
+	_, done = c.Handlers.AddTmp(PING, 0, func(c *Client, e Event) bool { return false })
+	c.Handlers.ClearAll()
+	if !closed(done, 5*time.Second) {
+		t.Fatal("temporary handler done not closed after Caller.ClearAll()")
+	}
+}
+
+func TestHandlerPriority(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test"})
+
+	var mu sync.Mutex
+	var order []string
+	add := func(name string) {
+		mu.Lock()
+		order = append(order, name)
+		mu.Unlock()
+	}
+
+	c.Handlers.AddPriority(PRIVMSG, -5, func(c *Client, e Event) { add("last") })
+	c.Handlers.Add(PRIVMSG, func(c *Client, e Event) { add("default") })
+	c.Handlers.AddPriority(PRIVMSG, 10, func(c *Client, e Event) {
+		// Give lower priority handlers a chance to run too early.
+		time.Sleep(5 * time.Millisecond)
+		add("first")
+	})
+	c.Handlers.AddPriority(PRIVMSG, 5, func(c *Client, e Event) { add("second") })
+
+	for i := 0; i < 10; i++ {
+		order = nil
+		c.RunHandlers(ParseEvent(":nick!user@host PRIVMSG #channel :hello"))
+
+		if want := []string{"first", "second", "default", "last"}; !reflect.DeepEqual(order, want) {
+			t.Fatalf("handlers executed in order %v, want %v", order, want)
+		}
+	}
+}
+
+func TestHandlerGroup(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test"})
+
+	var calls int32
+	count := func(c *Client, e Event) { atomic.AddInt32(&calls, 1) }
+
+	plugin := c.Handlers.Group("plugin")
+	plugin.Add(PRIVMSG, count)
+	plugin.AddPriority(PRIVMSG, 5, count)
+	removed := plugin.Add(NOTICE, count)
+	_, done := plugin.AddTmp(PRIVMSG, 0, func(c *Client, e Event) bool { return false })
+	other := c.Handlers.Group("other").Add(PRIVMSG, count)
+
+	if !c.Handlers.Remove(removed) {
+		t.Fatal("Remove() didn't remove a grouped handler")
+	}
+
+	if plugin.Len() != 3 {
+		t.Fatalf("plugin.Len() == %d, want 3", plugin.Len())
+	}
+
+	if n := c.Handlers.ClearGroup("plugin"); n != 3 {
+		t.Fatalf("ClearGroup() removed %d handlers, want 3", n)
+	}
+
+	select {
+	case <-done:
+	default:
+		t.Fatal("ClearGroup() didn't close done of a temporary handler")
+	}
+
+	c.RunHandlers(ParseEvent(":nick!user@host PRIVMSG #channel :hello"))
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("%d handlers executed after clearing group, want 1", n)
+	}
+
+	if plugin.Len() != 0 || c.Handlers.ClearGroup("plugin") != 0 {
+		t.Fatal("cleared group still has handlers")
+	}
+
+	c.Handlers.Clear(PRIVMSG)
+	if c.Handlers.Group("other").Len() != 0 || c.Handlers.Remove(other) {
+		t.Fatal("Clear() didn't remove grouped handler")
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test"})
+
+	var mu sync.Mutex
+	calls := map[string]int{}
+	c.Handlers.AddHandler(PRIVMSG, Throttle("test", 2, 50*time.Millisecond, ThrottleBySource, HandlerFunc(func(c *Client, e Event) {
+		mu.Lock()
+		calls[e.Source.Name]++
+		mu.Unlock()
+	})))
+
+	throttled := make(chan Event, 1)
+	c.Handlers.Add(THROTTLED, func(c *Client, e Event) { throttled <- e })
+
+	for i := 0; i < 5; i++ {
+		c.RunHandlers(ParseEvent(":a!user@host PRIVMSG #channel :hello"))
+	}
+	c.RunHandlers(ParseEvent(":b!user@host PRIVMSG #channel :hello"))
+
+	mu.Lock()
+	if calls["a"] != 2 || calls["b"] != 1 {
+		t.Fatalf("handler executed %v times, want 2 for a and 1 for b", calls)
+	}
+	mu.Unlock()
+
+	select {
+	case e := <-throttled:
+		if want := []string{"test", "a", "3"}; !reflect.DeepEqual(e.Params, want) {
+			t.Fatalf("THROTTLED params == %v, want %v", e.Params, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no THROTTLED event after window passed")
+	}
+
+	c.RunHandlers(ParseEvent(":a!user@host PRIVMSG #channel :hello"))
+
+	mu.Lock()
+	if calls["a"] != 3 {
+		t.Fatalf("handler executed %d times for a after window passed, want 3", calls["a"])
+	}
+	mu.Unlock()
+
+	// Keys are folded using the server's CASEMAPPING.
+	c.RunHandlers(ParseEvent(":dummy.int 005 test CASEMAPPING=ascii :are supported by this server"))
+	if ThrottleBySource(c, *ParseEvent(":A{!user@host PRIVMSG #channel :hello")) != "a{" ||
+		ThrottleByTarget(c, *ParseEvent(":a!user@host PRIVMSG #Chan[] :hello")) != "#chan[]" {
+		t.Fatal("throttle keys weren't folded using the server's CASEMAPPING")
+	}
+}
+
+func TestAddNamed(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test"})
+
+	var calls []string
+	cuid := c.Handlers.AddNamed("auth-check", PRIVMSG, func(c *Client, e Event) { calls = append(calls, "v1") })
+	if cuid != "PRIVMSG:auth-check" {
+		t.Fatalf("AddNamed() cuid == %q, want %q", cuid, "PRIVMSG:auth-check")
+	}
+
+	// Reloading should replace the handler, rather than adding another.
+	c.Handlers.AddNamed("auth-check", PRIVMSG, func(c *Client, e Event) { calls = append(calls, "v2") })
+	c.RunHandlers(ParseEvent(":nick!user@host PRIVMSG #channel :hello"))
+
+	if want := []string{"v2"}; !reflect.DeepEqual(calls, want) {
+		t.Fatalf("handlers executed: %v, want %v", calls, want)
+	}
+
+	// Also when the event changes.
+	calls = nil
+	cuid = c.Handlers.AddNamed("auth-check", NOTICE, func(c *Client, e Event) { calls = append(calls, "v3") })
+	c.RunHandlers(ParseEvent(":nick!user@host PRIVMSG #channel :hello"))
+	c.RunHandlers(ParseEvent(":nick!user@host NOTICE #channel :hello"))
+
+	if want := []string{"v3"}; !reflect.DeepEqual(calls, want) {
+		t.Fatalf("handlers executed: %v, want %v", calls, want)
+	}
+
+	if c.Handlers.Len() != 1 {
+		t.Fatalf("Handlers.Len() == %d, want 1", c.Handlers.Len())
+	}
+
+	if !c.Handlers.Remove(cuid) || c.Handlers.Len() != 0 {
+		t.Fatal("Remove() didn't remove named handler")
+	}
+}
+
+func TestAddMatch(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test"})
+
+	var matched []string
+	c.Handlers.AddMatch(Match{
+		Commands: []string{PRIVMSG, NOTICE},
+		Target:   "#Channel",
+		Source:   "*!*@*.example.com",
+		Trailing: regexp.MustCompile(`^!ping\b`),
+	}, func(c *Client, e Event) { matched = append(matched, e.Command+" "+e.Source.Name) })
+
+	c.Handlers.AddMatch(Match{
+		Commands: []string{JOIN},
+		Func:     func(e Event) bool { return e.Source.Name == "two" },
+	}, func(c *Client, e Event) { matched = append(matched, e.Command+" "+e.Source.Name) })
+
+	events := []string{
+		":one!user@host.example.com PRIVMSG #channel :!ping",
+		":one!user@host.example.com NOTICE #CHANNEL :!ping now",
+		":one!user@host.example.com PRIVMSG #other :!ping",
+		":one!user@host.example.org PRIVMSG #channel :!ping",
+		":one!user@host.example.com PRIVMSG #channel :!pingpong",
+		":one!user@host.example.com TOPIC #channel :!ping",
+		":one!user@host JOIN #channel",
+		":two!user@host JOIN #channel",
+	}
+	for _, raw := range events {
+		c.RunHandlers(ParseEvent(raw))
+	}
+
+	if want := []string{"PRIVMSG one", "NOTICE one", "JOIN two"}; !reflect.DeepEqual(matched, want) {
+		t.Fatalf("handlers executed for %v, want %v", matched, want)
+	}
+
+	// Targets are compared using the server's CASEMAPPING.
+	c.RunHandlers(ParseEvent(":dummy.int 005 test CASEMAPPING=ascii :are supported by this server"))
+	m := Match{Target: "#{x}"}
+	if !m.Matches(c, *ParseEvent(":one!user@host PRIVMSG #{X} :hi")) || m.Matches(c, *ParseEvent(":one!user@host PRIVMSG #[x] :hi")) {
+		t.Fatal("Match.Matches() didn't compare the target using the server's CASEMAPPING")
+	}
+}
+
+func TestTraceHandlers(t *testing.T) {
+	c := New(Config{
+		Server:        "dummy.int",
+		Nick:          "test",
+		User:          "test",
+		TraceHandlers: 2,
+		RecoverFunc:   func(c *Client, err *HandlerError) {},
+	})
+
+	first := c.Handlers.AddPriority(PRIVMSG, 10, func(c *Client, e Event) {})
+	panics := c.Handlers.Add(PRIVMSG, func(c *Client, e Event) { panic("handler") })
+	live := c.Handlers.AddLive(PRIVMSG, func(c *Client, e Event) {})
+	c.Handlers.Use(func(next Handler) Handler {
+		return HandlerFunc(func(c *Client, e Event) {
+			if e.Trailing != "drop" {
+				next.Execute(c, e)
+			}
+		})
+	})
+
+	c.RunHandlers(ParseEvent("@msgid=abc :nick!user@host PRIVMSG #channel :hello"))
+
+	trace, ok := c.TraceMsgID("abc")
+	if !ok || trace.Seq != 1 || trace.Dropped || trace.Event.Trailing != "hello" {
+		t.Fatalf("TraceMsgID() = %#v, %t, want trace of first event", trace, ok)
+	}
+
+	outcomes := map[string]string{}
+	for _, h := range trace.Handlers {
+		if h.Internal {
+			continue
+		}
+
+		if len(outcomes) == 0 && h.CUID != first {
+			t.Fatalf("handler %s traced first, want handler with highest priority", h.CUID)
+		}
+		outcomes[h.CUID] = h.Outcome
+	}
+
+	if want := map[string]string{first: TraceDone, panics: TracePanicked, live: TraceDone}; !reflect.DeepEqual(outcomes, want) {
+		t.Fatalf("traced outcomes %v, want %v", outcomes, want)
+	}
+
+	replayed := ParseEvent(":nick!user@host PRIVMSG #channel :hello")
+	replayed.Replayed = true
+	c.RunHandlers(replayed)
+
+	if trace, ok = c.Trace(2); !ok {
+		t.Fatal("Trace(2) didn't return trace of replayed event")
+	}
+	for _, h := range trace.Handlers {
+		if h.CUID == live && h.Outcome != TraceSkipped {
+			t.Fatalf("live handler traced as %q for replayed event, want %q", h.Outcome, TraceSkipped)
+		}
+	}
+
+	c.RunHandlers(ParseEvent(":nick!user@host PRIVMSG #channel :drop"))
+	if trace, ok = c.Trace(3); !ok || !trace.Dropped {
+		t.Fatalf("Trace(3) = %#v, %t, want dropped event", trace, ok)
+	}
+
+	if _, ok = c.Trace(1); ok {
+		t.Fatal("Trace(1) returned trace which should have been evicted")
+	}
+
+	if traces := c.Traces(); len(traces) != 2 || traces[0].Seq != 2 || traces[1].Seq != 3 {
+		t.Fatalf("Traces() returned %d traces, want 2 and 3", len(traces))
+	}
+}
+
+func TestTracer(t *testing.T) {
+	timings := make(chan EventTiming, 10)
+	c := New(Config{
+		Server: "dummy.int",
+		Nick:   "test",
+		User:   "test",
+		Tracer: TraceFunc(func(t EventTiming) { timings <- t }),
+	})
+
+	slow := c.Handlers.Add(PRIVMSG, func(c *Client, e Event) { time.Sleep(20 * time.Millisecond) })
+	c.Handlers.Use(func(next Handler) Handler {
+		return HandlerFunc(func(c *Client, e Event) {
+			if e.Trailing != "drop" {
+				next.Execute(c, e)
+			}
+		})
+	})
+
+	event := ParseEvent(":nick!user@host PRIVMSG #channel :hello")
+	event.read = time.Now().Add(-time.Second)
+	c.RunHandlers(event)
+
+	timing := <-timings
+	if timing.Event.Trailing != "hello" || timing.Dropped || timing.Dispatched.Sub(timing.Read) < time.Second {
+		t.Fatalf("TraceFunc got %+v, want event read a second before it was dispatched", timing)
+	}
+
+	var found bool
+	for _, h := range timing.Handlers {
+		if h.CUID == slow {
+			found = h.Outcome == TraceDone && h.Duration >= 20*time.Millisecond && timing.Duration >= h.Duration
+		}
+	}
+	if !found {
+		t.Fatalf("TraceFunc got handlers %+v, want %s taking at least 20ms", timing.Handlers, slow)
+	}
+
+	c.RunHandlers(ParseEvent(":nick!user@host PRIVMSG #channel :drop"))
+	if timing = <-timings; !timing.Dropped || timing.Read.IsZero() {
+		t.Fatalf("TraceFunc got %+v, want dropped event", timing)
+	}
+
+	if len(timings) != 0 {
+		t.Fatalf("TraceFunc called %d more times, want once per event", len(timings))
+	}
+}
+
+func TestHandlerEventCopies(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test"})
+
+	got := make(chan string, 1)
+	c.Handlers.Add(ALL_EVENTS, func(c *Client, e Event) {
+		e.Params[0] = "#modified"
+		e.Tags["modified"] = "true"
+	})
+	c.Handlers.Add(PRIVMSG, func(c *Client, e Event) { got <- e.String() })
+
+	event := ParseEvent("@msgid=abc :nick!user@host PRIVMSG #channel :hello")
+	c.RunHandlers(event)
+
+	if want := "@msgid=abc :nick!user@host PRIVMSG #channel :hello"; event.String() != want {
+		t.Fatalf("event modified by handler: %q, want %q", event.String(), want)
+	}
+	if e := <-got; e != event.String() {
+		t.Fatalf("handler got event modified by other handler: %q, want %q", e, event.String())
+	}
+}
+
+func BenchmarkRunHandlers(b *testing.B) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test"})
+	c.Handlers.Add(PRIVMSG, func(c *Client, e Event) {})
+	event := ParseEvent("@msgid=abc :nick!user@host PRIVMSG #channel :hello")
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		c.RunHandlers(event)
+	}
+}
+
+func TestNumericClasses(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test"})
+
+	var mu sync.Mutex
+	got := map[string][]string{}
+	record := func(class string) func(c *Client, e Event) {
+		return func(c *Client, e Event) {
+			mu.Lock()
+			got[class] = append(got[class], e.Command)
+			mu.Unlock()
+		}
+	}
+
+	c.Handlers.Add(ALL_REPLIES, record("replies"))
+	c.Handlers.Add("err_*", record("errors"))
+	c.Handlers.Add("900-909", record("range"))
+	c.Handlers.AddNamed("named", "430-439", record("named"))
+
+	for _, raw := range []string{
+		":dummy.int 001 test :Welcome",
+		":dummy.int 433 * test :Nickname is already in use",
+		":dummy.int 900 test test!test@host test :You are now logged in",
+		":dummy.int 904 test :SASL authentication failed",
+		":dummy.int 501 test :Unknown MODE flag",
+		":nick!user@host PRIVMSG #channel :hello",
+	} {
+		c.RunHandlers(ParseEvent(raw))
+	}
+
+	want := map[string][]string{
+		"replies": {"001", "900"},
+		"errors":  {"433", "904", "501"},
+		"range":   {"900", "904"},
+		"named":   {"433"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("handlers executed for %v, want %v", got, want)
+	}
+
+	if _, ok := parseNumericClass("500-400"); ok {
+		t.Fatal("parseNumericClass() accepted inverted range")
+	}
+}
+
+func TestLegacyRegistration(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test", LegacyCompat: true})
+
+	connected := make(chan struct{}, 1)
+	c.Handlers.Add(RPL_WELCOME, func(c *Client, e Event) { connected <- struct{}{} })
+
+	c.RunHandlers(ParseEvent(":dummy.int 376 test_ :End of /MOTD command."))
+	select {
+	case <-connected:
+	default:
+		t.Fatal("end of MOTD without RPL_WELCOME didn't complete registration")
+	}
+
+	if nick := c.GetNick(); nick != "test_" {
+		t.Fatalf("nick after legacy registration = %q, want %q", nick, "test_")
+	}
+
+	// Only once.
+	c.RunHandlers(ParseEvent(":dummy.int 422 test_ :MOTD File is missing"))
+	if len(connected) != 0 {
+		t.Fatal("registration completed twice")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test"})
+
+	var order []string
+	c.Handlers.Use(func(next Handler) Handler {
+		return HandlerFunc(func(c *Client, e Event) {
+			if e.Command == PRIVMSG {
+				order = append(order, "outer")
+			}
+			if e.Source != nil && e.Source.Name == "spammer" {
+				return
+			}
+			next.Execute(c, e)
+		})
+	})
+	c.Handlers.Use(func(next Handler) Handler {
+		return HandlerFunc(func(c *Client, e Event) {
+			if e.Command == PRIVMSG {
+				order = append(order, "inner")
+			}
+			e.Trailing = strings.ToUpper(e.Trailing)
+			if e.Command == JOIN {
+				e.Source = &Source{Name: "rewritten", Ident: "~rewritten", Host: "rewritten.host"}
+			}
+			next.Execute(c, e)
+		})
+	})
+
+	var received []string
+	add := func(c *Client, e Event) { received = append(received, e.Command+" "+e.Trailing) }
+	c.Handlers.Add(JOIN, add)
+	c.Handlers.Add(PRIVMSG, add)
+
+	c.RunHandlers(ParseEvent(":test!~test@local.int JOIN #channel"))
+	c.RunHandlers(ParseEvent(":spammer!~spam@host JOIN #channel"))
+	c.RunHandlers(ParseEvent(":spammer!~spam@host PRIVMSG #channel :buy things"))
+	c.RunHandlers(ParseEvent(":nick!user@host PRIVMSG #channel :hello"))
+
+	if want := []string{"JOIN ", "PRIVMSG HELLO"}; !reflect.DeepEqual(received, want) {
+		t.Fatalf("handlers received %q, want %q", received, want)
+	}
+	if want := []string{"outer", "outer", "inner"}; !reflect.DeepEqual(order, want) {
+		t.Fatalf("middleware executed in order %v, want %v", order, want)
+	}
+
+	// Dropped events should still be tracked.
+	if user := c.LookupUser("spammer"); user == nil || !user.InChannel("#channel") {
+		t.Fatal("event dropped by middleware wasn't tracked")
+	}
+
+	// Events modified by middleware should be tracked as received.
+	if c.LookupChannel("#channel") == nil || c.LookupUser("rewritten") != nil {
+		t.Fatal("event modified by middleware was tracked as modified")
+	}
+}
+
+func TestRecoverFunc(t *testing.T) {
+	recovered := make(chan *HandlerError, 5)
+	c := New(Config{
+		Server:      "dummy.int",
+		Nick:        "test",
+		User:        "test",
+		RecoverFunc: func(c *Client, err *HandlerError) { recovered <- err },
+	})
+
+	var after bool
+	c.Handlers.Add(PRIVMSG, func(c *Client, e Event) { panic("handler") })
+	c.Handlers.AddPriority(PRIVMSG, -1, func(c *Client, e Event) { after = true })
+
+	// This shouldn't hang, and handlers after the panic should still run.
+	c.RunHandlers(ParseEvent(":nick!user@host PRIVMSG #channel :hello"))
+	if !after {
+		t.Fatal("handlers with lower priority weren't executed after a recovered panic")
+	}
+
+	select {
+	case err := <-recovered:
+		if err.Panic != "handler" || err.Event.Command != PRIVMSG || len(err.Stack) == 0 {
+			t.Fatalf("recovered %#v, want panic from PRIVMSG handler with stack", err)
+		}
+	default:
+		t.Fatal("handler panic wasn't recovered")
+	}
+
+	c.Handlers.Use(func(next Handler) Handler {
+		return HandlerFunc(func(c *Client, e Event) { panic("middleware") })
+	})
+
+	c.RunHandlers(ParseEvent(":nick!user@host NOTICE #channel :hello"))
+	select {
+	case err := <-recovered:
+		if err.Panic != "middleware" || err.ID != "middleware" {
+			t.Fatalf("recovered %#v, want panic from middleware", err)
+		}
+	default:
+		t.Fatal("middleware panic wasn't recovered")
+	}
+}
+
+func TestSession(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test"})
+
+	c.Session.Set("stale", 1)
+	c.Session.Set("deleted", 2)
+	c.Session.Delete("deleted")
+	if v, ok := c.Session.Get("stale"); !ok || v != 1 || c.Session.Len() != 1 {
+		t.Fatalf("Session.Get() = %v, %t with %d values", v, ok, c.Session.Len())
+	}
+
+	// Values set while connecting belong to the new connection.
+	c.Handlers.Add(CONNECTING, func(c *Client, e Event) { c.Session.Set("fresh", true) })
+
+	mockServer(t, c, nil)
+	defer c.Close()
+
+	if _, ok := c.Session.Get("stale"); ok {
+		t.Fatal("session values from before connecting weren't cleared")
+	}
+	if _, ok := c.Session.Get("fresh"); !ok {
+		t.Fatal("session value set while connecting was cleared")
+	}
+}
+
+func TestHandlerWorkers(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test", HandlerWorkers: 2})
+
+	var running, max, total int32
+	var wg sync.WaitGroup
+	wg.Add(10)
+	c.Handlers.AddBg(PRIVMSG, func(c *Client, e Event) {
+		defer wg.Done()
+
+		n := atomic.AddInt32(&running, 1)
+		for {
+			old := atomic.LoadInt32(&max)
+			if n <= old || atomic.CompareAndSwapInt32(&max, old, n) {
+				break
+			}
+		}
+
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&total, 1)
+		atomic.AddInt32(&running, -1)
+	})
+
+	for i := 0; i < 10; i++ {
+		c.RunHandlers(ParseEvent(":nick!user@host PRIVMSG #channel :hello"))
+	}
+	wg.Wait()
+
+	if total != 10 || max > 2 {
+		t.Fatalf("ran %d background handlers, with up to %d at once, want 10 with up to 2", total, max)
+	}
+
+	// Busy workers shouldn't hold up processing events, nor temporary
+	// handlers waiting for them.
+	release := make(chan struct{})
+	blocked := make(chan struct{}, 5)
+	c.Handlers.AddBg(NOTICE, func(c *Client, e Event) {
+		blocked <- struct{}{}
+		<-release
+	})
+
+	_, done := c.Handlers.AddTmp("TEST", 0, func(c *Client, e Event) bool { return true })
+
+	processed := make(chan struct{})
+	go func() {
+		for i := 0; i < 5; i++ {
+			c.RunHandlers(ParseEvent(":nick!user@host NOTICE #channel :hello"))
+		}
+		c.RunHandlers(&Event{Command: "TEST"})
+		close(processed)
+	}()
+
+	select {
+	case <-processed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("processing events was held up by busy workers")
+	}
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("temporary handler was held up by busy workers")
+	}
+
+	close(release)
+	for i := 0; i < 5; i++ {
+		<-blocked
+	}
+}
+
+func TestClientLoadShedding(t *testing.T) {
+	c := New(Config{
+		Server:       "dummy.int",
+		Nick:         "test",
+		User:         "test",
+		LoadShedding: &LoadShedding{Threshold: 10},
+	})
+
+	var started, stopped int
+	c.Handlers.Add(SHED_STARTED, func(c *Client, e Event) { started++ })
+	c.Handlers.Add(SHED_STOPPED, func(c *Client, e Event) { stopped++ })
+
+	for i := 0; i < 10; i++ {
+		c.rx <- &Event{Command: JOIN}
+	}
+
+	if !c.shedEvent(&Event{Command: QUIT}) {
+		t.Fatal("Client.shedEvent() returned false for QUIT with backed up queue")
+	}
+
+	if c.shedEvent(&Event{Command: PRIVMSG}) {
+		t.Fatal("Client.shedEvent() returned true for PRIVMSG")
+	}
+
+	// Events changing the client's own state are never shed.
+	for _, raw := range []string{
+		":TEST!~test@local.int JOIN #channel",
+		":test!~test@local.int NICK other",
+		":op!~op@op.host KICK #channel test :bye",
+	} {
+		if c.shedEvent(ParseEvent(raw)) {
+			t.Fatalf("Client.shedEvent() returned true for %q", raw)
+		}
+	}
+
+	for len(c.rx) > 0 {
+		<-c.rx
+	}
+
+	if c.shedEvent(&Event{Command: QUIT}) {
+		t.Fatal("Client.shedEvent() returned true for QUIT with empty queue")
+	}
+
+	if stats := c.ShedStats(); stats.Active || stats.Dropped != 1 || stats.Episodes != 1 {
+		t.Fatalf("Client.ShedStats() == %#v, unexpected", stats)
+	}
+
+	if started != 1 || stopped != 1 {
+		t.Fatalf("shedding started %d and stopped %d times, wanted 1 and 1", started, stopped)
+	}
+
+	// With a tiny queue, shedding shouldn't start while it's empty.
+	tiny := New(Config{Server: "dummy.int", Nick: "test", User: "test", EventQueueSize: 1, LoadShedding: &LoadShedding{}})
+	if tiny.shedEvent(&Event{Command: QUIT}) || tiny.ShedStats().Active {
+		t.Fatal("Client.shedEvent() started shedding with an empty queue of size 1")
+	}
+}
+
+func TestEventQueuePolicy(t *testing.T) {
+	queued := func(c *Client) (out []string) {
+		for len(c.rx) > 0 {
+			out = append(out, (<-c.rx).Trailing)
+			c.dequeued()
+		}
+		return out
+	}
+
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test", EventQueueSize: 2, EventQueuePolicy: QueueDropOldest})
+
+	var dropped []string
+	c.Handlers.Add(EVENTS_DROPPED, func(c *Client, e Event) { dropped = append(dropped, e.Trailing) })
+
+	for i := 1; i <= 5; i++ {
+		c.receive(&Event{Command: PRIVMSG, Trailing: strconv.Itoa(i)})
+	}
+
+	if stats := c.EventQueue(); stats != (EventQueueStats{Length: 2, Dropped: 3}) {
+		t.Fatalf("EventQueue() = %+v with QueueDropOldest, want 2 queued and 3 dropped", stats)
+	}
+	if got := queued(c); !reflect.DeepEqual(got, []string{"4", "5"}) || !reflect.DeepEqual(dropped, []string{"3"}) {
+		t.Fatalf("QueueDropOldest queued %v, and emitted EVENTS_DROPPED %v, want newest events and 3 dropped", got, dropped)
+	}
+
+	// PING and ERROR are never dropped, so the oldest other event is.
+	c = New(Config{Server: "dummy.int", Nick: "test", User: "test", EventQueueSize: 2, EventQueuePolicy: QueueDropOldest})
+	c.receive(&Event{Command: PING, Trailing: "ping"})
+	for i := 1; i <= 3; i++ {
+		c.receive(&Event{Command: PRIVMSG, Trailing: strconv.Itoa(i)})
+	}
+	if got := queued(c); !reflect.DeepEqual(got, []string{"ping", "3"}) {
+		t.Fatalf("QueueDropOldest queued %v after a PING, want the PING and the newest event", got)
+	}
+
+	c.receive(&Event{Command: PING, Trailing: "ping"})
+	c.receive(&Event{Command: ERROR, Trailing: "error"})
+	c.receive(&Event{Command: PRIVMSG, Trailing: "4"})
+	if got := queued(c); !reflect.DeepEqual(got, []string{"ping", "error"}) || c.EventQueue().Dropped != 3 {
+		t.Fatalf("QueueDropOldest queued %v with %d dropped, want the PING and ERROR, and the new event dropped", got, c.EventQueue().Dropped)
+	}
+
+	c = New(Config{Server: "dummy.int", Nick: "test", User: "test", EventQueueSize: 2, EventQueuePolicy: QueueSpill})
+	for i := 1; i <= 5; i++ {
+		c.receive(&Event{Command: PRIVMSG, Trailing: strconv.Itoa(i)})
+	}
+
+	if stats := c.EventQueue(); stats != (EventQueueStats{Length: 5, Spilled: 3}) {
+		t.Fatalf("EventQueue() = %+v with QueueSpill, want 5 queued and 3 spilled", stats)
+	}
+	c.receive(&Event{Command: PRIVMSG, Trailing: "6"})
+	if got := queued(c); !reflect.DeepEqual(got, []string{"1", "2", "3", "4", "5", "6"}) {
+		t.Fatalf("QueueSpill queued %v, want all events in order", got)
+	}
+
+	err := (&Config{Server: "dummy.int", Nick: "test", User: "test", EventQueueSize: 5, LoadShedding: &LoadShedding{Threshold: 10}}).Validate()
+	if err == nil {
+		t.Fatal("Validate() returned no error for LoadShedding.Threshold larger than EventQueueSize")
+	}
+}
+
+func TestClientDiagnose(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test"})
+
+	// Act as a server which echoes back NOTICEs, and truncates lines longer
+	// than 480 characters.
+	mockServer(t, c, func(e *Event, w io.Writer) {
+		if line := e.String(); len(line) > 480 {
+			e = ParseEvent(line[:480])
+		}
+
+		switch e.Command {
+		case PING:
+			fmt.Fprintf(w, ":dummy.int PONG dummy.int :%s\r\n", e.Params[0])
+		case NOTICE:
+			fmt.Fprintf(w, ":test!test@dummy.int NOTICE test :%s\r\n", e.Trailing)
+		}
+	})
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	d, err := c.Diagnose(ctx)
+	if err != nil {
+		t.Fatalf("Client.Diagnose() returned error: %s", err)
+	}
+
+	if d.FloodSent != diagnoseFloodCount || d.FloodReceived != diagnoseFloodCount {
+		t.Fatalf("Client.Diagnose() flood sent/received = %d/%d, wanted %d/%d", d.FloodSent, d.FloodReceived, diagnoseFloodCount, diagnoseFloodCount)
+	}
+
+	if d.MaxLineLength != 480 {
+		t.Fatalf("Client.Diagnose() max line length = %d, wanted 480", d.MaxLineLength)
+	}
+}
+
+func TestClientPipe(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test", AllowFlood: true})
+
+	lines := make(chan string, 10)
+	conn, _ := mockServer(t, c, func(e *Event, w io.Writer) { lines <- e.String() })
+	defer c.Close()
+
+	q := NewChanQueue(25)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	piped := make(chan error, 1)
+	go func() { piped <- c.Pipe(ctx, q) }()
+
+	acked := make(chan error, 1)
+	q.Out <- &Outbound{
+		Event: &Event{Command: PRIVMSG, Params: []string{"#test"}, Trailing: "from queue"},
+		Ack:   func(err error) { acked <- err },
+	}
+
+	if err := <-acked; err != nil {
+		t.Fatalf("Outbound.Ack() called with error: %s", err)
+	}
+
+	timeout := time.After(2 * time.Second)
+	for sent := false; !sent; {
+		select {
+		case line := <-lines:
+			sent = line == "PRIVMSG #test :from queue"
+		case <-timeout:
+			t.Fatal("timed out waiting for outbound event")
+		}
+	}
+
+	fmt.Fprint(conn, ":dummy.int NOTICE test :to queue\r\n")
+
+	for received := false; !received; {
+		select {
+		case e := <-q.In:
+			received = e.Command == NOTICE && e.Trailing == "to queue"
+		case <-timeout:
+			t.Fatal("timed out waiting for inbound event")
+		}
+	}
+
+	cancel()
+	if err := <-piped; err != context.Canceled {
+		t.Fatalf("Client.Pipe() returned %v, wanted context.Canceled", err)
+	}
+}
+
+func TestListBans(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test", AllowFlood: true})
+
+	mockServer(t, c, func(e *Event, w io.Writer) {
+		switch e.String() {
+		case "MODE #test +b":
+			fmt.Fprint(w, ":dummy.int 367 test #test *!*@bad.host alice 1496673001\r\n")
+			fmt.Fprint(w, ":dummy.int 367 test #test *!*@worse.host\r\n")
+			fmt.Fprint(w, ":dummy.int 368 test #test :End of channel ban list\r\n")
+		case "MODE #test +e":
+			fmt.Fprint(w, ":dummy.int 482 test #test :You're not a channel operator\r\n")
+		}
+	})
+	defer c.Close()
+
+	c.state.Lock()
+	c.state.createChannel("#test")
+	c.state.Unlock()
+
+	bans, err := c.Cmd.ListBans("#test")
+	if err != nil {
+		t.Fatalf("Commands.ListBans() returned error: %s", err)
+	}
+
+	want := []BanEntry{
+		{Mask: "*!*@bad.host", SetBy: "alice", SetAt: time.Unix(1496673001, 0)},
+		{Mask: "*!*@worse.host"},
+	}
+	if !reflect.DeepEqual(bans, want) {
+		t.Fatalf("Commands.ListBans() = %#v, want %#v", bans, want)
+	}
+
+	if cached := c.LookupChannel("#test").Lists["b"]; !reflect.DeepEqual(cached, want) {
+		t.Fatalf("Channel.Lists[\"b\"] = %#v, want %#v", cached, want)
+	}
+
+	if _, err = c.Cmd.ListExceptions("#test"); err == nil {
+		t.Fatal("Commands.ListExceptions() returned nil error, wanted ErrEvent")
+	}
+}
+
+func TestIdentityRotation(t *testing.T) {
+	c := New(Config{
+		Server: "dummy.int",
+		Nick:   "test",
+		User:   "test",
+		Identities: []Identity{
+			{Nick: "first", User: "one", Name: "First Identity"},
+			{Nick: "second"},
+		},
+	})
+
+	reported := make(chan Identity, 1)
+	c.Handlers.Add(CONNECTING, func(c *Client, e Event) {
+		reported <- Identity{Nick: e.Params[0], User: e.Params[1], Name: e.Trailing}
+	})
+
+	want := []Identity{
+		{Nick: "first", User: "one", Name: "First Identity"},
+		{Nick: "second", User: "test", Name: "test"},
+		{Nick: "first", User: "one", Name: "First Identity"},
+	}
+
+	for i := 0; i < len(want); i++ {
+		conn, server := net.Pipe()
+
+		errs := make(chan error, 1)
+		go func() { errs <- c.MockConnect(server) }()
+
+		b := bufio.NewReader(conn)
+		var nick, user string
+		for nick == "" || user == "" {
+			conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			line, err := b.ReadString(byte('\n'))
+			if err != nil {
+				t.Fatalf("attempt %d: failed reading from client: %s", i, err)
+			}
+
+			e := ParseEvent(line)
+			switch e.Command {
+			case NICK:
+				nick = e.Params[0]
+			case USER:
+				user = e.Params[0]
+			}
+		}
+
+		if id := <-reported; id != want[i] {
+			t.Fatalf("attempt %d: CONNECTING reported %#v, want %#v", i, id, want[i])
+		}
+
+		if nick != want[i].Nick || user != want[i].User {
+			t.Fatalf("attempt %d: registered as %q/%q, want %q/%q", i, nick, user, want[i].Nick, want[i].User)
+		}
+
+		if id := c.Identity(); id != want[i] {
+			t.Fatalf("attempt %d: Client.Identity() = %#v, want %#v", i, id, want[i])
+		}
+
+		if got := c.GetNick(); got != want[i].Nick {
+			t.Fatalf("attempt %d: Client.GetNick() = %q, want %q", i, got, want[i].Nick)
+		}
+
+		c.Close()
+		conn.Close()
+		<-errs
+	}
+
+	c.Config.IdentityFunc = func(attempt int) Identity {
+		return Identity{Nick: "bad nick"}
+	}
+
+	if err := c.MockConnect(nil); err == nil {
+		t.Fatal("Client.MockConnect() with invalid identity returned nil error")
+	}
+}
+
+func TestRejoin(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test", AllowFlood: true, Rejoin: true})
+
+	connected := make(chan struct{}, 1)
+	c.Handlers.Add(CONNECTED, func(c *Client, e Event) {
+		connected <- struct{}{}
+	})
+
+	for attempt := 0; attempt < 3; attempt++ {
+		joins := make(chan string, 10)
+		_, done := mockServer(t, c, func(e *Event, w io.Writer) {
+			switch e.Command {
+			case USER:
+				fmt.Fprint(w, ":dummy.int 001 test :Welcome\r\n")
+			case JOIN:
+				joins <- strings.Join(e.Params, " ")
+			}
+		})
+
+		// Wait for CONNECTED (which triggers the rejoin), so it isn't
+		// emitted during the next connection.
+		select {
+		case <-connected:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for CONNECTED")
+		}
+
+		if attempt == 0 {
+			// Join a few channels, and part one of them. Wait for the JOIN
+			// to be sent, so it isn't left queued for the next connection
+			// (and the state isn't reset after the channels are joined).
+			c.Cmd.JoinKey("#keyed", "secret")
+			select {
+			case <-joins:
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for JOIN")
+			}
+
+			for _, raw := range []string{
+				":test!test@local.int JOIN #one",
+				":test!test@local.int JOIN #keyed",
+				":test!test@local.int JOIN #parted",
+				":test!test@local.int PART #parted",
+			} {
+				c.RunHandlers(ParseEvent(raw))
+			}
+
+			c.Close()
+			<-done
+			continue
+		}
+
+		var got []string
+		for len(got) < 2 {
+			select {
+			case join := <-joins:
+				got = append(got, join)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out waiting for rejoin, got %v", got)
+			}
+		}
+
+		want := []string{"#one", "#keyed secret"}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("rejoined with %v, want %v", got, want)
+		}
+
+		// Only #one is rejoined, so #keyed should still be rejoined after
+		// the next reconnect.
+		c.RunHandlers(ParseEvent(":test!test@local.int JOIN #one"))
+
+		c.Close()
+		<-done
+	}
+
+	// Keys are looked up using the server's CASEMAPPING.
+	c.RunHandlers(ParseEvent(":dummy.int 005 test CASEMAPPING=ascii :are supported by this server"))
+	c.rejoin.setKey(c, "#{x}", "secret")
+	if c.rejoin.key(c, "#{X}") != "secret" || c.rejoin.key(c, "#[x]") != "" {
+		t.Fatal("channel keys weren't looked up using the server's CASEMAPPING")
+	}
+
+	// Keys are forgotten after parting, being kicked, or the key being
+	// removed.
+	for _, channel := range []string{"#parted", "#kicked", "#unkeyed", "#other"} {
+		c.rejoin.setKey(c, channel, "secret")
+	}
+	for _, raw := range []string{
+		":test!test@local.int PART #parted",
+		":op!op@local.int KICK #kicked test :bye",
+		":op!op@local.int MODE #unkeyed -k *",
+		":other!other@local.int PART #other",
+	} {
+		c.RunHandlers(ParseEvent(raw))
+	}
+
+	for channel, want := range map[string]string{"#parted": "", "#kicked": "", "#unkeyed": "", "#other": "secret"} {
+		if got := c.rejoin.key(c, channel); got != want {
+			t.Fatalf("key for %s = %q, want %q", channel, got, want)
+		}
+	}
+}
+
+func TestKickRejoin(t *testing.T) {
+	c := New(Config{
+		Server:     "dummy.int",
+		Nick:       "test",
+		User:       "test",
+		AllowFlood: true,
+		KickRejoin: &KickRejoin{Delay: 50 * time.Millisecond, MaxAttempts: 2},
+	})
+
+	attempts := make(chan Event, 5)
+	c.Handlers.Add(KICKED_REJOINING, func(c *Client, e Event) { attempts <- e })
+
+	joins := make(chan string, 5)
+	conn, _ := mockServer(t, c, func(e *Event, w io.Writer) {
+		if e.Command == JOIN {
+			joins <- e.Params[0]
+			fmt.Fprintf(w, ":dummy.int 474 test %s :Cannot join channel (+b)\r\n", e.Params[0])
+		}
+	})
+	defer c.Close()
+
+	fmt.Fprint(conn, ":op!op@op.host KICK #channel test :go away\r\n")
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		select {
+		case e := <-attempts:
+			want := []string{"#channel", "op", strconv.Itoa(attempt)}
+			if !reflect.DeepEqual(e.Params, want) || e.Trailing != "go away" {
+				t.Fatalf("KICKED_REJOINING event = %#v, want params %v", e, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for KICKED_REJOINING attempt %d", attempt)
+		}
+
+		select {
+		case channel := <-joins:
+			if channel != "#channel" {
+				t.Fatalf("rejoined %q, want #channel", channel)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for rejoin attempt %d", attempt)
+		}
+	}
+
+	select {
+	case channel := <-joins:
+		t.Fatalf("rejoined %q after max attempts", channel)
+	case e := <-attempts:
+		t.Fatalf("unexpected KICKED_REJOINING after max attempts: %#v", e)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestReOp(t *testing.T) {
+	c := New(Config{
+		Server:     "dummy.int",
+		Nick:       "test",
+		User:       "test",
+		AllowFlood: true,
+		ReOp:       &ReOp{Channels: []string{"#managed"}, ChanServ: true, Timeout: 100 * time.Millisecond},
+	})
+
+	outcomes := make(chan string, 5)
+	c.Handlers.Add(REOP_SUCCEEDED, func(c *Client, e Event) { outcomes <- e.Command + " " + e.Params[0] })
+	c.Handlers.Add(REOP_FAILED, func(c *Client, e Event) { outcomes <- e.Command + " " + e.Params[0] })
+
+	expect := func(want string) {
+		select {
+		case got := <-outcomes:
+			if got != want {
+				t.Fatalf("got outcome %q, want %q", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %q", want)
+		}
+	}
+	// Self JOINs also send WHO and MODE queries, which are skipped.
+	expectRequest := func() {
+		for {
+			select {
+			case o := <-c.tx:
+				e := o.event
+				if e.Command != PRIVMSG {
+					continue
+				}
+
+				if got, want := e.String(), "PRIVMSG ChanServ :OP #managed"; got != want {
+					t.Fatalf("sent %q, want %q", got, want)
+				}
+				return
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for op request")
+			}
+		}
+	}
+
+	// Joining a managed channel without op.
+	for _, channel := range []string{"#managed", "#other"} {
+		c.RunHandlers(ParseEvent(":test!~test@local.int JOIN " + channel))
+		c.RunHandlers(ParseEvent(":dummy.int 353 test = " + channel + " :test @other"))
+		c.RunHandlers(ParseEvent(":dummy.int 366 test " + channel + " :End of /NAMES list."))
+	}
+	expectRequest()
+
+	c.RunHandlers(ParseEvent(":ChanServ!service@services.int MODE #managed +o test"))
+	expect(REOP_SUCCEEDED + " #managed")
+
+	// Being deopped, and not given op back in time.
+	c.RunHandlers(ParseEvent(":other!~other@host MODE #other -o test"))
+	c.RunHandlers(ParseEvent(":other!~other@host MODE #managed -o test"))
+	expectRequest()
+	expect(REOP_FAILED + " #managed")
+
+	for len(c.tx) > 0 {
+		if e := (<-c.tx).event; e.Command == PRIVMSG {
+			t.Fatalf("op requested for unmanaged channel: %s", e)
+		}
+	}
+
+	// NAMES replies which don't follow our own JOIN are ignored.
+	c.RunHandlers(ParseEvent(":dummy.int 353 test = #managed :test @other"))
+	c.RunHandlers(ParseEvent(":dummy.int 366 test #managed :End of /NAMES list."))
+	select {
+	case o := <-c.tx:
+		t.Fatalf("sent %q after NAMES without joining", o.event.String())
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Channels are compared using the server's CASEMAPPING.
+	c.RunHandlers(ParseEvent(":dummy.int 005 test CASEMAPPING=ascii :are supported by this server"))
+	r := &ReOp{Channels: []string{"#{x}"}}
+	if !r.manages(c, "#{X}") || r.manages(c, "#[x]") {
+		t.Fatal("ReOp didn't compare channels using the server's CASEMAPPING")
+	}
+}
+
+func TestIsService(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test", ServiceMasks: []string{"Bot!bot@custom.services.host"}})
+	c.RunHandlers(ParseEvent(":dummy.int 005 test NETWORK=ExampleNet :are supported by this server"))
+
+	cases := []struct {
+		source string
+		want   bool
+	}{
+		{"NickServ!NickServ@services.", true},
+		{"ChanServ!ChanServ@services.libera.chat", true},
+		{"Q!TheQBot@CServe.quakenet.org", true},
+		{"NickServ!services@ExampleNet", true},
+		{"Global!services@irc.examplenet", true},
+		{"NickServ", true},
+		{"bot!bot@custom.services.host", true},
+		{"NickServ!~nick@some.isp.com", false},
+		{"user!user@services.example.com", false},
+		{"services.example.com", false},
+	}
+
+	for _, tt := range cases {
+		if got := c.IsService(ParseSource(tt.source)); got != tt.want {
+			t.Errorf("Client.IsService(%q) = %t, want %t", tt.source, got, tt.want)
+		}
+	}
+
+	routed := make(chan Event, 2)
+	c.Handlers.Add(SERVICE_NOTICE, func(c *Client, e Event) { routed <- e })
+	c.Handlers.Add(NOTICE, func(c *Client, e Event) {
+		if e.Source.Host == "services." && !e.IsService() {
+			t.Error("Event.IsService() = false for NickServ NOTICE")
+		}
+	})
+
+	c.RunHandlers(ParseEvent(":NickServ!NickServ@services. NOTICE test :You are now identified"))
+	c.RunHandlers(ParseEvent(":NickServ!~nick@some.isp.com NOTICE test :Please send me your password"))
+
+	if e := <-routed; e.Trailing != "You are now identified" {
+		t.Fatalf("unexpected SERVICE_NOTICE event: %#v", e)
+	}
+
+	select {
+	case e := <-routed:
+		t.Fatalf("spoofed services NOTICE was routed as SERVICE_NOTICE: %#v", e)
+	default:
+	}
+}
+
+func TestServices(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test", NickServPassword: "hunter2"})
+
+	next := func() string {
+		select {
+		case o := <-c.tx:
+			e := o.event
+			return e.String()
+		default:
+			return ""
+		}
+	}
+
+	c.Services.Identify("", "pass")
+	if got := next(); got != "PRIVMSG NickServ :IDENTIFY pass" {
+		t.Errorf("Services.Identify() sent %q", got)
+	}
+
+	c.Services.Identify("account", "pass")
+	if got := next(); got != "PRIVMSG NickServ :IDENTIFY account pass" {
+		t.Errorf("Services.Identify() sent %q", got)
+	}
+
+	if err := c.Services.Ghost("test", "pass"); err != nil || next() != "PRIVMSG NickServ :GHOST test pass" {
+		t.Errorf("Services.Ghost() failed: %v", err)
+	}
+
+	if err := c.Services.Regain("test", "pass"); err != nil || next() != "PRIVMSG NickServ :REGAIN test pass" {
+		t.Errorf("Services.Regain() failed: %v", err)
+	}
+
+	if err := c.Services.Invite("#channel"); err != nil || next() != "PRIVMSG ChanServ :INVITE #channel" {
+		t.Errorf("Services.Invite() failed: %v", err)
+	}
+
+	if err := c.Services.Invite("channel"); err == nil {
+		t.Error("Services.Invite() with invalid channel returned nil error")
+	}
+
+	if err := c.Services.Squery("Alis", "LIST *girc*"); err != nil || next() != "SQUERY Alis :LIST *girc*" {
+		t.Errorf("Services.Squery() failed: %v", err)
+	}
+
+	// Prompts from other users should be ignored.
+	c.RunHandlers(ParseEvent(":other!~other@some.isp.com NOTICE test :This nickname is registered."))
+	c.RunHandlers(ParseEvent(":NickServ!NickServ@services. NOTICE test :You are now identified"))
+	if got := next(); got != "" {
+		t.Fatalf("unexpected message sent: %q", got)
+	}
+
+	// The host of NickServ doesn't need to be recognized as services.
+	c.RunHandlers(ParseEvent(":NickServ!~nick@some.isp.com NOTICE test :This nickname is registered. Please choose a different nickname, or identify via \x02/msg NickServ identify <password>\x02."))
+	if got := next(); got != "PRIVMSG NickServ :IDENTIFY hunter2" {
+		t.Fatalf("auto-identify sent %q", got)
+	}
+
+	// Repeated prompts shouldn't cause us to identify again.
+	c.RunHandlers(ParseEvent(":NickServ!NickServ@services. NOTICE test :This nickname is registered."))
+	if got := next(); got != "" {
+		t.Fatalf("unexpected message sent: %q", got)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test"})
+	c.RunHandlers(ParseEvent(":dummy.int 005 test NETWORK=ExampleNet :are supported by this server"))
+
+	var ro interface{} = c.ReadOnly()
+
+	if _, ok := ro.(*Client); ok {
+		t.Fatal("Client.ReadOnly() can be asserted back to *Client")
+	}
+
+	if _, ok := ro.(interface{ Send(*Event) }); ok {
+		t.Fatal("Client.ReadOnly() exposes Send()")
+	}
+
+	view := ro.(ReadOnlyClient)
+	if view.IsConnected() || view.NetworkName() != "ExampleNet" || view.GetNick() != "test" {
+		t.Fatalf("unexpected read-only client: %s", view)
+	}
+
+	if opt, ok := view.GetServerOption("NETWORK"); !ok || opt != "ExampleNet" {
+		t.Fatalf("ReadOnlyClient.GetServerOption() = %q, %t", opt, ok)
+	}
+
+	if status := view.Status(); status != StatusDisconnected {
+		t.Fatalf("ReadOnlyClient.Status() = %v, want %v", status, StatusDisconnected)
+	}
+
+	if n := view.Stats().Events["005"]; n != 1 {
+		t.Fatalf("ReadOnlyClient.Stats().Events[\"005\"] = %d, want 1", n)
+	}
+
+	if queue := view.SendQueue(); queue.Length != 0 {
+		t.Fatalf("ReadOnlyClient.SendQueue() = %+v, want an empty queue", queue)
+	}
+}
+
+func TestNickReclaim(t *testing.T) {
+	c := New(Config{
+		Server:      "dummy.int",
+		Nick:        "test",
+		User:        "test",
+		AllowFlood:  true,
+		NickReclaim: &NickReclaim{Interval: time.Hour},
+	})
+
+	reclaimed := make(chan Event, 1)
+	c.Handlers.Add(NICK_RECLAIMED, func(c *Client, e Event) { reclaimed <- e })
+
+	lines := make(chan string, 20)
+	conn, _ := mockServer(t, c, func(e *Event, w io.Writer) {
+		switch {
+		case e.Command == NICK && e.Params[0] == "test_":
+			fmt.Fprint(w, ":dummy.int 001 test_ :Welcome\r\n")
+			fmt.Fprint(w, ":dummy.int 005 test_ MONITOR=100 :are supported by this server\r\n")
+		case e.Command == USER:
+			fmt.Fprint(w, ":dummy.int 433 * test :Nickname is already in use\r\n")
+		case e.Command == NICK || e.Command == MONITOR:
+			lines <- e.String()
+		}
+	})
+	defer c.Close()
+
+	expect := func(want string) {
+		select {
+		case line := <-lines:
+			if line != want {
+				t.Fatalf("client sent %q, want %q", line, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %q", want)
+		}
+	}
+
+	// First NICK is during registration.
+	expect("NICK test")
+	expect("MONITOR + test")
+	expect("NICK test")
+
+	// The reclaim attempt failing shouldn't cause another nickname change.
+	fmt.Fprint(conn, ":dummy.int 433 test_ test :Nickname is already in use\r\n")
+
+	// Once the nickname is available, it should be reclaimed immediately.
+	fmt.Fprint(conn, ":dummy.int 731 test_ :test\r\n")
+	expect("NICK test")
+
+	fmt.Fprint(conn, ":test_!test@local.int NICK test\r\n")
+	expect("MONITOR - test")
+
+	select {
+	case e := <-reclaimed:
+		if !reflect.DeepEqual(e.Params, []string{"test_", "test"}) {
+			t.Fatalf("NICK_RECLAIMED params = %v, want [test_ test]", e.Params)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for NICK_RECLAIMED")
+	}
+
+	if nick := c.GetNick(); nick != "test" {
+		t.Fatalf("Client.GetNick() = %q, want test", nick)
+	}
+}
+
+func TestPriming(t *testing.T) {
+	c := New(Config{
+		Server:     "dummy.int",
+		Nick:       "test",
+		User:       "test",
+		AllowFlood: true,
+		Priming:    &Priming{Who: true, Modes: true, Bans: true, Timeout: 100 * time.Millisecond},
+	})
+
+	ready := make(chan Event, 5)
+	c.Handlers.Add(CHANNEL_READY, func(c *Client, e Event) { ready <- e })
+
+	c.RunHandlers(ParseEvent(":test!~test@local.int JOIN #channel"))
+
+	var sent []string
+	for len(c.tx) > 0 {
+		sent = append(sent, (<-c.tx).event.String())
+	}
+	want := []string{"WHO #channel %tacuhnr," + whoxTrackingToken, "MODE #channel", "MODE #channel +b"}
+	if !reflect.DeepEqual(sent, want) {
+		t.Fatalf("sent %q after joining, want %q", sent, want)
+	}
+
+	replies := []string{
+		":dummy.int 353 test = #channel :test @nick",
+		":dummy.int 366 test #channel :End of /NAMES list.",
+		":dummy.int 354 test 1 #channel ~user host.int nick account :Real Name",
+		":dummy.int 315 test #channel :End of /WHO list.",
+		":dummy.int 324 test #channel +nt",
+		":dummy.int 367 test #channel *!*@bad.int op 1500000000",
+	}
+	for _, raw := range replies {
+		c.RunHandlers(ParseEvent(raw))
+	}
+
+	if len(ready) != 0 {
+		t.Fatal("CHANNEL_READY emitted before the ban list was received")
+	}
+
+	c.RunHandlers(ParseEvent(":dummy.int 368 test #channel :End of channel ban list"))
+
+	select {
+	case e := <-ready:
+		if e.Params[0] != "#channel" || e.Trailing != "" {
+			t.Fatalf("got %q, want CHANNEL_READY for #channel without timed out steps", e.String())
+		}
+	default:
+		t.Fatal("CHANNEL_READY not emitted once primed")
+	}
+
+	ch := c.LookupChannel("#channel")
+	if !ch.Modes.HasMode("n") || len(ch.Lists["b"]) != 1 || ch.Lists["b"][0].Mask != "*!*@bad.int" {
+		t.Fatalf("channel not primed: modes %q, bans %v", ch.Modes.String(), ch.Lists["b"])
+	}
+	if user := c.LookupUser("nick"); user == nil || user.Host != "host.int" {
+		t.Fatalf("channel not primed: LookupUser(nick) = %#v", user)
+	}
+
+	// Replies which never arrive.
+	c.RunHandlers(ParseEvent(":test!~test@local.int JOIN #slow"))
+	c.RunHandlers(ParseEvent(":dummy.int 366 test #slow :End of /NAMES list."))
+
+	select {
+	case e := <-ready:
+		if e.Params[0] != "#slow" || e.Trailing != "WHO MODE BANS" {
+			t.Fatalf("got %q, want CHANNEL_READY for #slow with the timed out steps", e.String())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("CHANNEL_READY not emitted after timeout")
+	}
+}
+
+func TestLogger(t *testing.T) {
+	var out bytes.Buffer
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test", Debug: &out})
+	c.logger.Warn("something happened", "channel", "#test", "reason", "a reason", "count", 2, "missing")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	want := regexp.MustCompile(`^\d\d:\d\d:\d\d client_test\.go:\d+: warn: something happened channel=#test reason="a reason" count=2 missing=!MISSING$`)
+	if line := lines[len(lines)-1]; !want.MatchString(line) {
+		t.Fatalf("Debug got %q, want it to match %q", line, want)
+	}
+
+	var mu sync.Mutex
+	var entries []string
+	c = New(Config{
+		Server: "dummy.int", Nick: "test", User: "test", Debug: &out,
+		Logger: LogFunc(func(level LogLevel, msg string, fields map[string]interface{}) {
+			mu.Lock()
+			entries = append(entries, fmt.Sprintf("%s %s %v", level, msg, fields))
+			mu.Unlock()
+		}),
+	})
+	c.Handlers.Add(PRIVMSG, func(c *Client, e Event) {})
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(entries) == 0 || !strings.HasPrefix(entries[len(entries)-1], "debug registering handler map[caller:") ||
+		!strings.Contains(entries[len(entries)-1], "command:PRIVMSG") {
+		t.Fatalf("Logger got %q, want the handler to be logged", entries)
+	}
+}
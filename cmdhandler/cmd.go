@@ -138,7 +138,8 @@ func (ch *CmdHandler) Add(cmd *Command) error {
 
 // Execute satisfies the girc.Handler interface.
 func (ch *CmdHandler) Execute(client *girc.Client, event girc.Event) {
-	if event.Source == nil || event.Command != girc.PRIVMSG {
+	// Don't trigger commands from replayed history.
+	if event.Source == nil || event.Command != girc.PRIVMSG || event.Replayed {
 		return
 	}
 
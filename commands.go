@@ -5,8 +5,10 @@
 package girc
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"strings"
 )
 
 // Commands holds a large list of useful methods to interact with the server,
@@ -15,9 +17,10 @@ type Commands struct {
 	c *Client
 }
 
-// Nick changes the client nickname.
+// Nick changes the client nickname. name is validated using the rules of the
+// network, see Client.IsValidNick().
 func (cmd *Commands) Nick(name string) error {
-	if !IsValidNick(name) {
+	if !cmd.c.IsValidNick(name) {
 		return &ErrInvalidTarget{Target: name}
 	}
 
@@ -26,8 +29,27 @@ func (cmd *Commands) Nick(name string) error {
 }
 
 // Join attempts to enter a list of IRC channels, at bulk if possible to
-// prevent sending extensive JOIN commands.
+// prevent sending extensive JOIN commands. If Config.Rejoin is enabled,
+// channels which were previously joined with JoinKey() (including in
+// previous runs, see Config.Store) are joined with the same key.
 func (cmd *Commands) Join(channels ...string) error {
+	for i := 0; i < len(channels); i++ {
+		if !IsValidChannel(channels[i]) {
+			return &ErrInvalidTarget{Target: channels[i]}
+		}
+	}
+
+	var plain []string
+	for _, channel := range channels {
+		if key := cmd.c.rejoin.key(cmd.c, channel); key != "" {
+			cmd.c.Send(&Event{Command: JOIN, Params: []string{channel, key}})
+			continue
+		}
+
+		plain = append(plain, channel)
+	}
+	channels = plain
+
 	// We can join multiple channels at once, however we need to ensure that
 	// we are not exceeding the line length. (see maxLength)
 	max := maxLength - len(JOIN) - 1
@@ -66,6 +88,8 @@ func (cmd *Commands) JoinKey(channel, password string) error {
 		return &ErrInvalidTarget{Target: channel}
 	}
 
+	cmd.c.rejoin.setKey(cmd.c, channel, password)
+
 	cmd.c.Send(&Event{Command: JOIN, Params: []string{channel, password}})
 	return nil
 }
@@ -124,22 +148,90 @@ func (cmd *Commands) SendCTCPReply(target, ctcpType, message string) error {
 	return cmd.Notice(target, out)
 }
 
-// Message sends a PRIVMSG to target (either channel, service, or user).
-func (cmd *Commands) Message(target, message string) error {
-	if !IsValidNick(target) && !IsValidChannel(target) {
-		return &ErrInvalidTarget{Target: target}
+// sendMessage sends message with command to target, which may be a comma
+// separated list of targets. Multiple targets are grouped together in as few
+// lines as the server allows (see ISUPPORT TARGMAX and MAXTARGETS). All
+// targets are validated before anything is sent.
+func (cmd *Commands) sendMessage(command, target, message string) error {
+	targets := strings.Split(target, ",")
+	for i := 0; i < len(targets); i++ {
+		if !cmd.c.isValidMessageTarget(targets[i]) {
+			return &ErrInvalidTarget{Target: targets[i]}
+		}
+	}
+
+	if len(targets) == 1 {
+		return cmd.c.send(&Event{Command: command, Params: []string{target}, Trailing: message})
+	}
+
+	for _, group := range cmd.c.groupTargets(command, targets, message) {
+		err := cmd.c.send(&Event{Command: command, Params: []string{strings.Join(group, ",")}, Trailing: message})
+		if err != nil {
+			return err
+		}
 	}
 
-	cmd.c.Send(&Event{Command: PRIVMSG, Params: []string{target}, Trailing: message})
 	return nil
 }
 
+// Message sends a PRIVMSG to target (either channel, service, or user). The
+// channel may be prefixed with one of the STATUSMSG prefixes supported by
+// the server (e.g. "@#channel"), to only message the users with that
+// permission (or higher) in the channel. Multiple targets can be supplied
+// as a comma separated list (e.g. "#channel,nick"), which are grouped
+// together as the server allows, see Client.Broadcast().
+func (cmd *Commands) Message(target, message string) error {
+	return cmd.sendMessage(PRIVMSG, target, message)
+}
+
 // Messagef sends a formated PRIVMSG to target (either channel, service, or
 // user).
 func (cmd *Commands) Messagef(target, format string, a ...interface{}) error {
 	return cmd.Message(target, fmt.Sprintf(format, a...))
 }
 
+// RelayMessage sends a PRIVMSG to channel which appears to originate from
+// nick, rather than the client itself. This requires the draft/relaymsg
+// capability (currently implemented by Ergo), and is primarily useful for
+// bridges which want relayed users to look like native IRC users. nick must
+// contain one of the separator characters the server advertises (commonly
+// "/", e.g. "user/discord"), so it cannot collide with real nicknames.
+func (cmd *Commands) RelayMessage(channel, nick, message string) error {
+	if !IsValidChannel(channel) {
+		return &ErrInvalidTarget{Target: channel}
+	}
+
+	if !cmd.c.HasCapability(capRelayMsg) {
+		return &ErrCapNotEnabled{Cap: capRelayMsg}
+	}
+
+	cmd.c.state.RLock()
+	separators := strings.Join(cmd.c.state.serverCaps[capRelayMsg], "")
+	cmd.c.state.RUnlock()
+
+	if separators == "" {
+		separators = "/"
+	}
+
+	if nick == "" || !strings.ContainsAny(nick, separators) {
+		return &ErrInvalidTarget{Target: nick}
+	}
+
+	cmd.c.Send(&Event{Command: RELAYMSG, Params: []string{channel, nick}, Trailing: message})
+	return nil
+}
+
+// SetUserMode changes the user modes of the client, e.g. "+B-x". See also
+// Client.UserModes().
+func (cmd *Commands) SetUserMode(modes string) error {
+	if len(modes) < 2 || (modes[0] != '+' && modes[0] != '-') {
+		return &ErrInvalidTarget{Target: modes}
+	}
+
+	cmd.c.Send(&Event{Command: MODE, Params: []string{cmd.c.GetNick(), modes}})
+	return nil
+}
+
 // ErrInvalidSource is returned when a method needs to know the origin of an
 // event, however Event.Source is unknown (e.g. sent by the user, not the
 // server.)
@@ -152,11 +244,8 @@ func (cmd *Commands) Reply(event Event, message string) error {
 		return ErrInvalidSource
 	}
 
-	if len(event.Params) > 0 && IsValidChannel(event.Params[0]) {
-		return cmd.Message(event.Params[0], message)
-	}
-
-	return cmd.Message(event.Source.Name, message)
+	target, _ := cmd.responseTarget(event)
+	return cmd.reply(event, target, message)
 }
 
 // Replyf sends a reply to channel or user with a format string, based on
@@ -173,11 +262,58 @@ func (cmd *Commands) ReplyTo(event Event, message string) error {
 		return ErrInvalidSource
 	}
 
-	if len(event.Params) > 0 && IsValidChannel(event.Params[0]) {
-		return cmd.Message(event.Params[0], event.Source.Name+", "+message)
+	target, channel := cmd.responseTarget(event)
+	if channel {
+		return cmd.reply(event, target, event.Source.Name+", "+message)
 	}
 
-	return cmd.Message(event.Source.Name, message)
+	return cmd.reply(event, target, message)
+}
+
+// ReplyMode determines whether replies sent with Commands.Reply() and
+// Commands.ReplyTo() use PRIVMSG or NOTICE. See Config.ReplyMode.
+type ReplyMode int
+
+const (
+	// ReplyPrivmsg sends all replies as a PRIVMSG. This is the default.
+	ReplyPrivmsg ReplyMode = iota
+	// ReplyNotice sends all replies as a NOTICE, which is what RFC1459
+	// recommends for automated replies.
+	ReplyNotice
+	// ReplyNoticeUsers sends replies to users as a NOTICE, and replies to
+	// channels as a PRIVMSG.
+	ReplyNoticeUsers
+)
+
+// reply sends message to target as either a PRIVMSG or NOTICE, depending on
+// the configured reply mode for the target. Replies to a NOTICE are always
+// sent as a NOTICE, to prevent automated reply loops.
+func (cmd *Commands) reply(event Event, target, message string) error {
+	if event.Command == NOTICE {
+		return cmd.Notice(target, message)
+	}
+
+	// Replies to STATUSMSG targets (e.g. "@#channel") use the mode of the
+	// channel.
+	_, channel, isChannel := splitStatusMsg(statusPrefixes, target)
+	if !isChannel {
+		channel = target
+	}
+
+	mode := cmd.c.Config.ReplyMode
+	for name, m := range cmd.c.Config.ReplyModeTargets {
+		if cmd.c.Equal(name, channel) {
+			mode = m
+			break
+		}
+	}
+
+	switch {
+	case mode == ReplyNotice, mode == ReplyNoticeUsers && !isChannel:
+		return cmd.Notice(target, message)
+	}
+
+	return cmd.Message(target, message)
 }
 
 // ReplyTof sends a reply to a channel or user with a format string, based
@@ -191,16 +327,15 @@ func (cmd *Commands) ReplyTof(event Event, format string, a ...interface{}) erro
 // Action sends a PRIVMSG ACTION (/me) to target (either channel, service,
 // or user).
 func (cmd *Commands) Action(target, message string) error {
-	if !IsValidNick(target) && !IsValidChannel(target) {
+	if !cmd.c.isValidMessageTarget(target) {
 		return &ErrInvalidTarget{Target: target}
 	}
 
-	cmd.c.Send(&Event{
+	return cmd.c.send(&Event{
 		Command:  PRIVMSG,
 		Params:   []string{target},
 		Trailing: fmt.Sprintf("\001ACTION %s\001", message),
 	})
-	return nil
 }
 
 // Actionf sends a formated PRIVMSG ACTION (/me) to target (either channel,
@@ -209,14 +344,10 @@ func (cmd *Commands) Actionf(target, format string, a ...interface{}) error {
 	return cmd.Action(target, fmt.Sprintf(format, a...))
 }
 
-// Notice sends a NOTICE to target (either channel, service, or user).
+// Notice sends a NOTICE to target (either channel, service, or user). Like
+// Message(), multiple targets can be supplied as a comma separated list.
 func (cmd *Commands) Notice(target, message string) error {
-	if !IsValidNick(target) && !IsValidChannel(target) {
-		return &ErrInvalidTarget{Target: target}
-	}
-
-	cmd.c.Send(&Event{Command: NOTICE, Params: []string{target}, Trailing: message})
-	return nil
+	return cmd.sendMessage(NOTICE, target, message)
 }
 
 // Noticef sends a formated NOTICE to target (either channel, service, or
@@ -226,9 +357,9 @@ func (cmd *Commands) Noticef(target, format string, a ...interface{}) error {
 }
 
 // SendRaw sends a raw string back to the server, without carriage returns
-// or newlines.
+// or newlines. The command is expanded if it is one of Config.Aliases.
 func (cmd *Commands) SendRaw(raw string) error {
-	e := ParseEvent(raw)
+	e := ParseEvent(cmd.c.expandAlias(raw))
 	if e == nil {
 		return errors.New("invalid event: " + raw)
 	}
@@ -243,6 +374,53 @@ func (cmd *Commands) SendRawf(format string, a ...interface{}) error {
 	return cmd.SendRaw(fmt.Sprintf(format, a...))
 }
 
+// SendRawBatch sends multiple raw lines to the server, in order, such as
+// from a script of commands. All lines are validated before anything is
+// sent, returning an ErrInvalidLine for the first invalid line. Lines are
+// rate limited across the batch like any other event (unless
+// Config.AllowFlood is set), and each line is written before the next one
+// is queued (see Client.SendCtx()). If ctx is cancelled before a line has
+// been written, it and the remaining lines are not sent, and the context
+// error is returned. sent is the amount of lines that were sent, i.e.
+// lines[:sent].
+// Like with Commands.SendRaw(), commands are expanded if they are one of
+// Config.Aliases.
+func (cmd *Commands) SendRawBatch(ctx context.Context, lines []string) (sent int, err error) {
+	events := make([]*Event, len(lines))
+	for i := 0; i < len(lines); i++ {
+		if strings.ContainsAny(lines[i], "\r\n") {
+			return 0, &ErrInvalidLine{Line: i, Raw: lines[i]}
+		}
+
+		if events[i] = ParseEvent(cmd.c.expandAlias(lines[i])); events[i] == nil || events[i].Len() > maxLength {
+			return 0, &ErrInvalidLine{Line: i, Raw: lines[i]}
+		}
+	}
+
+	if !cmd.c.IsConnected() {
+		return 0, ErrNotConnected
+	}
+
+	for sent = 0; sent < len(events); sent++ {
+		if err = cmd.c.SendCtx(ctx, events[sent]); err != nil {
+			return sent, err
+		}
+	}
+
+	return sent, nil
+}
+
+// ErrInvalidLine is returned by Commands.SendRawBatch() when a line isn't a
+// valid event. Line is the index of the line within the batch.
+type ErrInvalidLine struct {
+	Line int
+	Raw  string
+}
+
+func (e *ErrInvalidLine) Error() string {
+	return fmt.Sprintf("invalid event on line %d: %q", e.Line+1, e.Raw)
+}
+
 // Topic sets the topic of channel to message. Does not verify the length
 // of the topic.
 func (cmd *Commands) Topic(channel, message string) {
@@ -252,13 +430,15 @@ func (cmd *Commands) Topic(channel, message string) {
 // Who sends a WHO query to the server, which will attempt WHOX by default.
 // See http://faerion.sourceforge.net/doc/irc/whox.var for more details. This
 // sends "%tcuhnr,2" per default. Do not use "1" as this will conflict with
-// girc's builtin tracking functionality.
+// girc's builtin tracking functionality, see Client.WhoxToken() for tokens
+// which are safe to use with your own queries, or WhoWait() to collect the
+// replies.
 func (cmd *Commands) Who(target string) error {
 	if !IsValidNick(target) && !IsValidChannel(target) && !IsValidUser(target) {
 		return &ErrInvalidTarget{Target: target}
 	}
 
-	cmd.c.Send(&Event{Command: WHO, Params: []string{target, "%tcuhnr,2"}})
+	cmd.c.Send(&Event{Command: WHO, Params: []string{target, "%tcuhnr," + whoxWhoToken}})
 	return nil
 }
 
@@ -291,6 +471,89 @@ func (cmd *Commands) Oper(user, pass string) {
 	cmd.c.Send(&Event{Command: OPER, Params: []string{user, pass}, Sensitive: true})
 }
 
+// serverQuery sends a query for information about server (or a mask of
+// servers) to the server, or about the current server if server is empty.
+func (cmd *Commands) serverQuery(command string, params ...string) error {
+	event, err := serverQueryEvent(command, params...)
+	if err != nil {
+		return err
+	}
+
+	cmd.c.Send(event)
+	return nil
+}
+
+// serverQueryEvent returns the event of a query, see Commands.serverQuery().
+func serverQueryEvent(command string, params ...string) (*Event, error) {
+	var out []string
+	for _, param := range params {
+		if param == "" {
+			continue
+		}
+
+		if param[0] == messagePrefix || strings.ContainsAny(param, " \r\n\x00") {
+			return nil, &ErrInvalidTarget{Target: param}
+		}
+
+		out = append(out, param)
+	}
+
+	return &Event{Command: command, Params: out}, nil
+}
+
+// Admin sends an ADMIN query to the server, requesting the administrative
+// contact information of server, or the current server if empty.
+func (cmd *Commands) Admin(server string) error {
+	return cmd.serverQuery(ADMIN, server)
+}
+
+// Motd sends a MOTD query to the server, requesting the message of the day
+// of server, or the current server if empty.
+func (cmd *Commands) Motd(server string) error {
+	return cmd.serverQuery(MOTD, server)
+}
+
+// Stats sends a STATS query to the server, requesting the statistics
+// identified by query (e.g. "u" for uptime) of server, or the current server
+// if empty.
+func (cmd *Commands) Stats(query, server string) error {
+	if query == "" {
+		return &ErrInvalidTarget{Target: query}
+	}
+
+	return cmd.serverQuery(STATS, query, server)
+}
+
+// Time sends a TIME query to the server, requesting the local time of
+// server, or the current server if empty.
+func (cmd *Commands) Time(server string) error {
+	return cmd.serverQuery(TIME, server)
+}
+
+// Version sends a VERSION query to the server, requesting the version of
+// server, or the current server if empty.
+func (cmd *Commands) Version(server string) error {
+	return cmd.serverQuery(VERSION, server)
+}
+
+// Links sends a LINKS query to the server, requesting the servers matching
+// mask (or all servers if empty) known by remote (or the current server if
+// empty). Note that mask is required when remote is supplied.
+func (cmd *Commands) Links(remote, mask string) error {
+	if remote != "" && mask == "" {
+		return &ErrInvalidTarget{Target: mask}
+	}
+
+	return cmd.serverQuery(LINKS, remote, mask)
+}
+
+// Map sends a MAP query to the server, requesting the map of the servers in
+// the network. This isn't part of RFC2812, however is supported by most
+// servers (though it may be restricted to operators).
+func (cmd *Commands) Map() {
+	cmd.c.Send(&Event{Command: MAP})
+}
+
 // Kick sends a KICK query to the server, attempting to kick nick from
 // channel, with reason. If reason is blank, one will not be sent to the
 // server.
@@ -344,6 +607,22 @@ func (cmd *Commands) Back() {
 	cmd.c.Send(&Event{Command: AWAY})
 }
 
+// Quit sends a QUIT to the server with the given message, after which the
+// server will close the connection. If message is blank, Config.BotInfo is
+// used (if set). Use Client.Close() to disconnect without sending a QUIT.
+func (cmd *Commands) Quit(message string) {
+	if message == "" {
+		message = cmd.c.quitMessage()
+	}
+
+	if message == "" {
+		cmd.c.Send(&Event{Command: QUIT})
+		return
+	}
+
+	cmd.c.Send(&Event{Command: QUIT, Trailing: message})
+}
+
 // List sends a LIST query to the server, which will list channels and topics.
 // Supports multiple channels at once, in hopes it will reduce extensive
 // LIST queries to the server. Supply no channels to run a list against the
@@ -0,0 +1,834 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestReplyMode(t *testing.T) {
+	c := New(Config{
+		Server:           "dummy.int",
+		Nick:             "test",
+		User:             "test",
+		AllowFlood:       true,
+		ReplyMode:        ReplyNoticeUsers,
+		ReplyModeTargets: map[string]ReplyMode{"#Notices": ReplyNotice},
+	})
+
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: ":nick!user@host PRIVMSG #test :!ping", want: PRIVMSG},
+		{in: ":nick!user@host PRIVMSG test :!ping", want: NOTICE},
+		{in: ":nick!user@host PRIVMSG #notices :!ping", want: NOTICE},
+		{in: ":nick!user@host NOTICE #test :!ping", want: NOTICE},
+	}
+
+	for _, tt := range tests {
+		if err := c.Cmd.Reply(*ParseEvent(tt.in), "pong"); err != nil {
+			t.Fatalf("Commands.Reply() returned error: %s", err)
+		}
+
+		if e := (<-c.tx).event; e.Command != tt.want {
+			t.Fatalf("Commands.Reply() to %q sent %s, wanted %s", tt.in, e.Command, tt.want)
+		}
+	}
+}
+
+func TestGroupTargets(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test"})
+
+	targets := []string{"#a", "#b", "#c", "#d", "#e"}
+
+	if groups := c.groupTargets(NOTICE, targets, "test"); len(groups) != 5 {
+		t.Fatalf("Client.groupTargets() without TARGMAX = %q, want 5 groups", groups)
+	}
+
+	c.RunHandlers(ParseEvent(":dummy.int 005 test TARGMAX=PRIVMSG:3,NOTICE:2,KICK: :are supported by this server"))
+
+	groups := c.groupTargets(NOTICE, targets, "test")
+	if !reflect.DeepEqual(groups, [][]string{{"#a", "#b"}, {"#c", "#d"}, {"#e"}}) {
+		t.Fatalf("Client.groupTargets() = %q, unexpected", groups)
+	}
+
+	if max := c.targetMax(KICK); max != 0 {
+		t.Fatalf("Client.targetMax(KICK) = %d, want 0", max)
+	}
+
+	if max := c.targetMax(JOIN); max != 1 {
+		t.Fatalf("Client.targetMax(JOIN) = %d, want 1", max)
+	}
+}
+
+func TestBanRefresh(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test", AllowFlood: true, BanRefreshAge: time.Minute})
+
+	bans := make(chan string, 2)
+	mockServer(t, c, func(e *Event, w io.Writer) {
+		switch {
+		case e.Command == WHO && e.Params[0] == "nick":
+			fmt.Fprint(w, ":dummy.int 354 test 1 #channel ~user cloaked.host nick 0 :Real Name\r\n")
+			fmt.Fprint(w, ":dummy.int 315 test nick :End of /WHO list.\r\n")
+		case e.Command == MODE && len(e.Params) == 3:
+			bans <- e.Params[2]
+		}
+	})
+	defer c.Close()
+
+	c.state.Lock()
+	c.state.createChannel("#channel")
+	c.state.createUser("nick")
+	c.state.lookupUser("nick").addChannel("#channel")
+	c.state.lookupChannel("#channel").addUser("nick")
+	c.state.lookupUser("nick").Host = "stale.host"
+	c.state.Unlock()
+
+	if err := c.Cmd.Ban("#channel", "nick"); err != nil {
+		t.Fatalf("Commands.Ban() returned error: %s", err)
+	}
+
+	select {
+	case mask := <-bans:
+		if mask != "*!*@cloaked.host" {
+			t.Fatalf("Commands.Ban() used mask %q, want *!*@cloaked.host", mask)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for ban")
+	}
+
+	// The host is now fresh, so it shouldn't be refreshed again.
+	c.state.Lock()
+	c.state.lookupUser("nick").Host = "fresh.host"
+	c.state.Unlock()
+
+	if err := c.Cmd.Ban("#channel", "nick"); err != nil {
+		t.Fatalf("Commands.Ban() returned error: %s", err)
+	}
+
+	select {
+	case mask := <-bans:
+		if mask != "*!*@fresh.host" {
+			t.Fatalf("Commands.Ban() used mask %q, want *!*@fresh.host", mask)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for ban")
+	}
+}
+
+func TestWhoisWait(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test", AllowFlood: true})
+
+	mockServer(t, c, func(e *Event, w io.Writer) {
+		switch {
+		case e.Command != WHOIS:
+		case e.Params[0] == "nick":
+			fmt.Fprint(w, ":dummy.int 311 test Nick ~user some.host * :Real Name\r\n")
+			fmt.Fprint(w, ":dummy.int 319 test Nick :@#girc +#test\r\n")
+			fmt.Fprint(w, ":dummy.int 312 test Nick irc.dummy.int :Dummy Server\r\n")
+			fmt.Fprint(w, ":dummy.int 301 test Nick :gone fishing\r\n")
+			fmt.Fprint(w, ":dummy.int 313 test Nick :is an IRC Operator\r\n")
+			fmt.Fprint(w, ":dummy.int 671 test Nick :is using a secure connection\r\n")
+			fmt.Fprint(w, ":dummy.int 317 test Nick 42 1500000000 :seconds idle, signon time\r\n")
+			fmt.Fprint(w, ":dummy.int 330 test Nick account :is logged in as\r\n")
+			fmt.Fprint(w, ":dummy.int 318 test Nick :End of /WHOIS list.\r\n")
+		default:
+			fmt.Fprintf(w, ":dummy.int 401 test %s :No such nick/channel\r\n", e.Params[0])
+			fmt.Fprintf(w, ":dummy.int 318 test %s :End of /WHOIS list.\r\n", e.Params[0])
+		}
+	})
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	whois, err := c.Cmd.WhoisWait(ctx, "nick")
+	if err != nil {
+		t.Fatalf("Commands.WhoisWait() returned error: %s", err)
+	}
+
+	want := &Whois{
+		Nick: "Nick", Ident: "~user", Host: "some.host", Name: "Real Name",
+		Server: "irc.dummy.int", ServerInfo: "Dummy Server",
+		Channels: []string{"@#girc", "+#test"}, Account: "account",
+		Away: "gone fishing", Idle: 42 * time.Second, SignOn: time.Unix(1500000000, 0),
+		Secure: true, Oper: true,
+	}
+
+	if !reflect.DeepEqual(whois, want) {
+		t.Fatalf("Commands.WhoisWait() = %#v, want %#v", whois, want)
+	}
+
+	_, err = c.Cmd.WhoisWait(ctx, "missing")
+	if e, ok := err.(*ErrEvent); !ok || e.Event.Command != ERR_NOSUCHNICK {
+		t.Fatalf("Commands.WhoisWait() for missing nick returned %#v", err)
+	}
+}
+
+func TestWhoWait(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test", AllowFlood: true})
+
+	mockServer(t, c, func(e *Event, w io.Writer) {
+		if e.Command == PING {
+			fmt.Fprintf(w, ":dummy.int PONG dummy.int :%s\r\n", e.Params[0])
+			return
+		}
+
+		if e.Command != WHO || len(e.Params) != 2 {
+			return
+		}
+
+		token := e.Params[1][strings.IndexByte(e.Params[1], ',')+1:]
+
+		switch e.Params[0] {
+		case "#whox":
+			// Also include the replies to a query of the builtin tracking,
+			// including its end, which should be ignored.
+			fmt.Fprint(w, ":dummy.int 354 test 1 #whox ~other other.host other.dummy.int other H 0 :Other\r\n")
+			fmt.Fprint(w, ":dummy.int 315 test #whox :End of /WHO list.\r\n")
+			fmt.Fprintf(w, ":dummy.int 354 test %s #whox ~user some.host irc.dummy.int nick H@ account :Real Name\r\n", token)
+			fmt.Fprintf(w, ":dummy.int 354 test %s #whox user2 other.host irc.dummy.int nick2 G 0 :Another Name\r\n", token)
+			fmt.Fprint(w, ":dummy.int 315 test #whox :End of /WHO list.\r\n")
+		case "#who":
+			// Without WHOX, replies to other queries are merged.
+			for i := 0; i < 2; i++ {
+				fmt.Fprint(w, ":dummy.int 352 test #who ~user some.host irc.dummy.int nick H* :0 Real Name\r\n")
+				fmt.Fprint(w, ":dummy.int 315 test #who :End of /WHO list.\r\n")
+			}
+		}
+	})
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	replies, err := c.Cmd.WhoWait(ctx, "#whox")
+	if err != nil {
+		t.Fatalf("Commands.WhoWait() returned error: %s", err)
+	}
+
+	want := []WhoReply{
+		{Channel: "#whox", Nick: "nick", Ident: "~user", Host: "some.host", Server: "irc.dummy.int", Flags: "H@", Account: "account", Name: "Real Name"},
+		{Channel: "#whox", Nick: "nick2", Ident: "user2", Host: "other.host", Server: "irc.dummy.int", Flags: "G", Name: "Another Name"},
+	}
+
+	if !reflect.DeepEqual(replies, want) {
+		t.Fatalf("Commands.WhoWait() = %#v, want %#v", replies, want)
+	}
+
+	if !replies[1].Away() || replies[0].Away() {
+		t.Fatal("WhoReply.Away() returned unexpected results")
+	}
+
+	replies, err = c.Cmd.WhoWait(ctx, "#who")
+	if err != nil {
+		t.Fatalf("Commands.WhoWait() returned error: %s", err)
+	}
+
+	want = []WhoReply{{Channel: "#who", Nick: "nick", Ident: "~user", Host: "some.host", Server: "irc.dummy.int", Flags: "H*", Name: "Real Name"}}
+	if !reflect.DeepEqual(replies, want) {
+		t.Fatalf("Commands.WhoWait() without WHOX = %#v, want %#v", replies, want)
+	}
+}
+
+func TestWhoxToken(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test"})
+
+	for i := 0; i < 2000; i++ {
+		token := c.WhoxToken()
+		n, err := strconv.Atoi(token)
+		if err != nil || n < 3 || n > 999 {
+			t.Fatalf("Client.WhoxToken() returned invalid token %q", token)
+		}
+	}
+}
+
+func TestListWait(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test", AllowFlood: true})
+
+	var busy bool
+	lists := make(chan string, 5)
+	mockServer(t, c, func(e *Event, w io.Writer) {
+		if e.Command != LIST {
+			return
+		}
+
+		lists <- strings.Join(e.Params, " ")
+
+		if !busy {
+			busy = true
+			fmt.Fprint(w, ":dummy.int 263 test LIST :Server load is temporarily too heavy. Please wait a while and try again.\r\n")
+			return
+		}
+
+		fmt.Fprint(w, ":dummy.int 321 test Channel :Users  Name\r\n")
+		fmt.Fprint(w, ":dummy.int 322 test #girc 12 :[+nt] girc development\r\n")
+		fmt.Fprint(w, ":dummy.int 322 test #girc-test 2 :testing\r\n")
+		fmt.Fprint(w, ":dummy.int 322 test #other 50 :other\r\n")
+		fmt.Fprint(w, ":dummy.int 323 test :End of /LIST\r\n")
+	})
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	entries, err := c.Cmd.ListWait(ctx, &ListOptions{Masks: []string{"#GIRC*"}, MinUsers: 5, RetryDelay: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Commands.ListWait() returned error: %s", err)
+	}
+
+	want := []ListEntry{{Channel: "#girc", Users: 12, Topic: "[+nt] girc development"}}
+	if !reflect.DeepEqual(entries, want) {
+		t.Fatalf("Commands.ListWait() = %#v, want %#v", entries, want)
+	}
+
+	// The server doesn't support ELIST, so the filters shouldn't be sent, and
+	// the LIST should've been retried once.
+	for i := 0; i < 2; i++ {
+		if got := <-lists; got != "" {
+			t.Fatalf("Commands.ListWait() sent LIST %q, want no params", got)
+		}
+	}
+
+	// With ELIST support, the filters should be sent to the server.
+	c.RunHandlers(ParseEvent(":dummy.int 005 test ELIST=MU :are supported by this server"))
+
+	var streamed []string
+	entries, err = c.Cmd.ListWait(ctx, &ListOptions{
+		Masks: []string{"#girc*"}, MinUsers: 2, MaxUsers: 20,
+		Callback: func(entry ListEntry) { streamed = append(streamed, entry.Channel) },
+	})
+	if err != nil || entries != nil {
+		t.Fatalf("Commands.ListWait() with callback = %#v, %v", entries, err)
+	}
+
+	if got := <-lists; got != "#girc*,>1,<21" {
+		t.Fatalf("Commands.ListWait() sent LIST %q", got)
+	}
+
+	if !reflect.DeepEqual(streamed, []string{"#girc", "#girc-test"}) {
+		t.Fatalf("Commands.ListWait() streamed %v", streamed)
+	}
+}
+
+func TestNames(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test", AllowFlood: true})
+
+	mockServer(t, c, func(e *Event, w io.Writer) {
+		if e.Command != NAMES {
+			return
+		}
+
+		switch e.Params[0] {
+		case "#plain":
+			fmt.Fprint(w, ":dummy.int 353 test = #plain :@op +voice\r\n")
+			fmt.Fprint(w, ":dummy.int 353 test = #other :ignored\r\n")
+			fmt.Fprint(w, ":dummy.int 353 test = #plain :@+both user\r\n")
+			fmt.Fprint(w, ":dummy.int 366 test #plain :End of /NAMES list.\r\n")
+		case "#userhost":
+			fmt.Fprint(w, ":dummy.int 353 test @ #userhost :@op!~op@op.host user!user@user.host\r\n")
+			fmt.Fprint(w, ":dummy.int 366 test #userhost :End of /NAMES list.\r\n")
+		}
+	})
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	names, err := c.Cmd.Names(ctx, "#plain")
+	if err != nil {
+		t.Fatalf("Commands.Names() returned error: %s", err)
+	}
+
+	if want := []string{"@op", "+voice", "@+both", "user"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("Commands.Names() = %q, want %q", names, want)
+	}
+
+	entries, err := c.Cmd.NamesEntries(ctx, "#userhost")
+	if err != nil {
+		t.Fatalf("Commands.NamesEntries() returned error: %s", err)
+	}
+
+	want := []NamesEntry{
+		{Prefixes: "@", Nick: "op", Ident: "~op", Host: "op.host"},
+		{Nick: "user", Ident: "user", Host: "user.host"},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Fatalf("Commands.NamesEntries() = %#v, want %#v", entries, want)
+	}
+}
+
+func TestServerInfo(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test", AllowFlood: true})
+
+	var pinged bool
+	mockServer(t, c, func(e *Event, w io.Writer) {
+		switch {
+		case e.Command == PING:
+			// Replies which were sent before the query (e.g. while
+			// connecting) should be ignored.
+			if !pinged {
+				pinged = true
+				fmt.Fprint(w, ":dummy.int 251 test :There are 1 users and 0 invisible on 1 servers\r\n")
+			}
+
+			fmt.Fprintf(w, ":dummy.int PONG dummy.int :%s\r\n", e.Params[0])
+		case e.Command == LUSERS:
+			fmt.Fprint(w, ":dummy.int 251 test :There are 12 users and 30 invisible on 3 servers\r\n")
+			fmt.Fprint(w, ":dummy.int 252 test 4 :IRC Operators online\r\n")
+			fmt.Fprint(w, ":dummy.int 253 test 1 :unknown connection(s)\r\n")
+			fmt.Fprint(w, ":dummy.int 254 test 20 :channels formed\r\n")
+			fmt.Fprint(w, ":dummy.int 255 test :I have 15 clients and 1 servers\r\n")
+			fmt.Fprint(w, ":dummy.int 265 test 15 25 :Current local users 15, max 25\r\n")
+			fmt.Fprint(w, ":dummy.int 266 test :Current global users 42, max 50\r\n")
+		case e.Command == ADMIN && len(e.Params) == 0:
+			fmt.Fprint(w, ":dummy.int 256 test dummy.int :Administrative info\r\n")
+			fmt.Fprint(w, ":dummy.int 257 test :Somewhere, Earth\r\n")
+			fmt.Fprint(w, ":dummy.int 258 test :Dummy Org\r\n")
+			fmt.Fprint(w, ":dummy.int 259 test :admin@dummy.int\r\n")
+		case e.Command == ADMIN:
+			fmt.Fprintf(w, ":dummy.int 402 test %s :No such server\r\n", strings.ToUpper(e.Params[0]))
+		case e.Command == INFO && len(e.Params) > 0 && e.Params[0] == "missing.int":
+			fmt.Fprint(w, ":dummy.int 402 test missing.int :No such server\r\n")
+		case e.Command == INFO:
+			// An error for another query is ignored.
+			fmt.Fprint(w, ":dummy.int 402 test elsewhere.int :No such server\r\n")
+			fmt.Fprint(w, ":dummy.int 371 test :dummy ircd 1.0\r\n")
+			fmt.Fprint(w, ":dummy.int 371 test :written by nobody\r\n")
+			fmt.Fprint(w, ":dummy.int 374 test :End of /INFO list.\r\n")
+		}
+	})
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	lusers, err := c.Cmd.Lusers(ctx)
+	if err != nil {
+		t.Fatalf("Commands.Lusers() returned error: %s", err)
+	}
+
+	wantLusers := &Lusers{
+		Users: 12, Invisible: 30, Servers: 3, Operators: 4, Unknown: 1, Channels: 20,
+		LocalClients: 15, LocalServers: 1, LocalUsers: 15, MaxLocalUsers: 25,
+		GlobalUsers: 42, MaxGlobalUsers: 50,
+	}
+	if !reflect.DeepEqual(lusers, wantLusers) {
+		t.Fatalf("Commands.Lusers() = %#v, want %#v", lusers, wantLusers)
+	}
+
+	admin, err := c.Cmd.AdminWait(ctx, "")
+	if err != nil {
+		t.Fatalf("Commands.AdminWait() returned error: %s", err)
+	}
+
+	wantAdmin := &ServerAdmin{Server: "dummy.int", Location: "Somewhere, Earth", Organization: "Dummy Org", Email: "admin@dummy.int"}
+	if !reflect.DeepEqual(admin, wantAdmin) {
+		t.Fatalf("Commands.AdminWait() = %#v, want %#v", admin, wantAdmin)
+	}
+
+	_, err = c.Cmd.AdminWait(ctx, "missing.int")
+	if e, ok := err.(*ErrEvent); !ok || e.Event.Command != ERR_NOSUCHSERVER {
+		t.Fatalf("Commands.AdminWait() for missing server returned %#v", err)
+	}
+
+	info, err := c.Cmd.Info(ctx, "")
+	if err != nil {
+		t.Fatalf("Commands.Info() returned error: %s", err)
+	}
+
+	if want := []string{"dummy ircd 1.0", "written by nobody"}; !reflect.DeepEqual(info, want) {
+		t.Fatalf("Commands.Info() = %q, want %q", info, want)
+	}
+
+	_, err = c.Cmd.Info(ctx, "missing.int")
+	if e, ok := err.(*ErrEvent); !ok || e.Event.Command != ERR_NOSUCHSERVER {
+		t.Fatalf("Commands.Info() for missing server returned %#v", err)
+	}
+
+	if _, err = c.Cmd.Info(ctx, "bad server"); err == nil {
+		t.Fatal("Commands.Info() with an invalid server succeeded")
+	}
+}
+
+func TestSendRawBatch(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test"})
+
+	lines := []string{"MODE #channel +o one", "MODE #channel +v two", "TOPIC #channel :new topic"}
+	if _, err := c.Cmd.SendRawBatch(context.Background(), lines); err != ErrNotConnected {
+		t.Fatalf("SendRawBatch() when not connected = %v, want ErrNotConnected", err)
+	}
+
+	_, out, conn := mockBuffers()
+	c.conn = conn
+
+	sent, err := c.Cmd.SendRawBatch(context.Background(), []string{"MODE #channel +o one", "", "PRIVMSG #channel :a\r\nQUIT"})
+	if e, ok := err.(*ErrInvalidLine); !ok || e.Line != 1 || sent != 0 || len(c.tx) != 0 {
+		t.Fatalf("SendRawBatch() with invalid line = %d, %v, want ErrInvalidLine for line 1 and nothing sent", sent, err)
+	}
+
+	stop := runSendLoop(c)
+	if sent, err = c.Cmd.SendRawBatch(context.Background(), lines); sent != len(lines) || err != nil {
+		t.Fatalf("SendRawBatch() = %d, %v, want %d, nil", sent, err, len(lines))
+	}
+
+	// Once the rate limit kicks in, cancelling should stop the batch.
+	conn.mu.Lock()
+	conn.writeDelay = 30 * time.Second
+	conn.lastWrite = time.Now()
+	conn.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if sent, err = c.Cmd.SendRawBatch(ctx, lines); sent != 0 || err != context.DeadlineExceeded {
+		t.Fatalf("SendRawBatch() when cancelled = %d, %v, want 0, context.DeadlineExceeded", sent, err)
+	}
+	stop()
+
+	if want := strings.Join(lines, "\r\n") + "\r\n"; out.String() != want {
+		t.Fatalf("SendRawBatch() sent %q, want %q", out.String(), want)
+	}
+}
+
+func TestAliases(t *testing.T) {
+	c := New(Config{
+		Server: "dummy.int", Nick: "test", User: "test",
+		Aliases: map[string]string{"CS": "PRIVMSG ChanServ :", "UMODE": "MODE $nick", "j": "JOIN"},
+	})
+
+	_, out, conn := mockBuffers()
+	c.conn = conn
+
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{raw: "CS IDENTIFY password", want: "PRIVMSG ChanServ :IDENTIFY password"},
+		{raw: "cs op #channel", want: "PRIVMSG ChanServ :op #channel"},
+		{raw: "UMODE +i", want: "MODE test +i"},
+		{raw: "J #channel", want: "JOIN #channel"},
+		{raw: "PRIVMSG #channel :CS", want: "PRIVMSG #channel :CS"},
+		{raw: ":CS PRIVMSG #channel :hello", want: ":CS PRIVMSG #channel :hello"},
+	}
+
+	for _, tt := range tests {
+		if err := c.Cmd.SendRaw(tt.raw); err != nil {
+			t.Fatalf("SendRaw(%q) = %v", tt.raw, err)
+		}
+
+		if got := (<-c.tx).event.String(); got != tt.want {
+			t.Fatalf("SendRaw(%q) sent %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+
+	stop := runSendLoop(c)
+	if _, err := c.Cmd.SendRawBatch(context.Background(), []string{"UMODE -i"}); err != nil {
+		t.Fatalf("SendRawBatch() = %v", err)
+	}
+	stop()
+	if got := out.String(); got != "MODE test -i\r\n" {
+		t.Fatalf("SendRawBatch() sent %q, want %q", got, "MODE test -i\r\n")
+	}
+
+	conf := Config{Server: "dummy.int", Nick: "test", User: "test", Aliases: map[string]string{"a b": "JOIN"}}
+	if err := conf.Validate(); err == nil {
+		t.Fatal("Validate() accepted alias containing a space")
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test", Truncate: TruncateEllipsis})
+	long := strings.Repeat("é", 500)
+
+	// ":test!~test@<63 byte host> " is prepended by the server.
+	limit := maxLength - 76 - len("PRIVMSG #channel :")
+
+	if err := c.Cmd.Message("#channel", long); err != nil {
+		t.Fatalf("Message() = %v", err)
+	}
+	got := (<-c.tx).event.Trailing
+	if len(got) > limit || !utf8.ValidString(got) || !strings.HasSuffix(got, ellipsis) {
+		t.Fatalf("Message() sent %d bytes (valid UTF-8: %t), want at most %d ending with an ellipsis", len(got), utf8.ValidString(got), limit)
+	}
+
+	if err := c.Cmd.Action("#channel", long); err != nil {
+		t.Fatalf("Action() = %v", err)
+	}
+	if got = (<-c.tx).event.Trailing; len(got) > limit || !strings.HasSuffix(got, ellipsis+"\001") {
+		t.Fatalf("Action() sent %q, want CTCP to be kept intact", got[len(got)-10:])
+	}
+
+	if err := c.Cmd.Message("#channel", "short"); err != nil || (<-c.tx).event.Trailing != "short" {
+		t.Fatal("Message() didn't send short message as is")
+	}
+
+	c.Config.Truncate = TruncateReject
+	limit = maxLength - 76 - len("NOTICE #channel :")
+	err := c.Cmd.Notice("#channel", long)
+	if e, ok := err.(*ErrMessageTooLong); !ok || e.Limit != limit || e.Length != len(long) {
+		t.Fatalf("Notice() = %v, want ErrMessageTooLong with limit %d", err, limit)
+	}
+	if len(c.tx) != 0 {
+		t.Fatal("Notice() sent rejected message")
+	}
+}
+
+func TestModeCommands(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test"})
+
+	c.RunHandlers(ParseEvent(":test!user@host JOIN #channel"))
+	c.RunHandlers(ParseEvent(":op!user@host MODE #channel +mkl key 10"))
+	for len(c.tx) > 0 {
+		<-c.tx
+	}
+
+	tests := []struct {
+		name string
+		fn   func() error
+		want []string
+	}{
+		{"Mode", func() error { return c.Cmd.Mode("#channel", "+o-v", "one", "two") }, []string{"MODE #channel +o-v one two"}},
+		{"Mode query", func() error { return c.Cmd.Mode("#channel", "") }, []string{"MODE #channel"}},
+		{"Op", func() error { return c.Cmd.Op("#channel", "a", "b", "c", "d") }, []string{"MODE #channel +ooo a b c", "MODE #channel +o d"}},
+		{"Deop", func() error { return c.Cmd.Deop("#channel", "a") }, []string{"MODE #channel -o a"}},
+		{"Voice", func() error { return c.Cmd.Voice("#channel", "a", "b") }, []string{"MODE #channel +vv a b"}},
+		{"Devoice", func() error { return c.Cmd.Devoice("#channel", "a") }, []string{"MODE #channel -v a"}},
+		{"ClearModes", func() error { return c.Cmd.ClearModes("#channel") }, []string{"MODE #channel -mkl key"}},
+		{"Admin", func() error { return c.Cmd.Admin("") }, []string{"ADMIN"}},
+		{"Motd", func() error { return c.Cmd.Motd("irc.example.com") }, []string{"MOTD irc.example.com"}},
+		{"Stats", func() error { return c.Cmd.Stats("u", "") }, []string{"STATS u"}},
+		{"Time", func() error { return c.Cmd.Time("") }, []string{"TIME"}},
+		{"Version", func() error { return c.Cmd.Version("*.example.com") }, []string{"VERSION *.example.com"}},
+		{"Links", func() error { return c.Cmd.Links("irc.example.com", "*.com") }, []string{"LINKS irc.example.com *.com"}},
+		{"Map", func() error { c.Cmd.Map(); return nil }, []string{"MAP"}},
+	}
+
+	for _, tt := range tests {
+		if err := tt.fn(); err != nil {
+			t.Fatalf("%s() = %v", tt.name, err)
+		}
+
+		var got []string
+		for len(c.tx) > 0 {
+			got = append(got, (<-c.tx).event.String())
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("%s() sent %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	invalid := map[string]error{
+		"Mode":       c.Cmd.Mode("#channel", "o", "one"),
+		"Op":         c.Cmd.Op("#channel", "bad nick"),
+		"Voice":      c.Cmd.Voice("channel", "a"),
+		"ClearModes": c.Cmd.ClearModes("#unknown"),
+		"Stats":      c.Cmd.Stats("", ""),
+		"Motd":       c.Cmd.Motd("irc.example.com :x"),
+		"Links":      c.Cmd.Links("irc.example.com", ""),
+	}
+	for name, err := range invalid {
+		if _, ok := err.(*ErrInvalidTarget); !ok {
+			t.Fatalf("%s() with invalid target = %v, want ErrInvalidTarget", name, err)
+		}
+	}
+	if len(c.tx) != 0 {
+		t.Fatalf("%d events sent for invalid targets", len(c.tx))
+	}
+}
+
+func TestEventReply(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test"})
+
+	tests := []struct {
+		in      string
+		target  string
+		reply   string
+		replyTo string
+	}{
+		{in: ":nick!user@host PRIVMSG #channel :!ping", target: "#channel", reply: "PRIVMSG #channel :pong", replyTo: "PRIVMSG #channel :nick, pong"},
+		{in: ":nick!user@host PRIVMSG @#channel :!ping", target: "@#channel", reply: "PRIVMSG @#channel :pong", replyTo: "PRIVMSG @#channel :nick, pong"},
+		{in: ":nick!user@host PRIVMSG &channel :!ping", target: "&channel", reply: "PRIVMSG &channel :pong", replyTo: "PRIVMSG &channel :nick, pong"},
+		{in: ":nick!user@host PRIVMSG test :!ping", target: "nick", reply: "PRIVMSG nick :pong", replyTo: "PRIVMSG nick :pong"},
+		{in: ":test!user@host PRIVMSG other :!ping", target: "test", reply: "PRIVMSG other :pong", replyTo: "PRIVMSG other :pong"},
+	}
+
+	for _, tt := range tests {
+		e := ParseEvent(tt.in)
+		if got := e.ResponseTarget(); got != tt.target {
+			t.Fatalf("ResponseTarget() of %q = %q, want %q", tt.in, got, tt.target)
+		}
+
+		if err := e.Reply(c, "pong"); err != nil {
+			t.Fatalf("Reply() to %q = %v", tt.in, err)
+		}
+		if got := (<-c.tx).event.String(); got != tt.reply {
+			t.Fatalf("Reply() to %q sent %q, want %q", tt.in, got, tt.reply)
+		}
+
+		if err := e.ReplyTo(c, "pong"); err != nil {
+			t.Fatalf("ReplyTo() to %q = %v", tt.in, err)
+		}
+		if got := (<-c.tx).event.String(); got != tt.replyTo {
+			t.Fatalf("ReplyTo() to %q sent %q, want %q", tt.in, got, tt.replyTo)
+		}
+	}
+
+	if (&Event{Command: PRIVMSG, Params: []string{"#channel"}}).ResponseTarget() != "" {
+		t.Fatal("ResponseTarget() of event without source isn't empty")
+	}
+}
+
+func TestStrict(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test", Strict: true})
+
+	tests := []struct {
+		event  *Event
+		reason string
+	}{
+		{&Event{Command: "PRIV MSG", Params: []string{"#channel"}}, "invalid command"},
+		{&Event{Command: "0001"}, "invalid command"},
+		{&Event{Command: PRIVMSG, Params: []string{"#a #b"}, Trailing: "hi"}, "param 1 contains a space"},
+		{&Event{Command: PRIVMSG, Params: []string{"#channel", ":x"}}, "param 2 starts with"},
+		{&Event{Command: PRIVMSG, Params: []string{""}, Trailing: "hi"}, "param 1 is empty"},
+		{&Event{Command: PRIVMSG, Params: []string{"#channel"}, Trailing: "hi\r\nQUIT"}, "trailing contains NUL, CR or LF"},
+		{&Event{Command: "TAGMSG", Params: []string{"#channel"}, Tags: Tags{"+bad key": ""}}, "invalid tag name"},
+		{&Event{Command: "TAGMSG", Params: []string{"#channel"}, Tags: Tags{"+key": strings.Repeat("a", maxTagLength)}}, "tags too long"},
+		{&Event{Command: PRIVMSG, Params: []string{"#channel"}, Trailing: strings.Repeat("a", maxLength)}, "line too long"},
+	}
+
+	for _, tt := range tests {
+		err := c.send(tt.event)
+		if e, ok := err.(*ErrProtocolViolation); !ok || !strings.Contains(e.Reason, tt.reason) {
+			t.Errorf("send(%q) = %v, want violation %q", tt.event.String(), err, tt.reason)
+		}
+	}
+
+	if len(c.tx) != 0 {
+		t.Fatal("send() sent rejected events")
+	}
+
+	// Tags don't count towards the length of the line.
+	tagged := &Event{Command: PRIVMSG, Params: []string{"#channel"}, Trailing: strings.Repeat("a", 400), Tags: Tags{"+key": strings.Repeat("a", 200)}}
+	if err := c.send(tagged); err != nil {
+		t.Fatalf("send() of a tagged event = %v, want the tags to be excluded from the line length", err)
+	}
+	<-c.tx
+
+	invalid := string([]byte{'h', 'i', 0xff})
+	if err := c.Cmd.Message("#channel", invalid); err != nil {
+		t.Fatalf("Message() = %v, want invalid UTF-8 to be allowed without UTF8ONLY", err)
+	}
+	<-c.tx
+
+	c.state.Lock()
+	c.state.serverOptions["UTF8ONLY"] = ""
+	c.state.Unlock()
+
+	if _, ok := c.Cmd.Message("#channel", invalid).(*ErrProtocolViolation); !ok {
+		t.Fatal("Message() didn't reject invalid UTF-8 with UTF8ONLY")
+	}
+
+	if err := c.Cmd.Message("#channel", "héllo"); err != nil || (<-c.tx).event.Trailing != "héllo" {
+		t.Fatalf("Message() = %v, want valid message to be sent", err)
+	}
+}
+
+func TestStatusMsg(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test"})
+
+	if err := c.Cmd.Message("%#channel", "hi"); err != nil {
+		t.Fatalf("Message(%%#channel) = %v, want the common prefixes to be allowed", err)
+	}
+	<-c.tx
+
+	c.RunHandlers(ParseEvent(":dummy.int 005 test STATUSMSG=@+ :are supported by this server"))
+
+	if err := c.Cmd.Notice("@#channel", "ops only"); err != nil {
+		t.Fatalf("Notice(@#channel) = %v", err)
+	}
+	if got := (<-c.tx).event.String(); got != "NOTICE @#channel :ops only" {
+		t.Fatalf("Notice(@#channel) sent %q", got)
+	}
+
+	if _, ok := c.Cmd.Message("%#channel", "hi").(*ErrInvalidTarget); !ok {
+		t.Fatalf("Message() didn't reject %q, which the server doesn't support", "%#channel")
+	}
+
+	tests := []struct {
+		in      string
+		prefix  string
+		channel string
+		ok      bool
+	}{
+		{":nick!user@host PRIVMSG @#channel :hi", "@", "#channel", true},
+		{":nick!user@host NOTICE +#channel :hi", "+", "#channel", true},
+		{":nick!user@host PRIVMSG #channel :hi", "", "#channel", true},
+		{":nick!user@host PRIVMSG test :hi", "", "", false},
+	}
+
+	for _, tt := range tests {
+		e := ParseEvent(tt.in)
+		prefix, channel, ok := e.StatusMsg()
+		if prefix != tt.prefix || channel != tt.channel || ok != tt.ok {
+			t.Errorf("StatusMsg() of %q = %q, %q, %t, want %q, %q, %t", tt.in, prefix, channel, ok, tt.prefix, tt.channel, tt.ok)
+		}
+	}
+
+	if !ParseEvent(":nick!user@host PRIVMSG @#channel :hi").IsFromChannel() {
+		t.Fatal("IsFromChannel() of STATUSMSG message = false")
+	}
+}
+
+func TestMessageMultipleTargets(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test"})
+
+	sent := func() (lines []string) {
+		for len(c.tx) > 0 {
+			lines = append(lines, (<-c.tx).event.String())
+		}
+		return lines
+	}
+
+	if err := c.Cmd.Message("#a,#b", "hi"); err != nil {
+		t.Fatalf("Message() = %v", err)
+	}
+	if got, want := sent(), []string{"PRIVMSG #a :hi", "PRIVMSG #b :hi"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Message() without TARGMAX sent %q, want %q", got, want)
+	}
+
+	c.RunHandlers(ParseEvent(":dummy.int 005 test TARGMAX=PRIVMSG:2,NOTICE:3 :are supported by this server"))
+
+	if err := c.Cmd.Message("#a,#b,nick", "hi"); err != nil {
+		t.Fatalf("Message() = %v", err)
+	}
+	if got, want := sent(), []string{"PRIVMSG #a,#b :hi", "PRIVMSG nick :hi"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Message() sent %q, want %q", got, want)
+	}
+
+	if err := c.Cmd.Notice("#a,#b,nick", "hi"); err != nil {
+		t.Fatalf("Notice() = %v", err)
+	}
+	if got, want := sent(), []string{"NOTICE #a,#b,nick :hi"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Notice() sent %q, want %q", got, want)
+	}
+
+	if err, ok := c.Cmd.Message("#a,,#b", "hi").(*ErrInvalidTarget); !ok || err.Target != "" {
+		t.Fatalf("Message() = %v, want ErrInvalidTarget for the empty target", err)
+	}
+	if len(c.tx) != 0 {
+		t.Fatal("Message() sent to targets of an invalid list")
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+)
+
+// capLabeledResponse is the capability which allows tagging outgoing events
+// with a label, which the server will attach to its response(s).
+const capLabeledResponse = "labeled-response"
+
+// SendConfirmed sends an event to the server, and blocks until there is
+// evidence that the server has accepted it, or the context has been
+// cancelled. This is best-effort, and is useful for things like bridges,
+// which need at-least-once delivery semantics.
+//
+// The strongest method available is used to confirm delivery:
+//
+//  1. labeled-response: the event is labeled, and confirmed by any
+//     response (e.g. ACK, or echo) with the same label.
+//  2. echo-message: confirmed once the server echoes the message back.
+//  3. Otherwise, a PING is sent directly after the event, and the event is
+//     considered to be confirmed once the matching PONG has been received.
+//
+// If the server responds with an error for the target (e.g.
+// ERR_NOSUCHNICK, ERR_CANNOTSENDTOCHAN, etc), an ErrEvent is returned. If
+// the context is cancelled before confirmation, the context error is
+// returned.
+func (c *Client) SendConfirmed(ctx context.Context, event *Event) error {
+	if !c.IsConnected() {
+		return ErrNotConnected
+	}
+
+	var target string
+	if len(event.Params) > 0 {
+		target = event.Params[0]
+	}
+
+	// Send() may format the trailing text, so determine what the server
+	// would echo back ahead of time.
+	trailing := event.Trailing
+	if c.Config.GlobalFormat && trailing != "" && (event.Command == PRIVMSG || event.Command == NOTICE) {
+		trailing = Fmt(trailing)
+	}
+
+	token := fmt.Sprintf("%d", rand.Int63())
+
+	var method string
+	switch {
+	case c.Config.disableTracking:
+		method = PING
+	case c.HasCapability(capLabeledResponse):
+		method = capLabeledResponse
+
+		if event.Tags == nil {
+			event.Tags = Tags{}
+		}
+		event.Tags["label"] = token
+	case c.HasCapability("echo-message") && (event.Command == PRIVMSG || event.Command == NOTICE):
+		method = "echo-message"
+	default:
+		method = PING
+	}
+
+	result := make(chan error, 1)
+	resolve := func(err error) {
+		select {
+		case result <- err:
+		default:
+		}
+	}
+
+	// This is intentionally not a background/tmp handler, as responses must
+	// be checked in the order they were received (e.g. an error, followed
+	// by a PONG).
+	var batch string
+	cuid := c.Handlers.Add(ALL_EVENTS, func(c *Client, e Event) {
+		// Errors related to the target.
+		switch e.Command {
+		case ERR_NOSUCHNICK, ERR_NOSUCHCHANNEL, ERR_CANNOTSENDTOCHAN:
+			if target != "" && len(e.Params) > 1 && c.Equal(e.Params[1], target) {
+				resolve(&ErrEvent{Event: &e})
+				return
+			}
+		}
+
+		switch method {
+		case capLabeledResponse:
+			if label, ok := e.Tags.Get("label"); ok && label == token {
+				if isErrorReply(e.Command) {
+					resolve(&ErrEvent{Event: &e})
+					return
+				}
+
+				// Responses may be wrapped within a batch, in which case
+				// wait until the batch has completed.
+				if e.Command == BATCH && len(e.Params) > 0 && len(e.Params[0]) > 1 && e.Params[0][0] == '+' {
+					batch = e.Params[0][1:]
+					return
+				}
+
+				resolve(nil)
+				return
+			}
+
+			if batch == "" {
+				return
+			}
+
+			if ref, ok := e.Tags.Get("batch"); ok && ref == batch && isErrorReply(e.Command) {
+				resolve(&ErrEvent{Event: &e})
+				return
+			}
+
+			if e.Command == BATCH && len(e.Params) > 0 && e.Params[0] == "-"+batch {
+				resolve(nil)
+				return
+			}
+		case "echo-message":
+			if e.Command == event.Command && e.Source != nil && len(e.Params) > 0 &&
+				c.Equal(e.Source.Name, c.GetNick()) &&
+				c.Equal(e.Params[0], target) && e.Trailing == trailing {
+				resolve(nil)
+				return
+			}
+		case PING:
+			if e.Command == PONG && (e.Trailing == token || (len(e.Params) > 0 && e.Params[len(e.Params)-1] == token)) {
+				resolve(nil)
+				return
+			}
+		}
+	})
+	defer c.Handlers.Remove(cuid)
+
+	if err := c.SendCtx(ctx, event); err != nil {
+		return err
+	}
+
+	if method == PING {
+		// Control events are sent ahead of everything else (see
+		// isControlEvent()), however the PING must follow the event, which
+		// has been written by now.
+		c.write(&Event{Command: PING, Params: []string{token}})
+	}
+
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// isErrorReply returns true if the command is an error numeric (4xx or 5xx).
+func isErrorReply(command string) bool {
+	if len(command) != 3 || (command[0] != '4' && command[0] != '5') {
+		return false
+	}
+
+	return command[1] >= '0' && command[1] <= '9' && command[2] >= '0' && command[2] <= '9'
+}
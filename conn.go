@@ -10,6 +10,7 @@ import (
 	"crypto/tls"
 	"fmt"
 	"net"
+	"strings"
 	"sync"
 	"time"
 )
@@ -35,6 +36,13 @@ type ircConn struct {
 	// writeDelay is used to keep track of rate limiting of events sent to
 	// the server.
 	writeDelay time.Duration
+	// rateFactor is how much the rate limit has been slowed down by, see
+	// Config.AdaptiveRate. Zero is the same as 1 (not slowed down).
+	rateFactor int
+	// rateAdjusted is when rateFactor was last increased.
+	rateAdjusted time.Time
+	// rateRecovering is true while rateFactor is being recovered.
+	rateRecovering bool
 	// connected is true if we're actively connected to a server.
 	connected bool
 	// connTime is the time at which the client has connected to a server.
@@ -45,6 +53,10 @@ type ircConn struct {
 	// received a successful pong back.
 	lastPong  time.Time
 	pingDelay time.Duration
+	// stats are the stats of the client, see Client.Stats().
+	stats *stats
+	// onRaw receives the lines read from the server, see Config.OnRaw.
+	onRaw func(in bool, line string)
 }
 
 // Dialer is an interface implementation of net.Dialer. Use this if you would
@@ -59,10 +71,6 @@ type Dialer interface {
 
 // newConn sets up and returns a new connection to the server.
 func newConn(conf Config, dialer Dialer, addr string) (*ircConn, error) {
-	if err := conf.isValid(); err != nil {
-		return nil, err
-	}
-
 	var conn net.Conn
 	var err error
 
@@ -79,11 +87,26 @@ func newConn(conf Config, dialer Dialer, addr string) (*ircConn, error) {
 			netDialer.LocalAddr = local
 		}
 
+		if conf.ParallelDial > 1 {
+			ctx, cancel := context.WithTimeout(context.Background(), netDialer.Timeout)
+			addrs, rerr := resolveAddrs(ctx, addr)
+			cancel()
+			if rerr != nil {
+				return nil, rerr
+			}
+
+			if conn, err = dialParallel(netDialer.DialContext, addrs, conf.ParallelDial); err != nil {
+				return nil, err
+			}
+		}
+
 		dialer = netDialer
 	}
 
-	if conn, err = dialer.Dial("tcp", addr); err != nil {
-		return nil, err
+	if conn == nil {
+		if conn, err = dialer.Dial("tcp", addr); err != nil {
+			return nil, err
+		}
 	}
 
 	if conf.SSL {
@@ -133,6 +156,13 @@ func (c *ircConn) decode() (event *Event, err error) {
 		return nil, err
 	}
 
+	if c.stats != nil {
+		c.stats.lineRead(len(line))
+	}
+	if c.onRaw != nil {
+		c.onRaw(true, strings.TrimRightFunc(line, cutCRFunc))
+	}
+
 	if event = ParseEvent(line); event == nil {
 		return nil, ErrParseEvent{line}
 	}
@@ -245,22 +275,55 @@ func (c *Client) MockConnect(conn net.Conn) error {
 }
 
 func (c *Client) internalConnect(mock net.Conn, dialer Dialer) error {
+	// Check for problems with the configuration before doing anything else,
+	// so all of them are reported at once.
+	conf := c.config()
+	if err := conf.Validate(); err != nil {
+		return err
+	}
+
+	// Pick the identity to use for this attempt, and let handlers know
+	// about it before we start dialing.
+	c.mu.Lock()
+	identity, err := c.nextIdentity()
+	if err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	c.identity = identity
+	c.mu.Unlock()
+
+	// Connection-scoped values are cleared before any handlers for the new
+	// connection run.
+	c.Session.clear()
+
+	c.setStatus(StatusConnecting)
+	c.RunHandlers(&Event{Command: CONNECTING, Params: []string{identity.Nick, identity.User}, Trailing: identity.Name})
+
 	// We want to be the only one handling connects/disconnects right now.
 	c.mu.Lock()
 
 	if c.conn != nil {
 		panic("use of connect more than once")
 	}
+	c.serverErr = nil
+
+	// Remember the channels from the last connection before the state is
+	// reset, so they can be rejoined.
+	if c.Config.Rejoin && !c.Config.disableTracking {
+		c.rejoin.snapshot(c)
+	}
 
 	// Reset the state.
 	c.state.reset()
 
 	if mock == nil {
-		// Validate info, and actually make the connection.
-		c.debug.Printf("connecting to %s...", c.Server())
-		conn, err := newConn(c.Config, dialer, c.Server())
+		// Actually make the connection (the config was validated above).
+		c.logger.Info("connecting", "server", c.Server())
+		conn, err := newConn(c.config(), dialer, c.Server())
 		if err != nil {
 			c.mu.Unlock()
+			c.setStatus(StatusDisconnected)
 			return err
 		}
 
@@ -268,10 +331,19 @@ func (c *Client) internalConnect(mock net.Conn, dialer Dialer) error {
 	} else {
 		c.conn = newMockConn(mock)
 	}
+	c.conn.stats = c.stats
+	c.conn.onRaw = c.Config.OnRaw
+	c.stats.connected()
 
 	var ctx context.Context
 	ctx, c.stop = context.WithCancel(context.Background())
+	closed := make(chan struct{})
+	c.closed = closed
 	c.mu.Unlock()
+	defer close(closed)
+
+	// Before anything is read, so RPL_WELCOME can't be handled first.
+	c.setStatus(StatusRegistering)
 
 	errs := make(chan error, 4)
 	var wg sync.WaitGroup
@@ -289,14 +361,10 @@ func (c *Client) internalConnect(mock net.Conn, dialer Dialer) error {
 	}
 
 	// Then nickname.
-	c.write(&Event{Command: NICK, Params: []string{c.Config.Nick}})
+	c.write(&Event{Command: NICK, Params: []string{identity.Nick}})
 
 	// Then username and realname.
-	if c.Config.Name == "" {
-		c.Config.Name = c.Config.User
-	}
-
-	c.write(&Event{Command: USER, Params: []string{c.Config.User, "*", "*"}, Trailing: c.Config.Name})
+	c.write(&Event{Command: USER, Params: []string{identity.User, "*", "*"}, Trailing: identity.Name})
 
 	// List the IRCv3 capabilities, specifically with the max protocol we
 	// support.
@@ -309,13 +377,15 @@ func (c *Client) internalConnect(mock net.Conn, dialer Dialer) error {
 	var result error
 	select {
 	case <-ctx.Done():
-		c.debug.Print("received request to close, beginning clean up")
+		c.logger.Debug("received request to close, beginning clean up")
 		c.RunHandlers(&Event{Command: STOPPED, Trailing: c.Server()})
 	case err := <-errs:
-		c.debug.Print("received error, beginning clean up")
+		c.logger.Warn("disconnected, beginning clean up", "error", err)
 		result = err
 	}
 
+	c.setStatus(StatusClosing)
+
 	// Make sure that the connection is closed if not already.
 	c.mu.RLock()
 	if c.stop != nil {
@@ -328,12 +398,23 @@ func (c *Client) internalConnect(mock net.Conn, dialer Dialer) error {
 	c.mu.RUnlock()
 
 	// Once we have our error/result, let all other functions know we're done.
-	c.debug.Print("waiting for all routines to finish")
+	c.logger.Debug("waiting for all routines to finish")
 
 	// Wait for all goroutines to finish.
 	wg.Wait()
 	close(errs)
 
+	// An ERROR from the server is usually what caused any other error (e.g.
+	// the connection being closed), so it's what should be returned.
+	if result != nil {
+		for err := range errs {
+			if _, ok := err.(*ErrEvent); ok {
+				result = err
+				break
+			}
+		}
+	}
+
 	// This helps ensure that the end user isn't improperly using the client
 	// more than once. If they want to do this, they should be using multiple
 	// clients, not multiple instances of Connect().
@@ -341,14 +422,27 @@ func (c *Client) internalConnect(mock net.Conn, dialer Dialer) error {
 	c.conn = nil
 	c.mu.Unlock()
 
+	c.dropControl()
+	if c.Config.DropQueueOnDisconnect {
+		c.DropQueue()
+	}
+
+	c.setStatus(StatusDisconnected)
+
+	disconnected := &Event{Command: DISCONNECTED, Params: []string{c.Server()}}
+	if result != nil {
+		disconnected.Trailing = result.Error()
+	}
+	c.RunHandlers(disconnected)
+
 	return result
 }
 
 // readLoop sets a timeout of 300 seconds, and then attempts to read from the
 // IRC server. If there is an error, it calls Reconnect.
 func (c *Client) readLoop(ctx context.Context, errs chan error, wg *sync.WaitGroup) {
-	c.debug.Print("starting readLoop")
-	defer c.debug.Print("closing readLoop")
+	c.logger.Debug("starting readLoop")
+	defer c.logger.Debug("closing readLoop")
 
 	var event *Event
 	var err error
@@ -360,45 +454,366 @@ func (c *Client) readLoop(ctx context.Context, errs chan error, wg *sync.WaitGro
 			return
 		default:
 			_ = c.conn.sock.SetReadDeadline(time.Now().Add(300 * time.Second))
-			event, err = c.conn.decode()
+			if c.Config.LegacyCompat {
+				event, err = c.conn.decodeLegacy()
+			} else {
+				event, err = c.conn.decode()
+			}
 			if err != nil {
 				errs <- err
 				wg.Done()
 				return
 			}
 
-			c.rx <- event
+			event.read = time.Now()
+			c.receive(event)
+
+			// The server closes the connection after an ERROR. Stop
+			// reading, so the ERROR (rather than the connection being
+			// closed) is what ends the connection, once it has been
+			// handled.
+			if event.Command == ERROR {
+				wg.Done()
+				return
+			}
 		}
 	}
 }
 
+// controlCommands are the commands of control events, see isControlEvent().
+var controlCommands = map[string]bool{PING: true, PONG: true, QUIT: true, CAP: true, AUTHENTICATE: true, PASS: true}
+
+// isControlEvent returns true if e is control traffic (e.g. PONG, QUIT or
+// CAP), which keeps the connection alive or is part of registration. Control
+// events aren't rate limited, and are written before any other queued events,
+// so a backlog of messages can't cause a ping timeout.
+func isControlEvent(e *Event) bool {
+	return controlCommands[e.Command]
+}
+
 // Send sends an event to the server. Use Client.RunHandlers() if you are
-// simply looking to trigger handlers with an event.
+// simply looking to trigger handlers with an event. Send doesn't wait for
+// the event to be written: it's queued (see Client.SendQueue()), and
+// written by the write loop, which applies the rate limit (see
+// Config.AllowFlood), except for control traffic (PING, PONG, QUIT, CAP,
+// AUTHENTICATE and PASS), which is also written before any other queued
+// events. Errors sending the event (e.g. if it's rejected by Config.Strict,
+// the send queue is full, see Config.MaxSendQueue, or writing it fails) are
+// passed to Config.HandleError, and emitted as a SEND_FAILED event. Use Client.SendCtx() to wait for the event to be
+// written.
 func (c *Client) Send(event *Event) {
-	if !c.Config.AllowFlood {
-		<-time.After(c.conn.rate(event.Len()))
+	_ = c.send(event)
+}
+
+// SendCtx is much like Send, however waits until the event has been written
+// to the server, returning the error if it couldn't be (in addition to
+// Config.HandleError). If ctx is done before the event has been written,
+// it is not sent, and the context error is returned. Note that if the
+// client isn't connected, the event is written once it is.
+func (c *Client) SendCtx(ctx context.Context, event *Event) error {
+	if err := c.prepare(event); err != nil {
+		return err
+	}
+
+	o := &outgoing{event: event, control: isControlEvent(event), ctx: ctx, done: make(chan error, 1)}
+	if err := c.enqueue(o); err != nil {
+		return err
 	}
 
+	select {
+	case err := <-o.done:
+		return err
+	case <-ctx.Done():
+		if c.sendq.abort(o, ctx.Err()) {
+			return ctx.Err()
+		}
+
+		// The write loop has already taken the event, and will stop waiting
+		// for the rate limit.
+		return <-o.done
+	}
+}
+
+// send is much like Send, however returns the error if event is rejected
+// (see Client.prepare() and Config.MaxSendQueue).
+func (c *Client) send(event *Event) error {
+	if err := c.prepare(event); err != nil {
+		return err
+	}
+
+	return c.enqueue(&outgoing{event: event, control: isControlEvent(event)})
+}
+
+// prepare formats event (see Config.GlobalFormat), and checks if it can be
+// sent, see Client.truncate() and Config.Strict.
+func (c *Client) prepare(event *Event) (err error) {
 	if c.Config.GlobalFormat && event.Trailing != "" &&
 		(event.Command == PRIVMSG || event.Command == TOPIC || event.Command == NOTICE) {
 		event.Trailing = Fmt(event.Trailing)
 	}
 
-	c.write(event)
+	defer func() {
+		if err != nil {
+			c.sendFailed(event, err)
+		}
+	}()
+
+	if err = c.truncate(event); err != nil {
+		return err
+	}
+
+	if c.Config.Strict {
+		if err = c.checkStrict(event); err != nil {
+			c.logger.Warn("rejected outbound event", "command", event.Command, "error", err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ErrSendFailed is passed to Config.HandleError when an event could not be
+// sent. Err is the underlying error.
+type ErrSendFailed struct {
+	Event *Event
+	Err   error
+}
+
+func (e *ErrSendFailed) Error() string {
+	return "unable to send " + e.Event.Command + ": " + e.Err.Error()
+}
+
+// sendFailed surfaces an error sending event, see Config.HandleError and
+// SEND_FAILED.
+func (c *Client) sendFailed(event *Event, err error) {
+	if c.Config.HandleError != nil {
+		c.Config.HandleError(c, &ErrSendFailed{Event: event, Err: err})
+	}
+
+	// Handlers may send events themselves, so don't block the write loop.
+	go c.RunHandlers(&Event{Command: SEND_FAILED, Params: []string{event.Command}, Trailing: err.Error()})
+}
+
+// enqueue queues o to be sent, returning ErrQueueFull (which is also passed
+// to Config.HandleError) if the send queue is full, see
+// Config.MaxSendQueue. If Config.TargetRate applies, regular events are
+// queued once they may be sent to their targets, without blocking the
+// sender.
+func (c *Client) enqueue(o *outgoing) error {
+	max := c.Config.MaxSendQueue
+	if max == 0 {
+		max = defaultMaxSendQueue
+	}
+
+	if err := c.sendq.add(o, max); err != nil {
+		c.logger.Warn("send queue is full, rejecting event", "command", o.event.Command, "max", max)
+		c.sendFailed(o.event, err)
+		return err
+	}
+
+	if o.control {
+		c.push(o)
+		return nil
+	}
+
+	c.mu.RLock()
+	connected := c.conn != nil
+	c.mu.RUnlock()
+
+	// There's nothing to rate limit if we're not connected (e.g. when
+	// events are being fed from a FakeNetwork).
+	var delay time.Duration
+	var targets []string
+	if connected && !o.unpaced {
+		delay, targets = c.reserveTargetRate(o.event)
+	}
+
+	if delay <= 0 {
+		c.push(o)
+		return nil
+	}
+
+	go func() {
+		var cancel <-chan struct{}
+		if o.ctx != nil {
+			cancel = o.ctx.Done()
+		}
+
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-o.flush:
+		case <-o.drop:
+			c.sendq.forget(o)
+			c.cancelTargetRate(targets)
+			return
+		case <-cancel:
+			c.sendq.abort(o, o.ctx.Err())
+			c.sendq.forget(o)
+			c.cancelTargetRate(targets)
+			return
+		}
+
+		c.push(o)
+	}()
+
+	return nil
+}
+
+// paceLoop takes the regular events queued in c.tx, and passes them on to
+// the write loop (see Client.sendLoop()) once the rate limit allows it.
+func (c *Client) paceLoop(ctx context.Context, paced chan<- *outgoing) {
+	for {
+		o := c.sendq.resume()
+		if o == nil {
+			c.refill()
+
+			select {
+			case o = <-c.tx:
+				c.refill()
+				if o = c.sendq.take(o); o == nil {
+					continue
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		if !c.pace(ctx, o) {
+			if ctx.Err() != nil {
+				return
+			}
+
+			c.sendq.release(o)
+			continue
+		}
+
+		select {
+		case paced <- o:
+			c.sendq.release(o)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// pace waits until o may be written, see ircConn.rate(). This returns false
+// if it shouldn't be written (yet), i.e. if it was cancelled or dropped, or
+// ctx is done.
+func (c *Client) pace(ctx context.Context, o *outgoing) bool {
+	if allowFlood, _, _ := c.rateConfig(); allowFlood || o.unpaced || c.sendq.flushed() {
+		return true
+	}
+
+	c.mu.RLock()
+	conn := c.conn
+	c.mu.RUnlock()
+
+	if conn == nil {
+		return true
+	}
+
+	var cancel <-chan struct{}
+	if o.ctx != nil {
+		cancel = o.ctx.Done()
+	}
+
+	delay := conn.rate(o.event.Len())
+	if delay <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-o.flush:
+		return true
+	case <-o.drop:
+		return false
+	case <-cancel:
+		c.sendq.done(o, o.ctx.Err())
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// nextEvent returns the next event to be written, preferring control events
+// over the regular events passed on by Client.paceLoop(). ok is false once
+// ctx is done.
+func (c *Client) nextEvent(ctx context.Context, paced <-chan *outgoing) (o *outgoing, ok bool) {
+	for {
+		select {
+		case o = <-c.txControl:
+			c.refill()
+			if o = c.sendq.take(o); o != nil {
+				return o, true
+			}
+			continue
+		default:
+		}
+
+		select {
+		case o = <-c.txControl:
+			c.refill()
+			if o = c.sendq.take(o); o != nil {
+				return o, true
+			}
+		case o = <-paced:
+			return o, true
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+}
+
+// OnSend adds a hook which is executed for every event before it's written
+// to the server (including events sent internally, e.g. during
+// registration), in the order the hooks were added. The hook can inspect
+// or modify the event (e.g. to strip colors, or add tags), or return false
+// to veto it, in which case the event is dropped, and later hooks aren't
+// executed. Hooks are executed sequentially as events are written, so they
+// should be fast, and shouldn't send events themselves.
+func (c *Client) OnSend(hook func(client *Client, event *Event) bool) {
+	c.mu.Lock()
+	c.sendHooks = append(c.sendHooks, hook)
+	c.mu.Unlock()
+}
+
+// runSendHooks executes the hooks added with OnSend, returning false if
+// event was vetoed.
+func (c *Client) runSendHooks(event *Event) bool {
+	c.mu.RLock()
+	hooks := c.sendHooks
+	c.mu.RUnlock()
+
+	for i := 0; i < len(hooks); i++ {
+		if !hooks[i](c, event) {
+			c.logger.Debug("event vetoed by send hook", "command", event.Command)
+			return false
+		}
+	}
+
+	return true
 }
 
 // write is the lower level function to write an event. It does not have a
 // write-delay when sending events.
 func (c *Client) write(event *Event) {
-	c.tx <- event
+	_ = c.enqueue(&outgoing{event: event, control: isControlEvent(event), unpaced: true})
 }
 
 // rate allows limiting events based on how frequent the event is being sent,
 // as well as how many characters each event has.
 func (c *ircConn) rate(chars int) time.Duration {
-	_time := time.Second + ((time.Duration(chars) * time.Second) / 100)
-
 	c.mu.Lock()
+	_time := c.cost(chars)
+
 	if c.writeDelay += _time - time.Now().Sub(c.lastWrite); c.writeDelay < 0 {
 		c.writeDelay = 0
 	}
@@ -406,81 +821,145 @@ func (c *ircConn) rate(chars int) time.Duration {
 
 	c.mu.RLock()
 	defer c.mu.RUnlock()
-	if c.writeDelay > (8 * time.Second) {
+	if c.writeDelay > maxWriteDelay {
 		return _time
 	}
 
 	return 0
 }
 
-func (c *Client) sendLoop(ctx context.Context, errs chan error, wg *sync.WaitGroup) {
-	c.debug.Print("starting sendLoop")
-	defer c.debug.Print("closing sendLoop")
+// maxWriteDelay is the write delay after which events are paced, see
+// ircConn.rate().
+const maxWriteDelay = 8 * time.Second
 
-	var err error
+// cost returns how much sending an event with chars characters adds to the
+// write delay. c.mu must be locked.
+func (c *ircConn) cost(chars int) time.Duration {
+	_time := time.Second + ((time.Duration(chars) * time.Second) / 100)
+	if c.rateFactor > 1 {
+		_time *= time.Duration(c.rateFactor)
+	}
+
+	return _time
+}
+
+func (c *Client) sendLoop(ctx context.Context, errs chan error, wg *sync.WaitGroup) {
+	c.logger.Debug("starting sendLoop")
+	defer c.logger.Debug("closing sendLoop")
+
+	paced := make(chan *outgoing)
+	pacing := make(chan struct{})
+	go func() {
+		c.paceLoop(ctx, paced)
+		close(pacing)
+	}()
+
+	defer func() {
+		<-pacing
+		wg.Done()
+	}()
 
 	for {
-		select {
-		case event := <-c.tx:
-			// Check if tags exist on the event. If they do, and message-tags
-			// isn't a supported capability, remove them from the event.
-			if event.Tags != nil {
-				c.state.RLock()
-				var in bool
-				for i := 0; i < len(c.state.enabledCap); i++ {
-					if c.state.enabledCap[i] == "message-tags" {
-						in = true
-						break
-					}
-				}
-				c.state.RUnlock()
+		o, ok := c.nextEvent(ctx, paced)
+		if !ok {
+			return
+		}
 
-				if !in {
-					event.Tags = Tags{}
-				}
-			}
+		if o.ctx != nil && o.ctx.Err() != nil {
+			c.sendq.done(o, o.ctx.Err())
+			continue
+		}
 
-			// Log the event.
-			if event.Sensitive {
-				c.debug.Printf("> %s ***redacted***", event.Command)
-			} else {
-				c.debug.Print("> ", StripRaw(event.String()))
-			}
-			if c.Config.Out != nil {
-				if pretty, ok := event.Pretty(); ok {
-					fmt.Fprintln(c.Config.Out, StripRaw(pretty))
-				}
-			}
+		err := c.writeEvent(o.event)
+		c.sendq.done(o, err)
 
-			c.conn.mu.Lock()
-			c.conn.lastWrite = time.Now()
+		if err != nil {
+			c.sendFailed(o.event, err)
+			errs <- err
+			return
+		}
+	}
+}
+
+// writeEvent writes event to the server, unless it's vetoed by a hook (see
+// Client.OnSend()).
+func (c *Client) writeEvent(event *Event) (err error) {
+	if !c.runSendHooks(event) {
+		return nil
+	}
 
-			if event.Command != PING && event.Command != PONG && event.Command != WHO {
-				c.conn.lastActive = c.conn.lastWrite
+	// Check if tags exist on the event. If they do, and message-tags
+	// isn't a supported capability, remove them from the event.
+	if event.Tags != nil {
+		c.state.RLock()
+		var in, labeled bool
+		for i := 0; i < len(c.state.enabledCap); i++ {
+			switch c.state.enabledCap[i] {
+			case "message-tags":
+				in = true
+			case capLabeledResponse:
+				labeled = true
 			}
-			c.conn.mu.Unlock()
+		}
+		c.state.RUnlock()
 
-			// Write the raw line.
-			_, err = c.conn.io.Write(event.Bytes())
-			if err == nil {
-				// And the \r\n.
-				_, err = c.conn.io.Write(endline)
-				if err == nil {
-					// Lastly, flush everything to the socket.
-					err = c.conn.io.Flush()
-				}
+		if !in {
+			// Labels are still allowed with labeled-response, even
+			// without message-tags.
+			label, hasLabel := event.Tags.Get("label")
+
+			event.Tags = Tags{}
+			if labeled && hasLabel {
+				event.Tags["label"] = label
 			}
+		}
+	}
 
-			if err != nil {
-				errs <- err
-				wg.Done()
-				return
+	// Log the event.
+	if event.Sensitive {
+		c.logger.Debug("sent", "event", event.Command+" ***redacted***")
+	} else {
+		c.logger.Debug("sent", "event", StripRaw(event.String()))
+	}
+	if c.Config.Out != nil {
+		if pretty, ok := event.Pretty(); ok {
+			fmt.Fprintln(c.Config.Out, StripRaw(pretty))
+		}
+	}
+
+	c.conn.mu.Lock()
+	c.conn.lastWrite = time.Now()
+
+	if event.Command != PING && event.Command != PONG && event.Command != WHO {
+		c.conn.lastActive = c.conn.lastWrite
+	}
+	c.conn.mu.Unlock()
+
+	// Write the raw line.
+	line := event.Bytes()
+	_, err = c.conn.io.Write(line)
+	if err == nil {
+		// And the \r\n.
+		_, err = c.conn.io.Write(endline)
+		if err == nil {
+			// Lastly, flush everything to the socket.
+			err = c.conn.io.Flush()
+		}
+	}
+
+	if err == nil {
+		c.stats.lineWritten(len(line) + len(endline))
+
+		if c.Config.OnRaw != nil {
+			if event.Sensitive && !c.Config.RawSensitive {
+				c.Config.OnRaw(false, event.Command+" ***redacted***")
+			} else {
+				c.Config.OnRaw(false, string(line))
 			}
-		case <-ctx.Done():
-			wg.Done()
-			return
 		}
 	}
+
+	return err
 }
 
 // ErrTimedOut is returned when we attempt to ping the server, and timed out
@@ -505,8 +984,8 @@ func (c *Client) pingLoop(ctx context.Context, errs chan error, wg *sync.WaitGro
 		return
 	}
 
-	c.debug.Print("starting pingLoop")
-	defer c.debug.Print("closing pingLoop")
+	c.logger.Debug("starting pingLoop")
+	defer c.logger.Debug("closing pingLoop")
 
 	c.conn.mu.Lock()
 	c.conn.lastPing = time.Now()
@@ -7,7 +7,15 @@ package girc
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -92,6 +100,57 @@ func TestRate(t *testing.T) {
 	return
 }
 
+func TestAdaptiveRate(t *testing.T) {
+	client := New(Config{Server: "dummy.int", Nick: "test", User: "test", AdaptiveRate: true})
+
+	_, _, c := mockBuffers()
+	client.conn = c
+
+	adjusted := make(chan Event, 5)
+	client.Handlers.Add(RATE_ADJUSTED, func(c *Client, e Event) { adjusted <- e })
+
+	expect := func(factor string) {
+		select {
+		case e := <-adjusted:
+			if e.Params[0] != factor {
+				t.Fatalf("RATE_ADJUSTED factor = %s (%s), want %s", e.Params[0], e.Trailing, factor)
+			}
+		default:
+			t.Fatalf("no RATE_ADJUSTED event, want factor %s", factor)
+		}
+	}
+
+	client.RunHandlers(ParseEvent(":dummy.int 439 test #channel :Target change too fast. Please wait 10 seconds."))
+	expect("2")
+
+	// Complaints caused by the same burst should only slow down once.
+	client.RunHandlers(ParseEvent(":dummy.int 263 test PRIVMSG :Server load is temporarily too heavy."))
+	if len(adjusted) != 0 {
+		t.Fatal("rate limit was slowed down twice for the same burst")
+	}
+
+	c.mu.Lock()
+	c.rateAdjusted = time.Now().Add(-rateDebounce)
+	c.lastWrite = time.Now()
+	c.mu.Unlock()
+
+	client.RunHandlers(ParseEvent(":dummy.int 263 test PRIVMSG :Server load is temporarily too heavy."))
+	expect("4")
+
+	c.rate(0)
+	if c.writeDelay < 3*time.Second {
+		t.Fatalf("slowed down rate limit has write delay %s, want at least 3s", c.writeDelay)
+	}
+
+	// Once the server stops complaining, the rate limit should recover.
+	c.mu.Lock()
+	c.rateAdjusted = time.Now().Add(-rateRecovery)
+	c.mu.Unlock()
+
+	client.recoverRate(c)
+	expect("2")
+}
+
 func genMockConn() (client *Client, clientConn net.Conn, serverConn net.Conn) {
 	client = New(Config{
 		Server: "dummy.int",
@@ -106,6 +165,48 @@ func genMockConn() (client *Client, clientConn net.Conn, serverConn net.Conn) {
 	return client, conn1, conn2
 }
 
+// mockServer connects c to a mock server, which passes each event sent by
+// the client to respond (if not nil), and waits for the client to be
+// initialized. It returns the server side of the connection (for writing
+// unsolicited events), and the result of MockConnect(), after which the
+// connection is closed.
+func mockServer(t *testing.T, c *Client, respond func(e *Event, w io.Writer)) (conn net.Conn, done <-chan error) {
+	conn, server := net.Pipe()
+
+	go func() {
+		b := bufio.NewReader(conn)
+		for {
+			line, err := b.ReadString(byte('\n'))
+			if err != nil {
+				return
+			}
+
+			if e := ParseEvent(line); e != nil && respond != nil {
+				respond(e, conn)
+			}
+		}
+	}()
+
+	initialized := make(chan struct{})
+	cuid := c.Handlers.Add(INITIALIZED, func(c *Client, e Event) { close(initialized) })
+	defer c.Handlers.Remove(cuid)
+
+	errs := make(chan error, 1)
+	go func() {
+		err := c.MockConnect(server)
+		conn.Close()
+		errs <- err
+	}()
+
+	select {
+	case <-initialized:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out during connect")
+	}
+
+	return conn, errs
+}
+
 func mockReadBuffer(conn net.Conn) {
 	// Accept all outgoing writes from the client.
 	b := bufio.NewReader(conn)
@@ -117,3 +218,810 @@ func mockReadBuffer(conn net.Conn) {
 		}
 	}
 }
+
+func TestOnSend(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test"})
+
+	c.OnSend(func(c *Client, e *Event) bool {
+		return !(e.Command == PRIVMSG && e.Params[0] == "#blocked")
+	})
+	c.OnSend(func(c *Client, e *Event) bool {
+		if e.Command == PRIVMSG {
+			e.Trailing += " [bot]"
+		}
+		return true
+	})
+
+	messages := make(chan string, 5)
+	mockServer(t, c, func(e *Event, w io.Writer) {
+		if e.Command == PRIVMSG {
+			messages <- e.String()
+		}
+	})
+	defer c.Close()
+
+	c.Cmd.Message("#blocked", "hello")
+	c.Cmd.Message("#open", "hello")
+
+	select {
+	case got := <-messages:
+		if want := "PRIVMSG #open :hello [bot]"; got != want {
+			t.Fatalf("server received %q, want %q", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for message")
+	}
+}
+
+func TestDialParallel(t *testing.T) {
+	var mu sync.Mutex
+	var active, maxActive int
+	cancelled := make(chan string, 10)
+
+	dial := func(ctx context.Context, network, address string) (net.Conn, error) {
+		mu.Lock()
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		mu.Unlock()
+
+		defer func() {
+			mu.Lock()
+			active--
+			mu.Unlock()
+		}()
+
+		switch address {
+		case "refused:6667":
+			return nil, errors.New("connection refused")
+		case "ok:6667":
+			client, server := net.Pipe()
+			server.Close()
+			return client, nil
+		}
+
+		// Blackholed, until cancelled.
+		<-ctx.Done()
+		cancelled <- address
+		return nil, ctx.Err()
+	}
+
+	addrs := []string{"blackhole1:6667", "refused:6667", "blackhole2:6667", "ok:6667", "blackhole3:6667"}
+	conn, err := dialParallel(dial, addrs, 3)
+	if err != nil || conn == nil {
+		t.Fatalf("dialParallel() = %v, %v, want connection", conn, err)
+	}
+	conn.Close()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-cancelled:
+		case <-time.After(5 * time.Second):
+			t.Fatal("remaining dials weren't cancelled")
+		}
+	}
+
+	mu.Lock()
+	if maxActive > 3 {
+		t.Fatalf("%d dials active at once, want at most 3", maxActive)
+	}
+	mu.Unlock()
+
+	if _, err = dialParallel(dial, []string{"refused:6667", "refused:6667"}, 2); err == nil {
+		t.Fatal("dialParallel() succeeded without any successful dials")
+	}
+
+	if _, err = dialParallel(dial, nil, 2); err == nil {
+		t.Fatal("dialParallel() succeeded without any addresses")
+	}
+
+	if addrs, err := resolveAddrs(context.Background(), "[::1]:6697"); err != nil || len(addrs) != 1 || addrs[0] != "[::1]:6697" {
+		t.Fatalf("resolveAddrs() with IP = %v, %v, want it as is", addrs, err)
+	}
+}
+
+// runSendLoop runs the write loop of c, until the returned function is
+// called, which waits for it to stop.
+func runSendLoop(c *Client) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go c.sendLoop(ctx, make(chan error, 1), &wg)
+
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+func TestSendPriority(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test"})
+	_, out, conn := mockBuffers()
+	c.conn = conn
+
+	// Exceed the rate limit, so regular events are delayed.
+	conn.mu.Lock()
+	conn.writeDelay = 30 * time.Second
+	conn.lastWrite = time.Now()
+	conn.mu.Unlock()
+
+	// A backlog of regular events, followed by control events.
+	sent := make(chan struct{})
+	go func() {
+		for _, text := range []string{"a", "b", "c"} {
+			c.Cmd.Message("#channel", text)
+		}
+		c.Cmd.Pong("token")
+		c.Cmd.Quit("bye")
+		close(sent)
+	}()
+
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("Send() blocked on the rate limit")
+	}
+
+	stop := runSendLoop(c)
+	for c.SendQueue().Length != 3 {
+		time.Sleep(time.Millisecond)
+	}
+	if flushed := c.FlushQueue(); flushed != 3 {
+		t.Fatalf("FlushQueue() = %d, want 3", flushed)
+	}
+	for c.SendQueue().Length != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	stop()
+
+	want := "PONG token\r\nQUIT :bye\r\nPRIVMSG #channel :a\r\nPRIVMSG #channel :b\r\nPRIVMSG #channel :c\r\n"
+	if got := out.String(); got != want {
+		t.Fatalf("sent %q, want %q", got, want)
+	}
+}
+
+// failingWriter is an io.Writer which always fails.
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("broken pipe")
+}
+
+func TestSendError(t *testing.T) {
+	var mu sync.Mutex
+	var handled []error
+	c := New(Config{
+		Server: "dummy.int", Nick: "test", User: "test", Strict: true,
+		HandleError: func(c *Client, err error) {
+			mu.Lock()
+			handled = append(handled, err)
+			mu.Unlock()
+		},
+	})
+
+	failed := make(chan string, 2)
+	c.Handlers.Add(SEND_FAILED, func(c *Client, e Event) {
+		failed <- e.Params[0]
+	})
+
+	// Rejected events don't reach the queue.
+	c.Send(&Event{Command: PRIVMSG, Params: []string{""}, Trailing: "hi"})
+	if len(c.tx) != 0 {
+		t.Fatal("Send() queued a rejected event")
+	}
+
+	conn := &ircConn{io: bufio.NewReadWriter(bufio.NewReader(&bytes.Buffer{}), bufio.NewWriterSize(failingWriter{}, 16)), connected: true}
+	c.conn = conn
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go c.sendLoop(ctx, errs, &wg)
+
+	err := c.SendCtx(context.Background(), &Event{Command: PRIVMSG, Params: []string{"#channel"}, Trailing: "a message which doesn't fit the buffer"})
+	if err == nil || err.Error() != "broken pipe" {
+		t.Fatalf("SendCtx() = %v, want the write error", err)
+	}
+	if got := <-errs; got != err {
+		t.Fatalf("sendLoop() returned %v, want %v", got, err)
+	}
+	cancel()
+	wg.Wait()
+
+	for _, want := range []string{PRIVMSG, PRIVMSG} {
+		select {
+		case got := <-failed:
+			if got != want {
+				t.Fatalf("SEND_FAILED for %q, want %q", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for SEND_FAILED")
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(handled) != 2 {
+		t.Fatalf("HandleError called with %v, want 2 errors", handled)
+	}
+	if e, ok := handled[0].(*ErrSendFailed); !ok || e.Event.Command != PRIVMSG {
+		t.Fatalf("HandleError called with %#v, want *ErrSendFailed", handled[0])
+	} else if _, ok := e.Err.(*ErrProtocolViolation); !ok {
+		t.Fatalf("ErrSendFailed.Err = %v, want *ErrProtocolViolation", e.Err)
+	}
+	if e, ok := handled[1].(*ErrSendFailed); !ok || e.Err != err {
+		t.Fatalf("HandleError called with %v, want the write error", handled[1])
+	}
+}
+
+func TestTargetRate(t *testing.T) {
+	r := &TargetRate{Burst: 2, Rate: 0.5}
+	now := time.Now()
+
+	for i, want := range []time.Duration{0, 0, 2 * time.Second, 4 * time.Second} {
+		if got := r.reserve("#busy", now); got != want {
+			t.Fatalf("reserve() #%d = %s, want %s", i+1, got, want)
+		}
+	}
+	if got := r.reserve("#other", now); got != 0 {
+		t.Fatalf("reserve() for another target = %s, want 0", got)
+	}
+
+	// Tokens are refilled at Rate, up to Burst.
+	r.cancel("#busy")
+	if got := r.reserve("#busy", now.Add(3*time.Second)); got != time.Second {
+		t.Fatalf("reserve() after 3s = %s, want 1s", got)
+	}
+	if got := r.reserve("#other", now.Add(time.Hour)); got != 0 {
+		t.Fatalf("reserve() after an hour = %s, want 0", got)
+	}
+	if _, ok := r.buckets[ToRFC1459("#busy")]; ok || len(r.buckets) != 1 {
+		t.Fatalf("refilled buckets weren't swept: %v", r.buckets)
+	}
+
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test", AllowFlood: true, TargetRate: &TargetRate{Burst: 1, Rate: 0.1}})
+	_, out, conn := mockBuffers()
+	c.conn = conn
+	stop := runSendLoop(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	send := func(ctx context.Context, target string) error {
+		return c.SendCtx(ctx, &Event{Command: PRIVMSG, Params: []string{target}, Trailing: "test"})
+	}
+
+	if err := send(context.Background(), "#Busy"); err != nil {
+		t.Fatalf("first message = %v", err)
+	}
+	if err := send(ctx, "#busy,#other"); err != context.DeadlineExceeded {
+		t.Fatalf("message over the limit = %v, want context.DeadlineExceeded", err)
+	}
+	if err := send(context.Background(), "#other"); err != nil {
+		t.Fatalf("message to another target = %v", err)
+	}
+
+	// Send doesn't wait for the limit.
+	c.Cmd.Message("#busy", "later")
+	if length := c.SendQueue().Length; length != 1 {
+		t.Fatalf("SendQueue().Length = %d, want 1", length)
+	}
+	stop()
+
+	if want := "PRIVMSG #Busy :test\r\nPRIVMSG #other :test\r\n"; out.String() != want {
+		t.Fatalf("sent %q, want %q", out.String(), want)
+	}
+}
+
+func TestSendQueue(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test"})
+	_, out, conn := mockBuffers()
+	conn.writeDelay = 20 * time.Second
+	conn.lastWrite = time.Now()
+	c.conn = conn
+
+	if stats := c.SendQueue(); stats.Length != 0 || stats.Drain != 0 {
+		t.Fatalf("SendQueue() = %+v, want an empty queue", stats)
+	}
+
+	errs := make(chan error, 1)
+	send := func() {
+		errs <- c.SendCtx(context.Background(), &Event{Command: PRIVMSG, Params: []string{"#test"}, Trailing: "test"})
+	}
+
+	c.Cmd.Message("#test", "stale")
+	go send()
+	for c.SendQueue().Length != 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// 20s, plus 1s for each event and 10ms for each character, minus the
+	// allowed burst of 8s.
+	if stats := c.SendQueue(); stats.Drain < 14*time.Second || stats.Drain > 15*time.Second {
+		t.Fatalf("SendQueue().Drain = %s, want ~14.4s", stats.Drain)
+	}
+
+	if dropped := c.DropQueue(); dropped != 2 {
+		t.Fatalf("DropQueue() = %d, want 2", dropped)
+	}
+	select {
+	case err := <-errs:
+		if err != ErrQueueDropped {
+			t.Fatalf("dropped sender got %v, want ErrQueueDropped", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("dropped sender is still waiting")
+	}
+	if len(c.tx) != 0 || c.SendQueue().Length != 0 {
+		t.Fatalf("%d events left in the queue after DropQueue()", c.SendQueue().Length)
+	}
+
+	stop := runSendLoop(c)
+	go send()
+	for c.SendQueue().Length != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	if flushed := c.FlushQueue(); flushed != 1 {
+		t.Fatalf("FlushQueue() = %d, want 1", flushed)
+	}
+	select {
+	case err := <-errs:
+		if err != nil {
+			t.Fatalf("flushed sender got %v", err)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("flushed sender is still waiting")
+	}
+	stop()
+
+	if want := "PRIVMSG #test :test\r\n"; out.String() != want {
+		t.Fatalf("sent %q, want %q", out.String(), want)
+	}
+}
+
+func TestSendQueueControl(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test", AllowFlood: true})
+
+	// Control events don't block while not connected either, even once
+	// there are more than fit in the queue.
+	sent := make(chan struct{})
+	go func() {
+		for i := 0; i < 2*cap(c.txControl); i++ {
+			c.Cmd.Pong("token")
+		}
+		close(sent)
+	}()
+
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("Send() blocked on a full control queue")
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- c.SendCtx(context.Background(), &Event{Command: PONG, Params: []string{"last"}})
+	}()
+	for c.SendQueue().Length != 2*cap(c.txControl)+1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// They're dropped once the connection ends.
+	c.dropControl()
+	select {
+	case err := <-errs:
+		if err != ErrNotConnected {
+			t.Fatalf("dropped sender got %v, want ErrNotConnected", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("dropped sender is still waiting")
+	}
+	if len(c.txControl) != 0 || c.SendQueue().Length != 0 {
+		t.Fatalf("%d events left in the queue after the connection ended", c.SendQueue().Length)
+	}
+
+	// The same event can be sent more than once.
+	_, out, conn := mockBuffers()
+	c.conn = conn
+	stop := runSendLoop(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	event := &Event{Command: PRIVMSG, Params: []string{"#test"}, Trailing: "twice"}
+	c.Send(event)
+	if err := c.SendCtx(ctx, event); err != nil {
+		t.Fatalf("SendCtx() returned %v", err)
+	}
+	stop()
+
+	if c.SendQueue().Length != 0 {
+		t.Fatalf("%d events left in the queue after sending them", c.SendQueue().Length)
+	}
+	if want := "PRIVMSG #test :twice\r\nPRIVMSG #test :twice\r\n"; out.String() != want {
+		t.Fatalf("sent %q, want %q", out.String(), want)
+	}
+}
+
+func TestSendQueueLimit(t *testing.T) {
+	failed := make(chan error, 5)
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test", MaxSendQueue: 3, HandleError: func(c *Client, err error) {
+		failed <- err
+	}})
+
+	for i := 0; i < 3; i++ {
+		c.Cmd.Message("#test", "queued")
+	}
+
+	// Once the queue is full, events are rejected without waiting.
+	err := c.SendCtx(context.Background(), &Event{Command: PRIVMSG, Params: []string{"#test"}, Trailing: "rejected"})
+	if err != ErrQueueFull {
+		t.Fatalf("SendCtx() on a full queue returned %v, want ErrQueueFull", err)
+	}
+	if err, ok := (<-failed).(*ErrSendFailed); !ok || err.Err != ErrQueueFull {
+		t.Fatalf("HandleError got %v, want ErrQueueFull", err)
+	}
+
+	// Control traffic is always queued.
+	c.Cmd.Pong("token")
+	if stats := c.SendQueue(); stats.Length != 4 {
+		t.Fatalf("SendQueue().Length = %d, want 4", stats.Length)
+	}
+
+	c.DropQueue()
+	c.Cmd.Message("#test", "queued")
+	select {
+	case err := <-failed:
+		t.Fatalf("sending after DropQueue() failed: %v", err)
+	default:
+	}
+
+	conf := &Config{Server: "dummy.int", Nick: "test", User: "test", MaxSendQueue: -2}
+	if err := conf.isValid(); err == nil {
+		t.Fatal("isValid() returned nil for a negative MaxSendQueue")
+	}
+}
+
+// metricsRecorder is a MetricsHook which records the totals it receives.
+type metricsRecorder struct {
+	mu                 sync.Mutex
+	bytesIn, bytesOut  int
+	events, handlers   map[string]int
+	connects, reconnts int
+}
+
+func (m *metricsRecorder) LineRead(bytes int) {
+	m.mu.Lock()
+	m.bytesIn += bytes
+	m.mu.Unlock()
+}
+
+func (m *metricsRecorder) LineWritten(bytes int) {
+	m.mu.Lock()
+	m.bytesOut += bytes
+	m.mu.Unlock()
+}
+
+func (m *metricsRecorder) EventDispatched(command string) {
+	m.mu.Lock()
+	m.events[command]++
+	m.mu.Unlock()
+}
+
+func (m *metricsRecorder) HandlerExecuted(command string, duration time.Duration) {
+	m.mu.Lock()
+	m.handlers[command]++
+	m.mu.Unlock()
+}
+
+func (m *metricsRecorder) Connected(reconnect bool) {
+	m.mu.Lock()
+	m.connects++
+	if reconnect {
+		m.reconnts++
+	}
+	m.mu.Unlock()
+}
+
+func TestStats(t *testing.T) {
+	hook := &metricsRecorder{events: make(map[string]int), handlers: make(map[string]int)}
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test", Metrics: hook})
+
+	ping := "PING :token\r\n"
+	for i := 0; i < 2; i++ {
+		pong := make(chan struct{})
+		conn, done := mockServer(t, c, func(e *Event, w io.Writer) {
+			if e.Command == PONG {
+				close(pong)
+			}
+		})
+		_, _ = conn.Write([]byte(ping))
+
+		select {
+		case <-pong:
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for PONG")
+		}
+
+		c.Close()
+		<-done
+	}
+
+	stats := c.Stats()
+	if stats.LinesIn != 2 || stats.BytesIn != uint64(2*len(ping)) {
+		t.Fatalf("Stats() read %d lines, %d bytes, want 2, %d", stats.LinesIn, stats.BytesIn, 2*len(ping))
+	}
+	if stats.Connections != 2 || stats.Reconnects != 1 {
+		t.Fatalf("Stats() connections = %d, reconnects = %d, want 2, 1", stats.Connections, stats.Reconnects)
+	}
+	if stats.Events[PING] != 2 || stats.Events[INITIALIZED] != 2 {
+		t.Fatalf("Stats().Events = %v, want 2 of PING and INITIALIZED", stats.Events)
+	}
+	if h := stats.Handlers[PING]; h.Calls < 2 || h.Total < h.Max {
+		t.Fatalf("Stats().Handlers[PING] = %+v, want at least 2 calls", h)
+	}
+	if stats.LinesOut < 2 || stats.BytesOut == 0 {
+		t.Fatalf("Stats() wrote %d lines, %d bytes, want at least the PONGs", stats.LinesOut, stats.BytesOut)
+	}
+
+	hook.mu.Lock()
+	defer hook.mu.Unlock()
+
+	if hook.bytesIn != int(stats.BytesIn) || hook.bytesOut != int(stats.BytesOut) || hook.events[PING] != 2 ||
+		hook.handlers[PING] != int(stats.Handlers[PING].Calls) || hook.connects != 2 || hook.reconnts != 1 {
+		t.Fatalf("MetricsHook got %+v, want it to match %+v", hook, stats)
+	}
+}
+
+func TestOnRaw(t *testing.T) {
+	var mu sync.Mutex
+	var lines []string
+	conf := Config{
+		Server:     "dummy.int",
+		Nick:       "test",
+		User:       "test",
+		ServerPass: "secret",
+		OnRaw: func(in bool, line string) {
+			mu.Lock()
+			if in {
+				lines = append(lines, "< "+line)
+			} else {
+				lines = append(lines, "> "+line)
+			}
+			mu.Unlock()
+		},
+	}
+
+	for _, sensitive := range []bool{false, true} {
+		lines = nil
+		conf.RawSensitive = sensitive
+		c := New(conf)
+
+		pong := make(chan struct{})
+		conn, done := mockServer(t, c, func(e *Event, w io.Writer) {
+			if e.Command == PONG {
+				close(pong)
+			}
+		})
+		_, _ = conn.Write([]byte("@time=2020-01-01T00:00:00.000Z PING :token\r\n"))
+
+		select {
+		case <-pong:
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for PONG")
+		}
+
+		c.Close()
+		<-done
+
+		pass := "> PASS ***redacted***"
+		if sensitive {
+			pass = "> PASS secret"
+		}
+
+		mu.Lock()
+		got := strings.Join(lines, "\n") + "\n"
+		mu.Unlock()
+
+		for _, want := range []string{pass, "< @time=2020-01-01T00:00:00.000Z PING :token", "> PONG token"} {
+			if !strings.Contains(got, want+"\n") {
+				t.Fatalf("OnRaw got lines:\n%s\nwant %q", got, want)
+			}
+		}
+		if !sensitive && strings.Contains(got, "secret") {
+			t.Fatalf("OnRaw got lines:\n%s\nwant password redacted", got)
+		}
+	}
+}
+
+func TestStatus(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test"})
+
+	var mu sync.Mutex
+	var statuses []string
+	ready := make(chan struct{})
+	c.Handlers.Add(STATUS_CHANGED, func(c *Client, e Event) {
+		mu.Lock()
+		statuses = append(statuses, e.Params[0])
+		mu.Unlock()
+
+		if e.Params[0] == StatusReady.String() {
+			close(ready)
+		}
+	})
+
+	disconnected := make(chan Event, 1)
+	c.Handlers.Add(DISCONNECTED, func(c *Client, e Event) { disconnected <- e })
+
+	if c.Status() != StatusDisconnected {
+		t.Fatalf("Status() = %s before connecting, want %s", c.Status(), StatusDisconnected)
+	}
+
+	_, done := mockServer(t, c, func(e *Event, w io.Writer) {
+		if e.Command == USER {
+			fmt.Fprint(w, ":dummy.int 001 test :Welcome\r\n")
+		}
+	})
+
+	select {
+	case <-ready:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for StatusReady")
+	}
+
+	if c.Status() != StatusReady {
+		t.Fatalf("Status() = %s after RPL_WELCOME, want %s", c.Status(), StatusReady)
+	}
+
+	c.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("MockConnect() returned %v after Close()", err)
+	}
+
+	select {
+	case e := <-disconnected:
+		if len(e.Params) != 1 || e.Params[0] != c.Server() || e.Trailing != "" {
+			t.Fatalf("DISCONNECTED event %q, want server and no error", e.String())
+		}
+	default:
+		t.Fatal("DISCONNECTED wasn't emitted")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"connecting", "registering", "ready", "closing", "disconnected"}; !reflect.DeepEqual(statuses, want) {
+		t.Fatalf("STATUS_CHANGED emitted %v, want %v", statuses, want)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	for _, graceful := range []bool{true, false} {
+		c := New(Config{Server: "dummy.int", Nick: "test", User: "test"})
+
+		var bgDone, disconnected int32
+		c.Handlers.AddBg(INITIALIZED, func(c *Client, e Event) {
+			time.Sleep(50 * time.Millisecond)
+			atomic.StoreInt32(&bgDone, 1)
+		})
+		c.Handlers.Add(DISCONNECTED, func(c *Client, e Event) { atomic.StoreInt32(&disconnected, 1) })
+
+		if err := c.Shutdown(context.Background(), ""); err != ErrNotConnected {
+			t.Fatalf("Shutdown() before connecting returned %v, want ErrNotConnected", err)
+		}
+
+		// Act as a server which closes the connection after a QUIT, unless
+		// it's ignored.
+		quit := make(chan string, 1)
+		_, done := mockServer(t, c, func(e *Event, w io.Writer) {
+			if e.Command == QUIT {
+				quit <- e.String()
+				if graceful {
+					fmt.Fprint(w, "ERROR :Closing Link\r\n")
+				}
+			}
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		err := c.Shutdown(ctx, "bye")
+		cancel()
+
+		if graceful && err != nil {
+			t.Fatalf("Shutdown() returned %v, want nil", err)
+		} else if !graceful && err != context.DeadlineExceeded {
+			t.Fatalf("Shutdown() returned %v when the server ignored QUIT, want context.DeadlineExceeded", err)
+		}
+
+		if line := <-quit; line != "QUIT :bye" {
+			t.Fatalf("Shutdown() sent %q, want %q", line, "QUIT :bye")
+		}
+
+		select {
+		case <-done:
+		default:
+			t.Fatal("Shutdown() returned before MockConnect()")
+		}
+
+		if atomic.LoadInt32(&disconnected) != 1 || atomic.LoadInt32(&bgDone) != 1 {
+			t.Fatal("Shutdown() returned before handlers were executed")
+		}
+	}
+}
+
+func TestUpdateConfig(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test"})
+
+	if err := c.UpdateConfig(func(conf *RuntimeConfig) { conf.Nick = "first" }); err != nil {
+		t.Fatalf("UpdateConfig() returned %v", err)
+	}
+	if id := c.Identity(); id.Nick != "first" {
+		t.Fatalf("Identity() before connecting = %q, want the updated nick", id.Nick)
+	}
+
+	nicks := make(chan string, 10)
+	_, done := mockServer(t, c, func(e *Event, w io.Writer) {
+		if e.Command == NICK {
+			nicks <- e.String()
+		}
+	})
+
+	if nick := <-nicks; nick != "NICK first" {
+		t.Fatalf("registered with %q, want %q", nick, "NICK first")
+	}
+
+	// The rate limits are read while sending.
+	go func() {
+		for i := 0; i < 10; i++ {
+			c.Cmd.Message("#channel", "test")
+		}
+	}()
+
+	err := c.UpdateConfig(func(conf *RuntimeConfig) {
+		conf.Nick = "second"
+		conf.User = "other"
+		conf.AllowFlood = true
+		conf.TargetRate = &TargetRate{Burst: 1}
+	})
+	if err != nil {
+		t.Fatalf("UpdateConfig() returned %v", err)
+	}
+
+	select {
+	case nick := <-nicks:
+		if nick != "NICK second" {
+			t.Fatalf("UpdateConfig() sent %q, want %q", nick, "NICK second")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("UpdateConfig() didn't change the nick while connected")
+	}
+
+	err = c.UpdateConfig(func(conf *RuntimeConfig) {
+		conf.Nick = "invalid nick"
+		conf.AllowFlood = false
+	})
+	if _, ok := err.(*ErrInvalidConfig); !ok {
+		t.Fatalf("UpdateConfig() with an invalid nick returned %v, want ErrInvalidConfig", err)
+	}
+
+	if allowFlood, _, r := c.rateConfig(); !allowFlood || r == nil || r.Burst != 1 {
+		t.Fatal("UpdateConfig() changed the config despite it being invalid")
+	}
+
+	c.Close()
+	<-done
+
+	if id := c.Identity(); id.User != "test" {
+		t.Fatalf("Identity() = %q, want the user of the connection", id.User)
+	}
+
+	// User and Name apply the next time the client connects.
+	c.mu.Lock()
+	id, _ := c.nextIdentity()
+	c.mu.Unlock()
+
+	if id.Nick != "second" || id.User != "other" {
+		t.Fatalf("next identity = %+v, want the updated nick and user", id)
+	}
+}
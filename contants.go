@@ -15,18 +15,42 @@ const (
 	CTCP_TIME       = "TIME"
 	CTCP_FINGER     = "FINGER"
 	CTCP_ERRMSG     = "ERRMSG"
+	CTCP_DCC        = "DCC"
 )
 
 // Emulated event commands used to allow easier hooks into the changing
 // state of the client.
 const (
-	UPDATE_STATE   = "CLIENT_STATE_UPDATED"   // when channel/user state is updated.
-	UPDATE_GENERAL = "CLIENT_GENERAL_UPDATED" // when general state (client nick, server name, etc) is updated.
-	ALL_EVENTS     = "*"                      // trigger on all events
-	CONNECTED      = "CLIENT_CONNECTED"       // when it's safe to send arbitrary commands (joins, list, who, etc), trailing is host:port
-	INITIALIZED    = "CLIENT_INIT"            // verifies successful socket connection, trailing is host:port
-	DISCONNECTED   = "CLIENT_DISCONNECTED"    // occurs when we're disconnected from the server (user-requested or not)
-	STOPPED        = "CLIENT_STOPPED"         // occurs when Client.Stop() has been called
+	UPDATE_STATE          = "CLIENT_STATE_UPDATED"         // when channel/user state is updated.
+	UPDATE_GENERAL        = "CLIENT_GENERAL_UPDATED"       // when general state (client nick, server name, etc) is updated.
+	ALL_EVENTS            = "*"                            // trigger on all events
+	CONNECTING            = "CLIENT_CONNECTING"            // before each connection attempt, params are the nick and user/ident being used, trailing is the realname
+	CONNECTED             = "CLIENT_CONNECTED"             // when it's safe to send arbitrary commands (joins, list, who, etc), trailing is host:port
+	INITIALIZED           = "CLIENT_INIT"                  // verifies successful socket connection, trailing is host:port
+	DISCONNECTED          = "CLIENT_DISCONNECTED"          // occurs when we're disconnected from the server (user-requested or not), params are host:port, trailing is the error, if any
+	STOPPED               = "CLIENT_STOPPED"               // occurs when Client.Stop() has been called
+	STATUS_CHANGED        = "CLIENT_STATUS_CHANGED"        // when the status of the connection changes (see Client.Status()), params are the new and old status
+	SHED_STARTED          = "CLIENT_SHED_STARTED"          // when events start being shed (see Config.LoadShedding), trailing is the queue length
+	SHED_STOPPED          = "CLIENT_SHED_STOPPED"          // when events are no longer being shed, trailing is the amount of events dropped
+	EVENTS_DROPPED        = "CLIENT_EVENTS_DROPPED"        // when incoming events were dropped as the event queue was full (see Config.EventQueuePolicy), trailing is the amount of events dropped
+	TOPIC_CHANGED         = "CLIENT_TOPIC_CHANGED"         // when a channel topic is changed, params are channel, setter and old topic, trailing is the new topic
+	URL_SEEN              = "CLIENT_URL_SEEN"              // when a PRIVMSG contains URLs (see Config.ExtractURLs), params are the target followed by the URLs, trailing is the message without formatting
+	KICKED_REJOINING      = "CLIENT_KICKED_REJOINING"      // when rejoining a channel after being kicked (see Config.KickRejoin), params are the channel, kicker and attempt number, trailing is the kick reason
+	SERVICE_PRIVMSG       = "CLIENT_SERVICE_PRIVMSG"       // a PRIVMSG from network services (see Client.IsService()), with the same source, params and trailing
+	SERVICE_NOTICE        = "CLIENT_SERVICE_NOTICE"        // a NOTICE from network services (see Client.IsService()), with the same source, params and trailing
+	NICK_RECLAIMED        = "CLIENT_NICK_RECLAIMED"        // when the configured nickname has been reclaimed (see Config.NickReclaim), params are the old and new nickname
+	RATE_ADJUSTED         = "CLIENT_RATE_ADJUSTED"         // when the outbound rate limit is adjusted (see Config.AdaptiveRate), params are the new slowdown factor, trailing is the reason
+	REOP_SUCCEEDED        = "CLIENT_REOP_SUCCEEDED"        // when op has been given back after requesting it (see Config.ReOp), params are the channel
+	REOP_FAILED           = "CLIENT_REOP_FAILED"           // when op wasn't given back in time after requesting it (see Config.ReOp), params are the channel, trailing is the reason
+	THROTTLED             = "CLIENT_THROTTLED"             // when a throttled handler suppressed events (see Throttle()), params are the throttle name, key and amount of suppressed events, trailing is the window
+	CHANNEL_READY         = "CLIENT_CHANNEL_READY"         // when the tracked state of a joined channel has been populated (see Config.Priming), params are the channel, trailing are the steps which timed out, if any
+	DCC_CHAT_OFFER        = "CLIENT_DCC_CHAT_OFFER"        // when another user offers a DCC CHAT session (see Client.DCC), params are the offer id and nickname
+	DCC_CHAT_OPENED       = "CLIENT_DCC_CHAT_OPENED"       // when a DCC CHAT session has been established (see Client.DCC), params are the session id and nickname
+	DCC_CHAT_CLOSED       = "CLIENT_DCC_CHAT_CLOSED"       // when a DCC CHAT session has been closed (see DCCChat.Close()), params are the session id and nickname
+	DCC_SEND_OFFER        = "CLIENT_DCC_SEND_OFFER"        // when another user offers to send a file (see DCC.AcceptFile()), params are the offer id, nickname, filename and size
+	DCC_TRANSFER_PROGRESS = "CLIENT_DCC_TRANSFER_PROGRESS" // periodically during a DCC SEND file transfer (see DCCTransfer), params are the transfer id, bytes transferred (including the resume offset) and size
+	DCC_TRANSFER_DONE     = "CLIENT_DCC_TRANSFER_DONE"     // when a DCC SEND file transfer has finished (see DCCTransfer), params are the transfer id, nickname and filename, trailing is the error, if it failed
+	SEND_FAILED           = "CLIENT_SEND_FAILED"           // when an event could not be sent (see Config.HandleError), params are the command of the event, trailing is the error
 )
 
 // User/channel prefixes :: RFC1459.
@@ -267,7 +291,18 @@ const (
 // IRCv3 commands and extensions :: http://ircv3.net/irc/.
 const (
 	AUTHENTICATE = "AUTHENTICATE"
+	BATCH        = "BATCH"
+	BOUNCER      = "BOUNCER"
 	STARTTLS     = "STARTTLS"
+	RELAYMSG     = "RELAYMSG"
+	REGISTER     = "REGISTER"
+	VERIFY       = "VERIFY"
+	MONITOR      = "MONITOR"
+
+	// Standard replies.
+	FAIL = "FAIL"
+	WARN = "WARN"
+	NOTE = "NOTE"
 
 	CAP       = "CAP"
 	CAP_ACK   = "ACK"
@@ -298,6 +333,13 @@ const (
 	RPL_SASLMECHS   = "908"
 	RPL_STARTTLS    = "670"
 	ERR_STARTTLS    = "691"
+
+	// MONITOR.
+	RPL_MONONLINE    = "730"
+	RPL_MONOFFLINE   = "731"
+	RPL_MONLIST      = "732"
+	RPL_ENDOFMONLIST = "733"
+	ERR_MONLISTFULL  = "734"
 )
 
 // Numeric IRC event mapping :: RFC2812; section 5.3.
@@ -335,4 +377,9 @@ const (
 	RPL_LOCALUSERS     = "265" // aircd/hybrid/bahamut, used on freenode.
 	RPL_TOPICWHOTIME   = "333" // ircu, used on freenode.
 	RPL_WHOSPCRPL      = "354" // ircu, used on networks with WHOX support.
+	RPL_WHOISACCOUNT   = "330" // ircu/charybdis/inspircd, used on freenode.
+	RPL_WHOISSECURE    = "671" // unrealircd/charybdis/inspircd.
+	ERR_TARGETTOOFAST  = "439" // ircu/charybdis, "target change too fast".
+	ERR_TARGCHANGE     = "707" // charybdis/ratbox, "targets changing too fast".
+	MAP                = "MAP" // ircu/unrealircd/inspircd, shows the server map.
 )
@@ -6,7 +6,7 @@ package girc
 
 import (
 	"fmt"
-	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -15,6 +15,9 @@ import (
 // ctcpDelim if the delimiter used for CTCP formatted events/messages.
 const ctcpDelim byte = 0x01 // Prefix and suffix for CTCP messages.
 
+// defaultCTCPWindow is the default for Config.CTCPWindow.
+const defaultCTCPWindow = 10 * time.Second
+
 // CTCPEvent is the necessary information from an IRC message.
 type CTCPEvent struct {
 	// Origin is the original event that the CTCP event was decoded from.
@@ -120,6 +123,12 @@ type CTCP struct {
 	mu sync.RWMutex
 	// handlers is a map of CTCP message -> functions.
 	handlers map[string]CTCPHandler
+	// noDefaults disables the default handlers, and ERRMSG replies to
+	// unknown queries, see Config.DisableCTCPDefaults.
+	noDefaults bool
+	// throttle, if set, limits the queries handled per source, see
+	// Config.CTCPLimit.
+	throttle Handler
 }
 
 // newCTCP returns a new clean CTCP handler.
@@ -127,6 +136,17 @@ func newCTCP() *CTCP {
 	return &CTCP{handlers: map[string]CTCPHandler{}}
 }
 
+// handle executes the necessary CTCP handler for the incoming event/CTCP
+// command, unless its source has exceeded Config.CTCPLimit.
+func (c *CTCP) handle(client *Client, event *CTCPEvent) {
+	if c.throttle == nil || event.Reply || event.Origin == nil {
+		c.call(client, event)
+		return
+	}
+
+	c.throttle.Execute(client, *event.Origin)
+}
+
 // call executes the necessary CTCP handler for the incoming event/CTCP
 // command.
 func (c *CTCP) call(client *Client, event *CTCPEvent) {
@@ -140,13 +160,15 @@ func (c *CTCP) call(client *Client, event *CTCPEvent) {
 
 	// Support wildcard CTCP event handling. Gets executed first before
 	// regular event handlers.
-	if _, ok := c.handlers["*"]; ok {
+	_, wildcard := c.handlers["*"]
+	if wildcard {
 		c.handlers["*"](client, *event)
 	}
 
 	if _, ok := c.handlers[event.Command]; !ok {
-		// Send a ERRMSG reply, if we know who sent it.
-		if event.Source != nil && IsValidNick(event.Source.Name) {
+		// Send a ERRMSG reply, if we know who sent it, and it isn't up to
+		// the wildcard handler to reply.
+		if !c.noDefaults && !wildcard && !event.Reply && event.Source != nil && IsValidNick(event.Source.Name) {
 			client.Cmd.SendCTCPReply(event.Source.Name, CTCP_ERRMSG, "that is an unknown CTCP query")
 		}
 		return
@@ -195,7 +217,14 @@ func (c *CTCP) Set(cmd string, handler func(client *Client, ctcp CTCPEvent)) {
 // for more information.
 func (c *CTCP) SetBg(cmd string, handler func(client *Client, ctcp CTCPEvent)) {
 	c.Set(cmd, func(client *Client, ctcp CTCPEvent) {
-		go handler(client, ctcp)
+		client.goBg(true, func() {
+			// If they want to catch any panics, add to defer stack.
+			if client.Config.RecoverFunc != nil && ctcp.Origin != nil {
+				defer recoverHandlerPanic(client, ctcp.Origin, "ctcp-bg-"+strings.ToLower(ctcp.Command), 3)
+			}
+
+			handler(client, ctcp)
+		})
 	})
 }
 
@@ -210,7 +239,24 @@ func (c *CTCP) Clear(cmd string) {
 	c.mu.Unlock()
 }
 
-// ClearAll removes all currently setup and re-sets the default handlers.
+// Reset restores the default handler for cmd (e.g. VERSION), after it was
+// overridden with Set or removed with Clear. If cmd has no default handler,
+// its handler is removed instead.
+func (c *CTCP) Reset(cmd string) {
+	if cmd = c.parseCMD(cmd); cmd == "" {
+		return
+	}
+
+	if handler, ok := defaultCTCPHandlers[cmd]; ok {
+		c.SetBg(cmd, handler)
+		return
+	}
+
+	c.Clear(cmd)
+}
+
+// ClearAll removes all currently setup and re-sets the default handlers
+// (unless disabled, see Config.DisableCTCPDefaults).
 func (c *CTCP) ClearAll() {
 	c.mu.Lock()
 	c.handlers = map[string]CTCPHandler{}
@@ -220,18 +266,65 @@ func (c *CTCP) ClearAll() {
 	c.addDefaultHandlers()
 }
 
+// commands returns the sorted CTCP commands which have handlers, excluding
+// the wildcard handler.
+func (c *CTCP) commands() []string {
+	c.mu.RLock()
+	cmds := make([]string, 0, len(c.handlers))
+	for cmd := range c.handlers {
+		if cmd != "*" {
+			cmds = append(cmds, cmd)
+		}
+	}
+	c.mu.RUnlock()
+
+	sort.Strings(cmds)
+	return cmds
+}
+
 // CTCPHandler is a type that represents the function necessary to
 // implement a CTCP handler.
 type CTCPHandler func(client *Client, ctcp CTCPEvent)
 
-// addDefaultHandlers adds some useful default CTCP response handlers.
+// defaultCTCPHandlers are some useful default CTCP response handlers, see
+// CTCP.Reset() and Config.DisableCTCPDefaults.
+var defaultCTCPHandlers = map[string]CTCPHandler{
+	CTCP_PING:       handleCTCPPing,
+	CTCP_PONG:       handleCTCPPong,
+	CTCP_VERSION:    handleCTCPVersion,
+	CTCP_SOURCE:     handleCTCPSource,
+	CTCP_TIME:       handleCTCPTime,
+	CTCP_FINGER:     handleCTCPFinger,
+	CTCP_CLIENTINFO: handleCTCPClientInfo,
+}
+
+// addDefaultHandlers adds the default CTCP response handlers, unless
+// disabled.
 func (c *CTCP) addDefaultHandlers() {
-	c.SetBg(CTCP_PING, handleCTCPPing)
-	c.SetBg(CTCP_PONG, handleCTCPPong)
-	c.SetBg(CTCP_VERSION, handleCTCPVersion)
-	c.SetBg(CTCP_SOURCE, handleCTCPSource)
-	c.SetBg(CTCP_TIME, handleCTCPTime)
-	c.SetBg(CTCP_FINGER, handleCTCPFinger)
+	if c.noDefaults {
+		return
+	}
+
+	for cmd, handler := range defaultCTCPHandlers {
+		c.SetBg(cmd, handler)
+	}
+}
+
+// limit limits the queries handled per source, see Config.CTCPLimit.
+func (c *CTCP) limit(limit int, window time.Duration) {
+	if limit <= 0 {
+		return
+	}
+
+	if window <= 0 {
+		window = defaultCTCPWindow
+	}
+
+	c.throttle = Throttle("ctcp", limit, window, ThrottleBySource, HandlerFunc(func(client *Client, e Event) {
+		if ctcp := decodeCTCP(&e); ctcp != nil {
+			c.call(client, ctcp)
+		}
+	}))
 }
 
 // handleCTCPPing replies with a ping and whatever was originally requested.
@@ -250,25 +343,17 @@ func handleCTCPPong(client *Client, ctcp CTCPEvent) {
 	client.Cmd.SendCTCPReply(ctcp.Source.Name, CTCP_PONG, "")
 }
 
-// handleCTCPVersion replies with the name of the client, Go version, as well
-// as the os type (darwin, linux, windows, etc) and architecture type (x86,
-// arm, etc).
+// handleCTCPVersion replies with Config.Version, or Config.BotInfo and the
+// name of the client, Go version, as well as the os type (darwin, linux,
+// windows, etc) and architecture type (x86, arm, etc).
 func handleCTCPVersion(client *Client, ctcp CTCPEvent) {
-	if client.Config.Version != "" {
-		client.Cmd.SendCTCPReply(ctcp.Source.Name, CTCP_VERSION, client.Config.Version)
-		return
-	}
-
-	client.Cmd.SendCTCPReplyf(
-		ctcp.Source.Name, CTCP_VERSION,
-		"girc (github.com/lrstanley/girc) using %s (%s, %s)",
-		runtime.Version(), runtime.GOOS, runtime.GOARCH,
-	)
+	client.Cmd.SendCTCPReply(ctcp.Source.Name, CTCP_VERSION, client.versionReply())
 }
 
-// handleCTCPSource replies with the public git location of this library.
+// handleCTCPSource replies with the public git location of the application
+// (see BotInfo.Source), or of this library.
 func handleCTCPSource(client *Client, ctcp CTCPEvent) {
-	client.Cmd.SendCTCPReply(ctcp.Source.Name, CTCP_SOURCE, "https://github.com/lrstanley/girc")
+	client.Cmd.SendCTCPReply(ctcp.Source.Name, CTCP_SOURCE, client.sourceReply())
 }
 
 // handleCTCPTime replies with a RFC 1123 (Z) formatted version of Go's
@@ -284,5 +369,16 @@ func handleCTCPFinger(client *Client, ctcp CTCPEvent) {
 	active := client.conn.lastActive
 	client.conn.mu.RUnlock()
 
-	client.Cmd.SendCTCPReply(ctcp.Source.Name, CTCP_FINGER, fmt.Sprintf("%s -- idle %s", client.Config.Name, time.Since(active)))
+	client.Cmd.SendCTCPReply(ctcp.Source.Name, CTCP_FINGER, fmt.Sprintf("%s -- idle %s", client.Identity().Name, time.Since(active)))
+}
+
+// handleCTCPClientInfo replies with the CTCP commands which currently have
+// handlers (including those added with CTCP.Set()), so the reply is always
+// in sync with what we actually respond to.
+func handleCTCPClientInfo(client *Client, ctcp CTCPEvent) {
+	if ctcp.Reply {
+		return
+	}
+
+	client.Cmd.SendCTCPReply(ctcp.Source.Name, CTCP_CLIENTINFO, strings.Join(client.CTCP.commands(), " "))
 }
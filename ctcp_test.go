@@ -5,7 +5,17 @@
 package girc
 
 import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strconv"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -184,3 +194,610 @@ func TestClearAll(t *testing.T) {
 		t.Fatalf("ctcp.ClearAll() didn't remove all handlers: 1: %v 2: %v", first, second)
 	}
 }
+
+func TestCTCPDefaults(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test", DisableCTCPDefaults: true})
+
+	reply := func(cmd string) string {
+		c.CTCP.call(c, &CTCPEvent{Source: &Source{Name: "nick"}, Command: cmd})
+
+		select {
+		case o := <-c.tx:
+			e := o.event
+			return e.Trailing
+		case <-time.After(250 * time.Millisecond):
+			return ""
+		}
+	}
+
+	if cmds := c.CTCP.commands(); len(cmds) != 0 {
+		t.Fatalf("DisableCTCPDefaults registered %v", cmds)
+	}
+	if got := reply(CTCP_VERSION); got != "" {
+		t.Fatalf("DisableCTCPDefaults replied with %q", got)
+	}
+
+	c.CTCP.Reset(CTCP_PING)
+	c.CTCP.ClearAll()
+	if cmds := c.CTCP.commands(); len(cmds) != 0 {
+		t.Fatalf("ClearAll() with DisableCTCPDefaults registered %v", cmds)
+	}
+
+	c = New(Config{Server: "dummy.int", Nick: "test", User: "test"})
+	c.CTCP.Set(CTCP_VERSION, func(client *Client, ctcp CTCPEvent) {
+		client.Cmd.SendCTCPReply(ctcp.Source.Name, CTCP_VERSION, "custom")
+	})
+	c.CTCP.Clear(CTCP_TIME)
+
+	if got := reply(CTCP_VERSION); got != "\001VERSION custom\001" {
+		t.Fatalf("overridden VERSION replied with %q", got)
+	}
+	if got := reply(CTCP_TIME); got != "\001ERRMSG that is an unknown CTCP query\001" {
+		t.Fatalf("removed TIME replied with %q", got)
+	}
+
+	c.CTCP.Reset(CTCP_VERSION)
+	c.CTCP.Reset(CTCP_TIME)
+	if got := reply(CTCP_VERSION); got != "\001VERSION "+c.versionReply()+"\001" {
+		t.Fatalf("reset VERSION replied with %q", got)
+	}
+	if got := reply(CTCP_TIME); !strings.HasPrefix(got, "\001TIME ") {
+		t.Fatalf("reset TIME replied with %q", got)
+	}
+
+	// Unknown queries are left to the wildcard handler.
+	c.CTCP.Set("*", func(client *Client, event CTCPEvent) {})
+	if got := reply("FOO"); got != "" {
+		t.Fatalf("unknown query with a wildcard handler replied with %q", got)
+	}
+}
+
+func TestCTCPLimit(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test", CTCPLimit: 2, CTCPWindow: time.Minute})
+
+	replies := func(source string, queries int) (n int) {
+		for i := 0; i < queries; i++ {
+			c.RunHandlers(ParseEvent(":" + source + "!user@host PRIVMSG test :\001PING " + strconv.Itoa(i) + "\001"))
+		}
+
+		for {
+			select {
+			case <-c.tx:
+				n++
+			case <-time.After(250 * time.Millisecond):
+				return n
+			}
+		}
+	}
+
+	if n := replies("nick", 5); n != 2 {
+		t.Fatalf("replied to %d of 5 queries, want 2", n)
+	}
+	if n := replies("other", 1); n != 1 {
+		t.Fatalf("replied to %d queries from another source, want 1", n)
+	}
+
+	// Replies aren't limited.
+	c.RunHandlers(ParseEvent(":nick!user@host NOTICE test :\001PING 0\001"))
+
+	c.CTCP.throttle.(*throttleHandler).mu.Lock()
+	suppressed := c.CTCP.throttle.(*throttleHandler).windows["nick"].suppressed
+	c.CTCP.throttle.(*throttleHandler).mu.Unlock()
+	if suppressed != 3 {
+		t.Fatalf("suppressed %d queries, want 3", suppressed)
+	}
+}
+
+func TestClientInfo(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test"})
+
+	clientinfo := func() string {
+		c.CTCP.call(c, &CTCPEvent{Source: &Source{Name: "nick"}, Command: CTCP_CLIENTINFO})
+
+		select {
+		case o := <-c.tx:
+			e := o.event
+			return e.Trailing
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for CLIENTINFO reply")
+			return ""
+		}
+	}
+
+	if got, want := clientinfo(), "\001CLIENTINFO CLIENTINFO FINGER PING PONG SOURCE TIME VERSION\001"; got != want {
+		t.Fatalf("CLIENTINFO reply = %q, want %q", got, want)
+	}
+
+	c.CTCP.Set("*", func(client *Client, event CTCPEvent) {})
+	c.CTCP.Set("DCC", func(client *Client, event CTCPEvent) {})
+	c.CTCP.Clear(CTCP_FINGER)
+
+	if got, want := clientinfo(), "\001CLIENTINFO CLIENTINFO DCC PING PONG SOURCE TIME VERSION\001"; got != want {
+		t.Fatalf("CLIENTINFO reply = %q, want %q", got, want)
+	}
+}
+
+func TestBotInfo(t *testing.T) {
+	info := BotInfo{Name: "mybot", Version: "1.2.0", Contact: "admin@example.com", Source: "https://example.com/mybot"}
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test", BotInfo: info})
+
+	reply := func(command string) string {
+		c.CTCP.call(c, &CTCPEvent{Source: &Source{Name: "nick"}, Command: command})
+
+		select {
+		case o := <-c.tx:
+			e := o.event
+			return e.Trailing
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for %s reply", command)
+			return ""
+		}
+	}
+
+	want := "mybot v1.2.0 (contact: admin@example.com)"
+	if got := reply(CTCP_VERSION); !strings.HasPrefix(got, "\001VERSION "+want+", girc ") {
+		t.Fatalf("VERSION reply = %q, want prefix %q", got, want)
+	}
+	if got := reply(CTCP_SOURCE); got != "\001SOURCE https://example.com/mybot\001" {
+		t.Fatalf("SOURCE reply = %q", got)
+	}
+	if got := c.Identity().Name; got != want {
+		t.Fatalf("default realname = %q, want %q", got, want)
+	}
+
+	c.Cmd.Quit("")
+	if got := (<-c.txControl).event.String(); got != "QUIT :"+want {
+		t.Fatalf("default QUIT = %q, want %q", got, "QUIT :"+want)
+	}
+
+	// Config.Version takes precedence, and without BotInfo the defaults are
+	// used.
+	c = New(Config{Server: "dummy.int", Nick: "test", User: "test", Version: "custom"})
+	if got := reply(CTCP_VERSION); got != "\001VERSION custom\001" {
+		t.Fatalf("VERSION reply = %q, want Config.Version", got)
+	}
+	if got := reply(CTCP_SOURCE); got != "\001SOURCE "+defaultSource+"\001" {
+		t.Fatalf("SOURCE reply = %q", got)
+	}
+	if got := c.Identity().Name; got != "test" {
+		t.Fatalf("default realname = %q, want user", got)
+	}
+
+	c.Cmd.Quit("")
+	if got := (<-c.txControl).event.String(); got != QUIT {
+		t.Fatalf("default QUIT = %q, want %q", got, QUIT)
+	}
+}
+
+func TestDCCChat(t *testing.T) {
+	c := New(Config{
+		Server: "dummy.int",
+		Nick:   "test",
+		User:   "test",
+		DCC:    &DCCConfig{PublicIP: net.IPv4(127, 0, 0, 1), ListenAddr: "127.0.0.1:0", Timeout: 5 * time.Second, AllowPrivate: true},
+	})
+
+	events := make(chan Event, 10)
+	for _, cmd := range []string{DCC_CHAT_OFFER, DCC_CHAT_OPENED, DCC_CHAT_CLOSED} {
+		c.Handlers.Add(cmd, func(c *Client, e Event) { events <- e })
+	}
+	expect := func(cmd string) Event {
+		select {
+		case e := <-events:
+			if e.Command != cmd {
+				t.Fatalf("got event %q, want %s", e.String(), cmd)
+			}
+			return e
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s", cmd)
+			return Event{}
+		}
+	}
+	// sentOffer returns the address and token of the DCC CHAT offer sent.
+	sentOffer := func() (addr, token string) {
+		select {
+		case o := <-c.tx:
+			e := o.event
+			offer, ok := parseDCC(strings.TrimPrefix(strings.Trim(e.Trailing, "\001"), "DCC "))
+			if !ok || e.Params[0] != "nick" || offer.Type != DCCChatType {
+				t.Fatalf("sent %q, want DCC CHAT offer to nick", e.String())
+			}
+			return net.JoinHostPort(offer.IP.String(), strconv.Itoa(offer.Port)), offer.Token
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for offer")
+			return "", ""
+		}
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	port := strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+
+	// An offer from another user.
+	c.RunHandlers(ParseEvent(":nick!user@host PRIVMSG test :\001DCC CHAT chat 2130706433 " + port + "\001"))
+	offer, ok := c.DCC.Offer(expect(DCC_CHAT_OFFER).Params[0])
+	if !ok || offer.Source.Name != "nick" || !offer.IP.Equal(net.IPv4(127, 0, 0, 1)) || offer.Passive() {
+		t.Fatalf("DCC.Offer() = %#v, %t", offer, ok)
+	}
+
+	chat, err := c.DCC.Accept(offer.ID)
+	if err != nil {
+		t.Fatalf("DCC.Accept() = %v", err)
+	}
+	expect(DCC_CHAT_OPENED)
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err = chat.Send("hello"); err != nil {
+		t.Fatalf("DCCChat.Send() = %v", err)
+	}
+	if line, _ := bufio.NewReader(conn).ReadString('\n'); line != "hello\n" {
+		t.Fatalf("other user received %q, want %q", line, "hello\n")
+	}
+
+	conn.Write([]byte("hi\n"))
+	if line, _ := bufio.NewReader(chat).ReadString('\n'); line != "hi\n" {
+		t.Fatalf("read %q from chat, want %q", line, "hi\n")
+	}
+
+	if len(c.DCC.Chats()) != 1 {
+		t.Fatalf("DCC.Chats() = %v, want the open session", c.DCC.Chats())
+	}
+	chat.Close()
+	chat.Close()
+	expect(DCC_CHAT_CLOSED)
+	if len(c.DCC.Chats()) != 0 {
+		t.Fatal("DCC.Chats() still has the closed session")
+	}
+
+	// Offering a chat to another user.
+	result := make(chan error, 1)
+	go func() {
+		chat, err := c.DCC.Chat("nick")
+		if err == nil {
+			chat.Close()
+		}
+		result <- err
+	}()
+
+	addr, _ := sentOffer()
+	conn, err = net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if err = <-result; err != nil {
+		t.Fatalf("DCC.Chat() = %v", err)
+	}
+	expect(DCC_CHAT_OPENED)
+	expect(DCC_CHAT_CLOSED)
+
+	// Passive offers, where the other user accepts the connection.
+	go func() {
+		chat, err := c.DCC.ChatPassive("nick")
+		if err == nil {
+			chat.Close()
+		}
+		result <- err
+	}()
+
+	addr, token := sentOffer()
+	if !strings.HasSuffix(addr, ":0") || token == "" {
+		t.Fatalf("passive offer sent with address %s and token %q", addr, token)
+	}
+	c.RunHandlers(ParseEvent(":nick!user@host PRIVMSG test :\001DCC CHAT chat 2130706433 " + port + " " + token + "\001"))
+	if conn, err = ln.Accept(); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if err = <-result; err != nil {
+		t.Fatalf("DCC.ChatPassive() = %v", err)
+	}
+	expect(DCC_CHAT_OPENED)
+	expect(DCC_CHAT_CLOSED)
+
+	// Unsupported and rejected offers.
+	c.RunHandlers(ParseEvent(":nick!user@host PRIVMSG test :\001DCC FOO bar 2130706433 " + port + "\001"))
+	if got := (<-c.tx).event.String(); got != "NOTICE nick :\001DCC REJECT FOO bar\001" {
+		t.Fatalf("unsupported offer replied with %q", got)
+	}
+
+	c.RunHandlers(ParseEvent(":nick!user@host PRIVMSG test :\001DCC CHAT chat 2130706433 " + port + "\001"))
+	if err = c.DCC.Reject(expect(DCC_CHAT_OFFER).Params[0]); err != nil {
+		t.Fatalf("DCC.Reject() = %v", err)
+	}
+	if got := (<-c.tx).event.String(); got != "NOTICE nick :\001DCC REJECT CHAT chat\001" {
+		t.Fatalf("DCC.Reject() sent %q", got)
+	}
+	if len(c.DCC.Offers()) != 0 {
+		t.Fatal("DCC.Offers() still has the rejected offer")
+	}
+}
+
+func TestDCCSend(t *testing.T) {
+	c := New(Config{
+		Server: "dummy.int",
+		Nick:   "test",
+		User:   "test",
+		DCC:    &DCCConfig{PublicIP: net.IPv4(127, 0, 0, 1), ListenAddr: "127.0.0.1:0", Timeout: 5 * time.Second, AllowPrivate: true},
+	})
+
+	dir, err := ioutil.TempDir("", "girc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	offers := make(chan Event, 10)
+	done := make(chan Event, 10)
+	c.Handlers.Add(DCC_SEND_OFFER, func(c *Client, e Event) { offers <- e })
+	c.Handlers.Add(DCC_TRANSFER_DONE, func(c *Client, e Event) { done <- e })
+
+	var progress atomic.Value
+	c.Handlers.Add(DCC_TRANSFER_PROGRESS, func(c *Client, e Event) { progress.Store(e.Params[1]) })
+
+	sent := func() string {
+		select {
+		case o := <-c.tx:
+			e := o.event
+			return strings.TrimPrefix(strings.Trim(e.Trailing, "\001"), "DCC ")
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for a DCC request to be sent")
+			return ""
+		}
+	}
+	finished := func(tr *DCCTransfer) {
+		if err := tr.Wait(); err != nil {
+			t.Fatalf("DCCTransfer.Wait() = %v", err)
+		}
+		if e := <-done; e.Params[0] != tr.ID || e.Trailing != "" {
+			t.Fatalf("got %q, want DCC_TRANSFER_DONE for %s", e.String(), tr.ID)
+		}
+		if got := progress.Load(); got != strconv.FormatInt(tr.Size, 10) {
+			t.Fatalf("last progress was %v, want %d", got, tr.Size)
+		}
+	}
+	// transfer reads the whole file from conn, acknowledging it.
+	transfer := func(conn net.Conn, size int64) []byte {
+		defer conn.Close()
+
+		data, err := ioutil.ReadAll(io.LimitReader(conn, size))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ack := make([]byte, 4)
+		binary.BigEndian.PutUint32(ack, uint32(size))
+		conn.Write(ack)
+		return data
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	port := strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+
+	// Receiving a file, resuming a partial download.
+	path := filepath.Join(dir, "received.txt")
+	if err = ioutil.WriteFile(path, []byte("hello "), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c.RunHandlers(ParseEvent(":nick!user@host PRIVMSG test :\001DCC SEND \"my file.txt\" 2130706433 " + port + " 11\001"))
+	e := <-offers
+	if e.Params[1] != "nick" || e.Params[2] != "my file.txt" || e.Params[3] != "11" {
+		t.Fatalf("got offer %q", e.String())
+	}
+	if _, err = c.DCC.Accept(e.Params[0]); err == nil {
+		t.Fatal("DCC.Accept() accepted a DCC SEND offer")
+	}
+
+	result := make(chan *DCCTransfer, 1)
+	go func() {
+		tr, err := c.DCC.AcceptFile(e.Params[0], path)
+		if err != nil {
+			t.Errorf("DCC.AcceptFile() = %v", err)
+		}
+		result <- tr
+	}()
+
+	if got, want := sent(), "RESUME \"my file.txt\" "+port+" 6"; got != want {
+		t.Fatalf("sent %q, want %q", got, want)
+	}
+	c.RunHandlers(ParseEvent(":nick!user@host PRIVMSG test :\001DCC ACCEPT \"my file.txt\" " + port + " 6\001"))
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("world"))
+	ack := make([]byte, 4)
+	if _, err = io.ReadFull(conn, ack); err != nil || binary.BigEndian.Uint32(ack) != 11 {
+		t.Fatalf("acknowledged %v, %v, want 11", ack, err)
+	}
+	conn.Close()
+
+	tr := <-result
+	if tr.Offset != 6 || tr.Sending {
+		t.Fatalf("DCC.AcceptFile() = %#v", tr)
+	}
+	finished(tr)
+	if data, _ := ioutil.ReadFile(path); string(data) != "hello world" {
+		t.Fatalf("received %q, want %q", data, "hello world")
+	}
+
+	// Sending a file, which the other user resumes.
+	content := []byte(strings.Repeat("0123456789", 10))
+	path = filepath.Join(dir, "sent file.txt")
+	if err = ioutil.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		tr, err := c.DCC.SendFile("nick", path)
+		if err != nil {
+			t.Errorf("DCC.SendFile() = %v", err)
+		}
+		result <- tr
+	}()
+
+	offer, ok := parseDCC(sent())
+	if !ok || offer.Type != DCCSendType || offer.Argument != "sent file.txt" || offer.Size != 100 || offer.Passive() {
+		t.Fatalf("sent offer %#v", offer)
+	}
+	c.RunHandlers(ParseEvent(":nick!user@host PRIVMSG test :\001DCC RESUME \"sent file.txt\" " + strconv.Itoa(offer.Port) + " 40\001"))
+	if got, want := sent(), "ACCEPT \"sent file.txt\" "+strconv.Itoa(offer.Port)+" 40"; got != want {
+		t.Fatalf("sent %q, want %q", got, want)
+	}
+
+	if conn, err = net.Dial("tcp", net.JoinHostPort(offer.IP.String(), strconv.Itoa(offer.Port))); err != nil {
+		t.Fatal(err)
+	}
+	if data := transfer(conn, 60); string(data) != string(content[40:]) {
+		t.Fatalf("other user received %q, want %q", data, content[40:])
+	}
+	finished(<-result)
+
+	// Passive offers, where the other user accepts the connection.
+	go func() {
+		tr, err := c.DCC.SendFilePassive("nick", path)
+		if err != nil {
+			t.Errorf("DCC.SendFilePassive() = %v", err)
+		}
+		result <- tr
+	}()
+
+	if offer, ok = parseDCC(sent()); !ok || !offer.Passive() {
+		t.Fatalf("sent offer %#v, want passive offer", offer)
+	}
+	c.RunHandlers(ParseEvent(":nick!user@host PRIVMSG test :\001DCC SEND \"sent file.txt\" 2130706433 " + port + " 100 " + offer.Token + "\001"))
+	if conn, err = ln.Accept(); err != nil {
+		t.Fatal(err)
+	}
+	if data := transfer(conn, 100); string(data) != string(content) {
+		t.Fatalf("other user received %q, want %q", data, content)
+	}
+	finished(<-result)
+
+	if len(c.DCC.Transfers()) != 0 || len(c.DCC.Offers()) != 0 {
+		t.Fatalf("DCC.Transfers() = %v, DCC.Offers() = %v, want none", c.DCC.Transfers(), c.DCC.Offers())
+	}
+}
+
+func TestDCCAddress(t *testing.T) {
+	c := New(Config{
+		Server: "dummy.int",
+		Nick:   "test",
+		User:   "test",
+		DCC:    &DCCConfig{PublicIP: net.IPv4(127, 0, 0, 1), ListenAddr: "127.0.0.1:0", Timeout: time.Second},
+	})
+
+	for _, tt := range []struct {
+		ip    string
+		port  int
+		allow bool
+	}{
+		{"203.0.113.1", 5000, true},
+		{"2001:db8::1", 5000, true},
+		{"203.0.113.1", 22, false},
+		{"127.0.0.1", 5000, false},
+		{"0.0.0.0", 5000, false},
+		{"10.1.2.3", 5000, false},
+		{"172.20.0.1", 5000, false},
+		{"192.168.1.1", 5000, false},
+		{"169.254.169.254", 80, false},
+		{"169.254.169.254", 5000, false},
+		{"::1", 5000, false},
+		{"fe80::1", 5000, false},
+		{"fd00::1", 5000, false},
+	} {
+		if got := c.DCC.allowed(net.ParseIP(tt.ip), tt.port); got != tt.allow {
+			t.Errorf("DCC.allowed(%s, %d) = %t, want %t", tt.ip, tt.port, got, tt.allow)
+		}
+	}
+
+	offers := make(chan Event, 10)
+	c.Handlers.Add(DCC_CHAT_OFFER, func(c *Client, e Event) { offers <- e })
+	c.Handlers.Add(DCC_SEND_OFFER, func(c *Client, e Event) { offers <- e })
+
+	c.RunHandlers(ParseEvent(":nick!user@host PRIVMSG test :\001DCC CHAT chat 2130706433 5000\001"))
+	if _, err := c.DCC.Accept((<-offers).Params[0]); err != ErrDCCAddress {
+		t.Fatalf("DCC.Accept() of a loopback offer = %v, want ErrDCCAddress", err)
+	}
+
+	// Files aren't overwritten if the offer can't be accepted.
+	dir, err := ioutil.TempDir("", "girc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "file.txt")
+	if err = ioutil.WriteFile(path, []byte("hello world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c.RunHandlers(ParseEvent(":nick!user@host PRIVMSG test :\001DCC SEND file.txt 2130706433 5000 5\001"))
+	if _, err = c.DCC.AcceptFile((<-offers).Params[0], path); err != ErrDCCAddress {
+		t.Fatalf("DCC.AcceptFile() of a loopback offer = %v, want ErrDCCAddress", err)
+	}
+	if data, _ := ioutil.ReadFile(path); string(data) != "hello world" {
+		t.Fatalf("file contains %q after a failed DCC.AcceptFile(), want it untouched", data)
+	}
+
+	// Passive offers only accept connections from the address of the offer
+	// (127.0.0.2).
+	c.RunHandlers(ParseEvent(":nick!user@host PRIVMSG test :\001DCC CHAT chat 2130706434 0 token\001"))
+	id := (<-offers).Params[0]
+
+	result := make(chan error, 1)
+	go func() {
+		chat, err := c.DCC.Accept(id)
+		if err == nil {
+			if addr := chat.RemoteAddr().(*net.TCPAddr); !addr.IP.Equal(net.IPv4(127, 0, 0, 2)) {
+				err = fmt.Errorf("accepted connection from %s", addr)
+			}
+			chat.Close()
+		}
+		result <- err
+	}()
+
+	var addr string
+	select {
+	case o := <-c.tx:
+		offer, ok := parseDCC(strings.TrimPrefix(strings.Trim(o.event.Trailing, "\001"), "DCC "))
+		if !ok || offer.Token != "token" {
+			t.Fatalf("sent %q, want response to the passive offer", o.event.String())
+		}
+		addr = net.JoinHostPort(offer.IP.String(), strconv.Itoa(offer.Port))
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for response to the passive offer")
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("connection from an unexpected address wasn't closed")
+	}
+	conn.Close()
+
+	dialer := &net.Dialer{LocalAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 2)}}
+	if conn, err = dialer.Dial("tcp", addr); err != nil {
+		t.Skipf("unable to connect from 127.0.0.2: %v", err)
+	}
+	defer conn.Close()
+
+	if err = <-result; err != nil {
+		t.Fatalf("DCC.Accept() of a passive offer = %v", err)
+	}
+}
@@ -0,0 +1,713 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultDCCTimeout is the default for DCCConfig.Timeout.
+const defaultDCCTimeout = 2 * time.Minute
+
+// DCC session types.
+const (
+	DCCChatType = "CHAT"
+	DCCSendType = "SEND"
+)
+
+// ErrDCCDisabled is returned by the DCC methods if Config.DCC isn't
+// supplied.
+var ErrDCCDisabled = errors.New("DCC is disabled, see Config.DCC")
+
+// ErrDCCAddress is returned when refusing to connect to the address of a DCC
+// offer from another user, see DCCConfig.AllowPrivate.
+var ErrDCCAddress = errors.New("DCC address refused, see DCCConfig.AllowPrivate")
+
+// dccPrivateNets are the private networks which DCC connections aren't made
+// to, unless DCCConfig.AllowPrivate is set. Loopback and link-local addresses
+// are checked separately.
+var dccPrivateNets = func() (nets []*net.IPNet) {
+	for _, cidr := range []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7"} {
+		_, n, _ := net.ParseCIDR(cidr)
+		nets = append(nets, n)
+	}
+
+	return nets
+}()
+
+// DCCConfig configures DCC (Direct Client-to-Client) support, which allows
+// users to connect to each other directly, e.g. for private chats outside of
+// the IRC network. See Client.DCC.
+type DCCConfig struct {
+	// PublicIP is the IP address advertised to other users, which they
+	// connect to. Defaults to the local address of the connection to the
+	// server, which is likely wrong if the client is behind NAT, in which
+	// case passive DCC can be used instead (see DCC.ChatPassive()).
+	PublicIP net.IP
+	// ListenAddr is the local address listened on for DCC connections, e.g.
+	// ":5000". Defaults to all addresses, with a random port.
+	ListenAddr string
+	// Timeout is how long to wait for the other user to connect to us, or to
+	// respond to a passive offer. Offers from other users which haven't been
+	// accepted within Timeout are discarded. Defaults to 2 minutes.
+	Timeout time.Duration
+	// RateLimit limits the bandwidth of each file transfer, in bytes per
+	// second. Defaults to no limit.
+	RateLimit int64
+	// AllowPrivate allows connecting to loopback, private and link-local
+	// addresses, and to ports below 1024, when supplied by other users (e.g.
+	// for DCC within a LAN). By default, these are refused with
+	// ErrDCCAddress, so other users can't make the client connect to
+	// services on its own host or network.
+	AllowPrivate bool
+}
+
+// DCCOffer is an offer of a DCC session from another user, see
+// DCC_CHAT_OFFER, DCC_SEND_OFFER and DCC.Accept().
+type DCCOffer struct {
+	// ID identifies the offer.
+	ID string `json:"id"`
+	// Source is the user which sent the offer.
+	Source *Source `json:"source"`
+	// Type is the type of session, e.g. DCCChatType.
+	Type string `json:"type"`
+	// Argument is the type specific argument, e.g. "chat" for DCCChatType,
+	// or the name of the file for DCCSendType. Note that filenames are
+	// supplied by the other user, and shouldn't be trusted as a path.
+	Argument string `json:"argument"`
+	// Size is the size of the file in bytes for DCCSendType, 0 if unknown.
+	Size int64 `json:"size,omitempty"`
+	// IP and Port are the address to connect to. Port is 0 for passive
+	// (also known as reverse) offers, see DCCOffer.Passive().
+	IP   net.IP `json:"ip"`
+	Port int    `json:"port"`
+	// Token identifies passive offers, and is sent back when accepting them.
+	Token string `json:"token,omitempty"`
+	// Time is when the offer was received.
+	Time time.Time `json:"time"`
+
+	// position is the position of DCC RESUME and DCC ACCEPT requests.
+	position int64
+}
+
+// Passive returns true if the user which sent the offer can't accept
+// connections (e.g. as they're behind NAT), in which case they connect to
+// us instead once the offer is accepted.
+func (o *DCCOffer) Passive() bool {
+	return o.Port == 0 && o.Token != ""
+}
+
+// DCCChat is an established DCC CHAT session, which can be used as an
+// io.ReadWriteCloser. Messages are exchanged as lines, see DCCChat.Send().
+// A DCC_CHAT_CLOSED event is emitted once the session is closed, which
+// should be done once reading returns an error (e.g. io.EOF, once the other
+// user closed the session).
+type DCCChat struct {
+	// ID identifies the session, e.g. in DCC_CHAT_CLOSED events.
+	ID string
+	// Nick is the nickname of the other user.
+	Nick string
+
+	c      *Client
+	conn   net.Conn
+	closed sync.Once
+}
+
+// Read reads from the session.
+func (ch *DCCChat) Read(p []byte) (n int, err error) {
+	return ch.conn.Read(p)
+}
+
+// Write writes to the session.
+func (ch *DCCChat) Write(p []byte) (n int, err error) {
+	return ch.conn.Write(p)
+}
+
+// Send sends a single message to the other user.
+func (ch *DCCChat) Send(message string) error {
+	_, err := io.WriteString(ch.conn, strings.TrimRight(message, "\r\n")+"\n")
+	return err
+}
+
+// RemoteAddr returns the address of the other user.
+func (ch *DCCChat) RemoteAddr() net.Addr {
+	return ch.conn.RemoteAddr()
+}
+
+// Close closes the session, emitting a DCC_CHAT_CLOSED event the first time
+// it's called.
+func (ch *DCCChat) Close() error {
+	err := ch.conn.Close()
+
+	ch.closed.Do(func() {
+		ch.c.DCC.mu.Lock()
+		delete(ch.c.DCC.chats, ch.ID)
+		ch.c.DCC.mu.Unlock()
+
+		ch.c.logger.Info("DCC CHAT closed", "id", ch.ID, "nick", ch.Nick)
+		ch.c.RunHandlers(&Event{Command: DCC_CHAT_CLOSED, Params: []string{ch.ID, ch.Nick}})
+	})
+
+	return err
+}
+
+// dccWait is one of our passive offers, waiting for the other user to
+// respond with the address to connect to.
+type dccWait struct {
+	nick   string
+	result chan DCCOffer
+}
+
+// DCC manages DCC sessions with other users. Offers from other users emit
+// a DCC_CHAT_OFFER or DCC_SEND_OFFER event, after which they can be accepted
+// with DCC.Accept() or DCC.AcceptFile() respectively. Config.DCC must be
+// supplied for any of this to work. For example, to accept all chats:
+//
+//	client.Handlers.AddBg(girc.DCC_CHAT_OFFER, func(c *girc.Client, e girc.Event) {
+//		chat, err := c.DCC.Accept(e.Params[0])
+//		if err != nil {
+//			return
+//		}
+//		defer chat.Close()
+//
+//		chat.Send("hello!")
+//		// Read messages from chat, e.g. with a bufio.Scanner.
+//	})
+//
+// Methods which wait for the other user (e.g. DCC.Chat()) should only be
+// called from background handlers (see Caller.AddBg()), as their response
+// can't be processed while a regular handler is blocked.
+type DCC struct {
+	c *Client
+
+	mu        sync.Mutex
+	id        int
+	offers    map[string]*DCCOffer
+	passive   map[string]*dccWait
+	chats     map[string]*DCCChat
+	sends     map[string]*dccSend
+	resumes   map[string]*dccWait
+	transfers map[string]*DCCTransfer
+}
+
+// newDCC returns a new DCC for c.
+func newDCC(c *Client) *DCC {
+	return &DCC{
+		c:         c,
+		offers:    make(map[string]*DCCOffer),
+		passive:   make(map[string]*dccWait),
+		chats:     make(map[string]*DCCChat),
+		sends:     make(map[string]*dccSend),
+		resumes:   make(map[string]*dccWait),
+		transfers: make(map[string]*DCCTransfer),
+	}
+}
+
+// nextID returns a new unique id for offers, tokens and sessions. This is
+// NOT concurrency safe, lock DCC.mu on your own.
+func (d *DCC) nextID() string {
+	d.id++
+	return strconv.Itoa(d.id)
+}
+
+// timeout returns DCCConfig.Timeout, or the default.
+func (d *DCC) timeout() time.Duration {
+	if d.c.Config.DCC.Timeout > 0 {
+		return d.c.Config.DCC.Timeout
+	}
+
+	return defaultDCCTimeout
+}
+
+// publicIP returns the IP advertised to other users, see
+// DCCConfig.PublicIP.
+func (d *DCC) publicIP() (net.IP, error) {
+	if d.c.Config.DCC.PublicIP != nil {
+		return d.c.Config.DCC.PublicIP, nil
+	}
+
+	d.c.mu.RLock()
+	conn := d.c.conn
+	d.c.mu.RUnlock()
+
+	if conn == nil || conn.sock == nil {
+		return nil, ErrNotConnected
+	}
+
+	if addr, ok := conn.sock.LocalAddr().(*net.TCPAddr); ok {
+		return addr.IP, nil
+	}
+
+	return nil, errors.New("unable to determine public IP, see DCCConfig.PublicIP")
+}
+
+// Offer returns the pending offer with the given id, see DCC_CHAT_OFFER.
+func (d *DCC) Offer(id string) (offer DCCOffer, ok bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if o, ok := d.offers[id]; ok {
+		return *o, true
+	}
+
+	return offer, false
+}
+
+// Offers returns the pending offers from other users, oldest first.
+func (d *DCC) Offers() []DCCOffer {
+	d.mu.Lock()
+	offers := make([]DCCOffer, 0, len(d.offers))
+	for _, o := range d.offers {
+		offers = append(offers, *o)
+	}
+	d.mu.Unlock()
+
+	sort.Slice(offers, func(i, j int) bool { return offers[i].Time.Before(offers[j].Time) })
+
+	return offers
+}
+
+// Chats returns the open chat sessions.
+func (d *DCC) Chats() []*DCCChat {
+	d.mu.Lock()
+	chats := make([]*DCCChat, 0, len(d.chats))
+	for _, ch := range d.chats {
+		chats = append(chats, ch)
+	}
+	d.mu.Unlock()
+
+	sort.Slice(chats, func(i, j int) bool { return chats[i].ID < chats[j].ID })
+
+	return chats
+}
+
+// Chat offers a chat session to nick, and waits for them to connect (see
+// DCCConfig.Timeout).
+func (d *DCC) Chat(nick string) (*DCCChat, error) {
+	if d.c.Config.DCC == nil {
+		return nil, ErrDCCDisabled
+	}
+
+	if !IsValidNick(nick) {
+		return nil, &ErrInvalidTarget{Target: nick}
+	}
+
+	conn, err := d.listen(nil, func(ip net.IP, port int) error {
+		return d.c.Cmd.SendCTCP(nick, CTCP_DCC, fmt.Sprintf("%s chat %s %d", DCCChatType, encodeDCCIP(ip), port))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return d.open(nick, conn), nil
+}
+
+// ChatPassive is much like DCC.Chat(), however asks nick to accept the
+// connection instead (also known as reverse DCC), which is useful if we
+// can't accept connections (e.g. when behind NAT).
+func (d *DCC) ChatPassive(nick string) (*DCCChat, error) {
+	if d.c.Config.DCC == nil {
+		return nil, ErrDCCDisabled
+	}
+
+	if !IsValidNick(nick) {
+		return nil, &ErrInvalidTarget{Target: nick}
+	}
+
+	ip, err := d.publicIP()
+	if err != nil {
+		// The address is ignored for passive offers.
+		ip = net.IPv4zero
+	}
+
+	wait := &dccWait{nick: nick, result: make(chan DCCOffer, 1)}
+
+	d.mu.Lock()
+	token := d.nextID()
+	d.passive[token] = wait
+	d.mu.Unlock()
+
+	defer func() {
+		d.mu.Lock()
+		delete(d.passive, token)
+		d.mu.Unlock()
+	}()
+
+	if err = d.c.Cmd.SendCTCP(nick, CTCP_DCC, fmt.Sprintf("%s chat %s 0 %s", DCCChatType, encodeDCCIP(ip), token)); err != nil {
+		return nil, err
+	}
+
+	var offer DCCOffer
+	select {
+	case offer = <-wait.result:
+	case <-time.After(d.timeout()):
+		return nil, ErrNoResponse
+	}
+
+	conn, err := d.dial(offer.IP, offer.Port)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.open(nick, conn), nil
+}
+
+// Accept accepts the chat offer with the given id (see DCC_CHAT_OFFER),
+// either connecting to the user which sent it (see DCCConfig.AllowPrivate),
+// or for passive offers, waiting for them to connect to us from the address
+// of the offer. See DCC.AcceptFile() for file offers.
+func (d *DCC) Accept(id string) (*DCCChat, error) {
+	if d.c.Config.DCC == nil {
+		return nil, ErrDCCDisabled
+	}
+
+	offer, err := d.take(id, DCCChatType)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := d.connect(offer)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.open(offer.Source.Name, conn), nil
+}
+
+// take removes the offer with the given id and type from the pending
+// offers, and returns it.
+func (d *DCC) take(id, typ string) (*DCCOffer, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	offer, ok := d.offers[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown DCC offer: %s", id)
+	}
+
+	if offer.Type != typ {
+		return nil, fmt.Errorf("DCC offer %s is a %s offer, not %s", id, offer.Type, typ)
+	}
+
+	delete(d.offers, id)
+	return offer, nil
+}
+
+// connect establishes the connection of an offer from another user, either
+// by connecting to them, or for passive offers, by letting them know where
+// to connect to, and waiting for them to connect from the address of the
+// offer.
+func (d *DCC) connect(offer *DCCOffer) (net.Conn, error) {
+	if !offer.Passive() {
+		return d.dial(offer.IP, offer.Port)
+	}
+
+	return d.listen(offer.IP, func(ip net.IP, port int) error {
+		text := fmt.Sprintf("%s %s %s %d %s", offer.Type, quoteDCC(offer.Argument), encodeDCCIP(ip), port, offer.Token)
+		if offer.Type == DCCSendType {
+			text = fmt.Sprintf("%s %s %s %d %d %s", offer.Type, quoteDCC(offer.Argument), encodeDCCIP(ip), port, offer.Size, offer.Token)
+		}
+
+		return d.c.Cmd.SendCTCP(offer.Source.Name, CTCP_DCC, text)
+	})
+}
+
+// Reject rejects the offer with the given id, letting the user which sent
+// it know.
+func (d *DCC) Reject(id string) error {
+	d.mu.Lock()
+	offer, ok := d.offers[id]
+	delete(d.offers, id)
+	d.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("unknown DCC offer: %s", id)
+	}
+
+	return d.c.Cmd.SendCTCPReply(offer.Source.Name, CTCP_DCC, "REJECT "+offer.Type+" "+quoteDCC(offer.Argument))
+}
+
+// dial connects to the address of a DCC offer from another user, unless
+// it's refused, see DCCConfig.AllowPrivate.
+func (d *DCC) dial(ip net.IP, port int) (net.Conn, error) {
+	if !d.allowed(ip, port) {
+		d.c.logger.Warn("refusing DCC connection", "ip", ip, "port", port)
+		return nil, ErrDCCAddress
+	}
+
+	return net.DialTimeout("tcp", net.JoinHostPort(ip.String(), strconv.Itoa(port)), d.timeout())
+}
+
+// allowed returns true if DCC connections can be made to ip and port, see
+// DCCConfig.AllowPrivate.
+func (d *DCC) allowed(ip net.IP, port int) bool {
+	if d.c.Config.DCC.AllowPrivate {
+		return true
+	}
+
+	if port < 1024 || ip.IsUnspecified() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsMulticast() {
+		return false
+	}
+
+	for _, n := range dccPrivateNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// listen listens for a single DCC connection, calling announce with the
+// address to let the other user know where to connect to. If from is
+// supplied, connections from other addresses are closed.
+func (d *DCC) listen(from net.IP, announce func(ip net.IP, port int) error) (net.Conn, error) {
+	ip, err := d.publicIP()
+	if err != nil {
+		return nil, err
+	}
+
+	addr := d.c.Config.DCC.ListenAddr
+	if addr == "" {
+		addr = ":0"
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer ln.Close()
+
+	if err = announce(ip, ln.Addr().(*net.TCPAddr).Port); err != nil {
+		return nil, err
+	}
+
+	if err = ln.(*net.TCPListener).SetDeadline(time.Now().Add(d.timeout())); err != nil {
+		return nil, err
+	}
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return nil, ErrNoResponse
+			}
+
+			return nil, err
+		}
+
+		if addr, ok := conn.RemoteAddr().(*net.TCPAddr); from == nil || ok && addr.IP.Equal(from) {
+			return conn, nil
+		}
+
+		d.c.logger.Warn("rejecting DCC connection from unexpected address", "addr", conn.RemoteAddr(), "want", from)
+		conn.Close()
+	}
+}
+
+// open registers the chat session with nick over conn, emitting a
+// DCC_CHAT_OPENED event.
+func (d *DCC) open(nick string, conn net.Conn) *DCCChat {
+	d.mu.Lock()
+	ch := &DCCChat{ID: d.nextID(), Nick: nick, c: d.c, conn: conn}
+	d.chats[ch.ID] = ch
+	d.mu.Unlock()
+
+	d.c.logger.Info("DCC CHAT opened", "id", ch.ID, "nick", nick, "addr", conn.RemoteAddr())
+	d.c.RunHandlers(&Event{Command: DCC_CHAT_OPENED, Params: []string{ch.ID, nick}})
+
+	return ch
+}
+
+// encodeDCCIP encodes ip for use in DCC offers: IPv4 addresses as an
+// integer, and IPv6 addresses as is.
+func encodeDCCIP(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return strconv.FormatUint(uint64(binary.BigEndian.Uint32(ip4)), 10)
+	}
+
+	return ip.String()
+}
+
+// decodeDCCIP decodes an IP address of a DCC offer, see encodeDCCIP().
+func decodeDCCIP(raw string) net.IP {
+	if n, err := strconv.ParseUint(raw, 10, 32); err == nil {
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, uint32(n))
+		return ip
+	}
+
+	return net.ParseIP(raw)
+}
+
+// quoteDCC quotes the argument of a DCC CTCP (e.g. a filename) if it
+// contains spaces.
+func quoteDCC(arg string) string {
+	if strings.IndexByte(arg, ' ') > -1 {
+		return `"` + arg + `"`
+	}
+
+	return arg
+}
+
+// splitDCC splits the text of a DCC CTCP into its type, argument (which may
+// be quoted, see quoteDCC()) and the remaining fields.
+func splitDCC(text string) (typ, arg string, fields []string, ok bool) {
+	fields = strings.Fields(text)
+	if len(fields) < 2 {
+		return "", "", nil, false
+	}
+
+	typ = strings.ToUpper(fields[0])
+	text = strings.TrimLeft(strings.TrimSpace(text)[len(fields[0]):], " ")
+
+	if text[0] != '"' {
+		return typ, fields[1], fields[2:], true
+	}
+
+	end := strings.IndexByte(text[1:], '"')
+	if end < 1 {
+		return "", "", nil, false
+	}
+
+	return typ, text[1 : end+1], strings.Fields(text[end+2:]), true
+}
+
+// parseDCC parses the text of a DCC CTCP, e.g. "CHAT chat 3232235777 5000",
+// "SEND file.txt 3232235777 5000 1024", or "RESUME file.txt 5000 512". The
+// positions of RESUME and ACCEPT requests are stored in DCCOffer.position.
+func parseDCC(text string) (offer DCCOffer, ok bool) {
+	var fields []string
+	if offer.Type, offer.Argument, fields, ok = splitDCC(text); !ok {
+		return offer, false
+	}
+
+	// RESUME and ACCEPT don't include the IP.
+	if offer.Type == "RESUME" || offer.Type == "ACCEPT" {
+		fields = append([]string{"0"}, fields...)
+	}
+
+	if len(fields) < 2 {
+		return offer, false
+	}
+
+	if offer.IP = decodeDCCIP(fields[0]); offer.IP == nil {
+		return offer, false
+	}
+
+	port, err := strconv.Atoi(fields[1])
+	if err != nil || port < 0 || port > 65535 {
+		return offer, false
+	}
+	offer.Port = port
+	fields = fields[2:]
+
+	switch offer.Type {
+	case DCCSendType, "RESUME", "ACCEPT":
+		var n int64
+		if len(fields) > 0 {
+			if n, err = strconv.ParseInt(fields[0], 10, 64); err != nil || n < 0 {
+				return offer, false
+			}
+			fields = fields[1:]
+		}
+
+		if offer.Type == DCCSendType {
+			offer.Size = n
+		} else {
+			offer.position = n
+		}
+	}
+
+	if len(fields) > 0 {
+		offer.Token = fields[0]
+	}
+
+	return offer, true
+}
+
+// dccKey identifies offers by their port, or token for passive offers.
+func dccKey(port int, token string) string {
+	if token != "" {
+		return "token:" + token
+	}
+
+	return "port:" + strconv.Itoa(port)
+}
+
+// handleCTCPDCC handles DCC offers from other users, and responses to our
+// own offers. See Client.DCC.
+func handleCTCPDCC(client *Client, ctcp CTCPEvent) {
+	if ctcp.Reply || ctcp.Source == nil || client.Config.DCC == nil {
+		return
+	}
+
+	offer, ok := parseDCC(ctcp.Text)
+	if !ok {
+		client.logger.Warn("invalid DCC request", "nick", ctcp.Source.Name, "text", ctcp.Text)
+		return
+	}
+
+	offer.Source = ctcp.Source.Copy()
+	offer.Time = time.Now()
+	d := client.DCC
+
+	switch offer.Type {
+	case DCCChatType, DCCSendType:
+	case "RESUME":
+		d.handleResume(offer)
+		return
+	case "ACCEPT":
+		d.handleAccept(offer)
+		return
+	default:
+		client.Cmd.SendCTCPReply(ctcp.Source.Name, CTCP_DCC, "REJECT "+offer.Type+" "+quoteDCC(offer.Argument))
+		return
+	}
+
+	// A response to one of our passive offers, with the address to connect
+	// to.
+	if offer.Token != "" && offer.Port != 0 {
+		d.mu.Lock()
+		wait, ok := d.passive[offer.Token]
+		if ok && client.Equal(wait.nick, offer.Source.Name) {
+			delete(d.passive, offer.Token)
+			wait.result <- offer
+			d.mu.Unlock()
+			return
+		}
+		d.mu.Unlock()
+	}
+
+	d.mu.Lock()
+	offer.ID = d.nextID()
+	d.offers[offer.ID] = &offer
+	d.mu.Unlock()
+
+	// Discard the offer if it isn't accepted in time.
+	time.AfterFunc(d.timeout(), func() {
+		d.mu.Lock()
+		delete(d.offers, offer.ID)
+		d.mu.Unlock()
+	})
+
+	client.logger.Info("DCC offer", "type", offer.Type, "id", offer.ID, "nick", offer.Source.Name, "ip", offer.IP, "port", offer.Port)
+
+	if offer.Type == DCCSendType {
+		client.RunHandlers(&Event{Command: DCC_SEND_OFFER, Params: []string{offer.ID, offer.Source.Name, offer.Argument, strconv.FormatInt(offer.Size, 10)}})
+		return
+	}
+
+	client.RunHandlers(&Event{Command: DCC_CHAT_OFFER, Params: []string{offer.ID, offer.Source.Name}})
+}
@@ -0,0 +1,551 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// dccChunkSize is the size of the chunks files are transferred in.
+	dccChunkSize = 32 * 1024
+	// dccProgressInterval is how often DCC_TRANSFER_PROGRESS is emitted.
+	dccProgressInterval = time.Second
+)
+
+// ErrDCCCancelled is the result of file transfers which were cancelled with
+// DCCTransfer.Cancel().
+var ErrDCCCancelled = errors.New("DCC transfer cancelled")
+
+// dccSend is one of our file offers, waiting for the other user to connect,
+// or to respond. offset is set if they requested to resume the transfer.
+type dccSend struct {
+	nick   string
+	size   int64
+	offset int64
+}
+
+// DCCTransfer is a DCC SEND file transfer, either to or from another user,
+// see DCC.SendFile() and DCC.AcceptFile(). The transfer runs in the
+// background, emitting DCC_TRANSFER_PROGRESS events while in progress, and
+// a DCC_TRANSFER_DONE event once it has finished.
+type DCCTransfer struct {
+	// ID identifies the transfer, e.g. in DCC_TRANSFER_DONE events.
+	ID string
+	// Nick is the nickname of the other user.
+	Nick string
+	// Filename is the name of the file, as offered.
+	Filename string
+	// Size is the size of the file in bytes, 0 if unknown.
+	Size int64
+	// Offset is the position the transfer was resumed from, 0 if it wasn't.
+	Offset int64
+	// Sending is true if we're sending the file, false if receiving it.
+	Sending bool
+
+	c            *Client
+	conn         net.Conn
+	transferred  int64 // atomic, excludes Offset.
+	cancelled    int32 // atomic.
+	started      time.Time
+	lastProgress time.Time
+	done         chan struct{}
+	err          error
+}
+
+// Transferred returns the amount of bytes transferred, including Offset.
+func (t *DCCTransfer) Transferred() int64 {
+	return t.Offset + atomic.LoadInt64(&t.transferred)
+}
+
+// Wait waits for the transfer to finish, returning the error it failed
+// with, if any.
+func (t *DCCTransfer) Wait() error {
+	<-t.done
+	return t.err
+}
+
+// Cancel cancels the transfer, in which case it fails with
+// ErrDCCCancelled.
+func (t *DCCTransfer) Cancel() {
+	atomic.StoreInt32(&t.cancelled, 1)
+	t.conn.Close()
+}
+
+// progress emits DCC_TRANSFER_PROGRESS, at most once per
+// dccProgressInterval unless forced.
+func (t *DCCTransfer) progress(force bool) {
+	if !force && time.Since(t.lastProgress) < dccProgressInterval {
+		return
+	}
+	t.lastProgress = time.Now()
+
+	t.c.RunHandlers(&Event{Command: DCC_TRANSFER_PROGRESS, Params: []string{
+		t.ID, strconv.FormatInt(t.Transferred(), 10), strconv.FormatInt(t.Size, 10),
+	}})
+}
+
+// limit sleeps as long as needed to stay below DCCConfig.RateLimit.
+func (t *DCCTransfer) limit() {
+	rate := t.c.Config.DCC.RateLimit
+	if rate <= 0 {
+		return
+	}
+
+	expected := time.Duration(float64(atomic.LoadInt64(&t.transferred)) / float64(rate) * float64(time.Second))
+	if wait := expected - time.Since(t.started); wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// chunkSize returns the size of the chunks to transfer, which is smaller
+// than dccChunkSize with low rate limits, to keep the rate smooth.
+func (t *DCCTransfer) chunkSize() int {
+	if rate := t.c.Config.DCC.RateLimit; rate > 0 && rate < dccChunkSize {
+		return int(rate)
+	}
+
+	return dccChunkSize
+}
+
+// receive reads the file from the other user into w, acknowledging the
+// amount of bytes received (as a 32-bit integer, as the protocol requires).
+func (t *DCCTransfer) receive(w io.Writer) error {
+	buf := make([]byte, t.chunkSize())
+	ack := make([]byte, 4)
+
+	for t.Size == 0 || t.Transferred() < t.Size {
+		t.conn.SetReadDeadline(time.Now().Add(t.c.DCC.timeout()))
+
+		n, err := t.conn.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			atomic.AddInt64(&t.transferred, int64(n))
+
+			// Acknowledgements are best effort, as many clients ignore them.
+			binary.BigEndian.PutUint32(ack, uint32(t.Transferred()))
+			t.conn.Write(ack)
+
+			t.progress(false)
+			t.limit()
+		}
+
+		if err == io.EOF {
+			if t.Size > 0 {
+				return io.ErrUnexpectedEOF
+			}
+
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// send writes the file from r to the other user.
+func (t *DCCTransfer) send(r io.Reader) error {
+	// Read the acknowledgements, so we know when the other user received
+	// everything.
+	acked := make(chan struct{})
+	go func() {
+		defer close(acked)
+
+		ack := make([]byte, 4)
+		for {
+			if _, err := io.ReadFull(t.conn, ack); err != nil {
+				return
+			}
+
+			if binary.BigEndian.Uint32(ack) == uint32(t.Size) {
+				return
+			}
+		}
+	}()
+
+	buf := make([]byte, t.chunkSize())
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			t.conn.SetWriteDeadline(time.Now().Add(t.c.DCC.timeout()))
+
+			if _, werr := t.conn.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			atomic.AddInt64(&t.transferred, int64(n))
+
+			t.progress(false)
+			t.limit()
+		}
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if t.Transferred() != t.Size {
+		return io.ErrUnexpectedEOF
+	}
+
+	if t.Size == t.Offset {
+		return nil
+	}
+
+	// Closing the connection with unread acknowledgements may reset it,
+	// discarding the data the other user hasn't received yet.
+	select {
+	case <-acked:
+	case <-time.After(t.c.DCC.timeout()):
+	}
+
+	return nil
+}
+
+// Transfers returns the file transfers in progress.
+func (d *DCC) Transfers() []*DCCTransfer {
+	d.mu.Lock()
+	transfers := make([]*DCCTransfer, 0, len(d.transfers))
+	for _, t := range d.transfers {
+		transfers = append(transfers, t)
+	}
+	d.mu.Unlock()
+
+	sort.Slice(transfers, func(i, j int) bool { return transfers[i].ID < transfers[j].ID })
+
+	return transfers
+}
+
+// start runs the transfer t over conn in the background, closing closer (if
+// not nil) once it has finished.
+func (d *DCC) start(t *DCCTransfer, conn net.Conn, run func() error, closer io.Closer) *DCCTransfer {
+	d.mu.Lock()
+	t.ID = d.nextID()
+	t.c, t.conn = d.c, conn
+	t.started = time.Now()
+	t.done = make(chan struct{})
+	d.transfers[t.ID] = t
+	d.mu.Unlock()
+
+	d.c.logger.Info("DCC SEND started", "id", t.ID, "filename", t.Filename, "nick", t.Nick, "offset", t.Offset, "addr", conn.RemoteAddr())
+
+	go func() {
+		err := run()
+		conn.Close()
+		if closer != nil {
+			if cerr := closer.Close(); err == nil {
+				err = cerr
+			}
+		}
+
+		if atomic.LoadInt32(&t.cancelled) == 1 {
+			err = ErrDCCCancelled
+		}
+
+		d.mu.Lock()
+		delete(d.transfers, t.ID)
+		d.mu.Unlock()
+
+		t.progress(true)
+
+		event := &Event{Command: DCC_TRANSFER_DONE, Params: []string{t.ID, t.Nick, t.Filename}}
+		if err != nil {
+			event.Trailing = err.Error()
+			d.c.logger.Warn("DCC SEND failed", "id", t.ID, "filename", t.Filename, "nick", t.Nick, "error", err)
+		} else {
+			d.c.logger.Info("DCC SEND complete", "id", t.ID, "filename", t.Filename, "nick", t.Nick)
+		}
+
+		t.err = err
+		close(t.done)
+		d.c.RunHandlers(event)
+	}()
+
+	return t
+}
+
+// SendFile offers the file at path to nick, and waits for them to connect
+// (see DCCConfig.Timeout), after which the file is sent in the background.
+// Requests by nick to resume the transfer (DCC RESUME) are accepted.
+func (d *DCC) SendFile(nick, path string) (*DCCTransfer, error) {
+	return d.sendFile(nick, path, false)
+}
+
+// SendFilePassive is much like DCC.SendFile(), however asks nick to accept
+// the connection instead (also known as reverse DCC), which is useful if we
+// can't accept connections (e.g. when behind NAT).
+func (d *DCC) SendFilePassive(nick, path string) (*DCCTransfer, error) {
+	return d.sendFile(nick, path, true)
+}
+
+// sendFile offers the file at path to nick, see DCC.SendFile().
+func (d *DCC) sendFile(nick, path string, passive bool) (*DCCTransfer, error) {
+	if d.c.Config.DCC == nil {
+		return nil, ErrDCCDisabled
+	}
+
+	if !IsValidNick(nick) {
+		return nil, &ErrInvalidTarget{Target: nick}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	name := quoteDCC(filepath.Base(path))
+	pending := &dccSend{nick: nick, size: info.Size()}
+
+	var conn net.Conn
+	if passive {
+		conn, err = d.sendPassive(pending, name)
+	} else {
+		var key string
+		conn, err = d.listen(nil, func(ip net.IP, port int) error {
+			key = dccKey(port, "")
+
+			d.mu.Lock()
+			d.sends[key] = pending
+			d.mu.Unlock()
+
+			return d.c.Cmd.SendCTCP(nick, CTCP_DCC, fmt.Sprintf("%s %s %s %d %d", DCCSendType, name, encodeDCCIP(ip), port, pending.size))
+		})
+
+		d.mu.Lock()
+		delete(d.sends, key)
+		d.mu.Unlock()
+	}
+
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	d.mu.Lock()
+	offset := pending.offset
+	d.mu.Unlock()
+
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		conn.Close()
+		f.Close()
+		return nil, err
+	}
+
+	t := &DCCTransfer{Nick: nick, Filename: filepath.Base(path), Size: pending.size, Offset: offset, Sending: true}
+	return d.start(t, conn, func() error { return t.send(f) }, f), nil
+}
+
+// sendPassive sends a passive file offer, and connects to the address the
+// other user responds with.
+func (d *DCC) sendPassive(pending *dccSend, name string) (net.Conn, error) {
+	ip, err := d.publicIP()
+	if err != nil {
+		// The address is ignored for passive offers.
+		ip = net.IPv4zero
+	}
+
+	wait := &dccWait{nick: pending.nick, result: make(chan DCCOffer, 1)}
+
+	d.mu.Lock()
+	token := d.nextID()
+	d.passive[token] = wait
+	d.sends[dccKey(0, token)] = pending
+	d.mu.Unlock()
+
+	defer func() {
+		d.mu.Lock()
+		delete(d.passive, token)
+		delete(d.sends, dccKey(0, token))
+		d.mu.Unlock()
+	}()
+
+	if err = d.c.Cmd.SendCTCP(pending.nick, CTCP_DCC, fmt.Sprintf("%s %s %s 0 %d %s", DCCSendType, name, encodeDCCIP(ip), pending.size, token)); err != nil {
+		return nil, err
+	}
+
+	var offer DCCOffer
+	select {
+	case offer = <-wait.result:
+	case <-time.After(d.timeout()):
+		return nil, ErrNoResponse
+	}
+
+	return d.dial(offer.IP, offer.Port)
+}
+
+// AcceptFile accepts the file offer with the given id (see DCC_SEND_OFFER),
+// writing the file to path. If path exists and is smaller than the offered
+// file, the transfer is resumed from where it left off (see DCC RESUME),
+// otherwise it's overwritten. path is only opened (or truncated) once the
+// offer has been accepted, and the connection established. The file is
+// received in the background, see DCCTransfer.
+func (d *DCC) AcceptFile(id, path string) (*DCCTransfer, error) {
+	if d.c.Config.DCC == nil {
+		return nil, ErrDCCDisabled
+	}
+
+	offer, ok := d.Offer(id)
+	if !ok {
+		return nil, fmt.Errorf("unknown DCC offer: %s", id)
+	}
+
+	if offer.Type != DCCSendType {
+		return nil, fmt.Errorf("DCC offer %s is a %s offer, not %s", id, offer.Type, DCCSendType)
+	}
+
+	var offset int64
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() && info.Size() < offer.Size {
+		offset, flags = info.Size(), os.O_WRONLY|os.O_APPEND
+	}
+
+	return d.acceptFile(id, offset, func() (io.Writer, io.Closer, error) {
+		f, err := os.OpenFile(path, flags, 0644)
+		return f, f, err
+	})
+}
+
+// AcceptFileTo is much like DCC.AcceptFile(), however writes the file to w.
+// If offset is above 0, the transfer is resumed from offset (see DCC
+// RESUME), in which case w should already contain the file up to offset.
+func (d *DCC) AcceptFileTo(id string, w io.Writer, offset int64) (*DCCTransfer, error) {
+	if d.c.Config.DCC == nil {
+		return nil, ErrDCCDisabled
+	}
+
+	return d.acceptFile(id, offset, func() (io.Writer, io.Closer, error) {
+		return w, nil, nil
+	})
+}
+
+// acceptFile accepts the file offer with the given id, see
+// DCC.AcceptFileTo(). open returns where to write the file to (and what to
+// close once done, if anything), once the connection has been established.
+func (d *DCC) acceptFile(id string, offset int64, open func() (io.Writer, io.Closer, error)) (*DCCTransfer, error) {
+	offer, err := d.take(id, DCCSendType)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset > 0 {
+		if offer.Size > 0 && offset >= offer.Size {
+			return nil, fmt.Errorf("unable to resume %s at %d, the file is only %d bytes", offer.Argument, offset, offer.Size)
+		}
+
+		if err = d.resume(offer, offset); err != nil {
+			return nil, err
+		}
+	}
+
+	conn, err := d.connect(offer)
+	if err != nil {
+		return nil, err
+	}
+
+	w, closer, err := open()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	t := &DCCTransfer{Nick: offer.Source.Name, Filename: offer.Argument, Size: offer.Size, Offset: offset}
+	return d.start(t, conn, func() error { return t.receive(w) }, closer), nil
+}
+
+// resume requests the other user to resume the offered file at offset (DCC
+// RESUME), and waits for them to accept (DCC ACCEPT).
+func (d *DCC) resume(offer *DCCOffer, offset int64) error {
+	wait := &dccWait{nick: offer.Source.Name, result: make(chan DCCOffer, 1)}
+	key := dccKey(offer.Port, offer.Token)
+
+	d.mu.Lock()
+	d.resumes[key] = wait
+	d.mu.Unlock()
+
+	defer func() {
+		d.mu.Lock()
+		delete(d.resumes, key)
+		d.mu.Unlock()
+	}()
+
+	text := fmt.Sprintf("RESUME %s %d %d", quoteDCC(offer.Argument), offer.Port, offset)
+	if offer.Token != "" {
+		text += " " + offer.Token
+	}
+
+	if err := d.c.Cmd.SendCTCP(offer.Source.Name, CTCP_DCC, text); err != nil {
+		return err
+	}
+
+	select {
+	case accept := <-wait.result:
+		if accept.position != offset {
+			return fmt.Errorf("DCC RESUME of %s at %d accepted at %d instead", offer.Argument, offset, accept.position)
+		}
+
+		return nil
+	case <-time.After(d.timeout()):
+		return ErrNoResponse
+	}
+}
+
+// handleResume handles requests to resume one of our file offers (DCC
+// RESUME), accepting them (DCC ACCEPT).
+func (d *DCC) handleResume(offer DCCOffer) {
+	d.mu.Lock()
+	pending, ok := d.sends[dccKey(offer.Port, offer.Token)]
+	if !ok || !d.c.Equal(pending.nick, offer.Source.Name) || offer.position > pending.size {
+		d.mu.Unlock()
+		d.c.logger.Warn("invalid DCC RESUME", "nick", offer.Source.Name, "filename", offer.Argument, "position", offer.position)
+		return
+	}
+	pending.offset = offer.position
+	d.mu.Unlock()
+
+	text := fmt.Sprintf("ACCEPT %s %d %d", quoteDCC(offer.Argument), offer.Port, offer.position)
+	if offer.Token != "" {
+		text += " " + offer.Token
+	}
+
+	d.c.Cmd.SendCTCP(offer.Source.Name, CTCP_DCC, text)
+}
+
+// handleAccept handles responses to our requests to resume a file offer
+// (DCC ACCEPT), see DCC.resume().
+func (d *DCC) handleAccept(offer DCCOffer) {
+	key := dccKey(offer.Port, offer.Token)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if wait, ok := d.resumes[key]; ok && d.c.Equal(wait.nick, offer.Source.Name) {
+		delete(d.resumes, key)
+		wait.result <- offer
+	}
+}
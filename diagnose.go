@@ -0,0 +1,186 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// Diagnostics is a report of the behavior of the server the client is
+// connected to, as returned by Client.Diagnose().
+type Diagnostics struct {
+	// Server is the server name, as reported by RPL_MYINFO.
+	Server string `json:"server"`
+	// Version is the server software version, as reported by RPL_MYINFO.
+	Version string `json:"version"`
+	// Network is the network name, as reported by ISUPPORT.
+	Network string `json:"network"`
+	// Caps are the IRCv3 capabilities advertised by the server, and their
+	// values (if any).
+	Caps map[string][]string `json:"caps"`
+	// EnabledCaps are the IRCv3 capabilities which were negotiated.
+	EnabledCaps []string `json:"enabled_caps"`
+	// ISupport are the RPL_ISUPPORT values advertised by the server.
+	ISupport map[string]string `json:"isupport"`
+
+	// Lag is the round-trip time of a PING to the server.
+	Lag time.Duration `json:"lag"`
+
+	// FloodSent is the amount of paced test NOTICEs sent to ourselves.
+	FloodSent int `json:"flood_sent"`
+	// FloodReceived is the amount of test NOTICEs which were delivered back
+	// to us. If lower than FloodSent, the server likely dropped (or
+	// penalized) messages sent at the configured pace.
+	FloodReceived int `json:"flood_received"`
+	// FloodInterval is the delay between each test NOTICE.
+	FloodInterval time.Duration `json:"flood_interval"`
+
+	// MaxLineLength is the longest raw line (excluding CRLF) which we sent
+	// to the server, and was delivered back to us without truncation.
+	MaxLineLength int `json:"max_line_length"`
+}
+
+// diagnoseFloodCount is the amount of NOTICEs sent during the flood probe,
+// and diagnoseFloodInterval is the delay between them.
+const (
+	diagnoseFloodCount    = 5
+	diagnoseFloodInterval = 250 * time.Millisecond
+)
+
+// diagnoseLineLengths are the raw line lengths probed to see what the server
+// accepts.
+var diagnoseLineLengths = []int{256, 384, 448, 480, 496, 510}
+
+// Diagnose probes the behavior of the server the client is connected to, and
+// returns a report. This is useful when onboarding bots onto unfamiliar
+// networks. It should only be used once the client is connected (see the
+// CONNECTED event), and sends a handful of NOTICEs to the client itself.
+// Cancel ctx to stop waiting for responses, in which case the partial report
+// is returned along with the context error. ErrTrackingDisabled is returned
+// if tracking is disabled.
+func (c *Client) Diagnose(ctx context.Context) (*Diagnostics, error) {
+	if !c.IsTracking() {
+		return nil, ErrTrackingDisabled
+	}
+
+	if !c.IsConnected() {
+		return nil, ErrNotConnected
+	}
+
+	d := &Diagnostics{
+		Caps:          make(map[string][]string),
+		ISupport:      make(map[string]string),
+		FloodInterval: diagnoseFloodInterval,
+	}
+
+	c.state.RLock()
+	for k, v := range c.state.serverCaps {
+		d.Caps[k] = append([]string(nil), v...)
+	}
+	for k, v := range c.state.serverOptions {
+		d.ISupport[k] = v
+	}
+	d.EnabledCaps = append([]string(nil), c.state.enabledCap...)
+	c.state.RUnlock()
+
+	d.Server = d.ISupport["SERVER"]
+	d.Version = d.ISupport["VERSION"]
+	d.Network = d.ISupport["NETWORK"]
+
+	token := fmt.Sprintf("girc-diag-%d", rand.Int63())
+
+	received := make(chan Event, diagnoseFloodCount+len(diagnoseLineLengths)+1)
+	cuid := c.Handlers.Add(ALL_EVENTS, func(c *Client, e Event) {
+		if (e.Command == NOTICE && strings.HasPrefix(e.Trailing, token)) ||
+			(e.Command == PONG && (e.Trailing == token || (len(e.Params) > 0 && e.Params[len(e.Params)-1] == token))) {
+			select {
+			case received <- e:
+			default:
+			}
+		}
+	})
+	defer c.Handlers.Remove(cuid)
+
+	// Lag.
+	start := time.Now()
+	c.Cmd.Ping(token)
+	events, err := diagnoseWait(ctx, received, 1)
+	if err != nil {
+		return d, err
+	}
+
+	if len(events) == 0 {
+		return d, ErrNoResponse
+	}
+	d.Lag = time.Since(start)
+
+	nick := c.GetNick()
+
+	// Flood tolerance. These bypass the client rate limiting, as that is
+	// what we're trying to test.
+	for i := 0; i < diagnoseFloodCount; i++ {
+		c.write(&Event{Command: NOTICE, Params: []string{nick}, Trailing: fmt.Sprintf("%s flood %d", token, i)})
+		d.FloodSent++
+
+		select {
+		case <-time.After(diagnoseFloodInterval):
+		case <-ctx.Done():
+			return d, ctx.Err()
+		}
+	}
+
+	events, err = diagnoseWait(ctx, received, diagnoseFloodCount)
+	d.FloodReceived = len(events)
+	if err != nil {
+		return d, err
+	}
+
+	// Line length.
+	for _, length := range diagnoseLineLengths {
+		e := &Event{Command: NOTICE, Params: []string{nick}, Trailing: token + " "}
+		if pad := length - e.Len(); pad > 0 {
+			e.Trailing += strings.Repeat("x", pad)
+		}
+
+		c.write(e)
+
+		events, err = diagnoseWait(ctx, received, 1)
+		if err != nil {
+			return d, err
+		}
+
+		if len(events) == 0 || events[0].Trailing != e.Trailing {
+			// Dropped or truncated by the server, so no need to try longer
+			// lines.
+			break
+		}
+
+		d.MaxLineLength = e.Len()
+	}
+
+	return d, nil
+}
+
+// diagnoseWait waits for count events to be received, or until no events
+// have been received for a few seconds. An error is only returned if ctx is
+// done.
+func diagnoseWait(ctx context.Context, received chan Event, count int) (events []Event, err error) {
+	for len(events) < count {
+		select {
+		case e := <-received:
+			events = append(events, e)
+		case <-time.After(5 * time.Second):
+			return events, nil
+		case <-ctx.Done():
+			return events, ctx.Err()
+		}
+	}
+
+	return events, nil
+}
@@ -0,0 +1,122 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"context"
+	"net"
+)
+
+// dialFunc dials a single address, see net.Dialer.DialContext().
+type dialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// resolveAddrs resolves the host of addr to all of its addresses, returning
+// them as "host:port", alternating between IPv6 and IPv4 addresses (in the
+// order they were resolved) so a blackholed address family doesn't hold up
+// the other. If host is already an IP address, addr is returned as is.
+func resolveAddrs(ctx context.Context, addr string) ([]string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if net.ParseIP(host) != nil {
+		return []string{addr}, nil
+	}
+
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var v6, v4 []string
+	for _, ip := range ips {
+		if ip.IP.To4() != nil {
+			v4 = append(v4, net.JoinHostPort(ip.String(), port))
+		} else {
+			v6 = append(v6, net.JoinHostPort(ip.String(), port))
+		}
+	}
+
+	addrs := make([]string, 0, len(ips))
+	for i := 0; i < len(v6) || i < len(v4); i++ {
+		if i < len(v6) {
+			addrs = append(addrs, v6[i])
+		}
+		if i < len(v4) {
+			addrs = append(addrs, v4[i])
+		}
+	}
+
+	return addrs, nil
+}
+
+// dialResult is the outcome of dialing a single address.
+type dialResult struct {
+	conn net.Conn
+	err  error
+}
+
+// dialParallel dials addrs, at most limit at once, returning the first
+// successful connection. Once a connection succeeds, the remaining dials are
+// cancelled, and connections which still succeed are closed. If all dials
+// fail, the first error is returned.
+func dialParallel(dial dialFunc, addrs []string, limit int) (net.Conn, error) {
+	if limit < 1 {
+		limit = 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	results := make(chan dialResult, len(addrs))
+	launch := func(addr string) {
+		go func() {
+			conn, err := dial(ctx, "tcp", addr)
+			results <- dialResult{conn: conn, err: err}
+		}()
+	}
+
+	var next, pending int
+	for ; next < len(addrs) && pending < limit; next++ {
+		launch(addrs[next])
+		pending++
+	}
+
+	var firstErr error
+	for pending > 0 {
+		result := <-results
+		pending--
+
+		if result.err == nil {
+			// Close connections from dials which succeeded regardless.
+			go func(pending int) {
+				for ; pending > 0; pending-- {
+					if late := <-results; late.conn != nil {
+						late.conn.Close()
+					}
+				}
+			}(pending)
+
+			return result.conn, nil
+		}
+
+		if firstErr == nil {
+			firstErr = result.err
+		}
+
+		if next < len(addrs) {
+			launch(addrs[next])
+			next++
+			pending++
+		}
+	}
+
+	if firstErr == nil {
+		firstErr = &net.AddrError{Err: "no addresses to dial"}
+	}
+
+	return nil, firstErr
+}
@@ -0,0 +1,97 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrNilDone is returned by Client.Do() if the done filter is nil.
+var ErrNilDone = errors.New("girc: nil done filter")
+
+// Do sends event to the server, and blocks until an event matching done is
+// received, or ctx is cancelled. All events received in the meantime which
+// match collect (which may be nil) are returned, followed by the event which
+// matched done. Events are checked in the order they were received, and no
+// handlers are left behind once Do returns. If ctx is cancelled, the events
+// collected so far are returned along with the context error. done must not
+// be nil, see ErrNilDone.
+//
+// This can be used to build any command/response workflow. For example, to
+// collect the server links:
+//
+//	events, err := c.Do(ctx, &girc.Event{Command: girc.LINKS},
+//		func(e *girc.Event) bool { return e.Command == girc.RPL_ENDOFLINKS },
+//		func(e *girc.Event) bool { return e.Command == girc.RPL_LINKS },
+//	)
+//
+// This should not be called from non-background handlers, as the response
+// can't be processed until they return.
+func (c *Client) Do(ctx context.Context, event *Event, done, collect func(e *Event) bool) ([]*Event, error) {
+	return c.do(ctx, func() error {
+		c.Send(event)
+		return nil
+	}, done, collect)
+}
+
+// do is much like Do, however the request is sent by send, once the events
+// are being checked. If send returns an error, it's returned right away.
+func (c *Client) do(ctx context.Context, send func() error, done, collect func(e *Event) bool) ([]*Event, error) {
+	if done == nil {
+		return nil, ErrNilDone
+	}
+
+	if !c.IsConnected() {
+		return nil, ErrNotConnected
+	}
+
+	// mu guards events, and stopped, as the handler may still be running
+	// when ctx is cancelled.
+	var mu sync.Mutex
+	var events []*Event
+	var stopped bool
+	finished := make(chan struct{})
+
+	// This is intentionally not a tmp handler, as the events must be
+	// checked in the order they were received.
+	cuid := c.Handlers.Add(ALL_EVENTS, func(c *Client, e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if stopped {
+			return
+		}
+
+		if done(&e) {
+			events = append(events, e.Copy())
+			stopped = true
+			close(finished)
+			return
+		}
+
+		if collect != nil && collect(&e) {
+			events = append(events, e.Copy())
+		}
+	})
+	defer c.Handlers.Remove(cuid)
+
+	if err := send(); err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-finished:
+		return events, nil
+	case <-ctx.Done():
+		mu.Lock()
+		stopped = true
+		collected := events
+		mu.Unlock()
+
+		return collected, ctx.Err()
+	}
+}
@@ -6,8 +6,12 @@ package girc
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
+	"sync"
+	"time"
 )
 
 const (
@@ -40,6 +44,21 @@ type Event struct {
 	Trailing      string   `json:"trailing"`       // any trailing data. e.g. with a PRIVMSG, this is the message text.
 	EmptyTrailing bool     `json:"empty_trailing"` // if true, trailing prefix (:) will be added even if Event.Trailing is empty.
 	Sensitive     bool     `json:"sensitive"`      // if the message is sensitive (e.g. and should not be logged).
+	Replayed      bool     `json:"replayed"`       // if the event is replayed history (e.g. chathistory or bouncer playback), not live traffic.
+
+	// annotations are shared between all copies of the event made during a
+	// single dispatch. See Event.Set() and Event.Get().
+	annotations *annotations
+	// service is true if the event originated from network services. See
+	// Event.IsService().
+	service bool
+	// trace records the handlers executed for the event during a single
+	// dispatch, see Config.TraceHandlers.
+	trace *eventTrace
+	// read is when the event was read from the server, and span is its span
+	// during a single dispatch, see Config.Tracer.
+	read time.Time
+	span EventSpan
 }
 
 // ParseEvent takes a string and attempts to create a Event struct.
@@ -64,6 +83,11 @@ func ParseEvent(raw string) (e *Event) {
 
 		e.Tags = ParseTags(raw[1:i])
 		raw = raw[i+1:]
+		i = 0
+
+		if raw == "" {
+			return nil
+		}
 	}
 
 	if raw[0] == messagePrefix {
@@ -155,6 +179,12 @@ func (e *Event) Copy() *Event {
 		Trailing:      e.Trailing,
 		EmptyTrailing: e.EmptyTrailing,
 		Sensitive:     e.Sensitive,
+		Replayed:      e.Replayed,
+		annotations:   e.annotations,
+		service:       e.service,
+		trace:         e.trace,
+		read:          e.read,
+		span:          e.span,
 	}
 
 	// Copy Source field, as it's a pointer and needs to be dereferenced.
@@ -179,6 +209,152 @@ func (e *Event) Copy() *Event {
 	return newEvent
 }
 
+// eventJSON is the JSON representation of an Event, see Event.MarshalJSON().
+type eventJSON struct {
+	Source        *Source  `json:"source"`
+	Tags          Tags     `json:"tags"`
+	Command       string   `json:"command"`
+	Params        []string `json:"params"`
+	Trailing      string   `json:"trailing"`
+	EmptyTrailing bool     `json:"empty_trailing"`
+	Sensitive     bool     `json:"sensitive"`
+	Replayed      bool     `json:"replayed"`
+	// Time is the server-time of the event (see Event.Timestamp()), and
+	// Received is when it was read from the server. These are omitted if
+	// unknown.
+	Time     *time.Time `json:"time,omitempty"`
+	Received *time.Time `json:"received,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler. Events are encoded as an object
+// with the fields of the event (using the names of their json tags), along
+// with "time", the server-time of the event (see Event.Timestamp()), and
+// "received", when the event was read from the server, which are omitted
+// if unknown:
+//
+//	{
+//		"source": {"name": "nick", "ident": "user", "host": "host"},
+//		"tags": {"time": "2020-01-01T00:00:00.000Z"},
+//		"command": "PRIVMSG",
+//		"params": ["#channel"],
+//		"trailing": "hello",
+//		"empty_trailing": false,
+//		"sensitive": false,
+//		"replayed": false,
+//		"time": "2020-01-01T00:00:00Z",
+//		"received": "2020-01-01T00:00:01.5Z"
+//	}
+//
+// This makes it possible to ship events over message queues, log them, or
+// replay them later with Client.RunHandlers(), see Event.UnmarshalJSON().
+// Note that sensitive events (see Event.Sensitive) are encoded as is.
+func (e Event) MarshalJSON() ([]byte, error) {
+	out := eventJSON{
+		Source:        e.Source,
+		Tags:          e.Tags,
+		Command:       e.Command,
+		Params:        e.Params,
+		Trailing:      e.Trailing,
+		EmptyTrailing: e.EmptyTrailing,
+		Sensitive:     e.Sensitive,
+		Replayed:      e.Replayed,
+	}
+
+	if ts, ok := e.Timestamp(); ok {
+		out.Time = &ts
+	}
+
+	if !e.read.IsZero() {
+		received := e.read.UTC()
+		out.Received = &received
+	}
+
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON implements json.Unmarshaler, decoding an event encoded with
+// Event.MarshalJSON(). "time" is ignored, as the server-time of the event is
+// kept in its tags. An error is returned if the event has no command.
+func (e *Event) UnmarshalJSON(data []byte) error {
+	var in eventJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+
+	if in.Command == "" {
+		return errors.New("invalid event: missing command")
+	}
+
+	*e = Event{
+		Source:        in.Source,
+		Tags:          in.Tags,
+		Command:       in.Command,
+		Params:        in.Params,
+		Trailing:      in.Trailing,
+		EmptyTrailing: in.EmptyTrailing,
+		Sensitive:     in.Sensitive,
+		Replayed:      in.Replayed,
+	}
+
+	if in.Received != nil {
+		e.read = *in.Received
+	}
+
+	return nil
+}
+
+// IsService returns true if the event originated from a network services
+// pseudo-client, like NickServ or ChanServ. This is only set on events which
+// were dispatched to handlers. See Client.IsService() for how services are
+// detected.
+func (e *Event) IsService() bool {
+	return e.service
+}
+
+// annotations is the storage used by Event.Set() and Event.Get().
+type annotations struct {
+	mu     sync.RWMutex
+	values map[interface{}]interface{}
+}
+
+// Set annotates the event with value, stored under key, which can be read
+// by handlers which run later during the same dispatch with Event.Get(). For
+// example, an ALL_EVENTS handler may score messages for spam, which a PRIVMSG
+// handler then acts upon. Annotations are shared between copies of the event
+// (see Event.Copy()), and are safe for concurrent use.
+//
+// Handlers run in stages: ALL_EVENTS handlers first, then handlers for the
+// specific command, then CTCP handlers. Handlers within the same stage run
+// concurrently, so annotations are only guaranteed to be visible to
+// handlers in later stages. Like context.WithValue(), key should be of an
+// unexported type, to prevent collisions between packages.
+func (e *Event) Set(key, value interface{}) {
+	if e.annotations == nil {
+		e.annotations = &annotations{}
+	}
+
+	e.annotations.mu.Lock()
+	if e.annotations.values == nil {
+		e.annotations.values = make(map[interface{}]interface{})
+	}
+	e.annotations.values[key] = value
+	e.annotations.mu.Unlock()
+}
+
+// Get returns the annotation stored under key with Event.Set(), and if it
+// was set.
+func (e *Event) Get(key interface{}) (value interface{}, ok bool) {
+	if e.annotations == nil {
+		return nil, false
+	}
+
+	e.annotations.mu.RLock()
+	value, ok = e.annotations.values[key]
+	e.annotations.mu.RUnlock()
+
+	return value, ok
+}
+
 // Len calculates the length of the string representation of event. Note that
 // this will return the true length (even if longer than what IRC supports),
 // which may be useful if you are trying to check and see if a message is
@@ -394,13 +570,15 @@ func (e *Event) IsAction() bool {
 }
 
 // IsFromChannel checks to see if a message was from a channel (rather than
-// a private message).
+// a private message), including messages sent only to the users with a
+// certain permission in the channel (e.g. "@#channel", see
+// Event.StatusMsg()).
 func (e *Event) IsFromChannel() bool {
 	if e.Source == nil || e.Command != PRIVMSG || len(e.Params) < 1 {
 		return false
 	}
 
-	if !IsValidChannel(e.Params[0]) {
+	if _, _, ok := e.StatusMsg(); !ok {
 		return false
 	}
 
@@ -421,6 +599,19 @@ func (e *Event) IsFromUser() bool {
 	return true
 }
 
+// RelayedBy returns the nickname of the user which relayed the message on
+// behalf of the source, if the event was sent using the draft/relaymsg
+// extension (see Commands.RelayMessage()). ok is false if the event was not
+// relayed.
+func (e *Event) RelayedBy() (nick string, ok bool) {
+	if e.Command != PRIVMSG && e.Command != NOTICE {
+		return "", false
+	}
+
+	nick, ok = e.Tags.Get(capRelayMsg)
+	return nick, ok && nick != ""
+}
+
 // StripAction returns the stripped version of the action encoding from a
 // PRIVMSG ACTION (/me).
 func (e *Event) StripAction() string {
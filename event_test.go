@@ -5,8 +5,10 @@
 package girc
 
 import (
+	"encoding/json"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func mockEvent() *Event {
@@ -94,6 +96,8 @@ func TestParseEvent(t *testing.T) {
 		{in: "@aaa=bbb :nick!user@host TEST :test1", want: "@aaa=bbb :nick!user@host TEST :test1"},
 		{in: "@aaa=bbb;+ccc;example.com/ddd=eee :nick!user@host TEST :test1", want: "@aaa=bbb;+ccc;example.com/ddd=eee :nick!user@host TEST :test1"},
 		{in: "@bbb=aaa;aaa :nick!user@host TEST :test1", want: "@aaa;bbb=aaa :nick!user@host TEST :test1"},
+		{in: "@time=2020-01-01T00:00:00.000Z PING :token", want: "@time=2020-01-01T00:00:00.000Z PING :token"},
+		{in: "@aaa=bbb ", want: ""},
 	}
 
 	for _, tt := range tests {
@@ -126,6 +130,38 @@ func TestParseEvent(t *testing.T) {
 	}
 }
 
+func TestParseLegacyEvent(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "", want: ""},
+		{in: "   \r\n", want: ""},
+		{in: ":host.domain.com TEST arg1 arg2", want: ":host.domain.com TEST arg1 arg2"},
+		{in: "  :host.domain.com  TEST   arg1  arg2  ", want: ":host.domain.com TEST arg1 arg2"},
+		{in: ":nick!user@host  privmsg  #channel  :spaced  out  ", want: ":nick!user@host PRIVMSG #channel :spaced  out  "},
+		{in: ":host.domain.com 1 nick :Welcome", want: ":host.domain.com 001 nick :Welcome"},
+		{in: ":host.domain.com 42 nick :Unknown", want: ":host.domain.com 042 nick :Unknown"},
+		{in: ":host.domain.com 353 nick #channel :nick other", want: ":host.domain.com 353 nick = #channel :nick other"},
+		{in: ":host.domain.com 353 nick @ #channel :nick other", want: ":host.domain.com 353 nick @ #channel :nick other"},
+	}
+
+	for _, tt := range tests {
+		got := parseLegacyEvent(tt.in)
+
+		if got == nil {
+			if tt.want != "" {
+				t.Fatalf("parseLegacyEvent(%q): got nil, want %q", tt.in, tt.want)
+			}
+			continue
+		}
+
+		if got.String() != tt.want {
+			t.Fatalf("parseLegacyEvent(%q): got %q, want %q", tt.in, got.String(), tt.want)
+		}
+	}
+}
+
 func TestEventCopy(t *testing.T) {
 	var nilEvent *Event
 
@@ -189,3 +225,157 @@ func TestEventIs(t *testing.T) {
 		t.Fatalf("Event.IsFromUser: returned false on %#v", event)
 	}
 }
+
+func TestEventRelayedBy(t *testing.T) {
+	event := ParseEvent("@draft/relaymsg=bridge :user/discord!relay@host PRIVMSG #test :hello")
+
+	if nick, ok := event.RelayedBy(); !ok || nick != "bridge" {
+		t.Fatalf("Event.RelayedBy() = (%q, %t), want (\"bridge\", true)", nick, ok)
+	}
+
+	event = ParseEvent(":nick!user@host PRIVMSG #test :hello")
+	if nick, ok := event.RelayedBy(); ok {
+		t.Fatalf("Event.RelayedBy() = (%q, %t) on non-relayed event", nick, ok)
+	}
+}
+
+func TestEventTimestamp(t *testing.T) {
+	e := ParseEvent("@time=2017-06-05T14:30:01.123Z :nick!user@host PRIVMSG #test :hello")
+
+	ts, ok := e.Timestamp()
+	if !ok {
+		t.Fatal("Event.Timestamp() returned !ok, wanted timestamp")
+	}
+
+	if want := time.Date(2017, 6, 5, 14, 30, 1, 123000000, time.UTC); !ts.Equal(want) {
+		t.Fatalf("Event.Timestamp() == %s, wanted %s", ts, want)
+	}
+
+	if _, ok = ParseEvent(":nick!user@host PRIVMSG #test :hello").Timestamp(); ok {
+		t.Fatal("Event.Timestamp() returned ok for event without time tag")
+	}
+
+	if got := zncTimestamp(ts); got != "1496673001.123" {
+		t.Fatalf("zncTimestamp() == %q, wanted %q", got, "1496673001.123")
+	}
+}
+
+func TestEventJSON(t *testing.T) {
+	e := ParseEvent("@time=2020-01-01T00:00:00.000Z :nick!user@host PRIVMSG #channel :hello")
+	e.read = time.Date(2020, 1, 1, 0, 0, 1, 500000000, time.UTC)
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		t.Fatalf("json.Marshal() returned error: %s", err)
+	}
+
+	want := `{"source":{"name":"nick","ident":"user","host":"host"},"tags":{"time":"2020-01-01T00:00:00.000Z"},` +
+		`"command":"PRIVMSG","params":["#channel"],"trailing":"hello","empty_trailing":false,"sensitive":false,` +
+		`"replayed":false,"time":"2020-01-01T00:00:00Z","received":"2020-01-01T00:00:01.5Z"}`
+	if string(b) != want {
+		t.Fatalf("json.Marshal() = %s, want %s", b, want)
+	}
+
+	var got Event
+	if err = json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("json.Unmarshal() returned error: %s", err)
+	}
+	if !reflect.DeepEqual(&got, e) {
+		t.Fatalf("json.Unmarshal() = %#v, want %#v", got, e)
+	}
+
+	// Events are also encoded when they aren't addressable.
+	b, err = json.Marshal(struct{ Event Event }{*ParseEvent("PING :token")})
+	if err != nil || string(b) != `{"Event":{"source":null,"tags":null,"command":"PING","params":null,`+
+		`"trailing":"token","empty_trailing":false,"sensitive":false,"replayed":false}}` {
+		t.Fatalf("json.Marshal() = %s, %v, want event without time", b, err)
+	}
+
+	if err = json.Unmarshal([]byte(`{"params":["#channel"]}`), &got); err == nil {
+		t.Fatal("json.Unmarshal() of event without command returned no error")
+	}
+}
+
+type testAnnotation string
+
+func TestEventAnnotations(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test"})
+
+	const key testAnnotation = "spam"
+
+	result := make(chan interface{}, 1)
+	c.Handlers.Add(ALL_EVENTS, func(c *Client, e Event) {
+		if e.Command == PRIVMSG {
+			e.Set(key, 0.9)
+		}
+	})
+	c.Handlers.Add(PRIVMSG, func(c *Client, e Event) {
+		score, ok := e.Get(key)
+		if !ok {
+			score = nil
+		}
+		result <- score
+	})
+
+	c.RunHandlers(ParseEvent(":nick!user@host PRIVMSG #channel :buy now"))
+
+	if score := <-result; score != 0.9 {
+		t.Fatalf("Event.Get() in later handler = %v, want 0.9", score)
+	}
+
+	// Annotations shouldn't leak between dispatches.
+	c.Handlers.Clear(ALL_EVENTS)
+	c.RunHandlers(ParseEvent(":nick!user@host PRIVMSG #channel :hello"))
+
+	if score := <-result; score != nil {
+		t.Fatalf("Event.Get() for a new event = %v, want nil", score)
+	}
+
+	var e Event
+	if _, ok := e.Get(key); ok {
+		t.Fatal("Event.Get() on an unannotated event returned ok")
+	}
+
+	e.Set(key, "value")
+	if value, ok := e.Copy().Get(key); !ok || value != "value" {
+		t.Fatalf("Event.Get() on a copy = %v, %t, want value, true", value, ok)
+	}
+}
+
+func TestEventServerError(t *testing.T) {
+	tests := []struct {
+		raw      string
+		host     string
+		message  string
+		category string
+	}{
+		{raw: "ERROR :Closing Link: 1.2.3.4 (Ping timeout: 240 seconds)", host: "1.2.3.4", message: "Ping timeout: 240 seconds", category: ServerErrorTimeout},
+		{raw: "ERROR :Closing Link: nick[host.example.com] (Quit: bye)", host: "nick[host.example.com]", message: "Quit: bye", category: ServerErrorQuit},
+		{raw: "ERROR :Closing Link: host (Excess Flood)", host: "host", message: "Excess Flood", category: ServerErrorFlood},
+		{raw: "ERROR :Closing Link: host (Killed (oper (spamming)))", host: "host", message: "Killed (oper (spamming))", category: ServerErrorKilled},
+		{raw: "ERROR :Trying to reconnect too fast.", message: "Trying to reconnect too fast.", category: ServerErrorThrottled},
+		{raw: "ERROR :Closing Link: host [Server shutting down]", host: "host", message: "[Server shutting down]", category: ServerErrorShutdown},
+		{raw: "ERROR :You are banned from this server", message: "You are banned from this server", category: ServerErrorBanned},
+		{raw: "ERROR :Something else", message: "Something else", category: ServerErrorUnknown},
+	}
+
+	for _, tt := range tests {
+		err, ok := ParseEvent(tt.raw).ServerError()
+		if !ok {
+			t.Fatalf("ServerError() of %q not ok", tt.raw)
+		}
+
+		if err.Host != tt.host || err.Message != tt.message || err.Category != tt.category || err.ClosingLink != (tt.host != "") {
+			t.Fatalf("ServerError() of %q = %#v, want host %q, message %q, category %q", tt.raw, err, tt.host, tt.message, tt.category)
+		}
+	}
+
+	err, ok := ParseEvent(":oper!~oper@host KILL nick :spamming").ServerError()
+	if !ok || err.Category != ServerErrorKilled || err.KilledBy != "oper" || err.Message != "spamming" {
+		t.Fatalf("ServerError() of KILL = %#v, want killed by oper", err)
+	}
+
+	if _, ok := ParseEvent(":nick!user@host PRIVMSG #channel :hello").ServerError(); ok {
+		t.Fatal("ServerError() ok for PRIVMSG")
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"strconv"
+	"sync"
+)
+
+// defaultEventQueueSize is the size of the incoming event queue, if
+// Config.EventQueueSize isn't supplied.
+const defaultEventQueueSize = 25
+
+// EventQueuePolicy determines what happens once the incoming event queue is
+// full, i.e. when handlers can't keep up with the events read from the
+// server. See Config.EventQueuePolicy.
+type EventQueuePolicy int
+
+const (
+	// QueueBlock stops reading from the server until there's room in the
+	// queue. This is the default. No events are lost, however if handlers
+	// stall for too long, the client can't reply to PINGs, and the server
+	// will eventually time out the connection.
+	QueueBlock EventQueuePolicy = iota
+	// QueueDropOldest drops the oldest queued event to make room, so the
+	// client keeps up with the server, and handlers see the most recent
+	// events. An EVENTS_DROPPED event is emitted with the amount of dropped
+	// events before the next event is handled. PING and ERROR are never
+	// dropped, as the connection depends on them, so the oldest other event
+	// is dropped instead. Note that state tracking may become inaccurate, as
+	// events like JOIN and PART may be dropped.
+	QueueDropOldest
+	// QueueSpill keeps the events which don't fit in the queue in a buffer
+	// which grows as needed. No events are lost and the connection stays
+	// alive, at the expense of memory, which is unbounded while handlers
+	// stall. See Client.EventQueue() for how far behind the client is.
+	QueueSpill
+)
+
+// eventQueue holds the runtime state of the incoming event queue, which is
+// Client.rx, along with the events which spilled over (see QueueSpill).
+type eventQueue struct {
+	mu sync.Mutex
+	// spill are the events which didn't fit in Client.rx, see QueueSpill.
+	spill []*Event
+	// dropped is the total amount of events dropped, and pending is the
+	// amount which haven't been reported with EVENTS_DROPPED yet.
+	dropped, pending uint64
+}
+
+// EventQueueStats describes the incoming event queue, see
+// Client.EventQueue().
+type EventQueueStats struct {
+	// Length is the amount of events waiting to be handled, including
+	// Spilled.
+	Length int `json:"length"`
+	// Spilled is the amount of events waiting in the spill buffer, see
+	// QueueSpill.
+	Spilled int `json:"spilled"`
+	// Dropped is the total amount of events which have been dropped, see
+	// QueueDropOldest.
+	Dropped uint64 `json:"dropped"`
+}
+
+// EventQueue returns the amount of events waiting to be handled, and how
+// many have been dropped, e.g. to alert when handlers can't keep up with
+// the server. See Config.EventQueuePolicy.
+func (c *Client) EventQueue() EventQueueStats {
+	c.rxq.mu.Lock()
+	defer c.rxq.mu.Unlock()
+
+	return EventQueueStats{
+		Length:  len(c.rx) + len(c.rxq.spill),
+		Spilled: len(c.rxq.spill),
+		Dropped: c.rxq.dropped,
+	}
+}
+
+// queuedEvents returns the amount of events waiting to be handled.
+func (c *Client) queuedEvents() int {
+	c.rxq.mu.Lock()
+	defer c.rxq.mu.Unlock()
+
+	return len(c.rx) + len(c.rxq.spill)
+}
+
+// receive queues event to be handled by the exec loop, applying
+// Config.EventQueuePolicy if the queue is full.
+func (c *Client) receive(event *Event) {
+	switch c.Config.EventQueuePolicy {
+	case QueueDropOldest:
+		c.rxq.mu.Lock()
+		if !c.dropOldest(event) {
+			c.rxq.mu.Unlock()
+			return
+		}
+		c.rxq.mu.Unlock()
+
+		// The queue is full of events which can't be dropped, and so is
+		// event, so wait for room like QueueBlock.
+		c.rx <- event
+	case QueueSpill:
+		c.rxq.mu.Lock()
+		defer c.rxq.mu.Unlock()
+
+		// Once events have spilled over, the following events must spill
+		// over as well, so they're handled in order.
+		if len(c.rxq.spill) == 0 {
+			select {
+			case c.rx <- event:
+				return
+			default:
+			}
+		}
+
+		c.rxq.spill = append(c.rxq.spill, event)
+	default:
+		c.rx <- event
+	}
+}
+
+// isDroppable returns true if event may be dropped with QueueDropOldest,
+// which isn't the case for PING (as no PONG would be sent, and the
+// connection would time out) and ERROR (including those emulated during
+// SASL authentication, see handleSASLError()).
+func isDroppable(event *Event) bool {
+	return event.Command != PING && event.Command != ERROR
+}
+
+// dropOldest queues event, dropping the oldest droppable event (see
+// isDroppable()) while the queue is full, or event itself if none of the
+// queued events may be dropped. This returns true if event must be queued
+// regardless, as neither it nor any of the queued events may be dropped.
+// c.rxq.mu must be locked, so nothing else is queued in the meantime.
+func (c *Client) dropOldest(event *Event) (block bool) {
+	for {
+		select {
+		case c.rx <- event:
+			return false
+		default:
+		}
+
+		// Take the queued events out of the queue, and put them back
+		// (in order) without the oldest one which may be dropped.
+		queued := make([]*Event, 0, cap(c.rx))
+	take:
+		for {
+			select {
+			case e := <-c.rx:
+				queued = append(queued, e)
+			default:
+				break take
+			}
+		}
+
+		drop := -1
+		for i := 0; i < len(queued); i++ {
+			if isDroppable(queued[i]) {
+				drop = i
+				break
+			}
+		}
+
+		if drop == -1 && !isDroppable(event) {
+			for _, e := range queued {
+				c.rx <- e
+			}
+
+			return true
+		}
+
+		c.rxq.dropped++
+		c.rxq.pending++
+
+		if drop == -1 {
+			// Nothing queued may be dropped, so event is.
+			for _, e := range queued {
+				c.rx <- e
+			}
+
+			return false
+		}
+
+		for i, e := range queued {
+			if i != drop {
+				c.rx <- e
+			}
+		}
+	}
+}
+
+// dequeued is called by the exec loop each time it has taken an event from
+// the queue. This moves spilled events into the queue, as long as there's
+// room for them, and emits EVENTS_DROPPED if events were dropped since it
+// was last emitted.
+func (c *Client) dequeued() {
+	c.rxq.mu.Lock()
+refill:
+	for len(c.rxq.spill) > 0 {
+		select {
+		case c.rx <- c.rxq.spill[0]:
+			c.rxq.spill[0] = nil
+			c.rxq.spill = c.rxq.spill[1:]
+		default:
+			break refill
+		}
+	}
+
+	dropped := c.rxq.pending
+	c.rxq.pending = 0
+	c.rxq.mu.Unlock()
+
+	if dropped > 0 {
+		c.logger.Warn("event queue full, dropped events", "dropped", dropped)
+		c.RunHandlers(&Event{Command: EVENTS_DROPPED, Trailing: strconv.FormatUint(dropped, 10)})
+	}
+}
@@ -0,0 +1,326 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// FakeNetwork is a deterministic generator of IRC traffic (joins, parts,
+// quits, kicks, nick changes, messages, topic and mode changes) across a
+// set of synthetic users and channels. The same seed always produces the
+// same sequence of events. This is useful for fuzzing and load-testing
+// handlers and the state tracker, without needing a server (or any sockets),
+// see FakeNetwork.Feed().
+//
+// The client (see the nick supplied to NewFakeNetwork()) first joins all of
+// the channels, and is never removed from them.
+type FakeNetwork struct {
+	rand     *rand.Rand
+	nick     string
+	users    []*fakeUser
+	channels []string
+	// joined is the amount of channels the client has joined so far.
+	joined int
+	// renames is used to generate unique nicknames.
+	renames int
+}
+
+// fakeUser is a synthetic user on a FakeNetwork.
+type fakeUser struct {
+	nick, ident, host string
+	// channels is indexed the same as FakeNetwork.channels, and is true
+	// when the user is in that channel.
+	channels []bool
+}
+
+func (u *fakeUser) source() *Source {
+	return &Source{Name: u.nick, Ident: u.ident, Host: u.host}
+}
+
+// NewFakeNetwork returns a new FakeNetwork seeded with seed, with the
+// specified amount of synthetic users and channels (at least one of each).
+// nick is the nickname of the client which the events are generated for.
+func NewFakeNetwork(nick string, seed int64, users, channels int) *FakeNetwork {
+	if users < 1 {
+		users = 1
+	}
+
+	if channels < 1 {
+		channels = 1
+	}
+
+	n := &FakeNetwork{rand: rand.New(rand.NewSource(seed)), nick: nick}
+
+	for i := 0; i < channels; i++ {
+		n.channels = append(n.channels, fmt.Sprintf("#fake%d", i))
+	}
+
+	for i := 0; i < users; i++ {
+		n.users = append(n.users, &fakeUser{
+			nick:     fmt.Sprintf("fake%d", i),
+			ident:    fmt.Sprintf("ident%d", i),
+			host:     fmt.Sprintf("host%d.fake.int", i),
+			channels: make([]bool, channels),
+		})
+	}
+
+	return n
+}
+
+// Channels returns the names of the synthetic channels.
+func (n *FakeNetwork) Channels() []string {
+	return append([]string(nil), n.channels...)
+}
+
+// Members returns the nicknames of the users which are currently in channel,
+// according to the events generated so far (including the client, once it
+// has joined). This can be compared against what the state tracker reports.
+func (n *FakeNetwork) Members(channel string) (members []string) {
+	for i := 0; i < len(n.channels); i++ {
+		if n.channels[i] != channel {
+			continue
+		}
+
+		if i < n.joined {
+			members = append(members, n.nick)
+		}
+
+		for _, user := range n.users {
+			if user.channels[i] {
+				members = append(members, user.nick)
+			}
+		}
+	}
+
+	return members
+}
+
+// Next returns the next generated event.
+func (n *FakeNetwork) Next() *Event {
+	if n.joined < len(n.channels) {
+		n.joined++
+
+		return &Event{
+			Source:  &Source{Name: n.nick, Ident: n.nick, Host: "client.fake.int"},
+			Command: JOIN,
+			Params:  []string{n.channels[n.joined-1]},
+		}
+	}
+
+	var e *Event
+	switch r := n.rand.Intn(100); {
+	case r < 40:
+		e = n.message()
+	case r < 55:
+		e = n.join()
+	case r < 65:
+		e = n.part()
+	case r < 75:
+		e = n.mode()
+	case r < 82:
+		e = n.rename()
+	case r < 88:
+		e = n.kick()
+	case r < 93:
+		e = n.quit()
+	default:
+		e = n.topic()
+	}
+
+	// The chosen action may not be possible (e.g. parting when nobody is in
+	// the channel), so fall back to a join, which is possible unless
+	// everyone is in every channel, in which case a message always is.
+	if e == nil {
+		e = n.join()
+	}
+
+	if e == nil {
+		e = n.message()
+	}
+
+	return e
+}
+
+// Feed runs count generated events through the client's handlers, as if
+// they had been received from the server. If the client isn't connected,
+// anything sent by handlers in response is discarded. Feed blocks until all
+// events have been dispatched (though handlers registered to run in the
+// background may still be running).
+func (n *FakeNetwork) Feed(c *Client, count int) {
+	if !c.IsConnected() {
+		done := make(chan struct{})
+		defer close(done)
+
+		go func() {
+			for {
+				select {
+				case o := <-c.tx:
+					c.discard(o)
+					c.refill()
+				case o := <-c.txControl:
+					c.discard(o)
+					c.refill()
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < count; i++ {
+		c.RunHandlers(n.Next())
+	}
+}
+
+// member returns a random user in channel i, or nil if it's empty.
+func (n *FakeNetwork) member(i int) *fakeUser {
+	var members []*fakeUser
+	for _, user := range n.users {
+		if user.channels[i] {
+			members = append(members, user)
+		}
+	}
+
+	if len(members) == 0 {
+		return nil
+	}
+
+	return members[n.rand.Intn(len(members))]
+}
+
+// present returns a random user which is in at least one channel, or nil.
+func (n *FakeNetwork) present() *fakeUser {
+	var present []*fakeUser
+	for _, user := range n.users {
+		for _, in := range user.channels {
+			if in {
+				present = append(present, user)
+				break
+			}
+		}
+	}
+
+	if len(present) == 0 {
+		return nil
+	}
+
+	return present[n.rand.Intn(len(present))]
+}
+
+func (n *FakeNetwork) message() *Event {
+	user := n.users[n.rand.Intn(len(n.users))]
+	text := fmt.Sprintf("message %d from %s", n.rand.Intn(100000), user.nick)
+
+	// Occasionally message the client directly.
+	if n.rand.Intn(10) == 0 {
+		return &Event{Source: user.source(), Command: PRIVMSG, Params: []string{n.nick}, Trailing: text}
+	}
+
+	i := n.rand.Intn(len(n.channels))
+	return &Event{Source: user.source(), Command: PRIVMSG, Params: []string{n.channels[i]}, Trailing: text}
+}
+
+func (n *FakeNetwork) join() *Event {
+	type candidate struct {
+		user    *fakeUser
+		channel int
+	}
+
+	var candidates []candidate
+	for _, user := range n.users {
+		for i, in := range user.channels {
+			if !in {
+				candidates = append(candidates, candidate{user, i})
+			}
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	chosen := candidates[n.rand.Intn(len(candidates))]
+	chosen.user.channels[chosen.channel] = true
+
+	return &Event{Source: chosen.user.source(), Command: JOIN, Params: []string{n.channels[chosen.channel]}}
+}
+
+func (n *FakeNetwork) part() *Event {
+	i := n.rand.Intn(len(n.channels))
+	user := n.member(i)
+	if user == nil {
+		return nil
+	}
+
+	user.channels[i] = false
+
+	return &Event{Source: user.source(), Command: PART, Params: []string{n.channels[i]}, Trailing: "leaving"}
+}
+
+func (n *FakeNetwork) kick() *Event {
+	i := n.rand.Intn(len(n.channels))
+	user := n.member(i)
+	if user == nil {
+		return nil
+	}
+
+	// Kicks are performed by another member if possible, otherwise by the
+	// user themselves.
+	by := n.member(i)
+	user.channels[i] = false
+
+	return &Event{Source: by.source(), Command: KICK, Params: []string{n.channels[i], user.nick}, Trailing: "kicked"}
+}
+
+func (n *FakeNetwork) quit() *Event {
+	user := n.present()
+	if user == nil {
+		return nil
+	}
+
+	for i := 0; i < len(user.channels); i++ {
+		user.channels[i] = false
+	}
+
+	return &Event{Source: user.source(), Command: QUIT, Trailing: "quit"}
+}
+
+func (n *FakeNetwork) rename() *Event {
+	user := n.present()
+	if user == nil {
+		return nil
+	}
+
+	n.renames++
+	e := &Event{Source: user.source(), Command: NICK, Params: []string{fmt.Sprintf("renamed%d", n.renames)}}
+	user.nick = e.Params[0]
+
+	return e
+}
+
+func (n *FakeNetwork) mode() *Event {
+	i := n.rand.Intn(len(n.channels))
+	user := n.member(i)
+	if user == nil {
+		return nil
+	}
+
+	by := n.member(i)
+	modes := [...]string{"+o", "-o", "+v", "-v"}
+
+	return &Event{Source: by.source(), Command: MODE, Params: []string{n.channels[i], modes[n.rand.Intn(len(modes))], user.nick}}
+}
+
+func (n *FakeNetwork) topic() *Event {
+	i := n.rand.Intn(len(n.channels))
+	user := n.member(i)
+	if user == nil {
+		return nil
+	}
+
+	return &Event{Source: user.source(), Command: TOPIC, Params: []string{n.channels[i]}, Trailing: fmt.Sprintf("topic %d", n.rand.Intn(100000))}
+}
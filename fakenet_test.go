@@ -0,0 +1,49 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestFakeNetworkDeterministic(t *testing.T) {
+	a := NewFakeNetwork("test", 42, 10, 3)
+	b := NewFakeNetwork("test", 42, 10, 3)
+
+	for i := 0; i < 500; i++ {
+		if ea, eb := a.Next().String(), b.Next().String(); ea != eb {
+			t.Fatalf("event %d differs between networks with the same seed: %q != %q", i, ea, eb)
+		}
+	}
+}
+
+func TestFakeNetworkFeed(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test"})
+
+	n := NewFakeNetwork(c.GetNick(), 1, 15, 4)
+	n.Feed(c, 2000)
+
+	for _, name := range n.Channels() {
+		ch := c.LookupChannel(name)
+		if ch == nil {
+			t.Fatalf("channel %q not tracked", name)
+		}
+
+		want := n.Members(name)
+		for i := 0; i < len(want); i++ {
+			want[i] = ToRFC1459(want[i])
+		}
+		sort.Strings(want)
+
+		got := append([]string(nil), ch.UserList...)
+		sort.Strings(got)
+
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("tracked users in %s = %v, want %v", name, got, want)
+		}
+	}
+}
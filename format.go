@@ -7,6 +7,7 @@ package girc
 import (
 	"bytes"
 	"strings"
+	"unicode/utf8"
 )
 
 type ircFmtCode struct {
@@ -37,64 +38,113 @@ var codes = []*ircFmtCode{
 	{aliases: []string{"clear", "c"}, val: "\x03"}, // Clears formatting.
 	{aliases: []string{"reverse"}, val: "\x16"},
 	{aliases: []string{"underline", "ul"}, val: "\x1f"},
+	{aliases: []string{"strikethrough", "strike"}, val: "\x1e"},
+	{aliases: []string{"monospace", "mono"}, val: "\x11"},
 	{aliases: []string{"ctcp"}, val: "\x01"}, // CTCP/ACTION delimiter.
 }
 
-// Fmt takes format strings like "{red}" and turns them into the resulting
-// ASCII format/color codes for IRC.
-//
-// For example:
-//
-//   client.Message("#channel", Fmt("{red}{bold}Hello World{c}"))
-func Fmt(text string) string {
+// Raw IRC formatting characters, see StripFormat().
+const (
+	fmtBold          byte = 0x02
+	fmtColor         byte = 0x03
+	fmtHexColor      byte = 0x04
+	fmtReset         byte = 0x0f
+	fmtMonospace     byte = 0x11
+	fmtReverse       byte = 0x16
+	fmtItalic        byte = 0x1d
+	fmtStrikethrough byte = 0x1e
+	fmtUnderline     byte = 0x1f
+)
+
+// fmtAliases maps the aliases of codes to their values.
+var fmtAliases = func() map[string]string {
+	aliases := make(map[string]string)
 	for i := 0; i < len(codes); i++ {
 		for a := 0; a < len(codes[i].aliases); a++ {
-			text = strings.Replace(text, "{"+codes[i].aliases[a]+"}", codes[i].val, -1)
+			aliases[codes[i].aliases[a]] = codes[i].val
 		}
+	}
 
-		// makes parsing small strings slightly slower, but helps longer
-		// strings.
-		var more bool
-		for c := 0; c < len(text); c++ {
-			if text[c] == 0x7B {
-				more = true
-				break
-			}
-		}
-		if !more {
-			return text
-		}
+	return aliases
+}()
+
+// fmtCode returns the value of a format code, e.g. "red", or a foreground
+// and background color, e.g. "red,blue".
+func fmtCode(name string) (val string, ok bool) {
+	i := strings.IndexByte(name, ',')
+	if i < 0 {
+		val, ok = fmtAliases[name]
+		return val, ok
 	}
 
-	return text
+	fg, bg := fmtAliases[name[:i]], fmtAliases[name[i+1:]]
+	if len(fg) != 3 || fg[0] != fmtColor || len(bg) != 3 || bg[0] != fmtColor {
+		return "", false
+	}
+
+	return fg + "," + bg[1:], true
 }
 
-// TrimFmt strips all "{fmt}" formatting strings from the input text.
-// See Fmt() for more information.
-func TrimFmt(text string) string {
-	for i := 0; i < len(codes); i++ {
-		for a := 0; a < len(codes[i].aliases); a++ {
-			text = strings.Replace(text, "{"+codes[i].aliases[a]+"}", "", -1)
+// replaceFmt replaces all "{fmt}" formatting strings in text with their
+// values, or removes them if raw is false.
+func replaceFmt(text string, raw bool) string {
+	if strings.IndexByte(text, '{') < 0 {
+		return text
+	}
+
+	var out bytes.Buffer
+	for {
+		start := strings.IndexByte(text, '{')
+		if start < 0 {
+			break
 		}
 
-		// makes parsing small strings slightly slower, but helps longer
-		// strings.
-		var more bool
-		for c := 0; c < len(text); c++ {
-			if text[c] == 0x7B {
-				more = true
-				break
-			}
+		end := strings.IndexByte(text[start+1:], '}')
+		if end < 0 {
+			break
 		}
-		if !more {
-			return text
+		name := text[start+1 : start+1+end]
+
+		// E.g. "{re{c}", where only the latter can be a code.
+		if i := strings.LastIndexByte(name, '{'); i > -1 {
+			out.WriteString(text[:start+1+i])
+			text = text[start+1+i:]
+			continue
+		}
+
+		out.WriteString(text[:start])
+		if val, ok := fmtCode(name); !ok {
+			out.WriteString(text[start : start+end+2])
+		} else if raw {
+			out.WriteString(val)
 		}
+		text = text[start+end+2:]
 	}
 
-	return text
+	out.WriteString(text)
+	return out.String()
+}
+
+// Fmt takes format strings like "{red}" and turns them into the resulting
+// ASCII format/color codes for IRC. Colors can also be combined with a
+// background color, e.g. "{red,black}".
+//
+// For example:
+//
+//   client.Message("#channel", Fmt("{red}{bold}Hello World{c}"))
+func Fmt(text string) string {
+	return replaceFmt(text, true)
+}
+
+// TrimFmt strips all "{fmt}" formatting strings from the input text.
+// See Fmt() for more information.
+func TrimFmt(text string) string {
+	return replaceFmt(text, false)
 }
 
-// StripRaw tries to strip all ASCII format codes that are used for IRC.
+// StripRaw tries to strip all ASCII format codes that are used for IRC. It
+// only strips the codes generated by Fmt(), see StripFormat() to strip the
+// formatting of incoming text.
 func StripRaw(text string) string {
 	for i := 0; i < len(codes); i++ {
 		text = strings.Replace(text, codes[i].val, "", -1)
@@ -103,6 +153,80 @@ func StripRaw(text string) string {
 	return text
 }
 
+// StripFormat strips all IRC formatting (bold, italics, colors, including
+// their foreground and background numbers, hex colors, etc) from text, e.g.
+// from incoming messages.
+func StripFormat(text string) string {
+	var out bytes.Buffer
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case fmtBold, fmtItalic, fmtUnderline, fmtStrikethrough, fmtMonospace, fmtReverse, fmtReset:
+		case fmtColor:
+			i += colorLength(text[i+1:], 2, isDigit) - 1
+		case fmtHexColor:
+			i += colorLength(text[i+1:], 6, isHexDigit) - 1
+		default:
+			out.WriteByte(text[i])
+		}
+	}
+
+	return out.String()
+}
+
+// colorLength returns the length of the foreground and optional background
+// color at the start of text, each up to max characters matching valid. The
+// background is only included if there is a foreground.
+func colorLength(text string, max int, valid func(c byte) bool) int {
+	n := 0
+	for n < len(text) && n < max && valid(text[n]) {
+		n++
+	}
+
+	if n == 0 || n+1 >= len(text) || text[n] != ',' || !valid(text[n+1]) {
+		return n + 1
+	}
+
+	bg := 0
+	for n+1+bg < len(text) && bg < max && valid(text[n+1+bg]) {
+		bg++
+	}
+
+	return n + bg + 2
+}
+
+func isDigit(b byte) bool { return b >= '0' && b <= '9' }
+
+func isHexDigit(b byte) bool {
+	return isDigit(b) || (b >= 'a' && b <= 'f') || (b >= 'A' && b <= 'F')
+}
+
+// IsValidColorCode validates if code is a valid IRC color code (without the
+// leading control character), i.e. a foreground color of one or two digits
+// between 0 and 99, optionally followed by a comma and a background color,
+// e.g. "4", "04" or "04,01".
+func IsValidColorCode(code string) bool {
+	if i := strings.IndexByte(code, ','); i > -1 {
+		return isValidColor(code[:i]) && isValidColor(code[i+1:])
+	}
+
+	return isValidColor(code)
+}
+
+// isValidColor validates a single color number, see IsValidColorCode().
+func isValidColor(color string) bool {
+	if color == "" || len(color) > 2 {
+		return false
+	}
+
+	for i := 0; i < len(color); i++ {
+		if !isDigit(color[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
 // IsValidChannel validates if channel is an RFC complaint channel or not.
 //
 // NOTE: If you are using this to validate a channel that contains a channel
@@ -160,14 +284,22 @@ func IsValidChannel(channel string) bool {
 }
 
 // IsValidNick validates an IRC nickame. Note that this does not validate
-// IRC nickname length.
+// IRC nickname length, see Client.IsValidNick() to also check the NICKLEN
+// supported by the server. The server's CASEMAPPING only affects how
+// nicknames are compared (see Client.Equal()), not which are valid.
 //
 //   nickname =  ( letter / special ) *8( letter / digit / special / "-" )
 //   letter   =  0x41-0x5A / 0x61-0x7A
 //   digit    =  0x30-0x39
 //   special  =  0x5B-0x60 / 0x7B-0x7D
 func IsValidNick(nick string) bool {
-	if len(nick) <= 0 {
+	return isValidNick(nick, 0)
+}
+
+// isValidNick validates nick like IsValidNick(), also rejecting nicknames
+// longer than max (unless max is 0).
+func isValidNick(nick string, max int) bool {
+	if len(nick) <= 0 || (max > 0 && len(nick) > max) {
 		return false
 	}
 
@@ -241,59 +373,169 @@ func IsValidUser(name string) bool {
 // 1459. This will do things like replace an "A" with an "a", "[]" with "{}",
 // and so forth. Useful to compare two nicknames or channels.
 func ToRFC1459(input string) (out string) {
-	for i := 0; i < len(input); i++ {
-		if input[i] >= 65 && input[i] <= 94 {
-			out += string(rune(input[i]) + 32)
-		} else {
-			out += string(input[i])
+	return foldRange(input, 94)
+}
+
+// ToRFC1459Strict converts a string to the stripped down conversion within
+// the "rfc1459-strict" casemapping. This is the same as ToRFC1459(), with the
+// exception that "^" and "~" are not considered equivalent.
+func ToRFC1459Strict(input string) (out string) {
+	return foldRange(input, 93)
+}
+
+// ToASCII converts a string to lowercase using the "ascii" casemapping,
+// where only A-Z are considered to be uppercase.
+func ToASCII(input string) (out string) {
+	return foldRange(input, 90)
+}
+
+// foldRange lowercases all characters between "A" and max (inclusive).
+func foldRange(input string, max byte) string {
+	out := []byte(input)
+	for i := 0; i < len(out); i++ {
+		if out[i] >= 65 && out[i] <= max {
+			out[i] += 32
 		}
 	}
 
-	return out
+	return string(out)
+}
+
+// Equal compares two nicknames or channels using RFC1459 casemapping. See
+// Client.Equal() to compare using the casemapping supported by the server.
+func Equal(a, b string) bool {
+	return ToRFC1459(a) == ToRFC1459(b)
+}
+
+// casefold normalizes input using the given ISUPPORT CASEMAPPING. Unknown
+// casemappings fall back to rfc1459.
+func casefold(casemapping, input string) string {
+	switch casemapping {
+	case "ascii":
+		return ToASCII(input)
+	case "rfc1459-strict":
+		return ToRFC1459Strict(input)
+	}
+
+	return ToRFC1459(input)
 }
 
-const globChar = "*"
+// Glob wildcards: globAny matches any amount of characters (including
+// none), and globOne exactly one character.
+const (
+	globAny byte = '*'
+	globOne byte = '?'
+)
 
 // Glob will test a string pattern, potentially containing globs, against a
-// string. The glob character is *.
+// string, using the wildcard semantics of IRC masks: "*" matches any amount
+// of characters (including none), and "?" exactly one character. For
+// example, to match a hostmask against a ban mask:
+//
+//	Glob("nick!~user@host.example.com", "*!*user@*.example.com") // => true
+//
+// Matching is case-sensitive, see ToRFC1459() (or Client.Equal()) to fold
+// both first.
 func Glob(input, match string) bool {
-	// Empty pattern.
-	if match == "" {
-		return input == match
+	var i, j int
+	// The position of the last "*" in match, and the position in input it
+	// is retried from (with the "*" consuming one more character), if the
+	// rest of match doesn't match.
+	star, retry := -1, 0
+
+	for i < len(input) {
+		if j < len(match) {
+			switch match[j] {
+			case globAny:
+				star, retry = j, i
+				j++
+				continue
+			case globOne:
+				_, size := utf8.DecodeRuneInString(input[i:])
+				i += size
+				j++
+				continue
+			default:
+				if input[i] == match[j] {
+					i++
+					j++
+					continue
+				}
+			}
+		}
+
+		if star < 0 {
+			return false
+		}
+
+		_, size := utf8.DecodeRuneInString(input[retry:])
+		retry += size
+		i, j = retry, star+1
 	}
 
-	// If a glob, match all.
-	if match == globChar {
-		return true
+	// Trailing globs match nothing.
+	for j < len(match) && match[j] == globAny {
+		j++
 	}
 
-	parts := strings.Split(match, globChar)
+	return j == len(match)
+}
 
-	if len(parts) == 1 {
-		// No globs, test for equality.
-		return input == match
+// DefaultLineLength is a conservative length of text that can be sent in a
+// single PRIVMSG or NOTICE, leaving room for the prefix that the server
+// prepends when relaying the message to others.
+const DefaultLineLength = 400
+
+// Paginate joins items with sep into as few lines as possible, where each
+// line is no longer than max bytes (defaults to DefaultLineLength if max is
+// 0 or less). Items are never split, so items longer than max will be on a
+// line of their own. Useful for sending lists (e.g. from Channel.Members())
+// without exceeding line limits, or flooding.
+func Paginate(items []string, sep string, max int) (lines []string) {
+	if max <= 0 {
+		max = DefaultLineLength
 	}
 
-	leadingGlob, trailingGlob := strings.HasPrefix(match, globChar), strings.HasSuffix(match, globChar)
-	last := len(parts) - 1
+	var line string
+	for i := 0; i < len(items); i++ {
+		if line == "" {
+			line = items[i]
+			continue
+		}
 
-	// Check prefix first.
-	if !leadingGlob && !strings.HasPrefix(input, parts[0]) {
-		return false
+		if len(line)+len(sep)+len(items[i]) > max {
+			lines = append(lines, line)
+			line = items[i]
+			continue
+		}
+
+		line += sep + items[i]
 	}
 
-	// Check middle section.
-	for i := 1; i < last; i++ {
-		if !strings.Contains(input, parts[i]) {
-			return false
-		}
+	if line != "" {
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+// Page returns the items for a given page (starting at 1), with perPage
+// items on each page. pages is the total amount of pages available.
+func Page(items []string, page, perPage int) (out []string, pages int) {
+	if perPage <= 0 || len(items) == 0 {
+		return nil, 0
+	}
+
+	pages = (len(items) + perPage - 1) / perPage
+	if page < 1 || page > pages {
+		return nil, pages
+	}
 
-		// Trim already-evaluated text from input during loop over match
-		// text.
-		idx := strings.Index(input, parts[i]) + len(parts[i])
-		input = input[idx:]
+	start := (page - 1) * perPage
+	end := start + perPage
+	if end > len(items) {
+		end = len(items)
 	}
 
-	// Check suffix last.
-	return trailingGlob || strings.HasSuffix(input, parts[last])
+	return items[start:end], pages
 }
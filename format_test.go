@@ -5,6 +5,7 @@
 package girc
 
 import (
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -74,6 +75,9 @@ func TestFormat(t *testing.T) {
 		{name: "partial", args: args{text: "{redtest{c}"}, want: "{redtest\x03"},
 		{name: "inside", args: args{text: "{re{c}d}test{c}"}, want: "{re\x03d}test\x03"},
 		{name: "nothing", args: args{text: "this is a test."}, want: "this is a test."},
+		{name: "background", args: args{text: "{red,black}test{c}"}, want: "\x0304,01test\x03"},
+		{name: "invalid background", args: args{text: "{red,bold}test"}, want: "{red,bold}test"},
+		{name: "unknown", args: args{text: "{foo}{mono}test{strike}"}, want: "{foo}\x11test\x1e"},
 	}
 
 	for _, tt := range tests {
@@ -131,6 +135,42 @@ func TestStripRaw(t *testing.T) {
 	}
 }
 
+func TestStripFormatRaw(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{text: "\x02bold\x02 \x1ditalic\x1d \x1funderline\x1f \x1estrike\x1e \x11mono\x11 \x16reverse\x0f", want: "bold italic underline strike mono reverse"},
+		{text: "\x034red\x03 \x0304red\x03 \x0304,01red on black\x03", want: "red red red on black"},
+		{text: "\x03100 \x0304,100", want: "0 0"},
+		{text: "\x0304,test \x03,01test", want: ",test ,01test"},
+		{text: "\x04FF0000red\x04 \x04ff0000,00ff00red on green", want: "red red on green"},
+		{text: "trailing\x03", want: "trailing"},
+		{text: "\x01ACTION test\x01", want: "\x01ACTION test\x01"},
+		{text: "nothing", want: "nothing"},
+	}
+
+	for _, tt := range tests {
+		if got := StripFormat(tt.text); got != tt.want {
+			t.Errorf("StripFormat(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestIsValidColorCode(t *testing.T) {
+	for _, code := range []string{"4", "04", "99", "04,01", "4,1"} {
+		if !IsValidColorCode(code) {
+			t.Errorf("IsValidColorCode(%q) = false, want true", code)
+		}
+	}
+
+	for _, code := range []string{"", "100", "a", "04,", ",01", "04,01,02", "-1"} {
+		if IsValidColorCode(code) {
+			t.Errorf("IsValidColorCode(%q) = true, want false", code)
+		}
+	}
+}
+
 func TestIsValidNick(t *testing.T) {
 	type args struct {
 		nick string
@@ -334,6 +374,10 @@ func TestGlob(t *testing.T) {
 		"**********",      // Nothing but globs.
 		"*Ѿ*",             // Unicode.
 		"*is a ϗѾ *",      // Mixed ASCII/unicode.
+		"this?is*",        // Single character.
+		"*a ?? test",      // Single unicode characters.
+		"*?",              // Any single character at the end.
+		"t*s*s*t",         // Backtracking.
 	}
 
 	for _, pattern := range cases {
@@ -348,6 +392,9 @@ func TestGlob(t *testing.T) {
 		"* ",    // Trailing white space.
 		" *",    // Leading white space.
 		"*ʤ*",   // Non-matching unicode.
+		"?",     // Single character.
+		"this?", // Single character, without glob.
+		"*te?",  // Single character after glob.
 	}
 
 	// Non-matches
@@ -357,3 +404,150 @@ func TestGlob(t *testing.T) {
 
 	return
 }
+
+func TestCasefold(t *testing.T) {
+	cases := []struct {
+		casemapping string
+		in          string
+		want        string
+	}{
+		{"", "Nick[]^", "nick{}~"},
+		{"rfc1459", "Nick[]^", "nick{}~"},
+		{"rfc1459-strict", "Nick[]^", "nick{}^"},
+		{"ascii", "Nick[]^", "nick[]^"},
+		{"unknown", "Nick[]^", "nick{}~"},
+	}
+
+	for _, tt := range cases {
+		if got := casefold(tt.casemapping, tt.in); got != tt.want {
+			t.Errorf("casefold(%q, %q) = %q, want %q", tt.casemapping, tt.in, got, tt.want)
+		}
+	}
+
+	if !Equal("Nick[away]", "nick{AWAY}") {
+		t.Error("Equal() = false, want true")
+	}
+
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test"})
+	c.RunHandlers(ParseEvent(":dummy.int 005 test CASEMAPPING=ascii :are supported by this server"))
+
+	if c.Equal("Nick[away]", "nick{away}") {
+		t.Error("Client.Equal() = true with ascii casemapping, want false")
+	}
+
+	if !c.Equal("Nick[away]", "nick[AWAY]") {
+		t.Error("Client.Equal() = false with ascii casemapping, want true")
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	items := []string{"@alice", "@bob", "+carol", "dave", "erin"}
+
+	got := Paginate(items, " ", 12)
+	want := []string{"@alice @bob", "+carol dave", "erin"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Paginate() = %q, want %q", got, want)
+	}
+
+	if got = Paginate([]string{"averylongnickname", "a"}, ", ", 5); !reflect.DeepEqual(got, []string{"averylongnickname", "a"}) {
+		t.Fatalf("Paginate() = %q with long item, unexpected", got)
+	}
+
+	page, pages := Page(items, 2, 2)
+	if pages != 3 || !reflect.DeepEqual(page, []string{"+carol", "dave"}) {
+		t.Fatalf("Page() = %q, %d, want [+carol dave], 3", page, pages)
+	}
+
+	if page, _ = Page(items, 4, 2); page != nil {
+		t.Fatalf("Page() = %q for out of range page, want nil", page)
+	}
+}
+
+func TestExtractURLs(t *testing.T) {
+	cases := []struct {
+		in   string
+		want []string
+	}{
+		{in: "no urls here", want: nil},
+		{in: "see https://example.com/a.", want: []string{"https://example.com/a"}},
+		{in: "(https://example.com/b), and <http://example.org>", want: []string{"https://example.com/b", "http://example.org"}},
+		{in: "https://en.wikipedia.org/wiki/Go_(programming_language)", want: []string{"https://en.wikipedia.org/wiki/Go_(programming_language)"}},
+		{in: "\x02\x0304,12https://example.com/c\x0f is neat", want: []string{"https://example.com/c"}},
+		{in: "\x0399https://example.com/d\x03", want: []string{"https://example.com/d"}},
+		{in: "\x04FF0000https://example.com/e", want: []string{"https://example.com/e"}},
+		{in: "\x01ACTION likes www.example.com/f\x01", want: []string{"http://www.example.com/f"}},
+		{in: "http:// and https:///path", want: nil},
+	}
+
+	for _, tt := range cases {
+		if got := ExtractURLs(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ExtractURLs(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestURLSeen(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test", ExtractURLs: true})
+
+	seen := make(chan Event, 1)
+	c.Handlers.Add(URL_SEEN, func(c *Client, e Event) { seen <- e })
+
+	c.RunHandlers(ParseEvent(":nick!user@host PRIVMSG #channel :hello"))
+	c.RunHandlers(ParseEvent(":nick!user@host PRIVMSG #channel :look at \x02https://example.com\x02!"))
+
+	e := <-seen
+	if e.Source.Name != "nick" || !reflect.DeepEqual(e.Params, []string{"#channel", "https://example.com"}) || e.Trailing != "look at https://example.com!" {
+		t.Fatalf("unexpected URL_SEEN event: %#v", e)
+	}
+
+	select {
+	case e = <-seen:
+		t.Fatalf("unexpected extra URL_SEEN event: %#v", e)
+	default:
+	}
+}
+
+func TestToHTML(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{text: "plain <text> & more", want: "plain &lt;text&gt; &amp; more"},
+		{text: "\x02bold\x02 \x1ditalic\x1d \x1funderline\x1f \x1estrike\x1e \x11mono\x11", want: "<b>bold</b> <i>italic</i> <u>underline</u> <s>strike</s> <code>mono</code>"},
+		{text: "\x02\x1dboth\x0f none", want: "<b><i>both</i></b> none"},
+		{text: "\x0304red\x03 \x034,1red on black\x03", want: `<span style="color: #ff0000">red</span> <span style="color: #ff0000; background-color: #000000">red on black</span>`},
+		{text: "\x04FF8800hex\x04", want: `<span style="color: #ff8800">hex</span>`},
+		{text: "\x0399default", want: "default"},
+		{text: "\x02bold \x0304red\x02 red", want: `<b>bold </b><b><span style="color: #ff0000">red</span></b><span style="color: #ff0000"> red</span>`},
+	}
+
+	for _, tt := range tests {
+		if got := ToHTML(tt.text); got != tt.want {
+			t.Errorf("ToHTML(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestFromHTML(t *testing.T) {
+	tests := []struct {
+		html string
+		want string
+	}{
+		{html: "plain &lt;text&gt; &amp; more", want: "plain <text> & more"},
+		{html: "<b>bold</b> <strong><em>both</em></strong> <u>underline</u> <del>strike</del> <code>mono</code>", want: "\x02bold\x0f \x02\x1dboth\x0f \x1funderline\x0f \x1estrike\x0f \x11mono"},
+		{html: "<b>bold <i>both</i></b>", want: "\x02bold \x1dboth"},
+		{html: `<font color="#ff0000">red</font> <span data-mx-color="#000" data-mx-bg-color="#fff">black on white</span>`, want: "\x0304red\x0f \x0301,00black on white"},
+		{html: `<span style="color: blue; background-color: #fe0000">blue on red</span>0`, want: "\x0302,04blue on red\x0f0"},
+		{html: `<a href="https://example.com">example</a> <a href="https://example.com/">https://example.com/</a>`, want: "example (https://example.com) https://example.com/"},
+		{html: "<p>first\n   paragraph</p><p>second</p>line<br>break", want: "first paragraph\nsecond\nline\nbreak"},
+		{html: "<pre>a\n  b</pre>", want: "\x11a\n  b"},
+		{html: "<mx-reply><blockquote>quoted</blockquote></mx-reply>reply<!-- comment --><script>alert(1)</script>", want: "reply"},
+		{html: `<img src="x.png" alt="image"> <unknown attr="1">kept</unknown>`, want: "image kept"},
+	}
+
+	for _, tt := range tests {
+		if got := FromHTML(tt.html); got != tt.want {
+			t.Errorf("FromHTML(%q) = %q, want %q", tt.html, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import "time"
+
+// HandlerGroup registers handlers under a common name, so they can all be
+// removed at once with Caller.ClearGroup(), without having to track the
+// cuid of each handler. This is useful for plugin-style bots, where each
+// plugin can use its own group:
+//
+//	plugin := c.Handlers.Group("plugin-x")
+//	plugin.Add(girc.PRIVMSG, onMessage)
+//	plugin.Add(girc.JOIN, onJoin)
+//
+//	// Later, when unloading the plugin.
+//	c.Handlers.ClearGroup("plugin-x")
+//
+// Handlers in a group are otherwise regular handlers, and can still be
+// removed individually with Caller.Remove().
+type HandlerGroup struct {
+	name   string
+	caller *Caller
+}
+
+// Group returns the handler group with the given name. Groups don't need to
+// be created beforehand, and calling Group multiple times with the same name
+// refers to the same group.
+func (c *Caller) Group(name string) *HandlerGroup {
+	return &HandlerGroup{name: name, caller: c}
+}
+
+// Name returns the name of the group.
+func (g *HandlerGroup) Name() string {
+	return g.name
+}
+
+// Len returns the amount of handlers currently registered in the group.
+func (g *HandlerGroup) Len() int {
+	g.caller.mu.RLock()
+	defer g.caller.mu.RUnlock()
+
+	return len(g.caller.groups[g.name])
+}
+
+// Clear removes all handlers in the group. See Caller.ClearGroup().
+func (g *HandlerGroup) Clear() int {
+	return g.caller.ClearGroup(g.name)
+}
+
+// add records cuid as part of the group, unless it has been removed in the
+// meantime.
+func (g *HandlerGroup) add(cuid string) string {
+	c := g.caller
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cmd, uid := c.cuidToID(cuid)
+	if _, ok := c.external[cmd][uid]; !ok {
+		return cuid
+	}
+
+	if c.groups == nil {
+		c.groups = map[string]map[string]bool{}
+		c.grouped = map[string]string{}
+	}
+
+	if _, ok := c.groups[g.name]; !ok {
+		c.groups[g.name] = map[string]bool{}
+	}
+
+	c.groups[g.name][cuid] = true
+	c.grouped[cuid] = g.name
+
+	return cuid
+}
+
+// AddHandler is much like Caller.AddHandler(), however registers the
+// handler as part of the group.
+func (g *HandlerGroup) AddHandler(cmd string, handler Handler) (cuid string) {
+	return g.add(g.caller.AddHandler(cmd, handler))
+}
+
+// Add is much like Caller.Add(), however registers the handler as part of
+// the group.
+func (g *HandlerGroup) Add(cmd string, handler func(client *Client, event Event)) (cuid string) {
+	return g.add(g.caller.Add(cmd, handler))
+}
+
+// AddBg is much like Caller.AddBg(), however registers the handler as part
+// of the group.
+func (g *HandlerGroup) AddBg(cmd string, handler func(client *Client, event Event)) (cuid string) {
+	return g.add(g.caller.AddBg(cmd, handler))
+}
+
+// AddLive is much like Caller.AddLive(), however registers the handler as
+// part of the group.
+func (g *HandlerGroup) AddLive(cmd string, handler func(client *Client, event Event)) (cuid string) {
+	return g.add(g.caller.AddLive(cmd, handler))
+}
+
+// AddPriority is much like Caller.AddPriority(), however registers the
+// handler as part of the group.
+func (g *HandlerGroup) AddPriority(cmd string, priority int, handler func(client *Client, event Event)) (cuid string) {
+	return g.add(g.caller.AddPriority(cmd, priority, handler))
+}
+
+// AddTmp is much like Caller.AddTmp(), however registers the handler as
+// part of the group. Clearing the group closes done, like any other manual
+// removal.
+func (g *HandlerGroup) AddTmp(cmd string, deadline time.Duration, handler func(client *Client, event Event) bool) (cuid string, done chan struct{}) {
+	cuid, done = g.caller.AddTmp(cmd, deadline, handler)
+	return g.add(cuid), done
+}
+
+// AddMatch is much like Caller.AddMatch(), however registers the handler as
+// part of the group.
+func (g *HandlerGroup) AddMatch(m Match, handler func(client *Client, event Event)) (cuid string) {
+	return g.add(g.caller.AddMatch(m, handler))
+}
+
+// ClearGroup removes all handlers which were registered through the group
+// with the given name (see Caller.Group()), returning the amount of handlers
+// which were removed.
+func (c *Caller) ClearGroup(name string) (removed int) {
+	c.mu.Lock()
+	for cuid := range c.groups[name] {
+		if c.remove(cuid) {
+			removed++
+		}
+	}
+	delete(c.groups, name)
+	c.mu.Unlock()
+
+	c.logger.Debug("cleared handlers in group", "group", name, "removed", removed)
+
+	return removed
+}
+
+// ungroup removes cuid from the group it was registered in, if any. This is
+// NOT concurrency safe, lock Caller.mu on your own.
+func (c *Caller) ungroup(cuid string) {
+	name, ok := c.grouped[cuid]
+	if !ok {
+		return
+	}
+
+	delete(c.grouped, cuid)
+	delete(c.groups[name], cuid)
+
+	if len(c.groups[name]) == 0 {
+		delete(c.groups, name)
+	}
+}
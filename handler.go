@@ -6,10 +6,10 @@ package girc
 
 import (
 	"fmt"
-	"log"
 	"math/rand"
 	"runtime"
 	"runtime/debug"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -21,24 +21,111 @@ func (c *Client) RunHandlers(event *Event) {
 		return
 	}
 
+	// dispatched is true once the event has been passed on to the external
+	// handlers, see below.
+	var dispatched bool
+
+	c.stats.eventDispatched(event.Command)
+
+	if !event.Replayed {
+		event.Replayed = c.isReplayed(event)
+	}
+
+	if !event.service && event.Source != nil && (event.Command == PRIVMSG || event.Command == NOTICE) {
+		event.service = c.IsService(event.Source)
+	}
+
+	// Make sure annotations are shared between each of the copies passed to
+	// the handlers below.
+	if event.annotations == nil {
+		event.annotations = &annotations{}
+	}
+
+	if c.traces != nil && event.trace == nil {
+		event.trace = c.traces.start(event)
+	}
+
+	if c.Config.Tracer != nil && event.span == nil {
+		read := event.read
+		if read.IsZero() {
+			read = time.Now()
+		}
+
+		event.span = c.Config.Tracer.StartEvent(event, read)
+		defer func(span EventSpan) { span.End(!dispatched) }(event.span)
+	}
+
 	// Log the event.
-	c.debug.Print("< " + StripRaw(event.String()))
+	c.logger.Debug("received", "event", StripRaw(event.String()))
 	if c.Config.Out != nil {
 		if pretty, ok := event.Pretty(); ok {
 			fmt.Fprintln(c.Config.Out, StripRaw(pretty))
 		}
 	}
 
+	// Middleware (see Caller.Use()) decides if the event reaches the
+	// external handlers. Internal handlers always receive the original
+	// event, so tracking stays accurate, whatever the middleware does with
+	// its copy. Without middleware, there's nothing to decide, so the event
+	// doesn't need to be copied for it.
+	if !c.Handlers.hasMiddleware() {
+		dispatched = true
+		c.dispatch(event, true, true)
+	} else {
+		c.dispatch(event, true, false)
+
+		func() {
+			// If they want to catch any panics, add to defer stack.
+			if c.Config.RecoverFunc != nil {
+				defer recoverHandlerPanic(c, event, "middleware", 3)
+			}
+
+			// External handlers receive the event at most once, even if
+			// the middleware calls next more than once.
+			var once sync.Once
+			c.Handlers.chain(HandlerFunc(func(client *Client, e Event) {
+				once.Do(func() {
+					dispatched = true
+					c.dispatch(&e, false, true)
+				})
+			})).Execute(c, *event.Copy())
+		}()
+	}
+
+	if event.trace != nil && !dispatched {
+		event.trace.mu.Lock()
+		event.trace.trace.Dropped = true
+		event.trace.mu.Unlock()
+	}
+}
+
+// dispatch executes the handlers for event: internal handlers if internal
+// is true, and external handlers and CTCP handlers if external is true.
+// event isn't modified, see Caller.exec().
+func (c *Client) dispatch(event *Event, internal, external bool) {
 	// Regular wildcard handlers.
-	c.Handlers.exec(ALL_EVENTS, c, event.Copy())
+	c.Handlers.exec(ALL_EVENTS, internal, external, c, event)
 
 	// Then regular handlers.
-	c.Handlers.exec(event.Command, c, event.Copy())
+	c.Handlers.exec(event.Command, internal, external, c, event)
+
+	// And handlers for classes of numerics, see ALL_REPLIES and ALL_ERRORS.
+	for _, class := range c.Handlers.matchClasses(event.Command) {
+		c.Handlers.exec(class, internal, external, c, event)
+	}
+
+	if !external {
+		return
+	}
 
 	// Check if it's a CTCP.
-	if ctcp := decodeCTCP(event.Copy()); ctcp != nil {
+	if ctcp := decodeCTCP(event); ctcp != nil {
+		// CTCP handlers may modify the event, so they get their own copy.
+		ctcp.Origin = event.Copy()
+		ctcp.Source = ctcp.Origin.Source
+
 		// Execute it.
-		c.CTCP.call(c, ctcp)
+		c.CTCP.handle(c, ctcp)
 	}
 }
 
@@ -70,16 +157,32 @@ type Caller struct {
 	external map[string]map[string]Handler
 	// internal is a map of internally used handlers for the client.
 	internal map[string]map[string]Handler
-	// debug is the clients logger used for debugging.
-	debug *log.Logger
+	// recordings are the events recorded for handlers added with
+	// AddRecorded(), keyed by name.
+	recordings map[string]*recording
+	// middleware wraps the dispatch of each event to external handlers, see
+	// Caller.Use().
+	middleware []func(next Handler) Handler
+	// groups are the cuids of handlers registered through a HandlerGroup,
+	// keyed by group name, and grouped maps each of those cuids back to its
+	// group. See Caller.Group().
+	groups  map[string]map[string]bool
+	grouped map[string]string
+	// named maps the names of handlers added with AddNamed() to their cuid.
+	named map[string]string
+	// classes are the classes of numerics handlers have been registered
+	// for, see ALL_REPLIES and ALL_ERRORS.
+	classes map[string]numericClass
+	// logger is the logger of the client, see Config.Logger.
+	logger Logger
 }
 
 // newCaller creates and initializes a new handler.
-func newCaller(debugOut *log.Logger) *Caller {
+func newCaller(logger Logger) *Caller {
 	c := &Caller{
 		external: map[string]map[string]Handler{},
 		internal: map[string]map[string]Handler{},
-		debug:    debugOut,
+		logger:   logger,
 	}
 
 	return c
@@ -154,53 +257,133 @@ func (c *Caller) cuidToID(input string) (cmd, uid string) {
 
 type execStack struct {
 	Handler
-	cuid string
+	cuid     string
+	priority int
+	internal bool
 }
 
-// exec executes all handlers pertaining to specified event. Internal first,
-// then external.
+// handlerPriority returns the priority of handler, see Caller.AddPriority().
+func handlerPriority(handler Handler) int {
+	if p, ok := handler.(priorityHandler); ok {
+		return p.priority
+	}
+
+	return 0
+}
+
+// exec executes all handlers pertaining to specified event. Internal first
+// (if internal is true), then external (if external is true).
+//
+// Handlers are executed in order of priority (see Caller.AddPriority()),
+// highest first. Please note that there is no specific order for which
+// handlers with the same priority are executed, as they are executed
+// concurrently.
 //
-// Please note that there is no specific order/priority for which the
-// handler types themselves or the handlers are executed.
-func (c *Caller) exec(command string, client *Client, event *Event) {
+// Internal handlers don't modify the event, so they share it. External
+// handlers may, so they receive a copy, which is only made if there are any,
+// to keep events cheap to dispatch when most of them have no handlers.
+func (c *Caller) exec(command string, internal, external bool, client *Client, event *Event) {
 	// Build a stack of handlers which can be executed concurrently.
 	var stack []execStack
 
 	c.mu.RLock()
-	// Get internal handlers first.
-	if _, ok := c.internal[command]; ok {
+	// Get internal handlers first. Replayed history shouldn't affect the
+	// current state, with the exception of nested batches.
+	if _, ok := c.internal[command]; ok && internal && (!event.Replayed || command == BATCH) {
 		for cuid := range c.internal[command] {
-			stack = append(stack, execStack{c.internal[command][cuid], cuid})
+			stack = append(stack, execStack{c.internal[command][cuid], cuid, 0, true})
 		}
 	}
 
 	// Aaand then external handlers.
-	if _, ok := c.external[command]; ok {
+	if _, ok := c.external[command]; ok && external {
 		for cuid := range c.external[command] {
-			stack = append(stack, execStack{c.external[command][cuid], cuid})
+			if _, live := c.external[command][cuid].(liveHandler); live && event.Replayed {
+				if event.trace != nil || event.span != nil {
+					event.traceHandler(HandlerTrace{CUID: command + ":" + cuid, Command: command, Start: time.Now(), Outcome: TraceSkipped})
+				}
+				continue
+			}
+
+			stack = append(stack, execStack{c.external[command][cuid], cuid, handlerPriority(c.external[command][cuid]), false})
 		}
 	}
 	c.mu.RUnlock()
 
+	if len(stack) == 0 {
+		return
+	}
+
+	copied := event
+	for i := 0; i < len(stack); i++ {
+		if !stack[i].internal {
+			copied = event.Copy()
+			break
+		}
+	}
+
+	sort.SliceStable(stack, func(i, j int) bool { return stack[i].priority > stack[j].priority })
+
+	// Run handlers of the same priority concurrently, one priority after
+	// the other.
+	for start := 0; start < len(stack); {
+		end := start + 1
+		for end < len(stack) && stack[end].priority == stack[start].priority {
+			end++
+		}
+
+		c.execConcurrent(command, client, event, copied, stack, start, end)
+		start = end
+	}
+}
+
+// execConcurrent runs stack[start:end] concurrently across the same event.
+// Internal handlers receive event, and external handlers copied.
+func (c *Caller) execConcurrent(command string, client *Client, event, copied *Event, stack []execStack, start, end int) {
 	// Run all handlers concurrently across the same event. This should
 	// still help prevent mis-ordered events, while speeding up the
 	// execution speed.
 	var wg sync.WaitGroup
-	wg.Add(len(stack))
-	for i := 0; i < len(stack); i++ {
+	wg.Add(end - start)
+	for i := start; i < end; i++ {
 		go func(index int) {
-			c.debug.Printf("executing handler %s for event %s (%d of %d)", stack[index].cuid, command, index+1, len(stack))
+			// Deferred first, so it's still called after a recovered panic.
+			defer wg.Done()
+
+			c.logger.Debug("executing handler", "cuid", stack[index].cuid, "command", command, "index", index+1, "handlers", len(stack))
 			start := time.Now()
 
+			// Recorded after a panic has been recovered below, see
+			// Config.TraceHandlers and Config.Tracer.
+			outcome := TracePanicked
+			if event.trace != nil || event.span != nil {
+				defer func() {
+					event.traceHandler(HandlerTrace{
+						CUID:     command + ":" + stack[index].cuid,
+						Command:  command,
+						Internal: stack[index].internal,
+						Priority: stack[index].priority,
+						Start:    start,
+						Duration: time.Since(start),
+						Outcome:  outcome,
+					})
+				}()
+			}
+
 			// If they want to catch any panics, add to defer stack.
 			if client.Config.RecoverFunc != nil {
 				defer recoverHandlerPanic(client, event, stack[index].cuid, 3)
 			}
 
-			stack[index].Execute(client, *event)
+			if stack[index].internal {
+				stack[index].Execute(client, *event)
+			} else {
+				stack[index].Execute(client, *copied)
+			}
+			outcome = TraceDone
+			client.stats.handlerExecuted(command, time.Since(start))
 
-			c.debug.Printf("execution of %s took %s (%d of %d)", stack[index].cuid, time.Since(start), index+1, len(stack))
-			wg.Done()
+			c.logger.Debug("executed handler", "cuid", stack[index].cuid, "duration", time.Since(start), "index", index+1, "handlers", len(stack))
 		}(i)
 	}
 
@@ -209,14 +392,68 @@ func (c *Caller) exec(command string, client *Client, event *Event) {
 	wg.Wait()
 }
 
+// Use adds middleware, which is executed for every incoming event before
+// any external handlers (including CTCP handlers), and is useful for
+// cross-cutting concerns like logging, metrics, ignore lists, or panic
+// recovery. The middleware is given the next handler in the chain, and
+// returns the handler to use in its place, which should call
+// next.Execute() for the event to reach the external handlers (possibly
+// with a modified event). Middleware which doesn't call next drops the
+// event, however internal handlers (e.g. for state tracking) still receive
+// it. next must be called before the returned handler returns. Middleware
+// is executed in the order it was added, i.e. the first middleware added
+// is the outermost.
+//
+// For example, to ignore a user:
+//
+//	client.Handlers.Use(func(next girc.Handler) girc.Handler {
+//		return girc.HandlerFunc(func(c *girc.Client, e girc.Event) {
+//			if e.Source != nil && e.Source.Name == "spammer" {
+//				return
+//			}
+//			next.Execute(c, e)
+//		})
+//	})
+func (c *Caller) Use(middleware func(next Handler) Handler) {
+	c.mu.Lock()
+	c.middleware = append(c.middleware, middleware)
+	c.mu.Unlock()
+}
+
+// hasMiddleware returns true if any middleware was added, see Caller.Use().
+func (c *Caller) hasMiddleware() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return len(c.middleware) > 0
+}
+
+// chain wraps handler with all middleware, see Caller.Use().
+func (c *Caller) chain(handler Handler) Handler {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for i := len(c.middleware) - 1; i >= 0; i-- {
+		handler = c.middleware[i](handler)
+	}
+
+	return handler
+}
+
 // ClearAll clears all external handlers currently setup within the client.
 // This ignores internal handlers.
 func (c *Caller) ClearAll() {
 	c.mu.Lock()
+	for _, handlers := range c.external {
+		finishTmp(handlers)
+	}
 	c.external = map[string]map[string]Handler{}
+	c.groups = nil
+	c.grouped = nil
+	c.named = nil
 	c.mu.Unlock()
 
-	c.debug.Print("cleared all external handlers")
+	c.logger.Debug("cleared all external handlers")
 }
 
 // clearInternal clears all internal handlers currently setup within the
@@ -226,7 +463,7 @@ func (c *Caller) clearInternal() {
 	c.internal = map[string]map[string]Handler{}
 	c.mu.Unlock()
 
-	c.debug.Print("cleared all internal handlers")
+	c.logger.Debug("cleared all internal handlers")
 }
 
 // Clear clears all of the handlers for the given event.
@@ -236,11 +473,16 @@ func (c *Caller) Clear(cmd string) {
 
 	c.mu.Lock()
 	if _, ok := c.external[cmd]; ok {
+		finishTmp(c.external[cmd])
+		for uid := range c.external[cmd] {
+			c.ungroup(cmd + ":" + uid)
+			c.unname(cmd + ":" + uid)
+		}
 		delete(c.external, cmd)
 	}
 	c.mu.Unlock()
 
-	c.debug.Printf("cleared external handlers for %s", cmd)
+	c.logger.Debug("cleared external handlers", "command", cmd)
 }
 
 // Remove removes the handler with cuid from the handler stack. success
@@ -272,8 +514,14 @@ func (c *Caller) remove(cuid string) (success bool) {
 		return false
 	}
 
+	if tmp, ok := c.external[cmd][uid].(*tmpHandler); ok {
+		tmp.finish()
+	}
+
 	delete(c.external[cmd], uid)
-	c.debug.Printf("removed handler %s", cuid)
+	c.ungroup(cuid)
+	c.unname(cuid)
+	c.logger.Debug("removed handler", "cuid", cuid)
 
 	// Assume success.
 	return true
@@ -295,6 +543,7 @@ func (c *Caller) register(internal bool, cmd string, handler Handler) (cuid stri
 	var uid string
 
 	cmd = strings.ToUpper(cmd)
+	c.registerClass(cmd)
 
 	if internal {
 		if _, ok := c.internal[cmd]; !ok {
@@ -314,7 +563,7 @@ func (c *Caller) register(internal bool, cmd string, handler Handler) (cuid stri
 
 	_, file, line, _ := runtime.Caller(3)
 
-	c.debug.Printf("registering handler for %q with cuid %q (internal: %t) from: %s:%d", cmd, cuid, internal, file, line)
+	c.logger.Debug("registering handler", "command", cmd, "cuid", cuid, "internal", internal, "caller", fmt.Sprintf("%s:%d", file, line))
 
 	return cuid
 }
@@ -326,6 +575,48 @@ func (c *Caller) AddHandler(cmd string, handler Handler) (cuid string) {
 	return c.sregister(false, cmd, handler)
 }
 
+// AddNamed registers the handler function for the given event under a
+// user-chosen name, replacing any handler previously added with the same
+// name (even if it was for a different event). This allows code which is
+// reloaded to replace its handlers deterministically, rather than
+// accumulating duplicates. cuid is "<COMMAND>:<name>", and can be used to
+// remove the handler with Caller.Remove().
+func (c *Caller) AddNamed(name, cmd string, handler func(client *Client, event Event)) (cuid string) {
+	cmd = strings.ToUpper(cmd)
+	cuid = cmd + ":" + name
+
+	c.mu.Lock()
+	if old, ok := c.named[name]; ok {
+		c.remove(old)
+	}
+
+	if _, ok := c.external[cmd]; !ok {
+		c.external[cmd] = map[string]Handler{}
+	}
+
+	c.external[cmd][name] = HandlerFunc(handler)
+	c.registerClass(cmd)
+
+	if c.named == nil {
+		c.named = map[string]string{}
+	}
+	c.named[name] = cuid
+	c.mu.Unlock()
+
+	c.logger.Debug("registering named handler", "command", cmd, "cuid", cuid)
+
+	return cuid
+}
+
+// unname forgets the name of the handler with cuid, if it was added with
+// AddNamed(). This is NOT concurrency safe, lock Caller.mu on your own.
+func (c *Caller) unname(cuid string) {
+	_, uid := c.cuidToID(cuid)
+	if c.named[uid] == cuid {
+		delete(c.named, uid)
+	}
+}
+
 // Add registers the handler function for the given event. cuid is the
 // handler uid which can be used to remove the handler with Caller.Remove().
 func (c *Caller) Add(cmd string, handler func(client *Client, event Event)) (cuid string) {
@@ -339,17 +630,166 @@ func (c *Caller) AddBg(cmd string, handler func(client *Client, event Event)) (c
 	return c.sregister(false, cmd, HandlerFunc(func(client *Client, event Event) {
 		// Setting up background-based handlers this way allows us to get
 		// clean call stacks for use with panic recovery.
-		go func() {
+		client.goBg(true, func() {
 			// If they want to catch any panics, add to defer stack.
 			if client.Config.RecoverFunc != nil {
 				defer recoverHandlerPanic(client, &event, "goroutine", 3)
 			}
 
 			handler(client, event)
-		}()
+		})
 	}))
 }
 
+// goBg runs fn in a goroutine. If the amount of background handlers is
+// limited (see Config.HandlerWorkers) and limited is true, fn is queued
+// until a worker is free instead, without blocking the caller.
+func (c *Client) goBg(limited bool, fn func()) {
+	c.bgStart()
+	if c.bgWorkers == nil || !limited {
+		go func() {
+			defer c.bgDone()
+			fn()
+		}()
+		return
+	}
+
+	if fn = c.bgWorkers.take(fn); fn != nil {
+		go c.bgWorker(fn)
+	}
+}
+
+// bgWorker runs fn, and then any queued background handlers, until there
+// are none left, see Client.goBg().
+func (c *Client) bgWorker(fn func()) {
+	for fn != nil {
+		func() {
+			defer c.bgDone()
+			fn()
+		}()
+
+		fn = c.bgWorkers.next()
+	}
+}
+
+// bgStart records a background handler being started, see Client.goBg().
+func (c *Client) bgStart() {
+	c.mu.Lock()
+	if c.bgRunning == 0 {
+		c.bgIdle = make(chan struct{})
+	}
+	c.bgRunning++
+	c.mu.Unlock()
+}
+
+// bgDone records a background handler having returned, see Client.goBg().
+func (c *Client) bgDone() {
+	c.mu.Lock()
+	c.bgRunning--
+	if c.bgRunning == 0 {
+		close(c.bgIdle)
+	}
+	c.mu.Unlock()
+}
+
+// bgWait returns a channel which is closed once no background handlers are
+// running, see Client.Shutdown().
+func (c *Client) bgWait() <-chan struct{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.bgRunning == 0 {
+		idle := make(chan struct{})
+		close(idle)
+		return idle
+	}
+
+	return c.bgIdle
+}
+
+// workerPool limits the amount of background handlers running at once, see
+// Config.HandlerWorkers.
+type workerPool struct {
+	mu      sync.Mutex
+	limit   int
+	running int
+	queued  []func()
+}
+
+// take returns fn if a worker is free, which the caller should start, or
+// queues fn and returns nil otherwise.
+func (p *workerPool) take(fn func()) func() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.running >= p.limit {
+		p.queued = append(p.queued, fn)
+		return nil
+	}
+
+	p.running++
+	return fn
+}
+
+// next returns the next queued function for a worker which has finished,
+// or nil (freeing the worker) if there are none.
+func (p *workerPool) next() func() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.queued) == 0 {
+		p.running--
+		return nil
+	}
+
+	fn := p.queued[0]
+	p.queued[0] = nil
+	p.queued = p.queued[1:]
+	return fn
+}
+
+// liveHandler wraps a handler which should only receive live traffic. See
+// Caller.AddLive().
+type liveHandler struct {
+	Handler
+}
+
+// AddLive registers the handler function for the given event, much like
+// Caller.Add(), however the handler will not be executed for events which
+// are replayed history (e.g. from chathistory or bouncer playback, see
+// Event.Replayed). This is useful for handlers which trigger commands, so
+// they don't respond to old messages, while loggers can still use
+// Caller.Add() to receive everything. cuid is the handler uid which can be
+// used to remove the handler with Caller.Remove().
+func (c *Caller) AddLive(cmd string, handler func(client *Client, event Event)) (cuid string) {
+	return c.sregister(false, cmd, liveHandler{HandlerFunc(handler)})
+}
+
+// priorityHandler wraps a handler with an execution priority. See
+// Caller.AddPriority().
+type priorityHandler struct {
+	Handler
+	priority int
+}
+
+// AddPriority registers the handler function for the given event, much like
+// Caller.Add(), however with a priority. Handlers for an event are executed
+// in order of priority, highest first, and a handler isn't executed until
+// all handlers with a higher priority have returned. Handlers added with
+// Caller.Add() (and the internal handlers used for tracking) have a
+// priority of 0, so a positive priority can be used for handlers which must
+// run first (e.g. filtering or authorization), and a negative priority for
+// handlers which must run last. Handlers with the same priority are
+// executed concurrently. cuid is the handler uid which can be used to
+// remove the handler with Caller.Remove().
+func (c *Caller) AddPriority(cmd string, priority int, handler func(client *Client, event Event)) (cuid string) {
+	if priority == 0 {
+		return c.sregister(false, cmd, HandlerFunc(handler))
+	}
+
+	return c.sregister(false, cmd, priorityHandler{HandlerFunc(handler), priority})
+}
+
 // AddTmp adds a "temporary" handler, which is good for one-time or few-time
 // uses. This supports a deadline and/or manual removal, as this differs
 // much from how normal handlers work. An example of a good use for this
@@ -365,50 +805,111 @@ func (c *Caller) AddBg(cmd string, handler func(client *Client, event Event)) (c
 // This is useful in that it ensures that the handler is cleaned up if the
 // server does not respond appropriately, or takes too long to respond.
 //
+// done is closed once the handler has been removed, either because it
+// returned true, the deadline passed, or it was removed manually (e.g. with
+// Caller.Remove() or Caller.Clear()), which makes it easy to wait for
+// one-time events:
+//
+//	_, done := c.Handlers.AddTmp(RPL_WELCOME, 30*time.Second, func(c *girc.Client, e girc.Event) bool {
+//		return true
+//	})
+//	<-done
+//
 // Note that handlers supplied with AddTmp are executed in a goroutine to
-// ensure that they are not blocking other handlers. Additionally, use cuid
-// with Caller.Remove() to prematurely remove the handler from the stack,
+// ensure that they are not blocking other handlers, one event at a time if
+// multiple events arrive at once. Once the handler has returned true, it is
+// not executed again. Additionally, use cuid with
+// Caller.Remove() to prematurely remove the handler from the stack,
 // bypassing the timeout or waiting for the handler to return that it wants
 // to be removed from the stack.
 func (c *Caller) AddTmp(cmd string, deadline time.Duration, handler func(client *Client, event Event) bool) (cuid string, done chan struct{}) {
-	var uid string
-	cuid, uid = c.cuid(cmd, 20)
-
-	done = make(chan struct{})
+	tmp := &tmpHandler{fn: handler, done: make(chan struct{})}
 
 	c.mu.Lock()
-	if _, ok := c.external[cmd]; !ok {
-		c.external[cmd] = map[string]Handler{}
-	}
-	c.external[cmd][uid] = HandlerFunc(func(client *Client, event Event) {
-		// Setting up background-based handlers this way allows us to get
-		// clean call stacks for use with panic recovery.
-		go func() {
-			// If they want to catch any panics, add to defer stack.
-			if client.Config.RecoverFunc != nil {
-				defer recoverHandlerPanic(client, &event, "tmp-goroutine", 3)
-			}
-
-			remove := handler(client, event)
-			if remove {
-				if ok := c.Remove(cuid); ok {
-					close(done)
-				}
-			}
-		}()
-	})
+	cuid = c.register(false, cmd, tmp)
+	tmp.remove = func() { c.Remove(cuid) }
 	c.mu.Unlock()
 
 	if deadline > 0 {
 		go func() {
-			<-time.After(deadline)
-			if ok := c.Remove(cuid); ok {
-				close(done)
+			timer := time.NewTimer(deadline)
+			defer timer.Stop()
+
+			select {
+			case <-timer.C:
+				c.Remove(cuid)
+			case <-tmp.done:
 			}
 		}()
 	}
 
-	return cuid, done
+	return cuid, tmp.done
+}
+
+// tmpHandler is a handler registered with Caller.AddTmp().
+type tmpHandler struct {
+	fn     func(client *Client, event Event) bool
+	remove func()
+	done   chan struct{}
+	once   sync.Once
+
+	// run is held while fn runs, so it doesn't run again (e.g. for
+	// another event received at the same time) once it returned true.
+	run      sync.Mutex
+	mu       sync.RWMutex
+	finished bool
+}
+
+// Execute runs the handler in the background, removing it once it returns
+// true.
+func (h *tmpHandler) Execute(client *Client, event Event) {
+	// Setting up background-based handlers this way allows us to get
+	// clean call stacks for use with panic recovery. They usually wait for
+	// a later event (e.g. Client.Do()), so they aren't limited by
+	// Config.HandlerWorkers, as they could otherwise never receive it.
+	client.goBg(false, func() {
+		// If they want to catch any panics, add to defer stack.
+		if client.Config.RecoverFunc != nil {
+			defer recoverHandlerPanic(client, &event, "tmp-goroutine", 3)
+		}
+
+		h.run.Lock()
+		defer h.run.Unlock()
+
+		h.mu.RLock()
+		finished := h.finished
+		h.mu.RUnlock()
+
+		if finished {
+			return
+		}
+
+		if h.fn(client, event) {
+			h.remove()
+		}
+	})
+}
+
+// finish marks the handler as removed, closing done. Safe to call multiple
+// times.
+func (h *tmpHandler) finish() {
+	h.once.Do(func() {
+		h.mu.Lock()
+		h.finished = true
+		h.mu.Unlock()
+
+		close(h.done)
+	})
+}
+
+// finishTmp finishes all temporary handlers in handlers, which are about to
+// be removed.
+func finishTmp(handlers map[string]Handler) {
+	for _, handler := range handlers {
+		if tmp, ok := handler.(*tmpHandler); ok {
+			tmp.finish()
+		}
+	}
 }
 
 // recoverHandlerPanic is used to catch all handler panics, and re-route
@@ -470,15 +971,21 @@ func (e *HandlerError) String() string {
 }
 
 // DefaultRecoverHandler can be used with Config.RecoverFunc as a default
-// catch-all for panics. This will log the error, and the call trace to the
-// debug log (see Config.Debug), or os.Stdout if Config.Debug is unset.
+// catch-all for panics. This will log the error, the event which triggered
+// it (unless sensitive), and the call trace as an error (see Config.Logger
+// and Config.Debug), or to os.Stdout if neither are set.
 func DefaultRecoverHandler(client *Client, err *HandlerError) {
-	if client.Config.Debug == nil {
+	event := "***redacted***"
+	if !err.Event.Sensitive {
+		event = StripRaw(err.Event.String())
+	}
+
+	if client.Config.Logger == nil && client.Config.Debug == nil {
 		fmt.Println(err.Error())
+		fmt.Println("event: " + event)
 		fmt.Println(err.String())
 		return
 	}
 
-	client.debug.Println(err.Error())
-	client.debug.Println(err.String())
+	client.logger.Error("recovered from panic in handler", "error", err.Error(), "event", event, "stack", string(err.Stack))
 }
@@ -0,0 +1,168 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"sort"
+	"time"
+)
+
+// maxMemberHistory is the maximum amount of membership changes remembered
+// per channel, see Client.DiffChannel().
+const maxMemberHistory = 1000
+
+// Kinds of membership changes.
+const (
+	memberJoined = iota
+	memberLeft
+	memberRenamed
+)
+
+// memberChange is a single change to the members of a channel.
+type memberChange struct {
+	at   time.Time
+	kind int
+	nick string
+	// to is the new nickname, if renamed.
+	to string
+}
+
+// recordMember records a change to the members of the channel, dropping the
+// oldest change if the history is full.
+func (ch *Channel) recordMember(kind int, nick, to string) {
+	if len(ch.history) >= maxMemberHistory {
+		ch.historyStart = ch.history[0].at
+		ch.history = ch.history[1:]
+	}
+
+	ch.history = append(ch.history, memberChange{at: time.Now(), kind: kind, nick: nick, to: to})
+}
+
+// ChannelDiff is the difference between the members of a channel at some
+// point in time, and now. See Client.DiffChannel().
+type ChannelDiff struct {
+	// Joined are the (current) nicknames of the users who joined the
+	// channel, and are still in it.
+	Joined []string `json:"joined"`
+	// Parted are the nicknames of the users who were in the channel, and
+	// have since left (parted, were kicked, or quit). Nicknames are the
+	// ones they had at the time the diff was from.
+	Parted []string `json:"parted"`
+	// Renamed are the users who were in the channel, and still are, however
+	// have changed their nickname, mapping their old nickname to the
+	// current one.
+	Renamed map[string]string `json:"renamed"`
+	// Complete is false if the history of the channel doesn't go back far
+	// enough (e.g. the client joined the channel after the point in time,
+	// or there were too many changes since), in which case changes before
+	// the history starts are missing.
+	Complete bool `json:"complete"`
+}
+
+// DiffChannel returns how the members of channel changed since the given
+// time, based on the tracked history of the channel, or nil if the client
+// isn't in channel. Users who joined and left (or left and rejoined) in the
+// meantime are not included, and users who changed nickname are tracked
+// across nickname changes. Always nil if tracking is disabled.
+func (c *Client) DiffChannel(channel string, since time.Time) *ChannelDiff {
+	c.state.RLock()
+	defer c.state.RUnlock()
+
+	ch := c.state.lookupChannel(channel)
+	if ch == nil {
+		return nil
+	}
+
+	start := ch.historyStart
+	if start.IsZero() {
+		start = ch.Joined
+	}
+
+	fold := func(nick string) string { return casefold(ch.casemapping, nick) }
+
+	// joined are the users who joined since, keyed by their folded current
+	// nickname. parted are the users who left, keyed by the folded nickname
+	// they had at since. renamed maps the folded current nickname of users
+	// who were there at since, to the nickname they had at since.
+	joined := map[string]string{}
+	parted := map[string]string{}
+	renamed := map[string]string{}
+
+	for _, change := range ch.history {
+		if !change.at.After(since) {
+			continue
+		}
+
+		nick := fold(change.nick)
+
+		switch change.kind {
+		case memberJoined:
+			if _, ok := parted[nick]; ok {
+				// They left and came back.
+				delete(parted, nick)
+				continue
+			}
+
+			joined[nick] = change.nick
+		case memberLeft:
+			if _, ok := joined[nick]; ok {
+				delete(joined, nick)
+				continue
+			}
+
+			if old, ok := renamed[nick]; ok {
+				delete(renamed, nick)
+				parted[fold(old)] = old
+				continue
+			}
+
+			parted[nick] = change.nick
+		case memberRenamed:
+			to := fold(change.to)
+
+			if _, ok := joined[nick]; ok {
+				delete(joined, nick)
+				joined[to] = change.to
+				continue
+			}
+
+			old, ok := renamed[nick]
+			if !ok {
+				old = change.nick
+			}
+			delete(renamed, nick)
+
+			if fold(old) != to {
+				renamed[to] = old
+			}
+		}
+	}
+
+	diff := &ChannelDiff{
+		Joined:   []string{},
+		Parted:   []string{},
+		Renamed:  map[string]string{},
+		Complete: !since.Before(start),
+	}
+
+	for key := range joined {
+		// Use the current nickname, as it may have changed case.
+		diff.Joined = append(diff.Joined, joined[key])
+	}
+	for key := range parted {
+		diff.Parted = append(diff.Parted, parted[key])
+	}
+	for key, old := range renamed {
+		diff.Renamed[old] = key
+		if user := c.state.lookupUser(key); user != nil {
+			diff.Renamed[old] = user.Nick
+		}
+	}
+
+	sort.Strings(diff.Joined)
+	sort.Strings(diff.Parted)
+
+	return diff
+}
@@ -0,0 +1,518 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ircColors are the RGB values of the IRC color codes 0 through 98, see
+// https://modern.ircdocs.horse/formatting.html#colors.
+var ircColors = [...]string{
+	"ffffff", "000000", "00007f", "009300", "ff0000", "7f0000", "9c009c", "fc7f00",
+	"ffff00", "00fc00", "009393", "00ffff", "0000fc", "ff00ff", "7f7f7f", "d2d2d2",
+	"470000", "472100", "474700", "324700", "004700", "00472c", "004747", "002747", "000047", "2e0047", "470047", "47002a",
+	"740000", "743a00", "747400", "517400", "007400", "007449", "007474", "004074", "000074", "4b0074", "740074", "740045",
+	"b50000", "b56300", "b5b500", "7db500", "00b500", "00b571", "00b5b5", "0063b5", "0000b5", "7500b5", "b500b5", "b5006b",
+	"ff0000", "ff8c00", "ffff00", "b2ff00", "00ff00", "00ffa0", "00ffff", "008cff", "0000ff", "a500ff", "ff00ff", "ff0098",
+	"ff5959", "ffb459", "ffff71", "cfff60", "6fff6f", "65ffc9", "6dffff", "59b4ff", "5959ff", "c459ff", "ff66ff", "ff59bc",
+	"ff9c9c", "ffd39c", "ffff9c", "e2ff9c", "9cff9c", "9cffdb", "9cffff", "9cd3ff", "9c9cff", "dc9cff", "ff9cff", "ff94d3",
+	"000000", "131313", "282828", "363636", "4d4d4d", "656565", "818181", "9f9f9f", "bcbcbc", "e2e2e2", "ffffff",
+}
+
+// textStyle is the formatting of a span of text, see ToHTML() and
+// FromHTML(). Colors are RGB hex values, e.g. "ff0000".
+type textStyle struct {
+	bold, italic, underline, strike, mono bool
+	fg, bg                                string
+}
+
+// htmlTags returns the opening and closing HTML tags for s.
+func (s textStyle) htmlTags() (open, close string) {
+	tags := []struct {
+		on  bool
+		tag string
+	}{{s.bold, "b"}, {s.italic, "i"}, {s.underline, "u"}, {s.strike, "s"}, {s.mono, "code"}}
+
+	for _, t := range tags {
+		if t.on {
+			open += "<" + t.tag + ">"
+			close = "</" + t.tag + ">" + close
+		}
+	}
+
+	if s.fg != "" || s.bg != "" {
+		var style []string
+		if s.fg != "" {
+			style = append(style, "color: #"+s.fg)
+		}
+		if s.bg != "" {
+			style = append(style, "background-color: #"+s.bg)
+		}
+
+		open += `<span style="` + strings.Join(style, "; ") + `">`
+		close = "</span>" + close
+	}
+
+	return open, close
+}
+
+// ircCodes returns the IRC formatting codes which apply s, from no
+// formatting.
+func (s textStyle) ircCodes() string {
+	var out string
+	if s.bold {
+		out += string(fmtBold)
+	}
+	if s.italic {
+		out += string(fmtItalic)
+	}
+	if s.underline {
+		out += string(fmtUnderline)
+	}
+	if s.strike {
+		out += string(fmtStrikethrough)
+	}
+	if s.mono {
+		out += string(fmtMonospace)
+	}
+
+	if s.fg != "" || s.bg != "" {
+		// Color codes are always two digits, so text starting with digits
+		// isn't mistaken for part of the code.
+		fg := nearestIRCColor(s.fg)
+		if fg < 0 {
+			fg = 99 // The default color.
+		}
+		out += fmt.Sprintf("%c%02d", fmtColor, fg)
+
+		if bg := nearestIRCColor(s.bg); bg > -1 {
+			out += fmt.Sprintf(",%02d", bg)
+		}
+	}
+
+	return out
+}
+
+// ircColor returns the RGB value of the IRC color code, or an empty string
+// if it's unknown (e.g. 99, the default color).
+func ircColor(code string) string {
+	n, err := strconv.Atoi(code)
+	if err != nil || n < 0 || n >= len(ircColors) {
+		return ""
+	}
+
+	return ircColors[n]
+}
+
+// hexColor returns the RGB value of an IRC hex color code (see
+// fmtHexColor), or an empty string if it's invalid.
+func hexColor(code string) string {
+	if len(code) != 6 {
+		return ""
+	}
+
+	return strings.ToLower(code)
+}
+
+// nearestIRCColor returns the standard IRC color code (0 through 15) which
+// is closest to the RGB hex value rgb, or -1 if rgb is empty or invalid.
+func nearestIRCColor(rgb string) int {
+	value, err := strconv.ParseUint(rgb, 16, 32)
+	if len(rgb) != 6 || err != nil {
+		return -1
+	}
+
+	nearest, distance := -1, -1
+	for i := 0; i < 16; i++ {
+		c, _ := strconv.ParseUint(ircColors[i], 16, 32)
+
+		d := 0
+		for shift := uint(0); shift <= 16; shift += 8 {
+			delta := int(value>>shift&0xff) - int(c>>shift&0xff)
+			d += delta * delta
+		}
+
+		if distance < 0 || d < distance {
+			nearest, distance = i, d
+		}
+	}
+
+	return nearest
+}
+
+// ToHTML converts the IRC formatting of text (bold, italics, underline,
+// strikethrough, monospace and colors) to HTML, escaping the text itself,
+// e.g. for bridges which relay messages to web based frontends. Only a safe
+// subset of HTML is used: b, i, u, s, code, and span elements with colors
+// in their style attribute.
+func ToHTML(text string) string {
+	var out bytes.Buffer
+	var style, current textStyle
+	var closeTags string
+
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case fmtBold:
+			style.bold = !style.bold
+			continue
+		case fmtItalic:
+			style.italic = !style.italic
+			continue
+		case fmtUnderline:
+			style.underline = !style.underline
+			continue
+		case fmtStrikethrough:
+			style.strike = !style.strike
+			continue
+		case fmtMonospace:
+			style.mono = !style.mono
+			continue
+		case fmtReverse:
+			style.fg, style.bg = style.bg, style.fg
+			continue
+		case fmtReset:
+			style = textStyle{}
+			continue
+		case fmtColor, fmtHexColor:
+			max, valid, color := 2, isDigit, ircColor
+			if text[i] == fmtHexColor {
+				max, valid, color = 6, isHexDigit, hexColor
+			}
+
+			n := colorLength(text[i+1:], max, valid)
+			code := text[i+1 : i+n]
+			i += n - 1
+
+			// Without a color, the colors are reset.
+			if code == "" {
+				style.fg, style.bg = "", ""
+				continue
+			}
+
+			fg, bg := code, ""
+			if c := strings.IndexByte(code, ','); c > -1 {
+				fg, bg = code[:c], code[c+1:]
+				style.bg = color(bg)
+			}
+			style.fg = color(fg)
+			continue
+		}
+
+		if style != current {
+			open, close := style.htmlTags()
+			out.WriteString(closeTags + open)
+			closeTags, current = close, style
+		}
+
+		// Escape the text up until the next formatting character.
+		end := i + 1
+		for end < len(text) && !isFmtChar(text[end]) {
+			end++
+		}
+		out.WriteString(html.EscapeString(text[i:end]))
+		i = end - 1
+	}
+
+	out.WriteString(closeTags)
+	return out.String()
+}
+
+// isFmtChar returns true if c is an IRC formatting character.
+func isFmtChar(c byte) bool {
+	switch c {
+	case fmtBold, fmtItalic, fmtUnderline, fmtStrikethrough, fmtMonospace, fmtReverse, fmtReset, fmtColor, fmtHexColor:
+		return true
+	}
+
+	return false
+}
+
+var (
+	// htmlTagRe matches HTML tags, with the name of the tag, and its
+	// attributes.
+	htmlTagRe = regexp.MustCompile(`<(/?)([a-zA-Z][a-zA-Z0-9-]*)([^>]*)>`)
+	// htmlAttrRe matches the attributes of HTML tags.
+	htmlAttrRe = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9-]*)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+	// htmlCommentRe matches HTML comments and declarations, e.g. doctypes.
+	htmlCommentRe = regexp.MustCompile(`(?s)<!--.*?-->|<![^>]*>`)
+	// htmlSpaceRe matches runs of whitespace.
+	htmlSpaceRe = regexp.MustCompile(`\s+`)
+	// cssColorRe matches color properties within style attributes.
+	cssColorRe = regexp.MustCompile(`(?i)(^|;)\s*(background-color|background|color)\s*:\s*([^;]+)`)
+)
+
+// htmlSkipTags are the tags whose content is dropped by FromHTML(), e.g.
+// the quoted message of Matrix replies.
+var htmlSkipTags = map[string]bool{"script": true, "style": true, "head": true, "title": true, "mx-reply": true}
+
+// htmlBlockTags are the tags which are separated from the surrounding text
+// by line breaks in FromHTML().
+var htmlBlockTags = map[string]bool{
+	"p": true, "div": true, "pre": true, "blockquote": true, "li": true, "ul": true, "ol": true, "tr": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+}
+
+// htmlElement is an open element while converting HTML, see FromHTML().
+type htmlElement struct {
+	tag   string
+	style textStyle
+	href  string
+	start int
+}
+
+// FromHTML converts a subset of HTML (e.g. from web based frontends, or the
+// formatted body of Matrix messages) to text with IRC formatting. Bold,
+// italics, underline, strikethrough, monospace and colors are converted to
+// their IRC formatting codes (colors are approximated to the 16 standard
+// IRC colors), links are written as "text (url)", and line breaks and block
+// elements (e.g. paragraphs) are converted to newlines, which should be sent
+// as separate messages. Other tags are stripped, keeping their content.
+func FromHTML(input string) string {
+	var out bytes.Buffer
+	var stack []htmlElement
+	var emitted textStyle
+	skip := 0
+
+	style := func() textStyle {
+		if len(stack) == 0 {
+			return textStyle{}
+		}
+
+		return stack[len(stack)-1].style
+	}
+
+	pre := func() bool {
+		for i := 0; i < len(stack); i++ {
+			if stack[i].tag == "pre" {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	lineStart := func() bool {
+		return out.Len() == 0 || bytes.HasSuffix(out.Bytes(), []byte("\n"))
+	}
+
+	newline := func() {
+		if skip == 0 && !lineStart() {
+			out.WriteByte('\n')
+		}
+	}
+
+	write := func(text string) {
+		if skip > 0 || text == "" {
+			return
+		}
+
+		if current := style(); current != emitted {
+			// Attributes and colors are added as is, however removing them
+			// (or changing colors) requires resetting first.
+			reset := (emitted.bold && !current.bold) || (emitted.italic && !current.italic) ||
+				(emitted.underline && !current.underline) || (emitted.strike && !current.strike) ||
+				(emitted.mono && !current.mono) || ((emitted.fg != "" || emitted.bg != "") && (emitted.fg != current.fg || emitted.bg != current.bg))
+
+			if reset {
+				out.WriteByte(fmtReset)
+				out.WriteString(current.ircCodes())
+			} else {
+				added := textStyle{
+					bold:      current.bold && !emitted.bold,
+					italic:    current.italic && !emitted.italic,
+					underline: current.underline && !emitted.underline,
+					strike:    current.strike && !emitted.strike,
+					mono:      current.mono && !emitted.mono,
+				}
+				if emitted.fg == "" && emitted.bg == "" {
+					added.fg, added.bg = current.fg, current.bg
+				}
+				out.WriteString(added.ircCodes())
+			}
+			emitted = current
+		}
+
+		out.WriteString(text)
+	}
+
+	text := func(raw string) {
+		raw = html.UnescapeString(raw)
+		if !pre() {
+			// Whitespace is collapsed, as browsers would.
+			raw = htmlSpaceRe.ReplaceAllString(raw, " ")
+			if lineStart() || bytes.HasSuffix(out.Bytes(), []byte(" ")) {
+				raw = strings.TrimLeft(raw, " ")
+			}
+		}
+
+		write(raw)
+	}
+
+	input = htmlCommentRe.ReplaceAllString(input, "")
+	for len(input) > 0 {
+		loc := htmlTagRe.FindStringSubmatchIndex(input)
+		if loc == nil {
+			text(input)
+			break
+		}
+
+		text(input[:loc[0]])
+		closing := loc[3] > loc[2]
+		tag := strings.ToLower(input[loc[4]:loc[5]])
+		attrs := input[loc[6]:loc[7]]
+		input = input[loc[1]:]
+
+		if closing {
+			// Find the matching element, closing all within it.
+			i := len(stack) - 1
+			for i >= 0 && stack[i].tag != tag {
+				i--
+			}
+			if i < 0 {
+				continue
+			}
+
+			for j := len(stack) - 1; j >= i; j-- {
+				el := stack[j]
+				stack = stack[:j]
+
+				switch {
+				case htmlSkipTags[el.tag]:
+					skip--
+				case el.tag == "a" && el.href != "" && skip == 0:
+					// Links are written with their URL, unless the text is
+					// the URL itself.
+					switch linked := StripFormat(out.String()[el.start:]); linked {
+					case el.href, strings.TrimPrefix(el.href, "mailto:"):
+					case "":
+						write(el.href)
+					default:
+						write(" (" + el.href + ")")
+					}
+				case htmlBlockTags[el.tag]:
+					newline()
+				}
+			}
+			continue
+		}
+
+		switch tag {
+		case "br":
+			if skip == 0 {
+				out.WriteByte('\n')
+			}
+			continue
+		case "img":
+			if alt := htmlAttr(attrs, "alt"); alt != "" {
+				text(alt)
+			}
+			continue
+		case "hr", "meta", "link", "input", "wbr":
+			continue
+		}
+
+		el := htmlElement{tag: tag, style: style(), start: out.Len()}
+		switch tag {
+		case "b", "strong":
+			el.style.bold = true
+		case "i", "em", "cite":
+			el.style.italic = true
+		case "u", "ins":
+			el.style.underline = true
+		case "s", "strike", "del":
+			el.style.strike = true
+		case "code", "pre", "tt", "kbd", "samp":
+			el.style.mono = true
+		case "a":
+			el.href = htmlAttr(attrs, "href")
+		}
+
+		if fg := parseHTMLColor(htmlAttr(attrs, "color")); fg != "" {
+			el.style.fg = fg
+		}
+		if fg := parseHTMLColor(htmlAttr(attrs, "data-mx-color")); fg != "" {
+			el.style.fg = fg
+		}
+		if bg := parseHTMLColor(htmlAttr(attrs, "data-mx-bg-color")); bg != "" {
+			el.style.bg = bg
+		}
+		for _, m := range cssColorRe.FindAllStringSubmatch(htmlAttr(attrs, "style"), -1) {
+			if c := parseHTMLColor(m[3]); c != "" {
+				if strings.EqualFold(m[2], "color") {
+					el.style.fg = c
+				} else {
+					el.style.bg = c
+				}
+			}
+		}
+
+		if htmlBlockTags[tag] {
+			newline()
+			el.start = out.Len()
+		}
+
+		if htmlSkipTags[tag] {
+			skip++
+		}
+
+		// Self closing tags, e.g. <span/>, have no content.
+		if strings.HasSuffix(strings.TrimSpace(attrs), "/") {
+			continue
+		}
+
+		stack = append(stack, el)
+	}
+
+	return strings.TrimRight(out.String(), "\n ")
+}
+
+// htmlAttr returns the (unescaped) value of the attribute name within the
+// attributes of a tag.
+func htmlAttr(attrs, name string) string {
+	for _, m := range htmlAttrRe.FindAllStringSubmatch(attrs, -1) {
+		if strings.EqualFold(m[1], name) {
+			return html.UnescapeString(m[2] + m[3] + m[4])
+		}
+	}
+
+	return ""
+}
+
+// parseHTMLColor parses an HTML/CSS color (e.g. "#ff0000", "#f00" or
+// "red") into its RGB hex value, or an empty string if it's invalid.
+func parseHTMLColor(color string) string {
+	color = strings.ToLower(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(color), "!important")))
+
+	if !strings.HasPrefix(color, "#") {
+		// Named colors share the names of those supported by Fmt().
+		if val, ok := fmtAliases[color]; ok && len(val) == 3 && val[0] == fmtColor {
+			return ircColor(val[1:])
+		}
+
+		return ""
+	}
+
+	color = color[1:]
+	for i := 0; i < len(color); i++ {
+		if !isHexDigit(color[i]) {
+			return ""
+		}
+	}
+
+	switch len(color) {
+	case 3:
+		return string([]byte{color[0], color[0], color[1], color[1], color[2], color[2]})
+	case 6:
+		return color
+	}
+
+	return ""
+}
@@ -0,0 +1,78 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+// Identity is the nickname, username/ident and realname used when
+// registering with the server. See Config.Identities and
+// Config.IdentityFunc.
+type Identity struct {
+	// Nick is the nickname to register with.
+	Nick string `json:"nick"`
+	// User is the username/ident to register with.
+	User string `json:"user"`
+	// Name is the "realname" to register with.
+	Name string `json:"name"`
+}
+
+// nextIdentity returns the identity which should be used for the next
+// connection attempt, falling back to the Nick, User and Name from the
+// config for any fields which are empty. This should be called with
+// Client.mu held.
+func (c *Client) nextIdentity() (Identity, error) {
+	attempt := c.attempts
+	c.attempts++
+
+	var id Identity
+	if c.Config.IdentityFunc != nil {
+		id = c.Config.IdentityFunc(attempt)
+	} else if len(c.Config.Identities) > 0 {
+		id = c.Config.Identities[attempt%len(c.Config.Identities)]
+	}
+
+	conf := c.config()
+	if id.Nick == "" {
+		id.Nick = conf.Nick
+	}
+	if id.User == "" {
+		id.User = conf.User
+	}
+	if id.Name == "" {
+		id.Name = conf.Name
+	}
+	if id.Name == "" {
+		id.Name = c.defaultName(id.User)
+	}
+
+	if !IsValidNick(id.Nick) {
+		return id, &ErrInvalidConfig{Conf: conf, Errs: []error{&ConfigError{Field: "Identities", Reason: "bad nickname in identity: " + id.Nick}}}
+	}
+	if !IsValidUser(id.User) {
+		return id, &ErrInvalidConfig{Conf: conf, Errs: []error{&ConfigError{Field: "Identities", Reason: "bad user/ident in identity: " + id.User}}}
+	}
+
+	return id, nil
+}
+
+// Identity returns the identity (nickname, username/ident and realname)
+// used for the current (or last) connection attempt, or the one from the
+// config if no attempts have been made yet. Note that the nickname may have
+// since changed, see Client.GetNick().
+func (c *Client) Identity() Identity {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.identity.Nick == "" {
+		c.confMu.RLock()
+		id := Identity{Nick: c.Config.Nick, User: c.Config.User, Name: c.Config.Name}
+		c.confMu.RUnlock()
+		if id.Name == "" {
+			id.Name = c.defaultName(id.User)
+		}
+
+		return id
+	}
+
+	return c.identity
+}
@@ -0,0 +1,105 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"strings"
+)
+
+// parseLegacyEvent is much like ParseEvent, however tolerates the quirks of
+// ancient (or embedded) servers, see Config.LegacyCompat:
+//
+//   - leading spaces, and multiple spaces between the prefix, command and
+//     params (the RFC1459 grammar allows this, however most servers don't
+//     send it, so ParseEvent doesn't support it).
+//   - numerics which aren't zero-padded (e.g. "1" instead of "001").
+//   - RPL_NAMREPLY without the channel type (e.g. "353 nick #channel :...").
+//
+// Returns nil if the event is invalid.
+func parseLegacyEvent(raw string) *Event {
+	raw = strings.TrimFunc(raw, cutCRFunc)
+	raw = strings.TrimLeft(raw, " ")
+
+	// Collapse the spaces before the trailing text (if any), which may
+	// itself contain multiple spaces that need to be kept.
+	head, trailing := raw, ""
+	if i := strings.Index(raw, " :"); i > 0 {
+		head, trailing = raw[:i], raw[i:]
+	} else {
+		head = strings.TrimRight(head, " ")
+	}
+
+	e := ParseEvent(strings.Join(strings.Fields(head), " ") + trailing)
+	if e == nil {
+		return nil
+	}
+
+	if len(e.Command) < 3 && isNumeric(e.Command) {
+		e.Command = strings.Repeat("0", 3-len(e.Command)) + e.Command
+	}
+
+	if e.Command == RPL_NAMREPLY && len(e.Params) == 2 {
+		e.Params = []string{e.Params[0], "=", e.Params[1]}
+	}
+
+	return e
+}
+
+// isNumeric returns true if s is made up of only digits.
+func isNumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// decodeLegacy is much like decode, however uses parseLegacyEvent, and skips
+// lines which can't be parsed (e.g. blank lines) rather than failing.
+func (c *ircConn) decodeLegacy() (event *Event, err error) {
+	for {
+		line, err := c.io.ReadString(delim)
+		if err != nil {
+			return nil, err
+		}
+
+		if c.stats != nil {
+			c.stats.lineRead(len(line))
+		}
+		if c.onRaw != nil {
+			c.onRaw(true, strings.TrimRightFunc(line, cutCRFunc))
+		}
+
+		if event = parseLegacyEvent(line); event != nil {
+			return event, nil
+		}
+	}
+}
+
+// handleLegacyRegistered treats the end of the MOTD as the end of
+// registration for servers which don't send RPL_WELCOME, see
+// Config.LegacyCompat.
+func handleLegacyRegistered(c *Client, e Event) {
+	if !c.Config.LegacyCompat || len(e.Params) == 0 {
+		return
+	}
+
+	c.state.RLock()
+	registered := c.state.nick != ""
+	c.state.RUnlock()
+
+	if registered {
+		return
+	}
+
+	c.logger.Info("no RPL_WELCOME received before end of MOTD, assuming registration is complete")
+	c.RunHandlers(&Event{Source: e.Source, Command: RPL_WELCOME, Params: []string{e.Params[0]}, Trailing: "Welcome"})
+}
@@ -0,0 +1,115 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// listTimeout is how long list retrieval methods (e.g. Commands.ListBans())
+// will wait for the server to finish responding.
+const listTimeout = 30 * time.Second
+
+// BanEntry is an entry of a channel list mode, e.g. a ban (+b), ban
+// exception (+e), or invite exception (+I).
+type BanEntry struct {
+	// Mask is the hostmask (or extban) of the entry, e.g. "*!*@host".
+	Mask string `json:"mask"`
+	// SetBy is who set the entry, if the server supplied it.
+	SetBy string `json:"set_by"`
+	// SetAt is when the entry was set, if the server supplied it.
+	SetAt time.Time `json:"set_at"`
+}
+
+// ListBans retrieves the ban list (+b) of channel. This blocks until the
+// server has sent the full list. If tracking is enabled, the list is also
+// stored in Channel.Lists.
+func (cmd *Commands) ListBans(channel string) ([]BanEntry, error) {
+	return cmd.listMode(channel, "b", RPL_BANLIST, RPL_ENDOFBANLIST)
+}
+
+// ListExceptions retrieves the ban exception list (+e) of channel. See
+// ListBans() for more information.
+func (cmd *Commands) ListExceptions(channel string) ([]BanEntry, error) {
+	return cmd.listMode(channel, "e", RPL_EXCEPTLIST, RPL_ENDOFEXCEPTLIST)
+}
+
+// ListInvites retrieves the invite exception list (+I) of channel. See
+// ListBans() for more information.
+func (cmd *Commands) ListInvites(channel string) ([]BanEntry, error) {
+	return cmd.listMode(channel, "I", RPL_INVITELIST, RPL_ENDOFINVITELIST)
+}
+
+// listMode requests a list mode of channel, and collects the entries which
+// the server responds with, until the end numeric is received.
+func (cmd *Commands) listMode(channel, mode, entry, end string) ([]BanEntry, error) {
+	if !IsValidChannel(channel) {
+		return nil, &ErrInvalidTarget{Target: channel}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), listTimeout)
+	defer cancel()
+
+	// All replies have the channel as the second param.
+	forChannel := func(e *Event) bool {
+		return len(e.Params) > 1 && cmd.c.Equal(e.Params[1], channel)
+	}
+
+	events, err := cmd.c.Do(ctx, &Event{Command: MODE, Params: []string{channel, "+" + mode}},
+		func(e *Event) bool {
+			switch e.Command {
+			case end, ERR_NOSUCHCHANNEL, ERR_CHANOPRIVSNEEDED, ERR_NOTONCHANNEL:
+				return forChannel(e)
+			}
+
+			return false
+		},
+		func(e *Event) bool { return e.Command == entry && len(e.Params) > 2 && forChannel(e) },
+	)
+	if err == context.DeadlineExceeded {
+		return nil, ErrNoResponse
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []BanEntry
+	for _, e := range events {
+		switch e.Command {
+		case entry:
+			ban := BanEntry{Mask: e.Params[2]}
+			if len(e.Params) > 3 {
+				ban.SetBy = e.Params[3]
+			}
+
+			if len(e.Params) > 4 {
+				if ts, err := strconv.ParseInt(e.Params[4], 10, 64); err == nil {
+					ban.SetAt = time.Unix(ts, 0)
+				}
+			}
+
+			entries = append(entries, ban)
+		case ERR_NOSUCHCHANNEL, ERR_CHANOPRIVSNEEDED, ERR_NOTONCHANNEL:
+			return nil, &ErrEvent{Event: e}
+		}
+	}
+
+	if !cmd.c.Config.disableTracking {
+		cmd.c.state.Lock()
+		if ch := cmd.c.state.lookupChannel(channel); ch != nil {
+			if ch.Lists == nil {
+				ch.Lists = make(map[string][]BanEntry)
+			}
+
+			ch.Lists[mode] = append([]BanEntry(nil), entries...)
+		}
+		cmd.c.state.Unlock()
+		cmd.c.state.notify(cmd.c, UPDATE_STATE)
+	}
+
+	return entries, nil
+}
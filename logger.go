@@ -0,0 +1,154 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// LogLevel is the severity of a log entry, see Logger.
+type LogLevel int
+
+// Log levels, from least to most severe.
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogWarn
+	LogError
+)
+
+// String returns the name of the level, e.g. "debug".
+func (l LogLevel) String() string {
+	switch l {
+	case LogDebug:
+		return "debug"
+	case LogInfo:
+		return "info"
+	case LogWarn:
+		return "warn"
+	case LogError:
+		return "error"
+	}
+
+	return "level(" + strconv.Itoa(int(l)) + ")"
+}
+
+// Logger receives the logs of the client, see Config.Logger. fields are
+// alternating keys and values, like with log/slog, which means a
+// *slog.Logger can be used as is:
+//
+//	client.Config.Logger = slog.Default()
+//
+// See LogFunc for adapting other structured loggers.
+type Logger interface {
+	Debug(msg string, fields ...interface{})
+	Info(msg string, fields ...interface{})
+	Warn(msg string, fields ...interface{})
+	Error(msg string, fields ...interface{})
+}
+
+// LogFunc is a Logger which calls the function with each log entry, with
+// its fields as a map. This makes it simple to adapt other structured
+// loggers, e.g. logrus:
+//
+//	client.Config.Logger = girc.LogFunc(func(level girc.LogLevel, msg string, fields map[string]interface{}) {
+//		lvl, _ := logrus.ParseLevel(level.String())
+//		logger.WithFields(fields).Log(lvl, msg)
+//	})
+type LogFunc func(level LogLevel, msg string, fields map[string]interface{})
+
+// Debug implements Logger.
+func (f LogFunc) Debug(msg string, fields ...interface{}) { f(LogDebug, msg, fieldMap(fields)) }
+
+// Info implements Logger.
+func (f LogFunc) Info(msg string, fields ...interface{}) { f(LogInfo, msg, fieldMap(fields)) }
+
+// Warn implements Logger.
+func (f LogFunc) Warn(msg string, fields ...interface{}) { f(LogWarn, msg, fieldMap(fields)) }
+
+// Error implements Logger.
+func (f LogFunc) Error(msg string, fields ...interface{}) { f(LogError, msg, fieldMap(fields)) }
+
+// fieldMap converts alternating keys and values into a map. A key without a
+// value is set to "!MISSING".
+func fieldMap(fields []interface{}) map[string]interface{} {
+	m := make(map[string]interface{}, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		if i+1 < len(fields) {
+			m[fmt.Sprint(fields[i])] = fields[i+1]
+		} else {
+			m[fmt.Sprint(fields[i])] = "!MISSING"
+		}
+	}
+
+	return m
+}
+
+// nopLogger is a Logger which discards everything.
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, fields ...interface{}) {}
+func (nopLogger) Info(msg string, fields ...interface{})  {}
+func (nopLogger) Warn(msg string, fields ...interface{})  {}
+func (nopLogger) Error(msg string, fields ...interface{}) {}
+
+// writerLogger is a Logger which writes each log entry as a line of text,
+// with its fields formatted as key=value, e.g.:
+//
+//	15:04:05 conn.go:311: debug: connecting server=irc.example.com:6667
+//
+// This is used for Config.Debug.
+type writerLogger struct {
+	log *log.Logger
+}
+
+// newWriterLogger returns a writerLogger writing to w.
+func newWriterLogger(w io.Writer) *writerLogger {
+	return &writerLogger{log: log.New(w, "", log.Ltime|log.Lshortfile)}
+}
+
+func (l *writerLogger) Debug(msg string, fields ...interface{}) { l.write(LogDebug, msg, fields) }
+func (l *writerLogger) Info(msg string, fields ...interface{})  { l.write(LogInfo, msg, fields) }
+func (l *writerLogger) Warn(msg string, fields ...interface{})  { l.write(LogWarn, msg, fields) }
+func (l *writerLogger) Error(msg string, fields ...interface{}) { l.write(LogError, msg, fields) }
+
+// write writes a log entry. This must only be called from the methods
+// above, so the file and line of their caller is logged.
+func (l *writerLogger) write(level LogLevel, msg string, fields []interface{}) {
+	var buf bytes.Buffer
+	buf.WriteString(level.String())
+	buf.WriteString(": ")
+	buf.WriteString(msg)
+
+	for i := 0; i < len(fields); i += 2 {
+		buf.WriteByte(' ')
+		buf.WriteString(fmt.Sprint(fields[i]))
+		buf.WriteByte('=')
+
+		if i+1 < len(fields) {
+			buf.WriteString(logValue(fields[i+1]))
+		} else {
+			buf.WriteString("!MISSING")
+		}
+	}
+
+	_ = l.log.Output(3, buf.String())
+}
+
+// logValue formats a field value, quoting it if it's empty, or contains
+// spaces, quotes, or other special characters.
+func logValue(v interface{}) string {
+	s := fmt.Sprint(v)
+	if s == "" || strings.ContainsAny(s, " =\"\\") || strconv.Quote(s) != `"`+s+`"` {
+		return strconv.Quote(s)
+	}
+
+	return s
+}
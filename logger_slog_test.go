@@ -0,0 +1,13 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+//go:build go1.21
+// +build go1.21
+
+package girc
+
+import "log/slog"
+
+// A *slog.Logger can be used as a Logger as is.
+var _ Logger = (*slog.Logger)(nil)
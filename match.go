@@ -0,0 +1,96 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Match is a filter for events, used with Caller.AddMatch(). An event must
+// match all of the non-empty fields to match.
+type Match struct {
+	// Commands are the commands (or numerics, or emulated events) to match,
+	// any of which matches. If empty, all commands match.
+	Commands []string
+	// Target is the target of the event to match (i.e. the first param,
+	// like the channel or user a message was sent to), case-insensitive.
+	// May contain globs, see Glob().
+	Target string
+	// Source is a "nick!user@host" mask of the source of the event to
+	// match, case-insensitive. May contain globs, see Glob().
+	Source string
+	// Trailing is matched against the trailing text of the event (e.g. the
+	// message of a PRIVMSG).
+	Trailing *regexp.Regexp
+	// Func, if supplied, is called with events which match all other fields,
+	// and should return true if the event matches.
+	Func func(e Event) bool
+}
+
+// Matches returns true if the event received by c matches m. Target and
+// Source are compared using the server's CASEMAPPING (see Client.Equal()).
+func (m Match) Matches(c *Client, e Event) bool {
+	if len(m.Commands) > 0 {
+		var found bool
+		for i := 0; i < len(m.Commands); i++ {
+			if strings.EqualFold(m.Commands[i], e.Command) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	if m.Target != "" && (len(e.Params) == 0 || !Glob(c.fold(e.Params[0]), c.fold(m.Target))) {
+		return false
+	}
+
+	if m.Source != "" && (e.Source == nil || !Glob(c.fold(e.Source.String()), c.fold(m.Source))) {
+		return false
+	}
+
+	if m.Trailing != nil && !m.Trailing.MatchString(e.Trailing) {
+		return false
+	}
+
+	if m.Func != nil && !m.Func(e) {
+		return false
+	}
+
+	return true
+}
+
+// AddMatch registers the handler function for events matching m, rather
+// than for a single command, which moves common filtering out of handlers.
+// For example, to respond to "!ping" in a specific channel:
+//
+//	c.Handlers.AddMatch(girc.Match{
+//		Commands: []string{girc.PRIVMSG},
+//		Target:   "#channel",
+//		Trailing: regexp.MustCompile(`^!ping\b`),
+//	}, func(c *girc.Client, e girc.Event) {
+//		c.Cmd.Reply(e, "pong!")
+//	})
+//
+// cuid is the handler uid which can be used to remove the handler with
+// Caller.Remove().
+func (c *Caller) AddMatch(m Match, handler func(client *Client, event Event)) (cuid string) {
+	// Handlers for a single command are registered on that command, so
+	// they aren't executed for all other events.
+	cmd := ALL_EVENTS
+	if len(m.Commands) == 1 {
+		cmd = m.Commands[0]
+	}
+
+	return c.sregister(false, cmd, HandlerFunc(func(client *Client, event Event) {
+		if m.Matches(client, event) {
+			handler(client, event)
+		}
+	}))
+}
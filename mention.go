@@ -0,0 +1,86 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+// Mention is a nickname mentioned within the text of a message, see
+// Client.Mentions().
+type Mention struct {
+	// Nick is the current nickname of the mentioned user, which may differ
+	// in case from how it was written.
+	Nick string `json:"nick"`
+	// Start and End are the byte offsets of the mention within the text,
+	// i.e. text[Start:End] is the mention as written.
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// isNickChar returns true if b can be part of a nickname (a-z, A-Z, 0-9,
+// -, and _\[]{}^|`).
+func isNickChar(b byte) bool {
+	return (b >= 0x41 && b <= 0x7D) || (b >= 0x30 && b <= 0x39) || b == 0x2D
+}
+
+// Mentions returns the members of channel that are mentioned within text
+// (e.g. the text of a PRIVMSG sent to channel), in the order they appear.
+// Nicknames are compared using the server casemapping, must not be
+// directly surrounded by other nickname characters (so "bob" isn't found
+// within "bobby", however is within "bob: hi" or "@bob"), and the longest
+// nickname wins if multiple match at the same position (e.g. "bob_" over
+// "bob"). Useful for bridges, to map mentions to those of another
+// platform. Returns nil if the client isn't in channel, or nobody was
+// mentioned, or if tracking is disabled.
+func (c *Client) Mentions(channel, text string) []Mention {
+	c.state.RLock()
+	defer c.state.RUnlock()
+
+	ch := c.state.lookupChannel(channel)
+	if ch == nil {
+		return nil
+	}
+
+	// Folding doesn't change the length of the text, so offsets within
+	// folded are the same as within text.
+	folded := casefold(ch.casemapping, text)
+
+	var longest int
+	nicks := make(map[string]string, len(ch.UserList))
+	for i := 0; i < len(ch.UserList); i++ {
+		nick := ch.UserList[i]
+		nicks[nick] = nick
+		if user := c.state.lookupUser(nick); user != nil {
+			nicks[nick] = user.Nick
+		}
+
+		if len(nick) > longest {
+			longest = len(nick)
+		}
+	}
+
+	var mentions []Mention
+	for i := 0; i < len(folded); i++ {
+		if i > 0 && isNickChar(folded[i-1]) {
+			continue
+		}
+
+		max := longest
+		if max > len(folded)-i {
+			max = len(folded) - i
+		}
+
+		for n := max; n > 0; n-- {
+			if i+n < len(folded) && isNickChar(folded[i+n]) {
+				continue
+			}
+
+			if nick, ok := nicks[folded[i:i+n]]; ok {
+				mentions = append(mentions, Mention{Nick: nick, Start: i, End: i + n})
+				i += n - 1
+				break
+			}
+		}
+	}
+
+	return mentions
+}
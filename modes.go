@@ -6,6 +6,8 @@ package girc
 
 import (
 	"encoding/json"
+	"errors"
+	"strconv"
 	"strings"
 	"sync"
 )
@@ -90,6 +92,29 @@ func (c *CModes) String() string {
 	return out + args
 }
 
+// Name returns the mode character. E.g. 'm', or 'b'.
+func (c *CMode) Name() byte { return c.name }
+
+// Add returns true if the mode is being added (+), rather than removed (-).
+func (c *CMode) Add() bool { return c.add }
+
+// Args returns the arguments of the mode, if any.
+func (c *CMode) Args() string { return c.args }
+
+// Modes returns the list of modes which are currently set.
+func (c *CModes) Modes() []CMode {
+	out := make([]CMode, len(c.modes))
+	copy(out, c.modes)
+
+	return out
+}
+
+// IsSet checks if a given mode is set, e.g. "m" for a moderated channel.
+// This is the same as HasMode().
+func (c *CModes) IsSet(mode string) bool {
+	return c.HasMode(mode)
+}
+
 // HasMode checks if the CModes state has a given mode. E.g. "m", or "I".
 func (c *CModes) HasMode(mode string) bool {
 	for i := 0; i < len(c.modes); i++ {
@@ -165,8 +190,11 @@ func (c *CModes) Apply(modes []CMode) {
 			if !modes[i].setting {
 				continue
 			}
-			if c.modes[j].name == modes[i].name && modes[i].add {
-				new = append(new, modes[i])
+			if c.modes[j].name == modes[i].name {
+				// Only keep the mode if it's being (re-)set, not removed.
+				if modes[i].add {
+					new = append(new, modes[i])
+				}
 				isin = true
 				break
 			}
@@ -257,6 +285,47 @@ func NewCModes(channelModes, userPrefixes string) CModes {
 	}
 }
 
+// ParseModeChanges parses the params of a MODE (or RPL_CHANNELMODEIS) after
+// the target, i.e. the flags followed by their arguments, into the list of
+// individual mode changes. isupport is the ISUPPORT information of the
+// server (see Client.ServerOptions()), which determines which modes have
+// arguments (CHANMODES and PREFIX), falling back to ModeDefaults and
+// DefaultPrefixes. For example, with the default modes:
+//
+//	ParseModeChanges(nil, []string{"+ob-l", "nick", "*!*@host"})
+//	// => +o nick, +b *!*@host, -l
+//
+// See CMode.Name(), CMode.Add() and CMode.Args() for the details of each
+// change.
+func ParseModeChanges(isupport map[string]string, params []string) []CMode {
+	if len(params) == 0 {
+		return nil
+	}
+
+	modes := NewCModes(isupportChanModes(isupport), isupportPrefixes(isupport))
+	return modes.Parse(params[0], params[1:])
+}
+
+// isupportChanModes returns the server-supported channel modes (CHANMODES)
+// from isupport, falling back to ModeDefaults.
+func isupportChanModes(isupport map[string]string) string {
+	if modes, ok := isupport["CHANMODES"]; ok && IsValidChannelMode(modes) {
+		return modes
+	}
+
+	return ModeDefaults
+}
+
+// isupportPrefixes returns the server-supported user prefixes (PREFIX) from
+// isupport, falling back to DefaultPrefixes.
+func isupportPrefixes(isupport map[string]string) string {
+	if prefix, ok := isupport["PREFIX"]; ok && isValidUserPrefix(prefix) {
+		return prefix
+	}
+
+	return DefaultPrefixes
+}
+
 // IsValidChannelMode validates a channel mode (CHANMODES).
 func IsValidChannelMode(raw string) bool {
 	if len(raw) < 1 {
@@ -322,6 +391,11 @@ func parsePrefixes(raw string) (modes, prefixes string) {
 // information for each channel, as well as if any of the modes affect user
 // permissions.
 func handleMODE(c *Client, e Event) {
+	// Some servers send the flags (or last argument) as trailing.
+	if e.Trailing != "" {
+		e.Params = append(e.Params, e.Trailing)
+	}
+
 	// Check if it's a RPL_CHANNELMODEIS.
 	if e.Command == RPL_CHANNELMODEIS && len(e.Params) > 2 {
 		// RPL_CHANNELMODEIS sends the user as the first param, skip it.
@@ -333,22 +407,26 @@ func handleMODE(c *Client, e Event) {
 		return
 	}
 
-	c.state.RLock()
+	c.state.Lock()
 	channel := c.state.lookupChannel(e.Params[0])
 	if channel == nil {
-		c.state.RUnlock()
+		c.state.Unlock()
 		return
 	}
 
-	flags := e.Params[1]
-	var args []string
-	if len(e.Params) > 2 {
-		args = append(args, e.Params[2:]...)
+	// RPL_CHANNELMODEIS contains all of the modes of the channel, so replace
+	// what we currently know.
+	if e.Command == RPL_CHANNELMODEIS {
+		channel.Modes.modes = []CMode{}
 	}
 
-	modes := channel.Modes.Parse(flags, args)
+	modes := ParseModeChanges(c.state.serverOptions, e.Params[1:])
 	channel.Modes.Apply(modes)
 
+	if e.Command == MODE {
+		c.state.addDelta(StateDelta{Type: DeltaMode, Channel: channel.Name, Modes: strings.Join(e.Params[1:], " ")})
+	}
+
 	// Loop through and update users modes as necessary.
 	for i := 0; i < len(modes); i++ {
 		if modes[i].setting || len(modes[i].args) == 0 {
@@ -363,29 +441,66 @@ func handleMODE(c *Client, e Event) {
 		}
 	}
 
-	c.state.RUnlock()
+	c.state.Unlock()
 	c.state.notify(c, UPDATE_STATE)
 }
 
+// handleUserMODE handles incoming MODE and RPL_UMODEIS messages which
+// change our own user modes.
+func handleUserMODE(c *Client, e Event) {
+	if e.Trailing != "" {
+		e.Params = append(e.Params, e.Trailing)
+	}
+
+	if len(e.Params) < 2 {
+		return
+	}
+
+	c.state.Lock()
+	if c.state.fold(e.Params[0]) != c.state.fold(c.state.nick) {
+		c.state.Unlock()
+		return
+	}
+
+	// RPL_UMODEIS contains all of our modes, so start fresh.
+	if e.Command == RPL_UMODEIS {
+		c.state.userModes = ""
+	}
+
+	add := true
+	for _, flags := range e.Params[1:] {
+		for i := 0; i < len(flags); i++ {
+			switch flags[i] {
+			case '+':
+				add = true
+			case '-':
+				add = false
+			default:
+				has := strings.IndexByte(c.state.userModes, flags[i]) > -1
+				if add && !has {
+					c.state.userModes += string(flags[i])
+				} else if !add && has {
+					c.state.userModes = strings.Replace(c.state.userModes, string(flags[i]), "", -1)
+				}
+			}
+		}
+	}
+	c.state.Unlock()
+
+	c.state.notify(c, UPDATE_GENERAL)
+}
+
 // chanModes returns the ISUPPORT list of server-supported channel modes,
 // alternatively falling back to ModeDefaults.
 func (s *state) chanModes() string {
-	if modes, ok := s.serverOptions["CHANMODES"]; ok && IsValidChannelMode(modes) {
-		return modes
-	}
-
-	return ModeDefaults
+	return isupportChanModes(s.serverOptions)
 }
 
 // userPrefixes returns the ISUPPORT list of server-supported user prefixes.
 // This includes mode characters, as well as user prefix symbols. Falls back
 // to DefaultPrefixes if not server-supported.
 func (s *state) userPrefixes() string {
-	if prefix, ok := s.serverOptions["PREFIX"]; ok && isValidUserPrefix(prefix) {
-		return prefix
-	}
-
-	return DefaultPrefixes
+	return isupportPrefixes(s.serverOptions)
 }
 
 // UserPerms contains all of the permissions for each channel the user is
@@ -393,12 +508,16 @@ func (s *state) userPrefixes() string {
 type UserPerms struct {
 	mu       sync.RWMutex
 	channels map[string]Perms
+	// casemapping is the server CASEMAPPING, used to normalize channel
+	// names.
+	casemapping string
 }
 
 // Copy returns a deep copy of the channel permissions.
 func (p *UserPerms) Copy() (perms *UserPerms) {
 	np := &UserPerms{
-		channels: make(map[string]Perms),
+		channels:    make(map[string]Perms),
+		casemapping: p.casemapping,
 	}
 
 	p.mu.RLock()
@@ -423,7 +542,7 @@ func (p *UserPerms) MarshalJSON() ([]byte, error) {
 // if the user is not in the given channel.
 func (p *UserPerms) Lookup(channel string) (perms Perms, ok bool) {
 	p.mu.RLock()
-	perms, ok = p.channels[ToRFC1459(channel)]
+	perms, ok = p.channels[casefold(p.casemapping, channel)]
 	p.mu.RUnlock()
 
 	return perms, ok
@@ -431,13 +550,13 @@ func (p *UserPerms) Lookup(channel string) (perms Perms, ok bool) {
 
 func (p *UserPerms) set(channel string, perms Perms) {
 	p.mu.Lock()
-	p.channels[ToRFC1459(channel)] = perms
+	p.channels[casefold(p.casemapping, channel)] = perms
 	p.mu.Unlock()
 }
 
 func (p *UserPerms) remove(channel string) {
 	p.mu.Lock()
-	delete(p.channels, ToRFC1459(channel))
+	delete(p.channels, casefold(p.casemapping, channel))
 	p.mu.Unlock()
 }
 
@@ -482,6 +601,46 @@ func (m Perms) IsTrusted() bool {
 	return false
 }
 
+// Rank returns a number representing the level of the permissions, which
+// can be used for sorting. Higher is more privileged, with 0 meaning no
+// permissions.
+func (m Perms) Rank() int {
+	switch {
+	case m.Owner:
+		return 5
+	case m.Admin:
+		return 4
+	case m.Op:
+		return 3
+	case m.HalfOp:
+		return 2
+	case m.Voice:
+		return 1
+	}
+
+	return 0
+}
+
+// Prefix returns the common prefix symbol for the highest permission the
+// user has (e.g. "@" for op, or "+" for voice), or an empty string if the
+// user has no permissions.
+func (m Perms) Prefix() string {
+	switch {
+	case m.Owner:
+		return OwnerPrefix
+	case m.Admin:
+		return AdminPrefix
+	case m.Op:
+		return OperatorPrefix
+	case m.HalfOp:
+		return HalfOperatorPrefix
+	case m.Voice:
+		return VoicePrefix
+	}
+
+	return ""
+}
+
 // reset resets the modes of a user.
 func (m *Perms) reset() {
 	m.Owner = false
@@ -548,3 +707,260 @@ func parseUserPrefix(raw string) (modes, nick string, success bool) {
 
 	return
 }
+
+// ModeBuilder batches channel mode changes, so they can be sent in as few
+// MODE lines as the server allows (see ISUPPORT MODES). Use Commands.Modes()
+// to create one, or NewModeBuilder() to use it without a client.
+type ModeBuilder struct {
+	cmd      *Commands
+	isupport map[string]string
+	channel  string
+	changes  []CMode
+}
+
+// Modes returns a ModeBuilder for channel. For example:
+//
+//	c.Cmd.Modes("#channel").Add('o', "nick1").Add('v', "nick2").Remove('m', "").Send()
+func (cmd *Commands) Modes(channel string) *ModeBuilder {
+	return &ModeBuilder{cmd: cmd, channel: channel}
+}
+
+// NewModeBuilder returns a ModeBuilder for channel which isn't tied to a
+// client, chunking the changes according to the MODES limit in isupport
+// (see Client.ServerOptions()). Use ModeBuilder.Events() to retrieve the
+// resulting MODE events, as it can't be sent directly.
+func NewModeBuilder(channel string, isupport map[string]string) *ModeBuilder {
+	return &ModeBuilder{isupport: isupport, channel: channel}
+}
+
+// Add queues mode (with args, if any) to be set.
+func (b *ModeBuilder) Add(mode byte, args string) *ModeBuilder {
+	b.changes = append(b.changes, CMode{add: true, name: mode, args: args})
+	return b
+}
+
+// Remove queues mode (with args, if any) to be unset.
+func (b *ModeBuilder) Remove(mode byte, args string) *ModeBuilder {
+	b.changes = append(b.changes, CMode{add: false, name: mode, args: args})
+	return b
+}
+
+// Len returns the amount of queued mode changes.
+func (b *ModeBuilder) Len() int {
+	return len(b.changes)
+}
+
+// Events returns the MODE events needed to apply all of the queued mode
+// changes, respecting the maximum amount of modes per line the server
+// supports (defaulting to 3), and the maximum line length.
+func (b *ModeBuilder) Events() (events []*Event) {
+	var max int
+	if b.cmd != nil {
+		max = b.cmd.c.modesPerLine()
+	} else {
+		modes, ok := b.isupport["MODES"]
+		max = modesLimit(modes, ok)
+	}
+
+	var flags string
+	var args []string
+	var count int
+	var add bool
+
+	flush := func() {
+		if count > 0 {
+			events = append(events, &Event{Command: MODE, Params: append([]string{b.channel, flags}, args...)})
+		}
+
+		flags, args, count = "", nil, 0
+	}
+
+	for _, mode := range b.changes {
+		if count > 0 && max > 0 && count >= max {
+			flush()
+		}
+
+		// Make sure the line won't be too long with this mode.
+		if count > 0 {
+			next := &Event{Command: MODE, Params: append([]string{b.channel, flags + "+" + string(mode.name)}, args...)}
+			if mode.args != "" {
+				next.Params = append(next.Params, mode.args)
+			}
+
+			if next.Len() > maxLength {
+				flush()
+			}
+		}
+
+		if count == 0 || mode.add != add {
+			add = mode.add
+			if add {
+				flags += ModeAddPrefix
+			} else {
+				flags += ModeDelPrefix
+			}
+		}
+
+		flags += string(mode.name)
+		if mode.args != "" {
+			args = append(args, mode.args)
+		}
+		count++
+	}
+
+	flush()
+
+	return events
+}
+
+// Send sends all of the queued mode changes to the server (see
+// ModeBuilder.Events()), and clears the queue. This is only supported by
+// builders created with Commands.Modes().
+func (b *ModeBuilder) Send() error {
+	if b.cmd == nil {
+		return errors.New("mode builder has no client, see Commands.Modes()")
+	}
+
+	if !IsValidChannel(b.channel) {
+		return &ErrInvalidTarget{Target: b.channel}
+	}
+
+	for _, event := range b.Events() {
+		b.cmd.c.Send(event)
+	}
+
+	b.changes = nil
+	return nil
+}
+
+// Mode sends a MODE query to the server, changing the modes of target (a
+// channel, or our own nickname) to modes (e.g. "+m", or "+o-v"), with args
+// for the modes which require them. If modes is empty, the current modes of
+// target are requested instead. See Commands.Modes() to build mode changes
+// which are split over multiple lines as needed.
+func (cmd *Commands) Mode(target, modes string, args ...string) error {
+	if !IsValidChannel(target) && !IsValidNick(target) {
+		return &ErrInvalidTarget{Target: target}
+	}
+
+	if modes == "" {
+		cmd.c.Send(&Event{Command: MODE, Params: []string{target}})
+		return nil
+	}
+
+	if len(modes) < 2 || (modes[0] != '+' && modes[0] != '-') || strings.ContainsAny(modes, " \r\n") {
+		return &ErrInvalidTarget{Target: modes}
+	}
+
+	cmd.c.Send(&Event{Command: MODE, Params: append([]string{target, modes}, args...)})
+	return nil
+}
+
+// modeUsers sets (or unsets) the user mode on each of nicks in channel,
+// using as few lines as possible.
+func (cmd *Commands) modeUsers(channel string, add bool, mode byte, nicks []string) error {
+	if !IsValidChannel(channel) {
+		return &ErrInvalidTarget{Target: channel}
+	}
+
+	builder := cmd.Modes(channel)
+	for _, nick := range nicks {
+		if !IsValidNick(nick) {
+			return &ErrInvalidTarget{Target: nick}
+		}
+
+		if add {
+			builder.Add(mode, nick)
+		} else {
+			builder.Remove(mode, nick)
+		}
+	}
+
+	return builder.Send()
+}
+
+// Op gives channel operator status to nicks in channel.
+func (cmd *Commands) Op(channel string, nicks ...string) error {
+	return cmd.modeUsers(channel, true, 'o', nicks)
+}
+
+// Deop removes channel operator status from nicks in channel.
+func (cmd *Commands) Deop(channel string, nicks ...string) error {
+	return cmd.modeUsers(channel, false, 'o', nicks)
+}
+
+// Voice gives voice to nicks in channel.
+func (cmd *Commands) Voice(channel string, nicks ...string) error {
+	return cmd.modeUsers(channel, true, 'v', nicks)
+}
+
+// Devoice removes voice from nicks in channel.
+func (cmd *Commands) Devoice(channel string, nicks ...string) error {
+	return cmd.modeUsers(channel, false, 'v', nicks)
+}
+
+// ClearModes removes all of the modes currently set on channel (e.g. "+mntk
+// key"), as known through tracking. List modes (like bans) and user
+// permissions are left as is. ErrTrackingDisabled is returned if tracking is
+// disabled.
+func (cmd *Commands) ClearModes(channel string) error {
+	if !IsValidChannel(channel) {
+		return &ErrInvalidTarget{Target: channel}
+	}
+
+	if !cmd.c.IsTracking() {
+		return ErrTrackingDisabled
+	}
+
+	ch := cmd.c.LookupChannel(channel)
+	if ch == nil {
+		return &ErrInvalidTarget{Target: channel}
+	}
+
+	builder := cmd.Modes(channel)
+	for _, mode := range ch.Modes.Modes() {
+		// Modes which only take args when set (e.g. "l") mustn't be
+		// given any when unset.
+		args := mode.args
+		if hasArgs, _ := ch.Modes.hasArg(false, mode.name); !hasArgs {
+			args = ""
+		}
+
+		builder.Remove(mode.name, args)
+	}
+
+	return builder.Send()
+}
+
+// modesPerLine returns the maximum amount of modes which can be sent in a
+// single MODE line, using ISUPPORT MODES. 0 means there is no limit. If the
+// server doesn't advertise it, 3 is returned.
+func (c *Client) modesPerLine() int {
+	if c.Config.disableTracking {
+		return 3
+	}
+
+	c.state.RLock()
+	modes, ok := c.state.serverOptions["MODES"]
+	c.state.RUnlock()
+
+	return modesLimit(modes, ok)
+}
+
+// modesLimit parses the value of ISUPPORT MODES, see Client.modesPerLine().
+// ok is false if the server doesn't advertise it.
+func modesLimit(modes string, ok bool) int {
+	if !ok {
+		return 3
+	}
+
+	if modes == "" {
+		return 0
+	}
+
+	if max, err := strconv.Atoi(modes); err == nil && max > 0 {
+		return max
+	}
+
+	return 3
+}
@@ -0,0 +1,267 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestChannelModeTracking(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test"})
+
+	c.RunHandlers(ParseEvent(":dummy.int 005 test CHANMODES=beI,k,l,imnpst :are supported by this server"))
+
+	c.state.Lock()
+	c.state.createChannel("#test")
+	c.state.Unlock()
+
+	c.RunHandlers(ParseEvent(":dummy.int 324 test #test +ntk secret"))
+	c.RunHandlers(ParseEvent(":nick!user@host MODE #test +ml-n 10"))
+	c.RunHandlers(ParseEvent(":nick!user@host MODE #test +b *!*@host"))
+
+	modes := c.LookupChannel("#test").Modes
+
+	for _, mode := range []string{"t", "k", "m", "l"} {
+		if !modes.IsSet(mode) {
+			t.Errorf("Channel.Modes.IsSet(%q) = false, want true (modes: %s)", mode, modes.String())
+		}
+	}
+
+	for _, mode := range []string{"n", "b"} {
+		if modes.IsSet(mode) {
+			t.Errorf("Channel.Modes.IsSet(%q) = true, want false (modes: %s)", mode, modes.String())
+		}
+	}
+
+	if key, ok := modes.Get("k"); !ok || key != "secret" {
+		t.Errorf("Channel.Modes.Get(\"k\") = %q, %t, want \"secret\", true", key, ok)
+	}
+
+	if limit, ok := modes.Get("l"); !ok || limit != "10" {
+		t.Errorf("Channel.Modes.Get(\"l\") = %q, %t, want \"10\", true", limit, ok)
+	}
+
+	c.RunHandlers(ParseEvent(":nick!user@host MODE #test -k secret"))
+	c.RunHandlers(ParseEvent(":dummy.int 324 test #test :+nt"))
+
+	modes = c.LookupChannel("#test").Modes
+	if modes.IsSet("k") || modes.IsSet("m") || !modes.IsSet("n") {
+		t.Errorf("Channel.Modes = %s after RPL_CHANNELMODEIS, want +nt", modes.String())
+	}
+}
+
+func TestChannelMembers(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test"})
+
+	c.state.Lock()
+	c.state.createChannel("#test")
+	for _, nick := range []string{"dave", "Carol", "bob", "alice"} {
+		c.state.createUser(nick)
+		c.state.lookupUser(nick).addChannel("#test")
+		c.state.lookupChannel("#test").addUser(nick)
+	}
+	c.state.Unlock()
+
+	c.RunHandlers(ParseEvent(":nick!user@host MODE #test +ov bob carol"))
+
+	var nicks []string
+	for _, user := range c.LookupChannel("#test").Members(c, SortByRank) {
+		perms, _ := user.Perms.Lookup("#test")
+		nicks = append(nicks, perms.Prefix()+user.Nick)
+	}
+
+	if want := []string{"@bob", "+Carol", "alice", "dave"}; !reflect.DeepEqual(nicks, want) {
+		t.Fatalf("Channel.Members(SortByRank) = %q, want %q", nicks, want)
+	}
+
+	nicks = nil
+	for _, user := range c.LookupChannel("#test").Members(c, SortByNick) {
+		nicks = append(nicks, user.Nick)
+	}
+
+	if want := []string{"alice", "bob", "Carol", "dave"}; !reflect.DeepEqual(nicks, want) {
+		t.Fatalf("Channel.Members(SortByNick) = %q, want %q", nicks, want)
+	}
+
+	// Nicknames are compared using the server's CASEMAPPING, where "[" sorts
+	// before "_" (unlike "{", its rfc1459 lowercase).
+	c.RunHandlers(ParseEvent(":dummy.int 005 test CASEMAPPING=ascii :are supported by this server"))
+	c.state.Lock()
+	c.state.createChannel("#ascii")
+	for _, nick := range []string{"x_", "x["} {
+		c.state.createUser(nick)
+		c.state.lookupUser(nick).addChannel("#ascii")
+		c.state.lookupChannel("#ascii").addUser(nick)
+	}
+	c.state.Unlock()
+
+	nicks = nil
+	for _, user := range c.LookupChannel("#ascii").Members(c, SortByNick) {
+		nicks = append(nicks, user.Nick)
+	}
+
+	if want := []string{"x[", "x_"}; !reflect.DeepEqual(nicks, want) {
+		t.Fatalf("Channel.Members(SortByNick) with CASEMAPPING=ascii = %q, want %q", nicks, want)
+	}
+}
+
+func TestUserModeTracking(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test"})
+
+	c.state.Lock()
+	c.state.nick = "test"
+	c.state.Unlock()
+
+	c.RunHandlers(ParseEvent(":dummy.int 221 test +iwx"))
+	c.RunHandlers(ParseEvent(":test MODE test :+B-x"))
+	c.RunHandlers(ParseEvent(":nick!user@host MODE nick +o"))
+
+	if modes := c.UserModes(); modes != "iwB" {
+		t.Fatalf("Client.UserModes() = %q, want %q", modes, "iwB")
+	}
+
+	if !c.HasUserMode(UserModeWallops) || c.HasUserMode(UserModeOperator) {
+		t.Fatalf("Client.HasUserMode() returned unexpected results for modes %q", c.UserModes())
+	}
+
+	c.RunHandlers(ParseEvent(":dummy.int 221 test :+i"))
+
+	if modes := c.UserModes(); modes != "i" {
+		t.Fatalf("Client.UserModes() = %q after RPL_UMODEIS, want %q", modes, "i")
+	}
+}
+
+func TestModeBuilder(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test"})
+	c.RunHandlers(ParseEvent(":dummy.int 005 test MODES=2 :are supported by this server"))
+
+	events := c.Cmd.Modes("#channel").Add('o', "a").Add('o', "b").Remove('v', "c").Add('m', "").Events()
+
+	var lines []string
+	for _, e := range events {
+		lines = append(lines, e.String())
+	}
+
+	want := []string{"MODE #channel +oo a b", "MODE #channel -v+m c"}
+	if !reflect.DeepEqual(lines, want) {
+		t.Fatalf("ModeBuilder.Events() = %q, want %q", lines, want)
+	}
+}
+
+func TestParseModeChanges(t *testing.T) {
+	tests := []struct {
+		isupport map[string]string
+		params   []string
+		want     []string
+	}{
+		{nil, []string{"+ob-l", "nick", "*!*@host"}, []string{"+o nick", "+b *!*@host", "-l"}},
+		{nil, []string{"+kl-k", "key", "10", "key"}, []string{"+k key", "+l 10", "-k key"}},
+		{map[string]string{"CHANMODES": "b,k,,imnt", "PREFIX": "(qov)~@+"}, []string{"+qlm", "nick"}, []string{"+q nick", "+l", "+m"}},
+		{nil, nil, nil},
+	}
+
+	for _, tt := range tests {
+		var got []string
+		for _, mode := range ParseModeChanges(tt.isupport, tt.params) {
+			got = append(got, mode.String())
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseModeChanges(%v, %q) = %q, want %q", tt.isupport, tt.params, got, tt.want)
+		}
+	}
+
+	events := NewModeBuilder("#channel", map[string]string{"MODES": "1"}).Add('o', "a").Remove('m', "").Events()
+	if len(events) != 2 || events[0].String() != "MODE #channel +o a" || events[1].String() != "MODE #channel -m" {
+		t.Fatalf("NewModeBuilder().Events() = %v, want one mode per line", events)
+	}
+
+	if err := NewModeBuilder("#channel", nil).Add('m', "").Send(); err == nil {
+		t.Fatal("Send() of builder without client = nil, want error")
+	}
+}
+
+func TestAutoModeCasemapping(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test"})
+	c.RunHandlers(ParseEvent(":dummy.int 005 test CASEMAPPING=ascii :are supported by this server"))
+
+	am := &AutoMode{}
+	am.Disable(c, "#{x}")
+	if am.Enabled(c, "#{X}") || !am.Enabled(c, "#[x]") {
+		t.Fatal("AutoMode.Disable() didn't compare channels using the server's CASEMAPPING")
+	}
+}
+
+func TestAutoMode(t *testing.T) {
+	c := New(Config{
+		Server:     "dummy.int",
+		Nick:       "test",
+		User:       "test",
+		AllowFlood: true,
+		AutoMode: &AutoMode{
+			Delay: 50 * time.Millisecond,
+			Rules: []AutoModeRule{
+				{Mode: 'v', Masks: []string{"*!*@trusted.host"}},
+				{Mode: 'o', Accounts: []string{"admin"}, Channels: []string{"#ops"}},
+			},
+		},
+	})
+
+	modes := make(chan string, 5)
+	mockServer(t, c, func(e *Event, w io.Writer) {
+		if e.Command == MODE && len(e.Params) > 2 {
+			modes <- e.String()
+		}
+	})
+	defer c.Close()
+
+	c.state.Lock()
+	c.state.nick = "test"
+	for _, ch := range []string{"#ops", "#other", "#noperms"} {
+		c.state.createChannel(ch)
+	}
+	c.state.createUser("test")
+	for _, ch := range []string{"#ops", "#other"} {
+		c.state.lookupUser("test").addChannel(ch)
+		c.state.lookupUser("test").Perms.set(ch, Perms{Op: true})
+	}
+	c.state.Unlock()
+
+	c.Config.AutoMode.Disable(c, "#other")
+
+	events := []string{
+		":one!user@trusted.host JOIN #ops",
+		":two!user@other.host JOIN #ops * :Admin",
+		":three!user@other.host JOIN #ops * :Nobody",
+		":four!user@trusted.host JOIN #other",
+		":five!user@trusted.host JOIN #noperms",
+	}
+	for _, raw := range events {
+		c.RunHandlers(ParseEvent(raw))
+	}
+
+	// Accounts are populated by the WHO query sent on join.
+	c.state.Lock()
+	c.state.lookupUser("two").Extras.Account = "admin"
+	c.state.Unlock()
+
+	select {
+	case mode := <-modes:
+		if mode != "MODE #ops +vo one two" {
+			t.Fatalf("AutoMode sent %q, want \"MODE #ops +vo one two\"", mode)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for modes")
+	}
+
+	select {
+	case mode := <-modes:
+		t.Fatalf("AutoMode sent unexpected modes: %q", mode)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"context"
+	"strings"
+)
+
+// NamesEntry is a single user returned by NAMES, see Commands.NamesEntries().
+type NamesEntry struct {
+	// Prefixes are the channel prefixes of the user (e.g. "@" or "@+").
+	Prefixes string `json:"prefixes"`
+	// Nick is the nickname of the user.
+	Nick string `json:"nick"`
+	// Ident is the ident/username of the user, which is only known if the
+	// userhost-in-names capability is enabled.
+	Ident string `json:"ident"`
+	// Host is the visible host of the user, which is only known if the
+	// userhost-in-names capability is enabled.
+	Host string `json:"host"`
+}
+
+// String returns the prefixed nickname of the user, e.g. "@nick".
+func (e NamesEntry) String() string {
+	return e.Prefixes + e.Nick
+}
+
+// Names sends a NAMES query for channel, and waits for the server to
+// respond (or ctx to be cancelled), returning the prefixed nicknames of the
+// users in the channel (e.g. "@nick"). This works for channels the client
+// isn't in (if they're visible to us), and when tracking is disabled. This
+// should not be called from non-background handlers, as the response can't
+// be processed until they return.
+func (cmd *Commands) Names(ctx context.Context, channel string) ([]string, error) {
+	entries, err := cmd.NamesEntries(ctx, channel)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, len(entries))
+	for i := 0; i < len(entries); i++ {
+		names[i] = entries[i].String()
+	}
+
+	return names, nil
+}
+
+// NamesEntries is much like Names, however returns the parsed entries,
+// which also include the ident and host of each user if the
+// userhost-in-names capability is enabled.
+func (cmd *Commands) NamesEntries(ctx context.Context, channel string) ([]NamesEntry, error) {
+	if !IsValidChannel(channel) {
+		return nil, &ErrInvalidTarget{Target: channel}
+	}
+
+	// The channel is the last param of both RPL_NAMREPLY and RPL_ENDOFNAMES.
+	forChannel := func(e *Event) bool {
+		return len(e.Params) > 1 && cmd.c.Equal(e.Params[len(e.Params)-1], channel)
+	}
+
+	events, err := cmd.c.Do(ctx, &Event{Command: NAMES, Params: []string{channel}},
+		func(e *Event) bool { return e.Command == RPL_ENDOFNAMES && forChannel(e) },
+		func(e *Event) bool { return e.Command == RPL_NAMREPLY && forChannel(e) },
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []NamesEntry
+	for _, e := range events {
+		if e.Command != RPL_NAMREPLY {
+			continue
+		}
+
+		for _, raw := range strings.Fields(e.Trailing) {
+			prefixes, nick, ok := parseUserPrefix(raw)
+			if !ok {
+				continue
+			}
+
+			entry := NamesEntry{Prefixes: prefixes, Nick: nick}
+
+			// If userhost-in-names.
+			if strings.Contains(nick, "@") {
+				s := ParseSource(nick)
+				if s == nil {
+					continue
+				}
+
+				entry.Nick, entry.Ident, entry.Host = s.Name, s.Ident, s.Host
+			}
+
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,108 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Classes of numeric events, which handlers can be registered for like any
+// other command (e.g. with Caller.Add()), to receive all numerics of that
+// class. Additionally, a range of numerics can be used as the command, e.g.
+// "400-499" or "900-999" (inclusive).
+const (
+	ALL_REPLIES = "RPL_*" // all numeric replies (i.e. numerics which aren't errors)
+	ALL_ERRORS  = "ERR_*" // all numeric errors (400-599, and the errors above, e.g. ERR_SASLFAIL)
+)
+
+// numericErrors are the numeric errors outside of 400-599.
+var numericErrors = map[int]bool{
+	691: true, // ERR_STARTTLS
+	707: true, // ERR_TARGCHANGE
+	734: true, // ERR_MONLISTFULL
+	904: true, // ERR_SASLFAIL
+	905: true, // ERR_SASLTOOLONG
+	906: true, // ERR_SASLABORTED
+	907: true, // ERR_SASLALREADY
+}
+
+// isNumericError returns true if the numeric n is an error.
+func isNumericError(n int) bool {
+	return (n >= 400 && n <= 599) || numericErrors[n]
+}
+
+// numericClass is a class of numerics, see ALL_REPLIES, ALL_ERRORS.
+type numericClass func(n int) bool
+
+// parseNumericClass returns the class of numerics cmd refers to, if it is
+// one of ALL_REPLIES, ALL_ERRORS or a range of numerics.
+func parseNumericClass(cmd string) (class numericClass, ok bool) {
+	switch cmd {
+	case ALL_REPLIES:
+		return func(n int) bool { return !isNumericError(n) }, true
+	case ALL_ERRORS:
+		return isNumericError, true
+	}
+
+	i := strings.IndexByte(cmd, '-')
+	if i < 1 || !isNumeric(cmd[:i]) || !isNumeric(cmd[i+1:]) {
+		return nil, false
+	}
+
+	low, _ := strconv.Atoi(cmd[:i])
+	high, _ := strconv.Atoi(cmd[i+1:])
+	if low > high {
+		return nil, false
+	}
+
+	return func(n int) bool { return n >= low && n <= high }, true
+}
+
+// registerClass keeps track of cmd if it is a class of numerics, so it can
+// be matched against numeric events. This is NOT concurrency safe, lock
+// Caller.mu on your own.
+func (c *Caller) registerClass(cmd string) {
+	if _, ok := c.classes[cmd]; ok {
+		return
+	}
+
+	class, ok := parseNumericClass(cmd)
+	if !ok {
+		return
+	}
+
+	if c.classes == nil {
+		c.classes = map[string]numericClass{}
+	}
+
+	c.classes[cmd] = class
+}
+
+// matchClasses returns the registered classes of numerics (see ALL_REPLIES,
+// ALL_ERRORS) which contain the command of a numeric event.
+func (c *Caller) matchClasses(command string) (matched []string) {
+	if !isNumeric(command) {
+		return nil
+	}
+
+	n, err := strconv.Atoi(command)
+	if err != nil {
+		return nil
+	}
+
+	c.mu.RLock()
+	for cmd, class := range c.classes {
+		if class(n) {
+			matched = append(matched, cmd)
+		}
+	}
+	c.mu.RUnlock()
+
+	sort.Strings(matched)
+
+	return matched
+}
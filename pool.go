@@ -0,0 +1,358 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultPoolBuffer is the size of Pool.Events(), if PoolOptions.Buffer
+	// isn't supplied.
+	defaultPoolBuffer = 100
+	// defaultReconnectDelay is how long to wait before reconnecting, if
+	// PoolOptions.ReconnectDelay isn't supplied.
+	defaultReconnectDelay = 30 * time.Second
+	// poolShutdownRetry is how often to retry shutting down a client which
+	// is still dialing, see Pool.Shutdown().
+	poolShutdownRetry = 50 * time.Millisecond
+)
+
+// ErrPoolClosed is returned when using a Pool which has been shut down.
+var ErrPoolClosed = errors.New("pool has been shut down")
+
+// PoolOptions are the options used with NewPool().
+type PoolOptions struct {
+	// Buffer is the amount of events which can be waiting in
+	// Pool.Events(). Defaults to 100. Once it's full, the clients wait for
+	// events to be read before handling more events.
+	Buffer int
+	// ReconnectDelay is how long to wait before reconnecting a client once
+	// its connection has ended (or failed). Defaults to 30 seconds. If
+	// negative, clients aren't reconnected. Clients also aren't reconnected
+	// if they were disconnected for a reason in Config.NoReconnect (e.g.
+	// because they're banned), see Client.ShouldReconnect().
+	ReconnectDelay time.Duration
+}
+
+// PoolEvent is an event received by one of the clients of a Pool.
+type PoolEvent struct {
+	// Network is the name the client was added to the pool with.
+	Network string
+	// Client is the client which received the event.
+	Client *Client
+	// Event is the event itself, which may also be an emulated event (e.g.
+	// CONNECTED or DISCONNECTED).
+	Event Event
+}
+
+// Pool runs multiple clients (e.g. one per network), reconnecting them as
+// needed, and multiplexes their events into a single channel, labeled with
+// the network they came from. This is the pattern used by bridges and
+// bouncers:
+//
+//	pool := girc.NewPool(girc.PoolOptions{})
+//	_ = pool.Add("libera", girc.New(girc.Config{Server: "irc.libera.chat", SSL: true, Nick: "bridge"}))
+//	_ = pool.Add("oftc", girc.New(girc.Config{Server: "irc.oftc.net", SSL: true, Nick: "bridge"}))
+//
+//	for e := range pool.Events() {
+//		if e.Event.Command == girc.PRIVMSG {
+//			log.Printf("[%s] %s", e.Network, e.Event.Pretty())
+//		}
+//	}
+//
+// Events() must be read from until it's closed (by Pool.Shutdown()), as
+// clients wait for their events to be read. Handlers registered on the
+// clients themselves are executed as usual.
+type Pool struct {
+	opts PoolOptions
+
+	mu      sync.RWMutex
+	clients map[string]*poolClient
+	// stopped is true once Shutdown() has been called.
+	stopped bool
+	wg      sync.WaitGroup
+
+	// sendMu guards events and closed, which is true once events has been
+	// closed. This is separate from mu, so the pool can be used while
+	// events are waiting to be read.
+	sendMu sync.RWMutex
+	events chan PoolEvent
+	closed bool
+}
+
+// poolClient is a client running in a Pool.
+type poolClient struct {
+	network string
+	client  *Client
+	cuid    string
+	// stop is closed to stop the client, once ctx and reason are set.
+	stop   chan struct{}
+	ctx    context.Context
+	reason string
+	// done is closed once events of the client should no longer be
+	// forwarded, and exited once the client is no longer running.
+	done, exited chan struct{}
+	once         sync.Once
+}
+
+// NewPool returns a new Pool, without any clients, see Pool.Add().
+func NewPool(opts PoolOptions) *Pool {
+	if opts.Buffer <= 0 {
+		opts.Buffer = defaultPoolBuffer
+	}
+	if opts.ReconnectDelay == 0 {
+		opts.ReconnectDelay = defaultReconnectDelay
+	}
+
+	return &Pool{
+		opts:    opts,
+		events:  make(chan PoolEvent, opts.Buffer),
+		clients: make(map[string]*poolClient),
+	}
+}
+
+// Events returns the channel which receives the events of all clients in
+// the pool. It's closed once the pool has been shut down.
+func (p *Pool) Events() <-chan PoolEvent {
+	return p.events
+}
+
+// Add adds client to the pool under the given network name, and connects
+// it (see Client.Connect()), reconnecting it as needed (see
+// PoolOptions.ReconnectDelay). The client must not be connected already.
+// An error is returned if a client with the same network name has already
+// been added, or the pool has been shut down.
+func (p *Pool) Add(network string, client *Client) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stopped {
+		return ErrPoolClosed
+	}
+
+	if _, ok := p.clients[network]; ok {
+		return errors.New("network already in pool: " + network)
+	}
+
+	pc := &poolClient{
+		network: network,
+		client:  client,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+		exited:  make(chan struct{}),
+	}
+	pc.cuid = client.Handlers.Add(ALL_EVENTS, func(c *Client, e Event) {
+		p.forward(pc, e)
+	})
+
+	p.clients[network] = pc
+	p.wg.Add(1)
+	go p.run(pc)
+
+	return nil
+}
+
+// Client returns the client with the given network name, or nil if there
+// is no such client in the pool.
+func (p *Pool) Client(network string) *Client {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if pc, ok := p.clients[network]; ok {
+		return pc.client
+	}
+
+	return nil
+}
+
+// Networks returns the network names of the clients in the pool, sorted.
+func (p *Pool) Networks() []string {
+	p.mu.RLock()
+	networks := make([]string, 0, len(p.clients))
+	for network := range p.clients {
+		networks = append(networks, network)
+	}
+	p.mu.RUnlock()
+
+	sort.Strings(networks)
+	return networks
+}
+
+// Remove gracefully disconnects the client with the given network name
+// (see Client.Shutdown()), and removes it from the pool. If ctx is done
+// before the client has disconnected, its connection is closed, and the
+// context error is returned.
+func (p *Pool) Remove(ctx context.Context, network, reason string) error {
+	p.mu.Lock()
+	pc, ok := p.clients[network]
+	if ok {
+		delete(p.clients, network)
+	}
+	p.mu.Unlock()
+
+	if !ok {
+		return errors.New("network not in pool: " + network)
+	}
+
+	return p.stopClient(ctx, pc, reason)
+}
+
+// Shutdown gracefully disconnects all clients in the pool (see
+// Client.Shutdown()), and closes Pool.Events() once they're no longer
+// running. If ctx is done before all clients have disconnected, their
+// connections are closed, their remaining events are discarded, and the
+// context error is returned. Events() should still be read from while
+// shutting down (i.e. from another goroutine), so the events of the
+// clients as they disconnect aren't lost.
+func (p *Pool) Shutdown(ctx context.Context, reason string) error {
+	p.mu.Lock()
+	if p.stopped {
+		p.mu.Unlock()
+		return ErrPoolClosed
+	}
+	p.stopped = true
+
+	clients := make([]*poolClient, 0, len(p.clients))
+	for network, pc := range p.clients {
+		clients = append(clients, pc)
+		delete(p.clients, network)
+	}
+	p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, pc := range clients {
+		wg.Add(1)
+		go func(pc *poolClient) {
+			defer wg.Done()
+			_ = p.stopClient(ctx, pc, reason)
+		}(pc)
+	}
+	wg.Wait()
+
+	// Clients which were removed before are waited for as well, so nothing
+	// is forwarded once the channel is closed.
+	p.wg.Wait()
+
+	p.sendMu.Lock()
+	p.closed = true
+	close(p.events)
+	p.sendMu.Unlock()
+
+	return ctx.Err()
+}
+
+// stopClient stops pc, waiting for it to no longer be running, and removes
+// the handler which forwards its events. If ctx is done first, its events
+// are discarded, see Pool.forward().
+func (p *Pool) stopClient(ctx context.Context, pc *poolClient, reason string) error {
+	pc.ctx, pc.reason = ctx, reason
+	close(pc.stop)
+
+	select {
+	case <-pc.exited:
+	case <-ctx.Done():
+		pc.discard()
+		<-pc.exited
+	}
+
+	pc.client.Handlers.Remove(pc.cuid)
+	pc.discard()
+
+	return ctx.Err()
+}
+
+// discard stops the events of pc from being forwarded.
+func (pc *poolClient) discard() {
+	pc.once.Do(func() { close(pc.done) })
+}
+
+// forward sends an event of pc to Pool.Events(), unless it's being
+// discarded.
+func (p *Pool) forward(pc *poolClient, e Event) {
+	p.sendMu.RLock()
+	defer p.sendMu.RUnlock()
+
+	if p.closed {
+		return
+	}
+
+	select {
+	case p.events <- PoolEvent{Network: pc.network, Client: pc.client, Event: e}:
+	case <-pc.done:
+	}
+}
+
+// run connects the client of pc, reconnecting it once its connection ends
+// (see PoolOptions.ReconnectDelay), until it's stopped.
+func (p *Pool) run(pc *poolClient) {
+	defer p.wg.Done()
+	defer close(pc.exited)
+
+	for {
+		connected := make(chan error, 1)
+		go func() { connected <- pc.client.Connect() }()
+
+		select {
+		case err := <-connected:
+			if err != nil {
+				pc.client.logger.Warn("connection ended", "network", pc.network, "error", err)
+			}
+		case <-pc.stop:
+			p.shutdownClient(pc, connected)
+			return
+		}
+
+		reconnect := p.opts.ReconnectDelay >= 0
+		if !pc.client.ShouldReconnect() {
+			pc.client.logger.Warn("not reconnecting", "network", pc.network, "category", pc.client.LastServerError().Category)
+			reconnect = false
+		}
+
+		if !reconnect {
+			<-pc.stop
+			return
+		}
+
+		timer := time.NewTimer(p.opts.ReconnectDelay)
+		select {
+		case <-timer.C:
+		case <-pc.stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// shutdownClient gracefully disconnects the client of pc, waiting for
+// connected to receive the result of Client.Connect(). The client may still
+// be dialing, in which case this retries until it has connected (and
+// closes the connection right away once pc.ctx is done).
+func (p *Pool) shutdownClient(pc *poolClient, connected chan error) {
+	for {
+		var err error
+		if pc.ctx.Err() != nil {
+			pc.client.Close()
+			err = ErrNotConnected
+		} else {
+			err = pc.client.Shutdown(pc.ctx, pc.reason)
+		}
+
+		if err != ErrNotConnected {
+			<-connected
+			return
+		}
+
+		select {
+		case <-connected:
+			return
+		case <-time.After(poolShutdownRetry):
+		}
+	}
+}
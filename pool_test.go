@@ -0,0 +1,226 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockPoolServer accepts connections on ln, welcoming each client once it
+// has registered, and closing the connection on QUIT. The first connection
+// of the nickname "flaky" is closed right after it has been welcomed, and
+// the nickname "banned" is K-lined.
+func mockPoolServer(ln net.Listener) {
+	var mu sync.Mutex
+	seen := map[string]bool{}
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+
+		go func(conn net.Conn) {
+			defer conn.Close()
+
+			r := bufio.NewReader(conn)
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+
+				switch fields := strings.Fields(line); fields[0] {
+				case NICK:
+					if fields[1] == "banned" {
+						_, _ = conn.Write([]byte("ERROR :Closing Link: 127.0.0.1 (K-Lined)\r\n"))
+						return
+					}
+
+					_, _ = conn.Write([]byte(":dummy.int 001 " + fields[1] + " :Welcome\r\n"))
+
+					mu.Lock()
+					first := !seen[fields[1]]
+					seen[fields[1]] = true
+					mu.Unlock()
+
+					if fields[1] == "flaky" && first {
+						return
+					}
+				case QUIT:
+					_, _ = conn.Write([]byte("ERROR :Closing Link\r\n"))
+					return
+				}
+			}
+		}(conn)
+	}
+}
+
+func TestPool(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go mockPoolServer(ln)
+
+	port := ln.Addr().(*net.TCPAddr).Port
+	newClient := func(nick string) *Client {
+		return New(Config{Server: "127.0.0.1", Port: port, Nick: nick, User: "test"})
+	}
+
+	pool := NewPool(PoolOptions{ReconnectDelay: 10 * time.Millisecond})
+	if err = pool.Add("stable", newClient("stable")); err != nil {
+		t.Fatalf("Add() returned %v", err)
+	}
+	if err = pool.Add("flaky", newClient("flaky")); err != nil {
+		t.Fatalf("Add() returned %v", err)
+	}
+
+	banned := newClient("banned")
+	banned.Config.NoReconnect = []string{ServerErrorBanned}
+	if err = pool.Add("banned", banned); err != nil {
+		t.Fatalf("Add() returned %v", err)
+	}
+	if err = pool.Add("flaky", newClient("other")); err == nil {
+		t.Fatal("Add() with a duplicate network succeeded")
+	}
+
+	if networks := pool.Networks(); !reflect.DeepEqual(networks, []string{"banned", "flaky", "stable"}) {
+		t.Fatalf("Networks() = %v, want [banned flaky stable]", networks)
+	}
+	if pool.Client("stable") == nil || pool.Client("missing") != nil {
+		t.Fatal("Client() didn't return the client of the network")
+	}
+
+	// Wait for the flaky client to reconnect, and the stable one to connect.
+	ready, connecting := map[string]int{}, map[string]int{}
+	timeout := time.After(5 * time.Second)
+	for ready["stable"] < 1 || ready["flaky"] < 2 {
+		select {
+		case e := <-pool.Events():
+			if e.Client != pool.Client(e.Network) {
+				t.Fatalf("event of %s labeled with the wrong client", e.Network)
+			}
+
+			if e.Event.Command == STATUS_CHANGED && e.Event.Params[0] == StatusReady.String() {
+				ready[e.Network]++
+			}
+			if e.Event.Command == CONNECTING {
+				connecting[e.Network]++
+			}
+		case <-timeout:
+			t.Fatalf("clients didn't (re)connect, got %v", ready)
+		}
+	}
+
+	// Keep reading the events while shutting down.
+	var disconnected []string
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for e := range pool.Events() {
+			if e.Event.Command == DISCONNECTED && e.Network != "banned" {
+				disconnected = append(disconnected, e.Network)
+			}
+			if e.Event.Command == CONNECTING {
+				connecting[e.Network]++
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err = pool.Remove(ctx, "flaky", "bye"); err != nil {
+		t.Fatalf("Remove() returned %v", err)
+	}
+	if pool.Client("flaky") != nil {
+		t.Fatal("Remove() didn't remove the client")
+	}
+
+	if err = pool.Shutdown(ctx, "bye"); err != nil {
+		t.Fatalf("Shutdown() returned %v", err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown() didn't close Events()")
+	}
+
+	if !reflect.DeepEqual(disconnected, []string{"flaky", "stable"}) {
+		t.Fatalf("got DISCONNECTED from %v, want [flaky stable]", disconnected)
+	}
+
+	// The banned client isn't reconnected, despite the short delay.
+	if connecting["banned"] != 1 {
+		t.Fatalf("banned client connected %d times, want once", connecting["banned"])
+	}
+
+	if err = pool.Add("late", newClient("late")); err != ErrPoolClosed {
+		t.Fatalf("Add() after Shutdown() returned %v, want ErrPoolClosed", err)
+	}
+	if err = pool.Shutdown(ctx, ""); err != ErrPoolClosed {
+		t.Fatalf("Shutdown() twice returned %v, want ErrPoolClosed", err)
+	}
+}
+
+func TestPoolShutdownTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// The server floods the client with more events than the pool can
+	// buffer, and ignores everything, including QUIT.
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		_, _ = conn.Write([]byte(":dummy.int 001 test :Welcome\r\n"))
+		for i := 0; i < 20; i++ {
+			_, _ = conn.Write([]byte(":dummy.int NOTICE test :flood\r\n"))
+		}
+		mockReadBuffer(conn)
+	}()
+
+	c := New(Config{Server: "127.0.0.1", Port: ln.Addr().(*net.TCPAddr).Port, Nick: "test", User: "test"})
+	pool := NewPool(PoolOptions{Buffer: 10, ReconnectDelay: -1})
+	if err = pool.Add("test", c); err != nil {
+		t.Fatalf("Add() returned %v", err)
+	}
+
+	// Nothing reads the events, so the client is stuck once the buffer is
+	// full.
+	for len(pool.Events()) < cap(pool.Events()) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	if err = pool.Shutdown(ctx, "bye"); err != context.DeadlineExceeded {
+		t.Fatalf("Shutdown() returned %v, want context.DeadlineExceeded", err)
+	}
+
+	if c.IsConnected() {
+		t.Fatal("Shutdown() returned with the client still connected")
+	}
+
+	for range pool.Events() {
+	}
+}
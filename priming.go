@@ -0,0 +1,239 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultPrimingTimeout is the default for Priming.Timeout.
+const defaultPrimingTimeout = 30 * time.Second
+
+// primingNumerics is the range of numerics which complete the steps of
+// priming a channel (RPL_ENDOFWHO through RPL_ENDOFBANLIST). It's registered
+// as a class of numerics (see ALL_REPLIES), as classes are executed after
+// the regular handlers, so the tracked state is already updated.
+const primingNumerics = "315-368"
+
+// Steps of priming a channel, see Priming.
+const (
+	primeNames = "NAMES"
+	primeWho   = "WHO"
+	primeTopic = "TOPIC"
+	primeModes = "MODE"
+	primeBans  = "BANS"
+)
+
+// Priming configures the queries sent after the client joins a channel, to
+// populate the tracked state of the channel. NAMES is always sent by the
+// server when joining. Once all replies have been received (or Timeout has
+// passed), a single CHANNEL_READY event is emitted, so handlers know when
+// the tracked state is fully populated, instead of acting on partial data:
+//
+//	client.Config.Priming = &girc.Priming{Who: true, Modes: true, Bans: true}
+//	client.Handlers.Add(girc.CHANNEL_READY, func(c *girc.Client, e girc.Event) {
+//		ch := c.LookupChannel(e.Params[0])
+//		// ...
+//	})
+//
+// Without Priming, WHO and MODE are still sent after joining, however no
+// CHANNEL_READY event is emitted. See Config.Priming. Tracking must be
+// enabled for this to work.
+type Priming struct {
+	// Who, if true, sends a WHO (WHOX if supported) for the channel, to
+	// populate the hosts, accounts and real names of its users.
+	Who bool
+	// Topic, if true, requests the topic of the channel. Servers usually
+	// send it when joining, however not if it isn't set.
+	Topic bool
+	// Modes, if true, requests the modes of the channel.
+	Modes bool
+	// Bans, if true, requests the ban list of the channel, which is stored
+	// in Channel.Lists (see Commands.ListBans()).
+	Bans bool
+	// Timeout is how long to wait for all replies, after which CHANNEL_READY
+	// is emitted regardless, with the steps which didn't complete as the
+	// trailing. Defaults to 30 seconds.
+	Timeout time.Duration
+
+	mu       sync.Mutex
+	channels map[string]*primingChannel
+}
+
+// primingChannel is the progress of priming a single channel.
+type primingChannel struct {
+	// name is the channel, and folded its folded name (see Client.fold()).
+	name    string
+	folded  string
+	pending map[string]bool
+	bans    []BanEntry
+	timer   *time.Timer
+}
+
+// steps returns the pending steps, in a stable order.
+func (p *primingChannel) steps() []string {
+	var steps []string
+	for _, step := range []string{primeNames, primeWho, primeTopic, primeModes, primeBans} {
+		if p.pending[step] {
+			steps = append(steps, step)
+		}
+	}
+
+	return steps
+}
+
+// start sends the queries for channel, which we've just joined, and waits
+// for the replies.
+func (p *Priming) start(c *Client, channel string) {
+	ch := &primingChannel{name: channel, folded: c.fold(channel), pending: map[string]bool{primeNames: true}}
+
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = defaultPrimingTimeout
+	}
+
+	p.mu.Lock()
+	if p.channels == nil {
+		p.channels = make(map[string]*primingChannel)
+	}
+	if old, ok := p.channels[ch.folded]; ok {
+		old.timer.Stop()
+	}
+	p.channels[ch.folded] = ch
+
+	if p.Who {
+		ch.pending[primeWho] = true
+	}
+	if p.Topic {
+		ch.pending[primeTopic] = true
+	}
+	if p.Modes {
+		ch.pending[primeModes] = true
+	}
+	if p.Bans {
+		ch.pending[primeBans] = true
+	}
+	ch.timer = time.AfterFunc(timeout, func() { p.expire(c, ch) })
+	p.mu.Unlock()
+
+	c.logger.Debug("priming channel", "channel", channel, "steps", strings.Join(ch.steps(), ","))
+
+	if p.Who {
+		c.Send(&Event{Command: WHO, Params: []string{channel, "%tacuhnr," + whoxTrackingToken}})
+	}
+	if p.Topic {
+		c.Send(&Event{Command: TOPIC, Params: []string{channel}})
+	}
+	if p.Modes {
+		c.Send(&Event{Command: MODE, Params: []string{channel}})
+	}
+	if p.Bans {
+		c.Send(&Event{Command: MODE, Params: []string{channel, "+b"}})
+	}
+}
+
+// expire emits CHANNEL_READY for ch once Timeout has passed, if it hasn't
+// completed in the meantime.
+func (p *Priming) expire(c *Client, ch *primingChannel) {
+	p.mu.Lock()
+	if p.channels[ch.folded] != ch {
+		p.mu.Unlock()
+		return
+	}
+	delete(p.channels, ch.folded)
+	steps := ch.steps()
+	p.mu.Unlock()
+
+	// We may have left the channel in the meantime.
+	c.state.RLock()
+	joined := c.state.lookupChannel(ch.name) != nil
+	c.state.RUnlock()
+
+	if !joined {
+		return
+	}
+
+	c.logger.Warn("priming channel timed out", "channel", ch.name, "steps", strings.Join(steps, ","))
+	c.RunHandlers(&Event{Command: CHANNEL_READY, Params: []string{ch.name}, Trailing: strings.Join(steps, " ")})
+}
+
+// handlePriming completes the steps of priming a channel as the replies are
+// received, emitting CHANNEL_READY once all are complete. See
+// Config.Priming.
+func handlePriming(c *Client, e Event) {
+	p := c.Config.Priming
+	if p == nil || len(e.Params) < 2 {
+		return
+	}
+
+	name := c.fold(e.Params[1])
+
+	p.mu.Lock()
+	ch, ok := p.channels[name]
+	if !ok {
+		p.mu.Unlock()
+		return
+	}
+
+	switch e.Command {
+	case RPL_ENDOFNAMES:
+		delete(ch.pending, primeNames)
+	case RPL_ENDOFWHO:
+		delete(ch.pending, primeWho)
+	case RPL_TOPIC, RPL_NOTOPIC:
+		delete(ch.pending, primeTopic)
+	case RPL_CHANNELMODEIS:
+		delete(ch.pending, primeModes)
+	case RPL_BANLIST:
+		if !ch.pending[primeBans] || len(e.Params) < 3 {
+			break
+		}
+
+		ban := BanEntry{Mask: e.Params[2]}
+		if len(e.Params) > 3 {
+			ban.SetBy = e.Params[3]
+		}
+		if len(e.Params) > 4 {
+			if ts, err := strconv.ParseInt(e.Params[4], 10, 64); err == nil {
+				ban.SetAt = time.Unix(ts, 0)
+			}
+		}
+		ch.bans = append(ch.bans, ban)
+	case RPL_ENDOFBANLIST:
+		if !ch.pending[primeBans] {
+			break
+		}
+		delete(ch.pending, primeBans)
+
+		c.state.Lock()
+		if channel := c.state.lookupChannel(ch.name); channel != nil {
+			if channel.Lists == nil {
+				channel.Lists = make(map[string][]BanEntry)
+			}
+
+			channel.Lists["b"] = ch.bans
+		}
+		c.state.Unlock()
+		c.state.notify(c, UPDATE_STATE)
+	case ERR_CHANOPRIVSNEEDED:
+		// Some servers only allow operators to see the ban list.
+		delete(ch.pending, primeBans)
+	}
+
+	if len(ch.pending) > 0 {
+		p.mu.Unlock()
+		return
+	}
+
+	ch.timer.Stop()
+	delete(p.channels, ch.folded)
+	p.mu.Unlock()
+
+	c.logger.Debug("priming channel complete", "channel", ch.name)
+	c.RunHandlers(&Event{Command: CHANNEL_READY, Params: []string{ch.name}})
+}
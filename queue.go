@@ -0,0 +1,119 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"context"
+	"time"
+)
+
+// Queue is an adapter between the client and an external message queue or
+// pipeline (e.g. NATS, Kafka, or simply Go channels, see ChanQueue). See
+// Client.Pipe() for how it is used.
+type Queue interface {
+	// Publish is called with each inbound event (including emulated events,
+	// like CONNECTED), in the order they were received. Events are only
+	// published one at a time, and the next event will not be published
+	// until Publish returns, so implementations which block until the
+	// queue has acknowledged the event get at-least-once, ordered delivery.
+	// If Publish returns an error, the event is published again (after a
+	// short delay), until Publish succeeds or the pipe is closed.
+	Publish(ctx context.Context, event *Event) error
+	// Consume returns the outbound events which should be sent to the
+	// server. The client stops consuming once the channel is closed.
+	Consume() <-chan *Outbound
+}
+
+// Outbound is an event received from a Queue, which should be sent to the
+// server.
+type Outbound struct {
+	// Event is the event to send to the server.
+	Event *Event
+	// Ack, if supplied, is called once the event has been queued to be sent
+	// to the server, with a nil error. If the event could not be sent (e.g.
+	// the client isn't connected), it is called with the error. Events which
+	// are not acknowledged are not retried by the client.
+	Ack func(err error)
+}
+
+// ChanQueue is a Queue which uses Go channels. Inbound events are sent to
+// In, and events sent to Out are sent to the server.
+type ChanQueue struct {
+	In  chan *Event
+	Out chan *Outbound
+}
+
+// NewChanQueue returns a new ChanQueue, with the specified buffer size for
+// both the inbound and outbound channels.
+func NewChanQueue(size int) *ChanQueue {
+	return &ChanQueue{In: make(chan *Event, size), Out: make(chan *Outbound, size)}
+}
+
+// Publish implements Queue.
+func (q *ChanQueue) Publish(ctx context.Context, event *Event) error {
+	select {
+	case q.In <- event:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Consume implements Queue.
+func (q *ChanQueue) Consume() <-chan *Outbound {
+	return q.Out
+}
+
+// Pipe connects the client to q, publishing all inbound events to the queue,
+// and sending all events consumed from the queue to the server. Pipe blocks
+// until ctx is cancelled, or the queue's consume channel is closed. Note
+// that while an event is being published, other handlers for the following
+// events will be blocked, to guarantee ordering.
+func (c *Client) Pipe(ctx context.Context, q Queue) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	cuid := c.Handlers.Add(ALL_EVENTS, func(c *Client, e Event) {
+		for {
+			if err := q.Publish(ctx, &e); err == nil {
+				return
+			}
+
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+	defer c.Handlers.Remove(cuid)
+
+	out := q.Consume()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg, ok := <-out:
+			if !ok {
+				return nil
+			}
+
+			if msg == nil || msg.Event == nil {
+				continue
+			}
+
+			var err error
+			if c.IsConnected() {
+				c.Send(msg.Event)
+			} else {
+				err = ErrNotConnected
+			}
+
+			if msg.Ack != nil {
+				msg.Ack(err)
+			}
+		}
+	}
+}
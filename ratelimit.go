@@ -0,0 +1,277 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxRateFactor is the most the rate limit will be slowed down by, see
+	// Config.AdaptiveRate.
+	maxRateFactor = 8
+	// rateRecovery is how long the server must stop complaining before the
+	// rate limit is sped up again, one step at a time.
+	rateRecovery = 30 * time.Second
+	// rateLagThreshold is the lag to PING at which the rate limit is slowed
+	// down.
+	rateLagThreshold = 5 * time.Second
+	// rateDebounce prevents several complaints caused by the same burst of
+	// events (e.g. a 439 for each message) from slowing down more than once.
+	rateDebounce = 2 * time.Second
+)
+
+// handleRateFeedback slows down the rate limit when the server indicates we
+// are sending too quickly, see Config.AdaptiveRate.
+func handleRateFeedback(c *Client, e Event) {
+	if allowFlood, adaptive, _ := c.rateConfig(); !adaptive || allowFlood {
+		return
+	}
+
+	c.mu.RLock()
+	conn := c.conn
+	c.mu.RUnlock()
+
+	if conn == nil {
+		return
+	}
+
+	switch e.Command {
+	case PONG:
+		conn.mu.RLock()
+		lag := time.Since(conn.lastPing)
+		conn.mu.RUnlock()
+
+		if lag >= rateLagThreshold {
+			c.slowDown(conn, "lag of "+lag.String())
+		}
+	case RPL_TRYAGAIN:
+		c.slowDown(conn, "server asked us to try again")
+	case ERR_TARGETTOOFAST, ERR_TARGCHANGE:
+		c.slowDown(conn, "target change too fast")
+	}
+}
+
+// slowDown doubles the slowdown factor of the rate limit of conn (up to
+// maxRateFactor), and schedules its recovery.
+func (c *Client) slowDown(conn *ircConn, reason string) {
+	conn.mu.Lock()
+	if time.Since(conn.rateAdjusted) < rateDebounce {
+		conn.mu.Unlock()
+		return
+	}
+
+	conn.rateAdjusted = time.Now()
+
+	if conn.rateFactor < 1 {
+		conn.rateFactor = 1
+	}
+
+	if conn.rateFactor >= maxRateFactor {
+		// Already as slow as we go, however recovery starts over.
+		conn.mu.Unlock()
+		return
+	}
+
+	conn.rateFactor *= 2
+	factor := conn.rateFactor
+
+	recovering := conn.rateRecovering
+	conn.rateRecovering = true
+	conn.mu.Unlock()
+
+	c.logger.Info("slowing down rate limit", "factor", factor, "reason", reason)
+	c.RunHandlers(&Event{Command: RATE_ADJUSTED, Params: []string{strconv.Itoa(factor)}, Trailing: reason})
+
+	if !recovering {
+		time.AfterFunc(rateRecovery, func() { c.recoverRate(conn) })
+	}
+}
+
+// recoverRate halves the slowdown factor of the rate limit of conn, if the
+// server hasn't complained for rateRecovery, until it is no longer slowed
+// down.
+func (c *Client) recoverRate(conn *ircConn) {
+	c.mu.RLock()
+	current := c.conn
+	c.mu.RUnlock()
+
+	conn.mu.Lock()
+	if current != conn {
+		// We've since reconnected, which starts with a clean rate limit.
+		conn.rateRecovering = false
+		conn.mu.Unlock()
+		return
+	}
+
+	if wait := rateRecovery - time.Since(conn.rateAdjusted); wait > 0 {
+		conn.mu.Unlock()
+		time.AfterFunc(wait, func() { c.recoverRate(conn) })
+		return
+	}
+
+	conn.rateFactor /= 2
+	factor := conn.rateFactor
+
+	conn.rateRecovering = factor > 1
+	conn.mu.Unlock()
+
+	c.logger.Info("recovering rate limit", "factor", factor)
+	c.RunHandlers(&Event{Command: RATE_ADJUSTED, Params: []string{strconv.Itoa(factor)}, Trailing: "recovering"})
+
+	if factor > 1 {
+		time.AfterFunc(rateRecovery, func() { c.recoverRate(conn) })
+	}
+}
+
+// Defaults for TargetRate.
+const (
+	defaultTargetBurst = 5
+	defaultTargetRate  = 1.0
+)
+
+// TargetRate limits the rate of messages (PRIVMSG and NOTICE) sent
+// to each channel or user, on top of the global rate limit, with a token
+// bucket per target: up to Burst messages can be sent to a target at once,
+// after which they're limited to Rate messages per second. Messages to a
+// busy target wait for their own turn without using up the global rate
+// limit, so e.g. a bot relaying a busy channel doesn't starve its other
+// targets. See Config.TargetRate.
+type TargetRate struct {
+	// Burst is how many messages can be sent to a target at once. Defaults
+	// to 5.
+	Burst int
+	// Rate is the sustained rate of messages per second to each target,
+	// e.g. 0.5 for a message every 2 seconds. Defaults to 1.
+	Rate float64
+
+	mu      sync.Mutex
+	buckets map[string]*targetBucket
+	swept   time.Time
+}
+
+// targetBucket is the token bucket of a single target.
+type targetBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// limits returns Burst and Rate, or their defaults.
+func (r *TargetRate) limits() (burst, rate float64) {
+	burst, rate = defaultTargetBurst, defaultTargetRate
+	if r.Burst > 0 {
+		burst = float64(r.Burst)
+	}
+	if r.Rate > 0 {
+		rate = r.Rate
+	}
+
+	return burst, rate
+}
+
+// reserve takes a token from the bucket of target (a casefolded channel or
+// nickname), returning how long to wait until the message can be sent.
+func (r *TargetRate) reserve(target string, now time.Time) time.Duration {
+	burst, rate := r.limits()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.buckets == nil {
+		r.buckets = make(map[string]*targetBucket)
+	}
+	r.sweep(now, burst, rate)
+
+	b, ok := r.buckets[target]
+	if !ok {
+		b = &targetBucket{tokens: burst, last: now}
+		r.buckets[target] = b
+	}
+
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		if b.tokens += elapsed.Seconds() * rate; b.tokens > burst {
+			b.tokens = burst
+		}
+		b.last = now
+	}
+
+	if b.tokens--; b.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens / rate * float64(time.Second))
+}
+
+// cancel returns the token taken by reserve, for messages which weren't
+// sent after all.
+func (r *TargetRate) cancel(target string) {
+	burst, _ := r.limits()
+
+	r.mu.Lock()
+	if b, ok := r.buckets[target]; ok && b.tokens < burst {
+		b.tokens++
+	}
+	r.mu.Unlock()
+}
+
+// sweep removes the buckets which have been refilled, at most once a
+// minute, so targets which are no longer messaged don't accumulate. r.mu
+// must be locked.
+func (r *TargetRate) sweep(now time.Time, burst, rate float64) {
+	if now.Sub(r.swept) < time.Minute {
+		return
+	}
+	r.swept = now
+
+	for target, b := range r.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*rate >= burst {
+			delete(r.buckets, target)
+		}
+	}
+}
+
+// reserveTargetRate reserves sending event to its targets, see
+// Config.TargetRate, returning how long to wait until it may be sent, and
+// the targets which were reserved (see Client.cancelTargetRate()).
+func (c *Client) reserveTargetRate(event *Event) (delay time.Duration, targets []string) {
+	_, _, r := c.rateConfig()
+	if r == nil || len(event.Params) == 0 {
+		return 0, nil
+	}
+
+	if event.Command != PRIVMSG && event.Command != NOTICE {
+		return 0, nil
+	}
+
+	now := time.Now()
+	targets = strings.Split(event.Params[0], ",")
+
+	for i := 0; i < len(targets); i++ {
+		targets[i] = c.fold(targets[i])
+		if d := r.reserve(targets[i], now); d > delay {
+			delay = d
+		}
+	}
+
+	return delay, targets
+}
+
+// cancelTargetRate returns the tokens reserved for targets, if the event
+// won't be sent after all, see Client.reserveTargetRate().
+func (c *Client) cancelTargetRate(targets []string) {
+	// Config.TargetRate may have been replaced (or removed) since, in which
+	// case the targets are simply not found.
+	_, _, r := c.rateConfig()
+	if r == nil {
+		return
+	}
+
+	for i := 0; i < len(targets); i++ {
+		r.cancel(targets[i])
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import "time"
+
+// ReadOnlyClient exposes only the methods of Client which query the client
+// and its tracked state, see Client.ReadOnly(). It can be handed to
+// components like metrics and reporting, which should never send anything to
+// the server or modify the client's state. Users and channels returned by
+// lookups are copies, so modifying them does not affect the client.
+type ReadOnlyClient interface {
+	// String returns a brief description of the current client state.
+	String() string
+	// Server returns the string representation of host+port pair for
+	// net.Conn.
+	Server() string
+	// Lifetime returns the amount of time that has passed since the client
+	// was created.
+	Lifetime() time.Duration
+	// Uptime is the time at which the client successfully connected to the
+	// server.
+	Uptime() (up *time.Time, err error)
+	// ConnSince is the duration that has past since the client successfully
+	// connected to the server.
+	ConnSince() (since *time.Duration, err error)
+	// IsConnected returns true if the client is connected to the server.
+	IsConnected() bool
+	// Lag is the latency between the server and the client.
+	Lag() time.Duration
+	// ShedStats returns load shedding counters for the client.
+	ShedStats() ShedStats
+	// Stats returns counters of the activity of the client.
+	Stats() Stats
+	// SendQueue returns the state of the queue of outgoing messages.
+	SendQueue() SendQueueStats
+	// Status returns the state of the connection.
+	Status() Status
+
+	// GetNick returns the current nickname of the active connection.
+	GetNick() string
+	// GetIdent returns the current ident of the active connection.
+	GetIdent() string
+	// GetHost returns the current host of the active connection.
+	GetHost() string
+	// Identity returns the identity used for the current connection.
+	Identity() Identity
+	// UserModes returns the modes of the client.
+	UserModes() string
+	// HasUserMode checks if the client has the specified mode.
+	HasUserMode(mode string) bool
+
+	// Channels returns the active list of channels that the client is in.
+	Channels() []string
+	// Users returns the active list of users that the client is tracking.
+	Users() []string
+	// LookupChannel looks up a given channel in state.
+	LookupChannel(name string) *Channel
+	// LookupUser looks up a given user in state.
+	LookupUser(nick string) *User
+	// IsInChannel returns true if the client is in channel.
+	IsInChannel(channel string) bool
+	// BouncerNetworks returns the networks which the bouncer has told us
+	// about.
+	BouncerNetworks() []BouncerNetwork
+
+	// GetServerOption retrieves a server capability setting that was
+	// retrieved during client connection (ISUPPORT).
+	GetServerOption(key string) (result string, ok bool)
+	// HasCapability checks if the client connection has the given
+	// capability.
+	HasCapability(name string) bool
+	// NetworkName returns the network identifier.
+	NetworkName() string
+	// ServerVersion returns the server software version.
+	ServerVersion() string
+	// ServerMOTD returns the servers message of the day.
+	ServerMOTD() string
+	// ServerMOTDLines returns the lines of the servers message of the day.
+	ServerMOTDLines() []string
+
+	// Equal compares two nicknames or channel names, using the casemapping
+	// of the server.
+	Equal(a, b string) bool
+	// IsService checks if source is a network services pseudo-client.
+	IsService(source *Source) bool
+	// IsFromSelf checks to see if a PRIVMSG or NOTICE was sent by us.
+	IsFromSelf(e Event) bool
+}
+
+// readOnlyClient wraps Client, so the Client can't be retrieved with a type
+// assertion on ReadOnlyClient.
+type readOnlyClient struct {
+	c *Client
+}
+
+// ReadOnly returns a view of the client which can only be used to query the
+// client and its state. See ReadOnlyClient for more information.
+func (c *Client) ReadOnly() ReadOnlyClient {
+	return readOnlyClient{c: c}
+}
+
+func (r readOnlyClient) String() string                            { return r.c.String() }
+func (r readOnlyClient) Server() string                            { return r.c.Server() }
+func (r readOnlyClient) Lifetime() time.Duration                   { return r.c.Lifetime() }
+func (r readOnlyClient) Uptime() (*time.Time, error)               { return r.c.Uptime() }
+func (r readOnlyClient) ConnSince() (*time.Duration, error)        { return r.c.ConnSince() }
+func (r readOnlyClient) IsConnected() bool                         { return r.c.IsConnected() }
+func (r readOnlyClient) Lag() time.Duration                        { return r.c.Lag() }
+func (r readOnlyClient) ShedStats() ShedStats                      { return r.c.ShedStats() }
+func (r readOnlyClient) Stats() Stats                              { return r.c.Stats() }
+func (r readOnlyClient) SendQueue() SendQueueStats                 { return r.c.SendQueue() }
+func (r readOnlyClient) Status() Status                            { return r.c.Status() }
+func (r readOnlyClient) GetNick() string                           { return r.c.GetNick() }
+func (r readOnlyClient) GetIdent() string                          { return r.c.GetIdent() }
+func (r readOnlyClient) GetHost() string                           { return r.c.GetHost() }
+func (r readOnlyClient) Identity() Identity                        { return r.c.Identity() }
+func (r readOnlyClient) UserModes() string                         { return r.c.UserModes() }
+func (r readOnlyClient) HasUserMode(mode string) bool              { return r.c.HasUserMode(mode) }
+func (r readOnlyClient) Channels() []string                        { return r.c.Channels() }
+func (r readOnlyClient) Users() []string                           { return r.c.Users() }
+func (r readOnlyClient) LookupChannel(name string) *Channel        { return r.c.LookupChannel(name) }
+func (r readOnlyClient) LookupUser(nick string) *User              { return r.c.LookupUser(nick) }
+func (r readOnlyClient) IsInChannel(channel string) bool           { return r.c.IsInChannel(channel) }
+func (r readOnlyClient) BouncerNetworks() []BouncerNetwork         { return r.c.BouncerNetworks() }
+func (r readOnlyClient) GetServerOption(key string) (string, bool) { return r.c.GetServerOption(key) }
+func (r readOnlyClient) HasCapability(name string) bool            { return r.c.HasCapability(name) }
+func (r readOnlyClient) NetworkName() string                       { return r.c.NetworkName() }
+func (r readOnlyClient) ServerVersion() string                     { return r.c.ServerVersion() }
+func (r readOnlyClient) ServerMOTD() string                        { return r.c.ServerMOTD() }
+func (r readOnlyClient) ServerMOTDLines() []string                 { return r.c.ServerMOTDLines() }
+func (r readOnlyClient) Equal(a, b string) bool                    { return r.c.Equal(a, b) }
+func (r readOnlyClient) IsService(source *Source) bool             { return r.c.IsService(source) }
+func (r readOnlyClient) IsFromSelf(e Event) bool                   { return r.c.IsFromSelf(e) }
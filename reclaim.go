@@ -0,0 +1,137 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"strings"
+	"time"
+)
+
+// NickReclaim configures reclaiming the configured nickname, when it was in
+// use while connecting (and the nick collision handler picked another
+// nickname). See Config.NickReclaim.
+type NickReclaim struct {
+	// Interval is how often to attempt reclaiming the nickname. Defaults to
+	// 1 minute. If the server supports MONITOR, the nickname is also
+	// reclaimed as soon as it becomes available.
+	Interval time.Duration
+	// Password, if supplied, is the NickServ password for the nickname,
+	// which is used to REGAIN the nickname (or GHOST the user using it, see
+	// Ghost) rather than waiting for it to become available.
+	Password string
+	// Ghost uses NickServ GHOST (followed by NICK), rather than REGAIN, for
+	// services which don't support REGAIN.
+	Ghost bool
+}
+
+// defaultReclaimInterval is the default for NickReclaim.Interval.
+const defaultReclaimInterval = time.Minute
+
+// reclaimNick returns the nickname which should be reclaimed, and if the
+// client isn't currently using it.
+func (c *Client) reclaimNick() (nick string, needed bool) {
+	nick = c.Identity().Nick
+	return nick, !c.Equal(c.GetNick(), nick)
+}
+
+// reclaim attempts to reclaim nick once.
+func (c *Client) reclaim(nick string) {
+	opts := c.Config.NickReclaim
+
+	if opts.Password == "" {
+		c.Cmd.Nick(nick)
+		return
+	}
+
+	if opts.Ghost {
+		c.Services.Ghost(nick, opts.Password)
+		c.Cmd.Nick(nick)
+		return
+	}
+
+	c.Services.Regain(nick, opts.Password)
+}
+
+// handleReclaim reclaims the configured nickname, see Config.NickReclaim.
+func handleReclaim(c *Client, e Event) {
+	if c.Config.NickReclaim == nil || c.Config.disableTracking {
+		return
+	}
+
+	switch e.Command {
+	case CONNECTED:
+		nick, needed := c.reclaimNick()
+		if !needed {
+			return
+		}
+
+		if _, ok := c.GetServerOption(MONITOR); ok {
+			c.Send(&Event{Command: MONITOR, Params: []string{"+", nick}})
+		}
+
+		c.reclaim(nick)
+		go reclaimLoop(c, nick)
+	case RPL_MONOFFLINE:
+		// The nickname is now available.
+		nick, needed := c.reclaimNick()
+		if !needed {
+			return
+		}
+
+		for _, target := range strings.Split(e.Trailing, ",") {
+			if c.Equal(target, nick) {
+				c.Cmd.Nick(nick)
+				return
+			}
+		}
+	}
+}
+
+// nickReclaimed is called by the state tracker (see handleNICK) once our
+// nickname has changed from old to nick, so that NICK_RECLAIMED handlers see
+// the updated state.
+func nickReclaimed(c *Client, old, nick string) {
+	if c.Config.NickReclaim == nil || !c.Equal(nick, c.Identity().Nick) || c.Equal(old, nick) {
+		return
+	}
+
+	if _, ok := c.GetServerOption(MONITOR); ok {
+		c.Send(&Event{Command: MONITOR, Params: []string{"-", nick}})
+	}
+
+	c.RunHandlers(&Event{Command: NICK_RECLAIMED, Params: []string{old, nick}})
+}
+
+// reclaimLoop periodically attempts to reclaim nick, until it's reclaimed,
+// or the client disconnects.
+func reclaimLoop(c *Client, nick string) {
+	interval := c.Config.NickReclaim.Interval
+	if interval <= 0 {
+		interval = defaultReclaimInterval
+	}
+
+	c.mu.RLock()
+	connection := c.attempts
+	c.mu.RUnlock()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		c.mu.RLock()
+		reconnected := c.attempts != connection
+		c.mu.RUnlock()
+
+		if reconnected || !c.IsConnected() {
+			return
+		}
+
+		if _, needed := c.reclaimNick(); !needed {
+			return
+		}
+
+		c.reclaim(nick)
+	}
+}
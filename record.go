@@ -0,0 +1,123 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import "sync"
+
+// recording is a ring buffer of the events delivered to a recorded handler,
+// see Caller.AddRecorded().
+type recording struct {
+	mu     sync.Mutex
+	events []Event
+	// next is the index in events which the next event is recorded to.
+	next int
+	// full is true once events has wrapped around.
+	full bool
+}
+
+// add records a copy of event, overwriting the oldest event if full.
+func (r *recording) add(event Event) {
+	// Annotations are specific to the original dispatch, so replayed events
+	// start without them.
+	recorded := event.Copy()
+	recorded.annotations = nil
+
+	r.mu.Lock()
+	r.events[r.next] = *recorded
+	r.next = (r.next + 1) % len(r.events)
+	if r.next == 0 {
+		r.full = true
+	}
+	r.mu.Unlock()
+}
+
+// list returns copies of the recorded events, oldest first.
+func (r *recording) list() []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var events []Event
+	if r.full {
+		events = append(events, r.events[r.next:]...)
+	}
+	events = append(events, r.events[:r.next]...)
+
+	for i := 0; i < len(events); i++ {
+		events[i] = *events[i].Copy()
+	}
+
+	return events
+}
+
+// AddRecorded registers the handler function for the given event, much like
+// Caller.Add(), however the last size events delivered to the handler are
+// recorded under name. This is useful while developing handlers, as the
+// recorded (real) traffic can be re-dispatched to a new implementation of
+// the handler with Caller.Replay(), without having to reproduce it. Adding
+// another recorded handler with the same name starts a new recording. The
+// recording is kept after the handler is removed.
+func (c *Caller) AddRecorded(name string, size int, cmd string, handler func(client *Client, event Event)) (cuid string) {
+	if size < 1 {
+		size = 1
+	}
+
+	rec := &recording{events: make([]Event, size)}
+
+	c.mu.Lock()
+	if c.recordings == nil {
+		c.recordings = make(map[string]*recording)
+	}
+	c.recordings[name] = rec
+	c.mu.Unlock()
+
+	return c.sregister(false, cmd, HandlerFunc(func(client *Client, event Event) {
+		rec.add(event)
+		handler(client, event)
+	}))
+}
+
+// Recorded returns the events recorded under name (see AddRecorded()),
+// oldest first.
+func (c *Caller) Recorded(name string) []Event {
+	c.mu.RLock()
+	rec, ok := c.recordings[name]
+	c.mu.RUnlock()
+
+	if !ok {
+		return nil
+	}
+
+	return rec.list()
+}
+
+// Replay re-dispatches the events recorded under name (see AddRecorded()) to
+// handler, in the order they were originally delivered, returning the amount
+// of events replayed. Unlike the original delivery, handler is called
+// synchronously, one event at a time. Events which are replayed are not
+// recorded again.
+func (c *Caller) Replay(name string, client *Client, handler func(client *Client, event Event)) int {
+	events := c.Recorded(name)
+
+	for i := 0; i < len(events); i++ {
+		handler(client, events[i])
+	}
+
+	return len(events)
+}
+
+// ClearRecorded removes the events recorded under name. Recorded handlers
+// which are still registered continue recording.
+func (c *Caller) ClearRecorded(name string) {
+	c.mu.Lock()
+	if rec, ok := c.recordings[name]; ok {
+		rec.mu.Lock()
+		rec.next, rec.full = 0, false
+		for i := range rec.events {
+			rec.events[i] = Event{}
+		}
+		rec.mu.Unlock()
+	}
+	c.mu.Unlock()
+}
@@ -0,0 +1,419 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rejoinBackoff is the initial delay before retrying to join a channel
+// which is temporarily unavailable (ERR_UNAVAILRESOURCE), which is doubled
+// after each attempt, up to rejoinAttempts attempts.
+const (
+	rejoinBackoff  = 15 * time.Second
+	rejoinAttempts = 5
+)
+
+// rejoiner remembers the channels the client was in, so they can be
+// rejoined after reconnecting. See Config.Rejoin.
+type rejoiner struct {
+	mu sync.Mutex
+	// keys are the keys used with Commands.JoinKey(), keyed by the folded
+	// channel name (see Client.fold()).
+	keys map[string]string
+	// pending are the channels which still need to be rejoined, keyed by
+	// the folded channel name.
+	pending map[string]*rejoinChannel
+	// kicks are the channels being rejoined after a kick, keyed by the
+	// folded channel name. See Config.KickRejoin.
+	kicks map[string]*kickRejoin
+}
+
+// rejoinChannel is a channel which is being rejoined.
+type rejoinChannel struct {
+	name     string
+	key      string
+	attempts int
+}
+
+// setKey remembers the key used to join channel, saving the keys to the
+// configured store (see Config.Store). Keys are only remembered if
+// Config.Rejoin is enabled.
+func (r *rejoiner) setKey(c *Client, channel, key string) {
+	if !c.Config.Rejoin {
+		return
+	}
+
+	name := c.fold(channel)
+
+	r.mu.Lock()
+	if r.keys == nil {
+		r.keys = make(map[string]string)
+	}
+	r.keys[name] = key
+	keys := r.copyKeys()
+	r.mu.Unlock()
+
+	c.save(storeChannelKeys, keys)
+}
+
+// removeKey forgets the key used to join channel (e.g. after parting it),
+// saving the keys to the configured store (see Config.Store).
+func (r *rejoiner) removeKey(c *Client, channel string) {
+	name := c.fold(channel)
+
+	r.mu.Lock()
+	if _, ok := r.keys[name]; !ok {
+		r.mu.Unlock()
+		return
+	}
+	delete(r.keys, name)
+	keys := r.copyKeys()
+	r.mu.Unlock()
+
+	c.save(storeChannelKeys, keys)
+}
+
+// copyKeys returns a copy of the known keys, for saving them to the store.
+// This should be called with rejoiner.mu held.
+func (r *rejoiner) copyKeys() map[string]string {
+	keys := make(map[string]string, len(r.keys))
+	for name, key := range r.keys {
+		keys[name] = key
+	}
+
+	return keys
+}
+
+// key returns the key used to join channel, if known.
+func (r *rejoiner) key(c *Client, channel string) string {
+	name := c.fold(channel)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.keys[name]
+}
+
+// snapshot remembers the channels (and their keys) from the state of the
+// last connection, before it is reset. Channels which were still waiting to
+// be rejoined during the last connection are kept. This should be called
+// with Client.mu held.
+func (r *rejoiner) snapshot(c *Client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.pending == nil {
+		r.pending = make(map[string]*rejoinChannel)
+	}
+
+	c.state.RLock()
+	for _, channel := range c.state.channels {
+		name := c.state.fold(channel.Name)
+
+		ch, ok := r.pending[name]
+		if !ok {
+			ch = &rejoinChannel{name: channel.Name, key: r.keys[name]}
+			r.pending[name] = ch
+		}
+
+		if key, ok := channel.Modes.Get("k"); ok && key != "" {
+			ch.key = key
+		}
+	}
+	c.state.RUnlock()
+}
+
+// join sends a JOIN for all channels which still need to be rejoined.
+func (r *rejoiner) join(c *Client) {
+	r.mu.Lock()
+	var plain []string
+	var keyed []*rejoinChannel
+	for _, ch := range r.pending {
+		if ch.key == "" {
+			plain = append(plain, ch.name)
+		} else {
+			keyed = append(keyed, ch)
+		}
+	}
+	r.mu.Unlock()
+
+	sort.Strings(plain)
+	if len(plain) > 0 {
+		c.Cmd.Join(plain...)
+	}
+
+	for _, ch := range keyed {
+		c.Cmd.JoinKey(ch.name, ch.key)
+	}
+}
+
+// joined marks channel as rejoined.
+func (r *rejoiner) joined(c *Client, channel string) {
+	name := c.fold(channel)
+
+	r.mu.Lock()
+	delete(r.pending, name)
+	r.mu.Unlock()
+}
+
+// unavailable schedules channel to be retried, with backoff, if it is
+// being rejoined.
+func (r *rejoiner) unavailable(c *Client, channel string) {
+	name := c.fold(channel)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ch, ok := r.pending[name]
+	if !ok {
+		return
+	}
+
+	if ch.attempts >= rejoinAttempts {
+		c.logger.Warn("giving up rejoining channel", "channel", ch.name, "attempts", ch.attempts)
+		delete(r.pending, name)
+		return
+	}
+
+	delay := rejoinBackoff << uint(ch.attempts)
+	ch.attempts++
+
+	c.mu.RLock()
+	attempt := c.attempts
+	c.mu.RUnlock()
+
+	c.logger.Info("channel is unavailable, retrying rejoin", "channel", ch.name, "delay", delay)
+	time.AfterFunc(delay, func() {
+		c.mu.RLock()
+		reconnected := c.attempts != attempt
+		c.mu.RUnlock()
+
+		r.mu.Lock()
+		_, stillPending := r.pending[name]
+		r.mu.Unlock()
+
+		if reconnected || !stillPending || !c.IsConnected() {
+			return
+		}
+
+		if ch.key != "" {
+			c.Cmd.JoinKey(ch.name, ch.key)
+			return
+		}
+
+		c.Cmd.Join(ch.name)
+	})
+}
+
+// handleRejoin rejoins channels after connecting, and keeps track of which
+// still need to be rejoined, and of their keys. See Config.Rejoin.
+func handleRejoin(c *Client, e Event) {
+	if !c.Config.Rejoin {
+		return
+	}
+
+	switch e.Command {
+	case CONNECTED:
+		c.rejoin.join(c)
+	case JOIN:
+		if e.Source == nil || !c.Equal(e.Source.Name, c.GetNick()) {
+			return
+		}
+
+		if len(e.Params) > 0 {
+			c.rejoin.joined(c, e.Params[0])
+		} else {
+			c.rejoin.joined(c, e.Trailing)
+		}
+	case ERR_UNAVAILRESOURCE:
+		// ERR_UNAVAILRESOURCE is also used for nicknames.
+		if len(e.Params) > 1 && IsValidChannel(e.Params[1]) {
+			c.rejoin.unavailable(c, e.Params[1])
+		}
+	case PART:
+		if e.Source == nil || len(e.Params) < 1 || !c.Equal(e.Source.Name, c.GetNick()) {
+			return
+		}
+
+		c.rejoin.removeKey(c, e.Params[0])
+	case KICK:
+		// The key is still needed to rejoin after the kick, see
+		// Config.KickRejoin.
+		if len(e.Params) < 2 || !c.Equal(e.Params[1], c.GetNick()) || c.Config.KickRejoin != nil {
+			return
+		}
+
+		c.rejoin.removeKey(c, e.Params[0])
+	case MODE:
+		// Some servers send the flags (or last argument) as trailing.
+		params := append([]string(nil), e.Params...)
+		if e.Trailing != "" {
+			params = append(params, e.Trailing)
+		}
+
+		if len(params) < 2 || !IsValidChannel(params[0]) {
+			return
+		}
+
+		for _, mode := range ParseModeChanges(c.ServerOptions(), params[1:]) {
+			if mode.Name() == 'k' && !mode.Add() {
+				c.rejoin.removeKey(c, params[0])
+			}
+		}
+	}
+}
+
+// KickRejoin configures automatically rejoining channels after being kicked.
+// See Config.KickRejoin.
+type KickRejoin struct {
+	// Delay is how long to wait after being kicked (or after a failed
+	// attempt to rejoin) before rejoining. Defaults to 5 seconds.
+	Delay time.Duration
+	// MaxAttempts is the maximum amount of attempts to rejoin the channel
+	// (e.g. if we're banned, or the channel is invite only), after which the
+	// client gives up. Defaults to 3.
+	MaxAttempts int
+}
+
+// kickRejoin is a channel which is being rejoined after a kick.
+type kickRejoin struct {
+	// name is the channel, and folded its folded name (see Client.fold()).
+	name     string
+	folded   string
+	kicker   string
+	reason   string
+	attempts int
+}
+
+// kicked schedules channel to be rejoined, after being kicked by kicker.
+func (r *rejoiner) kicked(c *Client, channel, kicker, reason string) {
+	ch := &kickRejoin{name: channel, folded: c.fold(channel), kicker: kicker, reason: reason}
+
+	r.mu.Lock()
+	if r.kicks == nil {
+		r.kicks = make(map[string]*kickRejoin)
+	}
+	r.kicks[ch.folded] = ch
+	r.mu.Unlock()
+
+	r.scheduleKickRejoin(c, ch)
+}
+
+// joinFailed schedules another attempt to rejoin channel, if it is being
+// rejoined after a kick, and there are attempts remaining.
+func (r *rejoiner) joinFailed(c *Client, channel string) {
+	name := c.fold(channel)
+
+	r.mu.Lock()
+	ch, ok := r.kicks[name]
+	r.mu.Unlock()
+
+	if ok {
+		r.scheduleKickRejoin(c, ch)
+	}
+}
+
+// scheduleKickRejoin emits KICKED_REJOINING, and rejoins the channel after
+// the configured delay, unless the client has reconnected in the meantime.
+func (r *rejoiner) scheduleKickRejoin(c *Client, ch *kickRejoin) {
+	opts := c.Config.KickRejoin
+
+	max := opts.MaxAttempts
+	if max <= 0 {
+		max = 3
+	}
+
+	delay := opts.Delay
+	if delay <= 0 {
+		delay = 5 * time.Second
+	}
+
+	r.mu.Lock()
+	if ch.attempts >= max {
+		c.logger.Warn("giving up rejoining channel after being kicked", "channel", ch.name, "attempts", ch.attempts)
+		delete(r.kicks, ch.folded)
+		r.mu.Unlock()
+
+		r.removeKey(c, ch.name)
+		return
+	}
+	ch.attempts++
+	attempt := ch.attempts
+	key := r.keys[ch.folded]
+	r.mu.Unlock()
+
+	c.RunHandlers(&Event{Command: KICKED_REJOINING, Params: []string{ch.name, ch.kicker, strconv.Itoa(attempt)}, Trailing: ch.reason})
+
+	c.mu.RLock()
+	connection := c.attempts
+	c.mu.RUnlock()
+
+	time.AfterFunc(delay, func() {
+		c.mu.RLock()
+		reconnected := c.attempts != connection
+		c.mu.RUnlock()
+
+		r.mu.Lock()
+		current, ok := r.kicks[ch.folded]
+		r.mu.Unlock()
+
+		if reconnected || !ok || current != ch || !c.IsConnected() {
+			return
+		}
+
+		if key != "" {
+			c.Cmd.JoinKey(ch.name, key)
+			return
+		}
+
+		c.Cmd.Join(ch.name)
+	})
+}
+
+// handleKickRejoin rejoins channels after being kicked. See
+// Config.KickRejoin.
+func handleKickRejoin(c *Client, e Event) {
+	if c.Config.KickRejoin == nil {
+		return
+	}
+
+	switch e.Command {
+	case KICK:
+		if len(e.Params) < 2 || !c.Equal(e.Params[1], c.GetNick()) {
+			return
+		}
+
+		var kicker string
+		if e.Source != nil {
+			kicker = e.Source.Name
+		}
+
+		c.rejoin.kicked(c, e.Params[0], kicker, e.Trailing)
+	case JOIN:
+		if e.Source == nil || !c.Equal(e.Source.Name, c.GetNick()) {
+			return
+		}
+
+		channel := e.Trailing
+		if len(e.Params) > 0 {
+			channel = e.Params[0]
+		}
+
+		name := c.fold(channel)
+
+		c.rejoin.mu.Lock()
+		delete(c.rejoin.kicks, name)
+		c.rejoin.mu.Unlock()
+	default:
+		// Failed to join, e.g. ERR_BANNEDFROMCHAN.
+		if len(e.Params) > 1 {
+			c.rejoin.joinFailed(c, e.Params[1])
+		}
+	}
+}
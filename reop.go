@@ -0,0 +1,204 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultReOpTimeout is the default for ReOp.Timeout.
+const defaultReOpTimeout = 30 * time.Second
+
+// ReOp configures requesting channel operator status back when the client
+// loses it in a managed channel, either because it was deopped, or because
+// it (re)joined the channel without it (e.g. after a restart). A
+// REOP_SUCCEEDED or REOP_FAILED event is emitted with the outcome of each
+// request. See Config.ReOp. Tracking must be enabled for this to work.
+type ReOp struct {
+	// Channels are the managed channels, in which op is requested back.
+	Channels []string
+	// ChanServ, if true, asks ChanServ for op. See Services.Op().
+	ChanServ bool
+	// Func, if supplied, is called to request op in any other way (e.g. by
+	// asking another bot). It is called in the background.
+	Func func(c *Client, channel string)
+	// Timeout is how long to wait for op after requesting it, after which
+	// the request is considered failed. Defaults to 30 seconds.
+	Timeout time.Duration
+
+	mu      sync.Mutex
+	pending map[string]bool
+	// joining are the managed channels we joined, whose NAMES (after which
+	// our own permissions are known) hasn't ended yet, keyed by the folded
+	// channel name.
+	joining map[string]bool
+}
+
+// manages returns true if channel is one of the managed channels.
+func (r *ReOp) manages(c *Client, channel string) bool {
+	for _, ch := range r.Channels {
+		if c.Equal(ch, channel) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// request requests op in channel, unless already requested, and waits for
+// the outcome in the background.
+func (r *ReOp) request(c *Client, channel string) {
+	key := c.fold(channel)
+
+	r.mu.Lock()
+	if r.pending == nil {
+		r.pending = make(map[string]bool)
+	}
+	if r.pending[key] {
+		r.mu.Unlock()
+		return
+	}
+	r.pending[key] = true
+	r.mu.Unlock()
+
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = defaultReOpTimeout
+	}
+
+	var regained int32
+	_, done := c.Handlers.AddTmp(MODE, timeout, func(c *Client, e Event) bool {
+		if ch, given, ok := selfOpChange(c, e); ok && given && c.Equal(ch, channel) {
+			atomic.StoreInt32(&regained, 1)
+			return true
+		}
+
+		return false
+	})
+
+	c.logger.Debug("requesting op", "channel", channel)
+	if r.ChanServ {
+		_ = c.Services.Op(channel)
+	}
+	if r.Func != nil {
+		go r.Func(c, channel)
+	}
+
+	go func() {
+		<-done
+
+		r.mu.Lock()
+		delete(r.pending, key)
+		r.mu.Unlock()
+
+		if atomic.LoadInt32(&regained) == 1 {
+			c.RunHandlers(&Event{Command: REOP_SUCCEEDED, Params: []string{channel}})
+			return
+		}
+
+		c.RunHandlers(&Event{Command: REOP_FAILED, Params: []string{channel}, Trailing: "op not given within " + timeout.String()})
+	}()
+}
+
+// selfOpChange returns the channel in which our own channel operator status
+// was changed by the MODE event e, and if it was given or taken.
+func selfOpChange(c *Client, e Event) (channel string, given, ok bool) {
+	params := append([]string{}, e.Params...)
+	if e.Trailing != "" {
+		params = append(params, e.Trailing)
+	}
+
+	if len(params) < 3 || !IsValidChannel(params[0]) {
+		return "", false, false
+	}
+
+	nick := c.GetNick()
+
+	c.state.RLock()
+	defer c.state.RUnlock()
+
+	ch := c.state.lookupChannel(params[0])
+	if ch == nil {
+		return "", false, false
+	}
+
+	for _, mode := range ch.Modes.Parse(params[1], params[2:]) {
+		if string(mode.name) == ModeOperator && c.state.fold(mode.args) == c.state.fold(nick) {
+			channel, given, ok = ch.Name, mode.add, true
+		}
+	}
+
+	return channel, given, ok
+}
+
+// handleReOp requests op back when we lose it (or join without it) in a
+// managed channel. See Config.ReOp.
+func handleReOp(c *Client, e Event) {
+	r := c.Config.ReOp
+	if r == nil {
+		return
+	}
+
+	switch e.Command {
+	case MODE:
+		if channel, given, ok := selfOpChange(c, e); ok && !given && r.manages(c, channel) {
+			r.request(c, channel)
+		}
+	case JOIN:
+		if e.Source == nil || !c.Equal(e.Source.Name, c.GetNick()) {
+			return
+		}
+
+		channel := e.Trailing
+		if len(e.Params) > 0 {
+			channel = e.Params[0]
+		}
+
+		if !r.manages(c, channel) {
+			return
+		}
+
+		r.mu.Lock()
+		if r.joining == nil {
+			r.joining = make(map[string]bool)
+		}
+		r.joining[c.fold(channel)] = true
+		r.mu.Unlock()
+	case RPL_ENDOFNAMES:
+		// The end of NAMES after joining, by which point our own
+		// permissions are known. NAMES requested at any other time are
+		// ignored.
+		if len(e.Params) < 2 {
+			return
+		}
+
+		key := c.fold(e.Params[1])
+
+		r.mu.Lock()
+		joined := r.joining[key]
+		delete(r.joining, key)
+		r.mu.Unlock()
+
+		if !joined {
+			return
+		}
+
+		nick := c.GetNick()
+
+		c.state.RLock()
+		var perms Perms
+		var in bool
+		if self := c.state.lookupUser(nick); self != nil {
+			perms, in = self.Perms.Lookup(e.Params[1])
+		}
+		c.state.RUnlock()
+
+		if in && !perms.IsAdmin() {
+			r.request(c, e.Params[1])
+		}
+	}
+}
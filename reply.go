@@ -0,0 +1,120 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import "strings"
+
+// statusPrefixes are the STATUSMSG prefixes commonly supported by servers,
+// which can be prepended to a channel to only message the users with that
+// permission (or higher) in the channel, e.g. "@#channel". The prefixes
+// supported by the server are advertised in ISUPPORT STATUSMSG.
+const statusPrefixes = "~&@%+"
+
+// splitStatusMsg splits target into its STATUSMSG prefix (if any, one of
+// prefixes) and the channel. ok is false if target isn't a channel.
+func splitStatusMsg(prefixes, target string) (prefix, channel string, ok bool) {
+	// Some prefixes are also channel types (e.g. "&channel" or "+channel"),
+	// so they're only a prefix if they're followed by a channel.
+	i := 0
+	for i < len(target) && strings.IndexByte(prefixes, target[i]) > -1 {
+		i++
+	}
+
+	if i > 0 && IsValidChannel(target[i:]) {
+		return target[:i], target[i:], true
+	}
+
+	if IsValidChannel(target) {
+		return "", target, true
+	}
+
+	return "", "", false
+}
+
+// statusMsgPrefixes returns the STATUSMSG prefixes supported by the server
+// (see ISUPPORT STATUSMSG), or the commonly supported prefixes if unknown.
+func (c *Client) statusMsgPrefixes() string {
+	if c.Config.disableTracking {
+		return statusPrefixes
+	}
+
+	c.state.RLock()
+	prefixes := c.state.serverOptions["STATUSMSG"]
+	c.state.RUnlock()
+
+	if prefixes == "" {
+		return statusPrefixes
+	}
+
+	return prefixes
+}
+
+// isValidMessageTarget returns true if target can be messaged, i.e. it is a
+// nickname, or a channel with an optional STATUSMSG prefix supported by the
+// server.
+func (c *Client) isValidMessageTarget(target string) bool {
+	_, _, ok := splitStatusMsg(c.statusMsgPrefixes(), target)
+	return ok || IsValidNick(target)
+}
+
+// StatusMsg returns the STATUSMSG prefix and channel the event (e.g. a
+// PRIVMSG or NOTICE) was sent to. For example, for a message sent to
+// "@#channel" (i.e. only to the channel operators), prefix is "@" and
+// channel is "#channel". prefix is empty if the message was sent to the
+// whole channel, and ok is false if it wasn't sent to a channel at all.
+func (e *Event) StatusMsg() (prefix, channel string, ok bool) {
+	if len(e.Params) == 0 {
+		return "", "", false
+	}
+
+	return splitStatusMsg(statusPrefixes, e.Params[0])
+}
+
+// ResponseTarget returns the target a response to the event should be sent
+// to: the channel it was sent to (including its STATUSMSG prefix, e.g.
+// "@#channel" for a message only sent to channel operators, so the response
+// is only seen by them as well), otherwise the nickname of the sender.
+// Empty if the event has no source. Note that messages sent by ourselves
+// through another client (see Client.IsFromSelf()) are taken into account by
+// Event.Reply(), but not here.
+func (e *Event) ResponseTarget() string {
+	if e.Source == nil {
+		return ""
+	}
+
+	if _, _, ok := e.StatusMsg(); ok {
+		return e.Params[0]
+	}
+
+	return e.Source.Name
+}
+
+// Reply sends a reply to the channel or user the event originated from. See
+// Commands.Reply().
+func (e *Event) Reply(c *Client, message string) error {
+	return c.Cmd.Reply(*e, message)
+}
+
+// ReplyTo sends a reply to the channel or user the event originated from,
+// addressing the sender when replying in a channel. See Commands.ReplyTo().
+func (e *Event) ReplyTo(c *Client, message string) error {
+	return c.Cmd.ReplyTo(*e, message)
+}
+
+// responseTarget is much like Event.ResponseTarget(), however replies to
+// the original recipient of messages sent by ourselves through another
+// client attached to the same bouncer user (znc.in/self-message).
+func (cmd *Commands) responseTarget(event Event) (target string, channel bool) {
+	target = event.ResponseTarget()
+	if _, _, ok := splitStatusMsg(statusPrefixes, target); ok {
+		return target, true
+	}
+
+	if len(event.Params) > 0 && !cmd.c.Config.disableTracking && cmd.c.IsFromSelf(event) {
+		return event.Params[0], false
+	}
+
+	return target, false
+}
@@ -0,0 +1,105 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+// RuntimeConfig are the fields of Config which can be changed while the
+// client is running, see Client.UpdateConfig().
+type RuntimeConfig struct {
+	// Nick, User and Name are the identity to register with, see
+	// Config.Nick, Config.User and Config.Name. If the client is connected
+	// and Nick is changed, the client changes its nickname right away. User
+	// and Name are used the next time the client connects.
+	Nick string
+	User string
+	Name string
+	// SupportedCaps are the additional capabilities to request, see
+	// Config.SupportedCaps. These are requested the next time the client
+	// connects.
+	SupportedCaps map[string][]string
+	// AllowFlood, AdaptiveRate and TargetRate are the limits of the rate of
+	// outbound messages, see Config.AllowFlood, Config.AdaptiveRate and
+	// Config.TargetRate. These apply right away, including to messages
+	// which are already waiting to be sent.
+	AllowFlood   bool
+	AdaptiveRate bool
+	TargetRate   *TargetRate
+}
+
+// UpdateConfig changes the fields of Config which can be changed while the
+// client is running (see RuntimeConfig), without racing with the
+// connection. update receives the current values, and changes the ones it
+// needs to, e.g.:
+//
+//	err := client.UpdateConfig(func(conf *girc.RuntimeConfig) {
+//		conf.Nick = "newnick"
+//		conf.AllowFlood = false
+//	})
+//
+// If the resulting config is invalid (see Config.Validate()), including a
+// nickname which isn't valid on the network (see Client.IsValidNick()),
+// nothing is changed, and an ErrInvalidConfig is returned. update must not
+// call any methods of the client.
+func (c *Client) UpdateConfig(update func(conf *RuntimeConfig)) error {
+	nickLen := c.nickLen()
+
+	c.confMu.Lock()
+
+	rc := RuntimeConfig{
+		Nick:          c.Config.Nick,
+		User:          c.Config.User,
+		Name:          c.Config.Name,
+		SupportedCaps: make(map[string][]string, len(c.Config.SupportedCaps)),
+		AllowFlood:    c.Config.AllowFlood,
+		AdaptiveRate:  c.Config.AdaptiveRate,
+		TargetRate:    c.Config.TargetRate,
+	}
+	for k, v := range c.Config.SupportedCaps {
+		rc.SupportedCaps[k] = v
+	}
+
+	update(&rc)
+
+	conf := c.Config
+	conf.Nick, conf.User, conf.Name = rc.Nick, rc.User, rc.Name
+	conf.SupportedCaps = rc.SupportedCaps
+	conf.AllowFlood, conf.AdaptiveRate, conf.TargetRate = rc.AllowFlood, rc.AdaptiveRate, rc.TargetRate
+
+	if err := conf.validate(nickLen); err != nil {
+		c.confMu.Unlock()
+		return err
+	}
+
+	// Only the fields which can change are written, as the others may be
+	// read concurrently.
+	nickChanged := conf.Nick != c.Config.Nick
+	c.Config.Nick, c.Config.User, c.Config.Name = conf.Nick, conf.User, conf.Name
+	c.Config.SupportedCaps = conf.SupportedCaps
+	c.Config.AllowFlood, c.Config.AdaptiveRate, c.Config.TargetRate = conf.AllowFlood, conf.AdaptiveRate, conf.TargetRate
+	c.confMu.Unlock()
+
+	if nickChanged && c.IsConnected() {
+		c.Cmd.Nick(conf.Nick)
+	}
+
+	return nil
+}
+
+// config returns a copy of Config, which is safe to use while the client is
+// running (see Client.UpdateConfig()).
+func (c *Client) config() Config {
+	c.confMu.RLock()
+	defer c.confMu.RUnlock()
+
+	return c.Config
+}
+
+// rateConfig returns Config.AllowFlood, Config.AdaptiveRate and
+// Config.TargetRate, see Client.UpdateConfig().
+func (c *Client) rateConfig() (allowFlood, adaptive bool, target *TargetRate) {
+	c.confMu.RLock()
+	defer c.confMu.RUnlock()
+
+	return c.Config.AllowFlood, c.Config.AdaptiveRate, c.Config.TargetRate
+}
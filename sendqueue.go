@@ -0,0 +1,430 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrQueueDropped is returned when sending an event which was dropped from
+// the send queue before it was sent, see Client.DropQueue().
+var ErrQueueDropped = errors.New("event dropped from the send queue")
+
+// ErrQueueFull is returned when sending an event while the send queue is
+// full, see Config.MaxSendQueue.
+var ErrQueueFull = errors.New("send queue is full")
+
+// defaultMaxSendQueue is the maximum amount of events waiting to be sent, if
+// Config.MaxSendQueue isn't supplied.
+const defaultMaxSendQueue = 1000
+
+// sendQueue tracks the events waiting to be sent. Regular events are queued
+// in Client.tx, and are paced by the rate limit (see Client.paceLoop())
+// before being written by the write loop (see Client.sendLoop()). Control
+// events are queued in Client.txControl, and are written right away. Both
+// spill over into overflow and controlOverflow once they're full, so
+// senders don't block. The amount of waiting events is limited by
+// Config.MaxSendQueue.
+type sendQueue struct {
+	mu sync.Mutex
+	// items are the events which haven't been taken from the queue yet,
+	// including those which were cancelled or dropped, which are skipped
+	// once they're taken.
+	items map[*outgoing]bool
+	// overflow and controlOverflow are the events queued while Client.tx
+	// and Client.txControl were full.
+	overflow, controlOverflow []*outgoing
+	// count is the amount of events waiting to be sent, and regular and
+	// chars are the amount and total length of those which aren't control
+	// events, excluding held.
+	count, regular, chars int
+	// held is the regular event which was taken from the queue, and is
+	// waiting for the rate limit. It is kept across reconnects.
+	held *outgoing
+	// flushing is the amount of regular events which are sent without
+	// waiting for the rate limit, see Client.FlushQueue().
+	flushing int
+	// flush and drop are closed (and replaced) to stop events from waiting,
+	// see Client.FlushQueue() and Client.DropQueue().
+	flush chan struct{}
+	drop  chan struct{}
+}
+
+// outgoing is an event waiting to be sent.
+type outgoing struct {
+	event   *Event
+	control bool
+	// unpaced is true if the event isn't rate limited, see Client.write().
+	unpaced bool
+	// ctx is the context of the sender, if it waits for the event to be
+	// sent (see Client.SendCtx()), in which case done receives the result.
+	ctx  context.Context
+	done chan error
+	// flush and drop are sendQueue.flush and sendQueue.drop, as of when
+	// the event started waiting.
+	flush, drop <-chan struct{}
+	// counted is true while the event is counted as waiting, skip is true
+	// if it was cancelled or dropped, and finished is true once done has
+	// received the result.
+	counted, skip, finished bool
+}
+
+// newSendQueue returns a new empty sendQueue.
+func newSendQueue() *sendQueue {
+	return &sendQueue{
+		items: make(map[*outgoing]bool),
+		flush: make(chan struct{}),
+		drop:  make(chan struct{}),
+	}
+}
+
+// add starts tracking o as waiting to be sent. This returns ErrQueueFull if
+// max (or more) events are already waiting, unless o is control traffic or
+// written by the client itself (see Client.write()), or max is negative.
+func (q *sendQueue) add(o *outgoing, max int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	waiting := q.count
+	if q.held != nil {
+		waiting++
+	}
+
+	if max >= 0 && waiting >= max && !o.control && !o.unpaced {
+		return ErrQueueFull
+	}
+
+	o.flush, o.drop = q.flush, q.drop
+	o.counted = true
+	q.items[o] = true
+	q.count++
+	if !o.control {
+		q.regular++
+		q.chars += o.event.Len()
+	}
+
+	return nil
+}
+
+// uncount stops counting o as waiting. q.mu must be locked.
+func (q *sendQueue) uncount(o *outgoing) {
+	if !o.counted {
+		return
+	}
+
+	o.counted = false
+	q.count--
+	if !o.control {
+		q.regular--
+		q.chars -= o.event.Len()
+	}
+}
+
+// finish sends the result of sending o to its sender, if it's waiting for
+// it. q.mu must be locked.
+func (q *sendQueue) finish(o *outgoing, err error) {
+	if o.finished {
+		return
+	}
+
+	o.finished = true
+	if o.done != nil {
+		o.done <- err
+	}
+}
+
+// done sends the result of sending o to its sender, see sendQueue.finish().
+func (q *sendQueue) done(o *outgoing, err error) {
+	q.mu.Lock()
+	q.finish(o, err)
+	q.mu.Unlock()
+}
+
+// abort stops o from being sent, passing err to its sender. This returns
+// false if o was already taken from the queue.
+func (q *sendQueue) abort(o *outgoing, err error) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.items[o] || o.skip {
+		return false
+	}
+
+	o.skip = true
+	q.uncount(o)
+	q.finish(o, err)
+	return true
+}
+
+// forget stops tracking o, once it won't be queued after all (e.g. when it
+// was aborted while waiting for Config.TargetRate).
+func (q *sendQueue) forget(o *outgoing) {
+	q.mu.Lock()
+	delete(q.items, o)
+	q.mu.Unlock()
+}
+
+// take stops tracking o as queued, once it has been taken from Client.tx or
+// Client.txControl, returning nil if it should be skipped. Regular events
+// are held until released, see sendQueue.release().
+func (q *sendQueue) take(o *outgoing) *outgoing {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.items, o)
+
+	if o.skip {
+		return nil
+	}
+
+	q.uncount(o)
+	if !o.control {
+		o.flush, o.drop = q.flush, q.drop
+		q.held = o
+	}
+
+	return o
+}
+
+// resume returns the held event, if any (e.g. after reconnecting).
+func (q *sendQueue) resume() *outgoing {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.held != nil {
+		q.held.flush, q.held.drop = q.flush, q.drop
+	}
+
+	return q.held
+}
+
+// release stops holding o.
+func (q *sendQueue) release(o *outgoing) {
+	q.mu.Lock()
+	if q.held == o {
+		q.held = nil
+	}
+	q.mu.Unlock()
+}
+
+// discard stops tracking o, once it has been taken from the queue to be
+// discarded rather than sent (e.g. by FakeNetwork.Feed()).
+func (c *Client) discard(o *outgoing) {
+	if o = c.sendq.take(o); o != nil {
+		c.sendq.release(o)
+		c.sendq.done(o, ErrNotConnected)
+	}
+}
+
+// flushed returns true if the next regular event should be sent without
+// waiting for the rate limit, see Client.FlushQueue().
+func (q *sendQueue) flushed() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.flushing == 0 {
+		return false
+	}
+
+	q.flushing--
+	return true
+}
+
+// push queues o in Client.tx (or Client.txControl for control events),
+// without blocking. Events spill over into sendQueue.overflow (or
+// sendQueue.controlOverflow) while the channel is full, see
+// Client.refill().
+func (c *Client) push(o *outgoing) {
+	c.sendq.mu.Lock()
+	defer c.sendq.mu.Unlock()
+
+	tx, overflow := c.tx, &c.sendq.overflow
+	if o.control {
+		tx, overflow = c.txControl, &c.sendq.controlOverflow
+	}
+
+	if len(*overflow) == 0 {
+		select {
+		case tx <- o:
+			return
+		default:
+		}
+	}
+
+	*overflow = append(*overflow, o)
+}
+
+// refill moves the events in sendQueue.overflow and
+// sendQueue.controlOverflow into Client.tx and Client.txControl, as long as
+// there's room for them.
+func (c *Client) refill() {
+	c.sendq.mu.Lock()
+	defer c.sendq.mu.Unlock()
+
+	c.sendq.overflow = refillQueue(c.tx, c.sendq.overflow)
+	c.sendq.controlOverflow = refillQueue(c.txControl, c.sendq.controlOverflow)
+}
+
+// refillQueue moves the events in overflow into tx, as long as there's room
+// for them, returning the ones which are left.
+func refillQueue(tx chan<- *outgoing, overflow []*outgoing) []*outgoing {
+	for len(overflow) > 0 {
+		select {
+		case tx <- overflow[0]:
+			overflow[0] = nil
+			overflow = overflow[1:]
+		default:
+			return overflow
+		}
+	}
+
+	return overflow
+}
+
+// SendQueueStats describes the events waiting to be sent, see
+// Client.SendQueue().
+type SendQueueStats struct {
+	// Length is the amount of events waiting to be sent, including those
+	// waiting for the rate limit.
+	Length int `json:"length"`
+	// Drain is the estimated time until all waiting events have been sent,
+	// based on the current rate limit.
+	Drain time.Duration `json:"drain"`
+}
+
+// SendQueue returns the amount of events waiting to be sent, and how long
+// it's estimated to take to send them, e.g. to avoid sending more messages
+// while the client is catching up.
+func (c *Client) SendQueue() SendQueueStats {
+	c.sendq.mu.Lock()
+	stats := SendQueueStats{Length: c.sendq.count}
+	regular, chars := c.sendq.regular, c.sendq.chars
+	if c.sendq.held != nil {
+		stats.Length++
+	}
+	c.sendq.mu.Unlock()
+
+	c.mu.RLock()
+	conn := c.conn
+	c.mu.RUnlock()
+
+	if allowFlood, _, _ := c.rateConfig(); conn == nil || allowFlood || stats.Length == 0 {
+		return stats
+	}
+
+	// The write delay already includes the held event, and events are paced
+	// once it's over the allowed burst (see ircConn.rate()).
+	conn.mu.RLock()
+	delay := conn.writeDelay - time.Since(conn.lastWrite)
+	if regular > 0 {
+		delay += time.Duration(regular-1)*conn.cost(0) + conn.cost(chars)
+	}
+	conn.mu.RUnlock()
+
+	if stats.Drain = delay - maxWriteDelay; stats.Drain < 0 {
+		stats.Drain = 0
+	}
+
+	return stats
+}
+
+// FlushQueue sends the events which are waiting for the rate limit right
+// away, returning how many were flushed. Note that sending too much at once
+// may cause the server to disconnect the client for flooding.
+func (c *Client) FlushQueue() (flushed int) {
+	c.sendq.mu.Lock()
+	defer c.sendq.mu.Unlock()
+
+	c.sendq.flushing = c.sendq.regular
+	flushed = c.sendq.regular
+	if c.sendq.held != nil {
+		flushed++
+	}
+
+	close(c.sendq.flush)
+	c.sendq.flush = make(chan struct{})
+
+	return flushed
+}
+
+// DropQueue drops the events waiting to be sent (excluding control traffic,
+// see Client.Send()), returning how many were dropped. Senders waiting for
+// them to be sent get ErrQueueDropped, see Client.SendCtx(). See also
+// Config.DropQueueOnDisconnect.
+func (c *Client) DropQueue() (dropped int) {
+	c.sendq.mu.Lock()
+	for o := range c.sendq.items {
+		if !o.control && !o.skip {
+			o.skip = true
+			c.sendq.uncount(o)
+			c.sendq.finish(o, ErrQueueDropped)
+			dropped++
+		}
+	}
+
+	if c.sendq.held != nil {
+		c.sendq.finish(c.sendq.held, ErrQueueDropped)
+		c.sendq.held = nil
+		dropped++
+	}
+
+	for i := 0; i < len(c.sendq.overflow); i++ {
+		delete(c.sendq.items, c.sendq.overflow[i])
+	}
+	c.sendq.overflow = nil
+	c.sendq.flushing = 0
+
+	close(c.sendq.drop)
+	c.sendq.drop = make(chan struct{})
+	c.sendq.mu.Unlock()
+
+	for {
+		select {
+		case o := <-c.tx:
+			c.sendq.forget(o)
+		default:
+			if dropped > 0 {
+				c.logger.Info("dropped queued events", "dropped", dropped)
+			}
+
+			return dropped
+		}
+	}
+}
+
+// dropControl drops the control events which are still waiting to be sent
+// once the connection has ended, as they only apply to the connection they
+// were sent on (e.g. PONG or AUTHENTICATE). Senders waiting for them to be
+// sent get ErrNotConnected.
+func (c *Client) dropControl() {
+	c.sendq.mu.Lock()
+	defer c.sendq.mu.Unlock()
+
+	drop := func(o *outgoing) {
+		delete(c.sendq.items, o)
+		if !o.skip {
+			o.skip = true
+			c.sendq.uncount(o)
+			c.sendq.finish(o, ErrNotConnected)
+		}
+	}
+
+	for _, o := range c.sendq.controlOverflow {
+		drop(o)
+	}
+	c.sendq.controlOverflow = nil
+
+	for {
+		select {
+		case o := <-c.txControl:
+			drop(o)
+		default:
+			return
+		}
+	}
+}
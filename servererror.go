@@ -0,0 +1,211 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import "strings"
+
+// Categories of ERROR messages sent by the server before it closes the
+// connection, see ServerError.
+const (
+	ServerErrorUnknown   = "unknown"   // the reason couldn't be categorized
+	ServerErrorQuit      = "quit"      // we quit, e.g. with Commands.Quit()
+	ServerErrorBanned    = "banned"    // we're banned from the server (e.g. K-line, G-line, Z-line)
+	ServerErrorThrottled = "throttled" // we're reconnecting too fast
+	ServerErrorShutdown  = "shutdown"  // the server is shutting down or restarting
+	ServerErrorTimeout   = "timeout"   // ping or registration timeout
+	ServerErrorKilled    = "killed"    // we were killed by an operator or services
+	ServerErrorFlood     = "flood"     // we sent too much, too quickly (e.g. excess flood, max sendq)
+)
+
+// serverErrorCategories maps (lowercase) text found in ERROR messages to
+// their category, checked in order.
+var serverErrorCategories = []struct {
+	text     string
+	category string
+}{
+	{"quit", ServerErrorQuit},
+	{"killed", ServerErrorKilled},
+	{"k-line", ServerErrorBanned},
+	{"g-line", ServerErrorBanned},
+	{"z-line", ServerErrorBanned},
+	{"d-line", ServerErrorBanned},
+	{"banned", ServerErrorBanned},
+	{"throttl", ServerErrorThrottled},
+	{"too fast", ServerErrorThrottled},
+	{"too many connections", ServerErrorThrottled},
+	{"flood", ServerErrorFlood},
+	{"sendq", ServerErrorFlood},
+	{"timeout", ServerErrorTimeout},
+	{"timed out", ServerErrorTimeout},
+	{"shutdown", ServerErrorShutdown},
+	{"shutting down", ServerErrorShutdown},
+	{"restart", ServerErrorShutdown},
+	{"terminating", ServerErrorShutdown},
+}
+
+// ServerError is the structured form of an ERROR message, which the server
+// sends before closing the connection, or of a KILL of the client, see
+// Event.ServerError(). For example:
+//
+//	ERROR :Closing Link: example.com (Ping timeout: 240 seconds)
+//
+// It's passed to Config.HandleError once the server has closed (or is about
+// to close) the connection, see also Client.LastServerError().
+type ServerError struct {
+	// Reason is the full text of the ERROR.
+	Reason string `json:"reason"`
+	// ClosingLink is true if the text was of the common "Closing Link:
+	// <host> (<message>)" form, in which case Host is our host (or
+	// "nick[host]", depending on the server).
+	ClosingLink bool   `json:"closing_link"`
+	Host        string `json:"host,omitempty"`
+	// Message is the reason the connection is being closed, without the
+	// "Closing Link" prefix (e.g. "Ping timeout: 240 seconds").
+	Message string `json:"message"`
+	// Category is the detected reason, one of the ServerError* constants
+	// (e.g. ServerErrorBanned or ServerErrorThrottled).
+	Category string `json:"category"`
+	// KilledBy is the nickname (or server) which killed the client, if it
+	// was killed with KILL.
+	KilledBy string `json:"killed_by,omitempty"`
+}
+
+// Error returns the reason of the error, e.g. "Closing Link: example.com
+// (Ping timeout: 240 seconds)".
+func (err *ServerError) Error() string {
+	if err.KilledBy != "" {
+		return "killed by " + err.KilledBy + ": " + err.Reason
+	}
+
+	return err.Reason
+}
+
+// ServerError parses an ERROR or KILL event into its structured form. ok is
+// false if the event is neither.
+func (e *Event) ServerError() (err *ServerError, ok bool) {
+	if e.Command == KILL {
+		err = &ServerError{Reason: e.Trailing, Message: e.Trailing, Category: ServerErrorKilled}
+		if e.Source != nil {
+			err.KilledBy = e.Source.Name
+		}
+
+		return err, true
+	}
+
+	if e.Command != ERROR {
+		return nil, false
+	}
+
+	err = &ServerError{Reason: e.Trailing, Message: e.Trailing}
+	if e.Trailing == "" && len(e.Params) > 0 {
+		err.Reason = strings.Join(e.Params, " ")
+		err.Message = err.Reason
+	}
+
+	if len(err.Reason) > 13 && strings.EqualFold(err.Reason[:13], "closing link:") {
+		err.ClosingLink = true
+		rest := strings.TrimSpace(err.Reason[13:])
+
+		if i := strings.IndexByte(rest, '('); i > -1 && strings.HasSuffix(rest, ")") {
+			err.Host = strings.TrimSpace(rest[:i])
+			err.Message = rest[i+1 : len(rest)-1]
+		} else {
+			err.Host, err.Message = rest, ""
+			if i := strings.IndexByte(rest, eventSpace); i > -1 {
+				err.Host, err.Message = rest[:i], strings.TrimSpace(rest[i+1:])
+			}
+		}
+	}
+
+	err.Category = ServerErrorUnknown
+	message := strings.ToLower(err.Message)
+	for _, c := range serverErrorCategories {
+		if strings.Contains(message, c.text) {
+			err.Category = c.category
+			break
+		}
+	}
+
+	return err, true
+}
+
+// isServerErrorCategory returns true if category is one of the ServerError*
+// constants.
+func isServerErrorCategory(category string) bool {
+	if category == ServerErrorUnknown {
+		return true
+	}
+
+	for _, c := range serverErrorCategories {
+		if c.category == category {
+			return true
+		}
+	}
+
+	return false
+}
+
+// handleKILL surfaces the client being killed, as the server may close the
+// connection without sending an ERROR. See Client.serverError().
+func handleKILL(c *Client, e Event) {
+	if len(e.Params) == 0 || !c.Equal(e.Params[0], c.GetNick()) {
+		return
+	}
+
+	serr, _ := e.ServerError()
+	c.serverError(serr)
+}
+
+// serverError records serr as the reason the connection is being closed
+// (see Client.LastServerError()), and passes it to Config.HandleError. If
+// the client was killed, the ERROR which usually follows is ignored, so
+// HandleError is called only once.
+func (c *Client) serverError(serr *ServerError) {
+	c.mu.Lock()
+	if prev := c.serverErr; prev != nil && prev.KilledBy != "" {
+		// The KILL is more specific than the ERROR.
+		c.mu.Unlock()
+		return
+	}
+	c.serverErr = serr
+	c.mu.Unlock()
+
+	c.logger.Warn("connection closed by server", "category", serr.Category, "reason", serr.Reason)
+
+	if c.Config.HandleError != nil {
+		c.Config.HandleError(c, serr)
+	}
+}
+
+// LastServerError returns the reason the server closed the current (or
+// last) connection, i.e. the ERROR it sent, or the KILL of the client. nil
+// if the server hasn't closed the connection (e.g. if the client quit on
+// its own, or the connection was lost).
+func (c *Client) LastServerError() *ServerError {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.serverErr
+}
+
+// ShouldReconnect returns false if the last connection was closed by the
+// server for a reason listed in Config.NoReconnect (e.g. the client is
+// banned), in which case reconnecting is pointless, or even harmful. Loops
+// which reconnect the client (like Pool) should check this once Connect()
+// returns.
+func (c *Client) ShouldReconnect() bool {
+	serr := c.LastServerError()
+	if serr == nil {
+		return true
+	}
+
+	for _, category := range c.Config.NoReconnect {
+		if category == serr.Category {
+			return false
+		}
+	}
+
+	return true
+}
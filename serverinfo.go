@@ -0,0 +1,275 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Lusers is the response to a LUSERS query, see Commands.Lusers(). Counts
+// which the server didn't supply are 0.
+type Lusers struct {
+	// Users and Invisible are the amount of visible and invisible users on
+	// the network, and Servers the amount of servers.
+	Users     int `json:"users"`
+	Invisible int `json:"invisible"`
+	Servers   int `json:"servers"`
+	// Operators is the amount of IRC operators online.
+	Operators int `json:"operators"`
+	// Unknown is the amount of connections which haven't registered yet.
+	Unknown int `json:"unknown"`
+	// Channels is the amount of channels.
+	Channels int `json:"channels"`
+	// LocalClients and LocalServers are the amount of clients and servers
+	// connected to the current server.
+	LocalClients int `json:"local_clients"`
+	LocalServers int `json:"local_servers"`
+	// LocalUsers and MaxLocalUsers are the current and highest amount of
+	// users on the current server, and GlobalUsers and MaxGlobalUsers on
+	// the network (not all servers send these).
+	LocalUsers     int `json:"local_users"`
+	MaxLocalUsers  int `json:"max_local_users"`
+	GlobalUsers    int `json:"global_users"`
+	MaxGlobalUsers int `json:"max_global_users"`
+}
+
+// ServerAdmin is the response to an ADMIN query, see Commands.AdminWait().
+type ServerAdmin struct {
+	// Server is the server the information is about.
+	Server string `json:"server"`
+	// Location is the location of the server (e.g. city and country), and
+	// Organization the institution hosting it. Servers use these loosely.
+	Location     string `json:"location"`
+	Organization string `json:"organization"`
+	// Email is the email address of the administrator.
+	Email string `json:"email"`
+}
+
+// replyCounts returns the numbers in the text of a reply, e.g. 4 and 1 for
+// "I have 4 clients and 1 servers".
+func replyCounts(text string) (counts []int) {
+	for _, field := range strings.Fields(text) {
+		if n, err := strconv.Atoi(strings.TrimRight(field, ",.;")); err == nil {
+			counts = append(counts, n)
+		}
+	}
+
+	return counts
+}
+
+// paramCount returns the count in param i of e, or 0.
+func paramCount(e *Event, i int) int {
+	if len(e.Params) <= i {
+		return 0
+	}
+
+	n, _ := strconv.Atoi(e.Params[i])
+	return n
+}
+
+// currentAndMax returns the current and highest amount of users in a
+// RPL_LOCALUSERS or RPL_GLOBALUSERS reply, which are either the params
+// following our nickname, or only in the text.
+func currentAndMax(e *Event) (current, max int) {
+	if len(e.Params) > 2 {
+		return paramCount(e, 1), paramCount(e, 2)
+	}
+
+	if counts := replyCounts(e.Trailing); len(counts) > 1 {
+		return counts[0], counts[1]
+	}
+
+	return 0, 0
+}
+
+// pongToken returns the token of a PONG, which is the last param.
+func pongToken(e *Event) string {
+	if e.Trailing != "" || len(e.Params) == 0 {
+		return e.Trailing
+	}
+
+	return e.Params[len(e.Params)-1]
+}
+
+// isNoSuchServer returns true if e is an ERR_NOSUCHSERVER in response to a
+// query for server. There can't be one for queries for the current server.
+func isNoSuchServer(c *Client, e *Event, server string) bool {
+	return e.Command == ERR_NOSUCHSERVER && server != "" && len(e.Params) > 1 && c.Equal(e.Params[1], server)
+}
+
+// fenceToken returns a new token for Commands.doFenced().
+func fenceToken() string {
+	return "girc-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+}
+
+// doFenced is much like Client.Do, however for queries which don't have an
+// end numeric. The query is surrounded by PINGs, and as servers reply to
+// commands in order, the PONGs mark the start and the end of the replies to
+// the query. Events before the first PONG (e.g. the same numerics sent while
+// connecting) aren't passed to done or collect. The last PONG is returned as
+// the last event, unless done matched an event before it.
+func (cmd *Commands) doFenced(ctx context.Context, query *Event, done, collect func(e *Event) bool) ([]*Event, error) {
+	end := fenceToken()
+	start := end + "-start"
+
+	// started is only used by the filters, which Client.do() calls for one
+	// event at a time.
+	var started bool
+
+	return cmd.c.do(ctx,
+		func() error {
+			cmd.c.Send(&Event{Command: PING, Params: []string{start}})
+
+			if err := cmd.c.SendCtx(ctx, query); err != nil {
+				return err
+			}
+
+			cmd.c.Send(&Event{Command: PING, Params: []string{end}})
+			return nil
+		},
+		func(e *Event) bool {
+			if !started {
+				started = e.Command == PONG && pongToken(e) == start
+				return false
+			}
+
+			return (e.Command == PONG && pongToken(e) == end) || (done != nil && done(e))
+		},
+		func(e *Event) bool {
+			return started && collect != nil && collect(e)
+		},
+	)
+}
+
+// Lusers sends a LUSERS query, and waits for the server to respond (or ctx
+// to be cancelled), returning the user, server and channel counts of the
+// network, e.g. for a status dashboard. This should not be called from
+// non-background handlers, as the response can't be processed until they
+// return.
+func (cmd *Commands) Lusers(ctx context.Context) (*Lusers, error) {
+	events, err := cmd.doFenced(ctx, &Event{Command: LUSERS}, nil, func(e *Event) bool {
+		switch e.Command {
+		case RPL_LUSERCLIENT, RPL_LUSEROP, RPL_LUSERUNKNOWN, RPL_LUSERCHANNELS,
+			RPL_LUSERME, RPL_LOCALUSERS, RPL_GLOBALUSERS:
+			return true
+		}
+
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	lusers := &Lusers{}
+	for _, e := range events {
+		switch e.Command {
+		case RPL_LUSERCLIENT:
+			if counts := replyCounts(e.Trailing); len(counts) > 2 {
+				lusers.Users, lusers.Invisible, lusers.Servers = counts[0], counts[1], counts[2]
+			}
+		case RPL_LUSEROP:
+			lusers.Operators = paramCount(e, 1)
+		case RPL_LUSERUNKNOWN:
+			lusers.Unknown = paramCount(e, 1)
+		case RPL_LUSERCHANNELS:
+			lusers.Channels = paramCount(e, 1)
+		case RPL_LUSERME:
+			if counts := replyCounts(e.Trailing); len(counts) > 1 {
+				lusers.LocalClients, lusers.LocalServers = counts[0], counts[1]
+			}
+		case RPL_LOCALUSERS:
+			lusers.LocalUsers, lusers.MaxLocalUsers = currentAndMax(e)
+		case RPL_GLOBALUSERS:
+			lusers.GlobalUsers, lusers.MaxGlobalUsers = currentAndMax(e)
+		}
+	}
+
+	return lusers, nil
+}
+
+// AdminWait sends an ADMIN query, and waits for the server to respond (or
+// ctx to be cancelled), returning the administrative contact information of
+// server, or the current server if empty. If the server doesn't exist or
+// has no administrative information, an ErrEvent is returned (with
+// ERR_NOSUCHSERVER or ERR_NOADMININFO). This should not be called from
+// non-background handlers, as the response can't be processed until they
+// return.
+func (cmd *Commands) AdminWait(ctx context.Context, server string) (*ServerAdmin, error) {
+	query, err := serverQueryEvent(ADMIN, server)
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := cmd.doFenced(ctx, query,
+		func(e *Event) bool { return e.Command == ERR_NOADMININFO || isNoSuchServer(cmd.c, e, server) },
+		func(e *Event) bool {
+			switch e.Command {
+			case RPL_ADMINME, RPL_ADMINLOC1, RPL_ADMINLOC2, RPL_ADMINEMAIL:
+				return true
+			}
+
+			return false
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	admin := &ServerAdmin{Server: server}
+	for _, e := range events {
+		switch e.Command {
+		case RPL_ADMINME:
+			if len(e.Params) > 1 {
+				admin.Server = e.Params[1]
+			}
+		case RPL_ADMINLOC1:
+			admin.Location = e.Trailing
+		case RPL_ADMINLOC2:
+			admin.Organization = e.Trailing
+		case RPL_ADMINEMAIL:
+			admin.Email = e.Trailing
+		case ERR_NOSUCHSERVER, ERR_NOADMININFO:
+			return nil, &ErrEvent{Event: e}
+		}
+	}
+
+	return admin, nil
+}
+
+// Info sends an INFO query, and waits for the server to respond (or ctx to
+// be cancelled), returning the lines describing the software of server (or
+// the current server if empty), e.g. its version and authors. If the server
+// doesn't exist, an ErrEvent is returned (with ERR_NOSUCHSERVER). This
+// should not be called from non-background handlers, as the response can't
+// be processed until they return.
+func (cmd *Commands) Info(ctx context.Context, server string) ([]string, error) {
+	query, err := serverQueryEvent(INFO, server)
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := cmd.c.Do(ctx, query,
+		func(e *Event) bool { return e.Command == RPL_ENDOFINFO || isNoSuchServer(cmd.c, e, server) },
+		func(e *Event) bool { return e.Command == RPL_INFO },
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	for _, e := range events {
+		switch e.Command {
+		case RPL_INFO:
+			lines = append(lines, e.Trailing)
+		case ERR_NOSUCHSERVER:
+			return nil, &ErrEvent{Event: e}
+		}
+	}
+
+	return lines, nil
+}
@@ -0,0 +1,231 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// knownServices are the nicknames commonly used by network services, in
+// rfc1459 folded form.
+var knownServices = map[string]bool{
+	"nickserv": true, "chanserv": true, "memoserv": true, "operserv": true,
+	"hostserv": true, "botserv": true, "saslserv": true, "infoserv": true,
+	"helpserv": true, "statserv": true, "groupserv": true, "authserv": true,
+	"global": true, "alis": true,
+	// QuakeNet and Undernet.
+	"q": true, "x": true,
+}
+
+// knownServiceHosts are the hosts of services on networks which don't use a
+// "services." host, in lowercase.
+var knownServiceHosts = map[string]bool{
+	"cserve.quakenet.org":   true,
+	"channels.undernet.org": true,
+}
+
+// IsService returns true if source looks like it's a network services
+// pseudo-client (e.g. NickServ, ChanServ, or Global). This is based on the
+// nickname and host of the source (as regular users cannot spoof the host),
+// the network name advertised via ISUPPORT, and Config.ServiceMasks.
+func (c *Client) IsService(source *Source) bool {
+	if source == nil || source.Name == "" {
+		return false
+	}
+
+	for _, mask := range c.Config.ServiceMasks {
+		if Glob(c.fold(source.String()), c.fold(mask)) {
+			return true
+		}
+	}
+
+	if !knownServices[c.fold(source.Name)] {
+		return false
+	}
+
+	// Some servers send services messages without a hostmask.
+	if source.IsServer() {
+		return !strings.Contains(source.Name, ".")
+	}
+
+	host := strings.ToLower(source.Host)
+	if knownServiceHosts[host] || host == "services" || strings.HasPrefix(host, "services.") || strings.Contains(host, ".services.") {
+		return true
+	}
+
+	// Some networks use their network name as the services host (e.g.
+	// "NickServ!NickServ@ExampleNet").
+	if !c.Config.disableTracking {
+		c.state.RLock()
+		network := c.state.serverOptions["NETWORK"]
+		c.state.RUnlock()
+
+		if network != "" && (strings.EqualFold(host, network) || strings.HasSuffix(host, "."+strings.ToLower(network))) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// handleServices re-emits PRIVMSGs and NOTICEs from network services under
+// SERVICE_PRIVMSG and SERVICE_NOTICE.
+func handleServices(c *Client, e Event) {
+	if !e.IsService() {
+		return
+	}
+
+	command := SERVICE_NOTICE
+	if e.Command == PRIVMSG {
+		command = SERVICE_PRIVMSG
+	}
+
+	se := e.Copy()
+	se.Command = command
+	se.annotations = nil
+	c.RunHandlers(se)
+}
+
+// nickServPrompts are (lowercase) fragments of the messages commonly sent by
+// NickServ when the nickname we're using is registered, and we should
+// identify.
+var nickServPrompts = []string{
+	"this nickname is registered",
+	"this nick is owned by someone else",
+	"nickname is registered and protected",
+	"please choose a different nick",
+	"/msg nickserv identify",
+	"/nickserv identify",
+}
+
+// autoIdentifyInterval is the minimum amount of time between identifying
+// automatically, in case NickServ sends multiple prompts.
+const autoIdentifyInterval = 30 * time.Second
+
+// Services contains helper methods to interact with network services, like
+// NickServ and ChanServ. See Client.Services.
+type Services struct {
+	c *Client
+
+	// NickServ is the nickname of the nickname service. Defaults to
+	// "NickServ".
+	NickServ string
+	// ChanServ is the nickname of the channel service. Defaults to
+	// "ChanServ".
+	ChanServ string
+
+	mu sync.Mutex
+	// identified is when we last identified automatically, see
+	// Config.NickServPassword.
+	identified time.Time
+}
+
+// newServices returns a new Services for c, with the default service names.
+func newServices(c *Client) *Services {
+	return &Services{c: c, NickServ: "NickServ", ChanServ: "ChanServ"}
+}
+
+// Identify identifies with NickServ using password. If account is empty, the
+// current nickname is used as the account name.
+func (s *Services) Identify(account, password string) {
+	text := "IDENTIFY " + password
+	if account != "" {
+		text = "IDENTIFY " + account + " " + password
+	}
+
+	s.c.Send(&Event{Command: PRIVMSG, Params: []string{s.NickServ}, Trailing: text, Sensitive: true})
+}
+
+// Ghost asks NickServ to disconnect the user using nick, which must be
+// registered to us (with password).
+func (s *Services) Ghost(nick, password string) error {
+	if !IsValidNick(nick) {
+		return &ErrInvalidTarget{Target: nick}
+	}
+
+	s.c.Send(&Event{Command: PRIVMSG, Params: []string{s.NickServ}, Trailing: "GHOST " + nick + " " + password, Sensitive: true})
+	return nil
+}
+
+// Regain asks NickServ to disconnect the user using nick (which must be
+// registered to us, with password), and to change our nickname to it.
+func (s *Services) Regain(nick, password string) error {
+	if !IsValidNick(nick) {
+		return &ErrInvalidTarget{Target: nick}
+	}
+
+	s.c.Send(&Event{Command: PRIVMSG, Params: []string{s.NickServ}, Trailing: "REGAIN " + nick + " " + password, Sensitive: true})
+	return nil
+}
+
+// Invite asks ChanServ to invite us to channel, which is useful for
+// channels which are invite only.
+func (s *Services) Invite(channel string) error {
+	if !IsValidChannel(channel) {
+		return &ErrInvalidTarget{Target: channel}
+	}
+
+	s.c.Send(&Event{Command: PRIVMSG, Params: []string{s.ChanServ}, Trailing: "INVITE " + channel})
+	return nil
+}
+
+// Op asks ChanServ to give us channel operator status in channel.
+func (s *Services) Op(channel string) error {
+	if !IsValidChannel(channel) {
+		return &ErrInvalidTarget{Target: channel}
+	}
+
+	s.c.Send(&Event{Command: PRIVMSG, Params: []string{s.ChanServ}, Trailing: "OP " + channel})
+	return nil
+}
+
+// Squery sends text to service using SQUERY, which (unlike PRIVMSG) can only
+// be delivered to services. This prevents commands (and passwords) being
+// sent to a user impersonating a service, on networks which support it.
+func (s *Services) Squery(service, text string) error {
+	if service == "" || strings.ContainsAny(service, " ,") {
+		return &ErrInvalidTarget{Target: service}
+	}
+
+	s.c.Send(&Event{Command: SQUERY, Params: []string{service}, Trailing: text})
+	return nil
+}
+
+// handleNickServPrompt identifies with NickServ when it asks us to, see
+// Config.NickServPassword. Notices are matched by the nickname of NickServ
+// (see Services.NickServ) rather than Client.IsService(), as the host of
+// services isn't recognized on all networks.
+func handleNickServPrompt(c *Client, e Event) {
+	if c.Config.NickServPassword == "" || e.Source == nil || !c.Equal(e.Source.Name, c.Services.NickServ) {
+		return
+	}
+
+	text := strings.ToLower(StripFormat(e.Trailing))
+
+	var prompted bool
+	for _, prompt := range nickServPrompts {
+		if strings.Contains(text, prompt) {
+			prompted = true
+			break
+		}
+	}
+
+	if !prompted {
+		return
+	}
+
+	c.Services.mu.Lock()
+	if time.Since(c.Services.identified) < autoIdentifyInterval {
+		c.Services.mu.Unlock()
+		return
+	}
+	c.Services.identified = time.Now()
+	c.Services.mu.Unlock()
+
+	c.logger.Info("identifying with NickServ")
+	c.Services.Identify(c.Config.NickServAccount, c.Config.NickServPassword)
+}
@@ -0,0 +1,58 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import "sync"
+
+// Session is a concurrency safe key-value store scoped to a single
+// connection, which is cleared each time the client (re)connects. It gives
+// handlers a place to keep connection-scoped state (e.g. pending requests,
+// or what has been negotiated with the server), rather than package-level
+// variables which leak across reconnects. See Client.Session.
+type Session struct {
+	mu     sync.RWMutex
+	values map[string]interface{}
+}
+
+// Get returns the value stored under key, and if it was set.
+func (s *Session) Get(key string) (value interface{}, ok bool) {
+	s.mu.RLock()
+	value, ok = s.values[key]
+	s.mu.RUnlock()
+
+	return value, ok
+}
+
+// Set stores value under key, replacing any existing value.
+func (s *Session) Set(key string, value interface{}) {
+	s.mu.Lock()
+	if s.values == nil {
+		s.values = make(map[string]interface{})
+	}
+	s.values[key] = value
+	s.mu.Unlock()
+}
+
+// Delete removes the value stored under key, if any.
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	delete(s.values, key)
+	s.mu.Unlock()
+}
+
+// Len returns the amount of values stored.
+func (s *Session) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.values)
+}
+
+// clear removes all values, see Client.Session.
+func (s *Session) clear() {
+	s.mu.Lock()
+	s.values = nil
+	s.mu.Unlock()
+}
@@ -0,0 +1,165 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// LoadShedding is the policy used to temporarily drop low-value events when
+// the incoming event queue is backed up for an extended period of time (e.g.
+// during netsplits, or other event storms). This keeps the client responsive
+// to events that matter, like PRIVMSG, PING, and MODE.
+//
+// Note that while shedding, state tracking may become inaccurate, as events
+// like JOIN, PART and QUIT may be dropped. Events from the client itself, or
+// which change its own state (e.g. it being kicked), are never dropped.
+type LoadShedding struct {
+	// Threshold is the number of queued events at (or above) which the queue
+	// is considered to be backed up. This must not be larger than
+	// Config.EventQueueSize (25 by default). Defaults to 20, or 80% of
+	// smaller queues.
+	Threshold int
+	// Delay is how long the queue must stay backed up before shedding
+	// starts. If zero, shedding starts as soon as the queue is backed up.
+	Delay time.Duration
+	// Commands are the commands which will be dropped while shedding.
+	// Defaults to DefaultShedCommands.
+	Commands []string
+}
+
+// DefaultShedCommands are the low-value commands which are dropped while
+// shedding, if LoadShedding.Commands isn't supplied.
+var DefaultShedCommands = []string{
+	JOIN, PART, QUIT, NICK, CAP_AWAY, CAP_CHGHOST, CAP_ACCOUNT,
+	RPL_MOTDSTART, RPL_MOTD, RPL_ENDOFMOTD,
+}
+
+// ShedStats are counters related to load shedding. See
+// Config.LoadShedding.
+type ShedStats struct {
+	// Active is true if events are currently being shed.
+	Active bool
+	// Dropped is the total amount of events which have been dropped.
+	Dropped uint64
+	// Episodes is the amount of times shedding has started.
+	Episodes uint64
+}
+
+// shedder holds the runtime state of load shedding.
+type shedder struct {
+	mu         sync.Mutex
+	stats      ShedStats
+	aboveSince time.Time
+	// episodeDropped is the amount of events dropped since shedding last
+	// started.
+	episodeDropped uint64
+}
+
+// ShedStats returns load shedding counters for the client. See
+// Config.LoadShedding.
+func (c *Client) ShedStats() ShedStats {
+	c.shed.mu.Lock()
+	defer c.shed.mu.Unlock()
+
+	return c.shed.stats
+}
+
+// shedEvent checks the current event queue against the load shedding
+// policy, and returns true if the event should be dropped. SHED_STARTED and
+// SHED_STOPPED events are triggered as shedding starts and stops.
+func (c *Client) shedEvent(event *Event) bool {
+	policy := c.Config.LoadShedding
+	if policy == nil || event == nil || event.Command == ERROR {
+		return false
+	}
+
+	threshold := policy.Threshold
+	if threshold <= 0 {
+		threshold = 20
+		if threshold >= cap(c.rx) {
+			threshold = cap(c.rx) * 4 / 5
+		}
+
+		// Otherwise, tiny queues would always be considered backed up.
+		if threshold < 1 {
+			threshold = 1
+		}
+	}
+
+	own := c.ownEvent(event)
+	queued := c.queuedEvents()
+	now := time.Now()
+
+	var started, stopped bool
+	var dropped uint64
+
+	c.shed.mu.Lock()
+	if queued >= threshold {
+		if c.shed.aboveSince.IsZero() {
+			c.shed.aboveSince = now
+		}
+
+		if !c.shed.stats.Active && now.Sub(c.shed.aboveSince) >= policy.Delay {
+			c.shed.stats.Active = true
+			c.shed.stats.Episodes++
+			c.shed.episodeDropped = 0
+			started = true
+		}
+	} else {
+		c.shed.aboveSince = time.Time{}
+
+		// Only stop once the queue has mostly drained, so we don't flap
+		// between states.
+		if c.shed.stats.Active && queued < threshold/2 {
+			c.shed.stats.Active = false
+			dropped = c.shed.episodeDropped
+			stopped = true
+		}
+	}
+
+	var shed bool
+	if c.shed.stats.Active && !own {
+		commands := policy.Commands
+		if commands == nil {
+			commands = DefaultShedCommands
+		}
+
+		for i := 0; i < len(commands); i++ {
+			if commands[i] == event.Command {
+				shed = true
+				c.shed.stats.Dropped++
+				c.shed.episodeDropped++
+				break
+			}
+		}
+	}
+	c.shed.mu.Unlock()
+
+	if started {
+		c.logger.Warn("event queue backed up, shedding events", "queued", queued)
+		c.RunHandlers(&Event{Command: SHED_STARTED, Trailing: strconv.Itoa(queued)})
+	}
+
+	if stopped {
+		c.logger.Info("event queue recovered", "dropped", dropped)
+		c.RunHandlers(&Event{Command: SHED_STOPPED, Trailing: strconv.FormatUint(dropped, 10)})
+	}
+
+	return shed
+}
+
+// ownEvent returns true if event is from the client itself, or changes its
+// own state (i.e. it being kicked), so it must not be shed.
+func (c *Client) ownEvent(event *Event) bool {
+	nick := c.GetNick()
+	if event.Source != nil && c.Equal(event.Source.Name, nick) {
+		return true
+	}
+
+	return event.Command == KICK && len(event.Params) > 1 && c.Equal(event.Params[1], nick)
+}
@@ -27,17 +27,51 @@ type state struct {
 	// last capability check. These will get sent once we have received the
 	// last capability list command from the server.
 	tmpCap []string
+	// serverCaps are the capabilities (and their values, if any) which the
+	// server has advertised during capability negotiation.
+	serverCaps map[string][]string
 	// serverOptions are the standard capabilities and configurations
 	// supported by the server at connection time. This also includes
 	// RPL_ISUPPORT entries.
 	serverOptions map[string]string
-	// motd is the servers message of the day.
-	motd string
+	// motd are the lines of the servers message of the day.
+	motd []string
+	// userModes are the user modes which are set on our own user, e.g. "iwx".
+	userModes string
+	// casemapping is the CASEMAPPING advertised by the server via
+	// ISUPPORT (e.g. "rfc1459" or "ascii"), used to normalize nicknames and
+	// channels. If empty, rfc1459 is assumed.
+	casemapping string
+	// batches are the currently open IRCv3 batches, keyed by their reference
+	// tag.
+	batches map[string]batchInfo
+	// bouncerNetworks are the networks advertised by a bouncer supporting
+	// soju.im/bouncer-networks, keyed by their network ID.
+	bouncerNetworks map[string]*BouncerNetwork
+	// whoReplies are the WHO/WHOX replies which haven't been committed to
+	// state yet, guarded by whoMu rather than the state lock. See
+	// handleWHO().
+	whoMu      sync.Mutex
+	whoReplies []whoReply
+	// streamDeltas is true if state changes should be queued in deltas,
+	// see Config.StateStream.
+	streamDeltas bool
+	deltas       []StateDelta
+}
+
+// batchInfo represents an IRCv3 batch which has been opened by the server.
+type batchInfo struct {
+	// kind is the batch type, e.g. "chathistory" or "netsplit".
+	kind string
+	// replayed is true if the events within the batch are replayed history,
+	// rather than live traffic.
+	replayed bool
 }
 
 // notify sends state change notifications so users can update their refs
 // when state changes.
 func (s *state) notify(c *Client, ntype string) {
+	c.flushDeltas()
 	c.RunHandlers(&Event{Command: ntype})
 }
 
@@ -51,8 +85,18 @@ func (s *state) reset() {
 	s.users = make(map[string]*User)
 	s.serverOptions = make(map[string]string)
 	s.enabledCap = []string{}
-	s.motd = ""
+	s.serverCaps = make(map[string][]string)
+	s.motd = nil
+	s.casemapping = ""
+	s.userModes = ""
+	s.batches = make(map[string]batchInfo)
+	s.bouncerNetworks = make(map[string]*BouncerNetwork)
+	s.deltas = nil
 	s.Unlock()
+
+	s.whoMu.Lock()
+	s.whoReplies = nil
+	s.whoMu.Unlock()
 }
 
 // User represents an IRC user and the state attached to them.
@@ -105,11 +149,32 @@ type User struct {
 		// server/tracking is disabled.
 		Away string `json:"away"`
 	} `json:"extras"`
+
+	// casemapping is the server CASEMAPPING at the time the user was
+	// created, used when comparing channel names.
+	casemapping string
+	// hostUpdated is when the ident/host were last updated, used to refresh
+	// stale hosts (see Config.BanRefreshAge).
+	hostUpdated time.Time
 }
 
-// Channels returns a reference of *Channels that the client knows the user
-// is in. If you're just looking for the namme of the channels, use
-// User.ChannelList.
+// setHost updates the users ident and host (ignoring empty values), and
+// when they were last updated.
+func (u *User) setHost(ident, host string) {
+	if ident != "" {
+		u.Ident = ident
+	}
+
+	if host != "" {
+		u.Host = host
+		u.hostUpdated = time.Now()
+	}
+}
+
+// Channels returns the channels that the client knows the user is in (i.e.
+// the channels shared with the user), kept up to date as users join, part,
+// quit, are kicked, or change their nickname. Each channel is a copy. If
+// you're just looking for the name of the channels, use User.ChannelList.
 func (u User) Channels(c *Client) []*Channel {
 	if c == nil {
 		panic("nil Client provided")
@@ -121,7 +186,7 @@ func (u User) Channels(c *Client) []*Channel {
 	for i := 0; i < len(u.ChannelList); i++ {
 		ch := c.state.lookupChannel(u.ChannelList[i])
 		if ch != nil {
-			channels = append(channels, ch)
+			channels = append(channels, ch.Copy())
 		}
 	}
 	c.state.RUnlock()
@@ -136,6 +201,8 @@ func (u *User) Copy() *User {
 	*nu = *u
 
 	nu.Perms = u.Perms.Copy()
+
+	nu.ChannelList = make([]string, len(u.ChannelList))
 	_ = copy(nu.ChannelList, u.ChannelList)
 
 	return nu
@@ -147,15 +214,15 @@ func (u *User) addChannel(name string) {
 		return
 	}
 
-	u.ChannelList = append(u.ChannelList, ToRFC1459(name))
-	sort.StringsAreSorted(u.ChannelList)
+	u.ChannelList = append(u.ChannelList, casefold(u.casemapping, name))
+	sort.Strings(u.ChannelList)
 
 	u.Perms.set(name, Perms{})
 }
 
 // deleteChannel removes an existing channel from the users channel list.
 func (u *User) deleteChannel(name string) {
-	name = ToRFC1459(name)
+	name = casefold(u.casemapping, name)
 
 	j := -1
 	for i := 0; i < len(u.ChannelList); i++ {
@@ -174,7 +241,7 @@ func (u *User) deleteChannel(name string) {
 
 // InChannel checks to see if a user is in the given channel.
 func (u *User) InChannel(name string) bool {
-	name = ToRFC1459(name)
+	name = casefold(u.casemapping, name)
 
 	for i := 0; i < len(u.ChannelList); i++ {
 		if u.ChannelList[i] == name {
@@ -208,6 +275,11 @@ type Channel struct {
 	Name string `json:"name"`
 	// Topic of the channel.
 	Topic string `json:"topic"`
+	// TopicSetBy is the nickname (or hostmask, depending on the server) of
+	// who set the topic. May be empty if unknown.
+	TopicSetBy string `json:"topic_set_by"`
+	// TopicSetAt is when the topic was set. May be zero if unknown.
+	TopicSetAt time.Time `json:"topic_set_at"`
 
 	// UserList is a sorted list of all users we are currently tracking within
 	// the channel. Each is the nickname, and is rfc1459 compliant.
@@ -216,10 +288,28 @@ type Channel struct {
 	Joined time.Time `json:"joined"`
 	// Modes are the known channel modes that the bot has captured.
 	Modes CModes `json:"modes"`
+	// Lists are the list modes (e.g. "b" for bans, "e" for exceptions, "I"
+	// for invite exceptions) which have been retrieved with
+	// Commands.ListBans() and similar, keyed by the mode. This is only a
+	// snapshot from when the list was last retrieved.
+	Lists map[string][]BanEntry `json:"lists"`
+
+	// casemapping is the server CASEMAPPING at the time the channel was
+	// created, used when comparing nicknames.
+	casemapping string
+	// history is the recent membership changes of the channel, oldest
+	// first, see Client.DiffChannel().
+	history []memberChange
+	// historyStart is the time from which history is complete, if older
+	// changes have been dropped. Otherwise, it's when the channel was
+	// joined.
+	historyStart time.Time
 }
 
-// Users returns a reference of *Users that the client knows the channel has
-// If you're just looking for just the name of the users, use Channnel.UserList.
+// Users returns the users that the client knows the channel has, kept up to
+// date as users join, part, quit, are kicked, or change their nickname. Each
+// user is a copy. If you're just looking for just the name of the users, use
+// Channel.UserList.
 func (ch Channel) Users(c *Client) []*User {
 	if c == nil {
 		panic("nil Client provided")
@@ -231,7 +321,7 @@ func (ch Channel) Users(c *Client) []*User {
 	for i := 0; i < len(ch.UserList); i++ {
 		user := c.state.lookupUser(ch.UserList[i])
 		if user != nil {
-			users = append(users, user)
+			users = append(users, user.Copy())
 		}
 	}
 	c.state.RUnlock()
@@ -257,7 +347,7 @@ func (ch Channel) Trusted(c *Client) []*User {
 
 		perms, ok := user.Perms.Lookup(ch.Name)
 		if ok && perms.IsTrusted() {
-			users = append(users, user)
+			users = append(users, user.Copy())
 		}
 	}
 	c.state.RUnlock()
@@ -284,11 +374,67 @@ func (ch Channel) Admins(c *Client) []*User {
 
 		perms, ok := user.Perms.Lookup(ch.Name)
 		if ok && perms.IsAdmin() {
-			users = append(users, user)
+			users = append(users, user.Copy())
+		}
+	}
+	c.state.RUnlock()
+
+	return users
+}
+
+// MemberSort determines how Channel.Members() sorts the users of a channel.
+type MemberSort int
+
+const (
+	// SortByRank sorts users by their channel permissions (e.g. owner, op,
+	// voice), highest first, and then by nickname.
+	SortByRank MemberSort = iota
+	// SortByNick sorts users by their nickname.
+	SortByNick
+	// SortByIdle sorts users by how recently they were active, most recently
+	// active first.
+	SortByIdle
+)
+
+// Members returns a sorted copy of the users that the client knows the
+// channel has. See Paginate() to split the result into IRC line-sized
+// chunks, e.g. for a "!ops" or "!names" command.
+func (ch Channel) Members(c *Client, by MemberSort) []*User {
+	if c == nil {
+		panic("nil Client provided")
+	}
+
+	users := []*User{}
+
+	c.state.RLock()
+	// Ties are broken by nickname, compared using the server's CASEMAPPING.
+	casemapping := c.state.casemapping
+	for i := 0; i < len(ch.UserList); i++ {
+		user := c.state.lookupUser(ch.UserList[i])
+		if user != nil {
+			users = append(users, user.Copy())
 		}
 	}
 	c.state.RUnlock()
 
+	sort.SliceStable(users, func(i, j int) bool {
+		switch by {
+		case SortByRank:
+			pi, _ := users[i].Perms.Lookup(ch.Name)
+			pj, _ := users[j].Perms.Lookup(ch.Name)
+
+			if pi.Rank() != pj.Rank() {
+				return pi.Rank() > pj.Rank()
+			}
+		case SortByIdle:
+			if !users[i].LastActive.Equal(users[j].LastActive) {
+				return users[i].LastActive.After(users[j].LastActive)
+			}
+		}
+
+		return casefold(casemapping, users[i].Nick) < casefold(casemapping, users[j].Nick)
+	})
+
 	return users
 }
 
@@ -298,13 +444,13 @@ func (ch *Channel) addUser(nick string) {
 		return
 	}
 
-	ch.UserList = append(ch.UserList, ToRFC1459(nick))
+	ch.UserList = append(ch.UserList, casefold(ch.casemapping, nick))
 	sort.Strings(ch.UserList)
 }
 
 // deleteUser removes an existing user from the users list.
 func (ch *Channel) deleteUser(nick string) {
-	nick = ToRFC1459(nick)
+	nick = casefold(ch.casemapping, nick)
 
 	j := -1
 	for i := 0; i < len(ch.UserList); i++ {
@@ -324,11 +470,23 @@ func (ch *Channel) Copy() *Channel {
 	nc := &Channel{}
 	*nc = *ch
 
+	nc.UserList = make([]string, len(ch.UserList))
 	_ = copy(nc.UserList, ch.UserList)
 
 	// And modes.
 	nc.Modes = ch.Modes.Copy()
 
+	// And list modes (bans, etc).
+	if ch.Lists != nil {
+		nc.Lists = make(map[string][]BanEntry, len(ch.Lists))
+		for mode, entries := range ch.Lists {
+			nc.Lists[mode] = append([]BanEntry(nil), entries...)
+		}
+	}
+
+	// The history is only used through Client.DiffChannel().
+	nc.history = nil
+
 	return nc
 }
 
@@ -339,7 +497,7 @@ func (ch *Channel) Len() int {
 
 // UserIn checks to see if a given user is in a channel.
 func (ch *Channel) UserIn(name string) bool {
-	name = ToRFC1459(name)
+	name = casefold(ch.casemapping, name)
 
 	for i := 0; i < len(ch.UserList); i++ {
 		if ch.UserList[i] == name {
@@ -361,15 +519,16 @@ func (s *state) createChannel(name string) (ok bool) {
 	supported := s.chanModes()
 	prefixes, _ := parsePrefixes(s.userPrefixes())
 
-	if _, ok := s.channels[ToRFC1459(name)]; ok {
+	if _, ok := s.channels[s.fold(name)]; ok {
 		return false
 	}
 
-	s.channels[ToRFC1459(name)] = &Channel{
-		Name:     name,
-		UserList: []string{},
-		Joined:   time.Now(),
-		Modes:    NewCModes(supported, prefixes),
+	s.channels[s.fold(name)] = &Channel{
+		Name:        name,
+		UserList:    []string{},
+		Joined:      time.Now(),
+		Modes:       NewCModes(supported, prefixes),
+		casemapping: s.casemapping,
 	}
 
 	return true
@@ -377,7 +536,7 @@ func (s *state) createChannel(name string) (ok bool) {
 
 // deleteChannel removes the channel from state, if not already done.
 func (s *state) deleteChannel(name string) {
-	name = ToRFC1459(name)
+	name = s.fold(name)
 
 	_, ok := s.channels[name]
 	if !ok {
@@ -385,6 +544,10 @@ func (s *state) deleteChannel(name string) {
 	}
 
 	for _, user := range s.channels[name].UserList {
+		if s.users[user] == nil {
+			continue
+		}
+
 		s.users[user].deleteChannel(name)
 
 		if len(s.users[user].ChannelList) == 0 {
@@ -398,30 +561,37 @@ func (s *state) deleteChannel(name string) {
 	delete(s.channels, name)
 }
 
+// fold normalizes a nickname or channel name using the servers
+// casemapping, for use as a state key or for comparisons.
+func (s *state) fold(name string) string {
+	return casefold(s.casemapping, name)
+}
+
 // lookupChannel returns a reference to a channel, nil returned if no results
 // found.
 func (s *state) lookupChannel(name string) *Channel {
-	return s.channels[ToRFC1459(name)]
+	return s.channels[s.fold(name)]
 }
 
 // lookupUser returns a reference to a user, nil returned if no results
 // found.
 func (s *state) lookupUser(name string) *User {
-	return s.users[ToRFC1459(name)]
+	return s.users[s.fold(name)]
 }
 
 // createUser creates the user in state, if not already done.
 func (s *state) createUser(nick string) (ok bool) {
-	if _, ok := s.users[ToRFC1459(nick)]; ok {
+	if _, ok := s.users[s.fold(nick)]; ok {
 		// User already exists.
 		return false
 	}
 
-	s.users[ToRFC1459(nick)] = &User{
-		Nick:       nick,
-		FirstSeen:  time.Now(),
-		LastActive: time.Now(),
-		Perms:      &UserPerms{channels: make(map[string]Perms)},
+	s.users[s.fold(nick)] = &User{
+		Nick:        nick,
+		FirstSeen:   time.Now(),
+		LastActive:  time.Now(),
+		Perms:       &UserPerms{channels: make(map[string]Perms), casemapping: s.casemapping},
+		casemapping: s.casemapping,
 	}
 
 	return true
@@ -436,10 +606,14 @@ func (s *state) deleteUser(channelName, nick string) {
 
 	if channelName == "" {
 		for i := 0; i < len(user.ChannelList); i++ {
-			s.channels[user.ChannelList[i]].deleteUser(nick)
+			if channel := s.channels[user.ChannelList[i]]; channel != nil {
+				channel.deleteUser(nick)
+				channel.recordMember(memberLeft, user.Nick, "")
+				s.addDelta(StateDelta{Type: DeltaQuit, Channel: channel.Name, Nick: user.Nick})
+			}
 		}
 
-		delete(s.users, ToRFC1459(nick))
+		delete(s.users, s.fold(nick))
 		return
 	}
 
@@ -450,21 +624,23 @@ func (s *state) deleteUser(channelName, nick string) {
 
 	user.deleteChannel(channelName)
 	channel.deleteUser(nick)
+	channel.recordMember(memberLeft, user.Nick, "")
+	s.addDelta(StateDelta{Type: DeltaPart, Channel: channel.Name, Nick: user.Nick})
 
 	if len(user.ChannelList) == 0 {
 		// This means they are no longer in any channels we track, delete
 		// them from state.
 
-		delete(s.users, ToRFC1459(nick))
+		delete(s.users, s.fold(nick))
 	}
 }
 
 // renameUser renames the user in state, in all locations where relevant.
 func (s *state) renameUser(from, to string) {
-	from = ToRFC1459(from)
+	from = s.fold(from)
 
 	// Update our nickname.
-	if from == ToRFC1459(s.nick) {
+	if from == s.fold(s.nick) {
 		s.nick = to
 	}
 
@@ -475,15 +651,27 @@ func (s *state) renameUser(from, to string) {
 
 	delete(s.users, from)
 
+	old := user.Nick
 	user.Nick = to
 	user.LastActive = time.Now()
-	s.users[ToRFC1459(to)] = user
+	s.users[s.fold(to)] = user
 
 	for i := 0; i < len(user.ChannelList); i++ {
-		for j := 0; j < len(s.channels[user.ChannelList[i]].UserList); j++ {
-			if s.channels[user.ChannelList[i]].UserList[j] == from {
-				s.channels[user.ChannelList[i]].UserList[j] = ToRFC1459(to)
+		channel := s.channels[user.ChannelList[i]]
+		if channel == nil {
+			continue
+		}
+
+		for j := 0; j < len(channel.UserList); j++ {
+			if channel.UserList[j] == from {
+				channel.UserList[j] = s.fold(to)
 			}
 		}
+
+		// Keep the user list sorted.
+		sort.Strings(channel.UserList)
+
+		channel.recordMember(memberRenamed, old, to)
+		s.addDelta(StateDelta{Type: DeltaNick, Channel: channel.Name, Nick: old, NewNick: to})
 	}
 }
@@ -5,7 +5,12 @@
 package girc
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"reflect"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 )
@@ -74,6 +79,10 @@ func TestState(t *testing.T) {
 			t.Fatalf("Client.ServerMOTD() returned invalid MOTD: %q", motd)
 		}
 
+		if motd := c.ServerMOTDLines(); !reflect.DeepEqual(motd, []string{"example motd"}) {
+			t.Fatalf("Client.ServerMOTDLines() returned invalid MOTD: %q", motd)
+		}
+
 		if network := c.NetworkName(); network != "DummyIRC" {
 			t.Fatalf("Client.NetworkName() returned invalid network name: %q", network)
 		}
@@ -245,3 +254,385 @@ func TestState(t *testing.T) {
 	}
 	c.Handlers.Remove(cuid)
 }
+
+func TestTopicTracking(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test"})
+
+	c.state.Lock()
+	c.state.createChannel("#test")
+	c.state.Unlock()
+
+	changed := make(chan Event, 1)
+	c.Handlers.Add(TOPIC_CHANGED, func(c *Client, e Event) { changed <- e })
+
+	c.RunHandlers(ParseEvent(":dummy.int 332 test #test :old topic"))
+	c.RunHandlers(ParseEvent(":dummy.int 333 test #test alice 1496673001"))
+
+	ch := c.LookupChannel("#test")
+	if ch.Topic != "old topic" || ch.TopicSetBy != "alice" || !ch.TopicSetAt.Equal(time.Unix(1496673001, 0)) {
+		t.Fatalf("Client.LookupChannel() topic = %q by %q at %s, unexpected", ch.Topic, ch.TopicSetBy, ch.TopicSetAt)
+	}
+
+	c.RunHandlers(ParseEvent(":bob!user@host TOPIC #test :new topic"))
+
+	select {
+	case e := <-changed:
+		if !reflect.DeepEqual(e.Params, []string{"#test", "bob", "old topic"}) || e.Trailing != "new topic" {
+			t.Fatalf("TOPIC_CHANGED event = %#v, unexpected", e)
+		}
+	default:
+		t.Fatal("TOPIC_CHANGED event not triggered")
+	}
+
+	if ch = c.LookupChannel("#test"); ch.TopicSetBy != "bob" || ch.TopicSetAt.IsZero() {
+		t.Fatalf("Client.LookupChannel() topic set by %q at %s, unexpected", ch.TopicSetBy, ch.TopicSetAt)
+	}
+}
+
+func TestLookupReturnsCopies(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test", AllowFlood: true})
+
+	events := []string{
+		":test!~test@local.int JOIN #channel",
+		":nick!~user@host.int JOIN #channel",
+		":dummy.int 354 test 1 #channel ~user host.int nick account :Real Name",
+		":dummy.int 315 test #channel :End of /WHO list.",
+		":test!~test@local.int MODE #channel +o nick",
+	}
+
+	for _, raw := range events {
+		c.RunHandlers(ParseEvent(raw))
+	}
+
+	user := c.LookupUser("nick")
+	if user == nil {
+		t.Fatal("Client.LookupUser() returned nil")
+	}
+
+	if user.Ident != "~user" || user.Host != "host.int" || user.Extras.Name != "Real Name" || user.Extras.Account != "account" {
+		t.Fatalf("Client.LookupUser() returned unexpected user: %#v", user)
+	}
+
+	if !reflect.DeepEqual(user.ChannelList, []string{"#channel"}) {
+		t.Fatalf("User.ChannelList = %v, want [#channel]", user.ChannelList)
+	}
+
+	if perms, ok := user.Perms.Lookup("#channel"); !ok || !perms.Op {
+		t.Fatalf("User.Perms.Lookup(#channel) = %#v, %t, want op", perms, ok)
+	}
+
+	// Modifying the copies shouldn't affect state.
+	user.ChannelList[0] = "#modified"
+	user.Perms.set("#channel", Perms{})
+
+	if user = c.LookupUser("nick"); user.ChannelList[0] != "#channel" {
+		t.Fatalf("modifying User.ChannelList of a copy changed state: %v", user.ChannelList)
+	}
+
+	if perms, _ := user.Perms.Lookup("#channel"); !perms.Op {
+		t.Fatal("modifying User.Perms of a copy changed state")
+	}
+
+	channel := c.LookupChannel("#channel")
+	if channel == nil {
+		t.Fatal("Client.LookupChannel() returned nil")
+	}
+
+	channel.UserList[0] = "modified"
+	if channel = c.LookupChannel("#channel"); channel.UserList[0] == "modified" {
+		t.Fatalf("modifying Channel.UserList of a copy changed state: %v", channel.UserList)
+	}
+}
+
+func TestMembershipIndex(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test"})
+
+	n := NewFakeNetwork(c.GetNick(), 7, 20, 5)
+
+	for round := 0; round < 10; round++ {
+		n.Feed(c, 200)
+
+		// Build the expected membership from the network's view.
+		want := make(map[string][]string)
+		for _, name := range n.Channels() {
+			var nicks []string
+			for _, nick := range n.Members(name) {
+				nicks = append(nicks, ToRFC1459(nick))
+				want[ToRFC1459(nick)] = append(want[ToRFC1459(nick)], ToRFC1459(name))
+			}
+
+			var got []string
+			for _, user := range c.LookupChannel(name).Users(c) {
+				got = append(got, ToRFC1459(user.Nick))
+			}
+
+			sort.Strings(nicks)
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, nicks) {
+				t.Fatalf("round %d: Channel.Users() for %s = %v, want %v", round, name, got, nicks)
+			}
+		}
+
+		for nick, channels := range want {
+			user := c.LookupUser(nick)
+			if user == nil {
+				t.Fatalf("round %d: user %q not tracked", round, nick)
+			}
+
+			var got []string
+			for _, ch := range user.Channels(c) {
+				got = append(got, ToRFC1459(ch.Name))
+			}
+
+			sort.Strings(channels)
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, channels) {
+				t.Fatalf("round %d: User.Channels() for %s = %v, want %v", round, nick, got, channels)
+			}
+		}
+
+		if users := c.Users(); len(users) != len(want) {
+			t.Fatalf("round %d: tracking %d users, want %d: %v", round, len(users), len(want), users)
+		}
+	}
+}
+
+func TestWHOBulkImport(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test", AllowFlood: true})
+
+	c.RunHandlers(ParseEvent(":test!~test@local.int JOIN #channel"))
+	c.RunHandlers(ParseEvent(":dummy.int 353 test = #channel :test one two"))
+	c.RunHandlers(ParseEvent(":dummy.int 354 test 1 #channel ~one one.host one acct1 :One"))
+	c.RunHandlers(ParseEvent(":dummy.int 352 test #channel ~two two.host dummy.int two H :0 Two"))
+
+	// Nothing should be committed until the end of the WHO response.
+	if user := c.LookupUser("one"); user.Host != "" {
+		t.Fatalf("WHO reply committed before RPL_ENDOFWHO: %#v", user)
+	}
+
+	var host string
+	c.Handlers.Add(RPL_ENDOFWHO, func(c *Client, e Event) {
+		host = c.LookupUser("two").Host
+	})
+
+	c.RunHandlers(ParseEvent(":dummy.int 315 test #channel :End of /WHO list."))
+
+	if host != "two.host" {
+		t.Fatalf("RPL_ENDOFWHO handler saw host %q, want two.host", host)
+	}
+
+	one := c.LookupUser("one")
+	if one.Ident != "~one" || one.Host != "one.host" || one.Extras.Account != "acct1" || one.Extras.Name != "One" {
+		t.Fatalf("unexpected user after WHOX import: %#v", one)
+	}
+
+	two := c.LookupUser("two")
+	if two.Ident != "~two" || two.Host != "two.host" || two.Extras.Name != "Two" {
+		t.Fatalf("unexpected user after WHO import: %#v", two)
+	}
+}
+
+func TestDiffChannel(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test", AllowFlood: true})
+
+	before := time.Now()
+	c.RunHandlers(ParseEvent(":test!~test@local.int JOIN #channel"))
+	c.RunHandlers(ParseEvent(":dummy.int 353 test = #channel :test a b c d"))
+
+	since := time.Now()
+	for _, raw := range []string{
+		":e!~e@host JOIN #channel",
+		":a!~a@host PART #channel",
+		":b!~b@host NICK b2",
+		":e!~e@host NICK e2",
+		":c!~c@host QUIT :bye",
+		":d!~d@host PART #channel",
+		":d!~d@host JOIN #channel",
+		":f!~f@host JOIN #channel",
+		":test!~test@local.int KICK #channel f",
+	} {
+		c.RunHandlers(ParseEvent(raw))
+	}
+
+	diff := c.DiffChannel("#channel", since)
+	want := &ChannelDiff{
+		Joined:   []string{"e2"},
+		Parted:   []string{"a", "c"},
+		Renamed:  map[string]string{"b": "b2"},
+		Complete: true,
+	}
+	if !reflect.DeepEqual(diff, want) {
+		t.Fatalf("DiffChannel() = %#v, want %#v", diff, want)
+	}
+
+	if diff = c.DiffChannel("#channel", before); diff.Complete {
+		t.Fatal("DiffChannel() from before the channel was joined is complete")
+	}
+
+	if diff = c.DiffChannel("#channel", time.Now()); len(diff.Joined) != 0 || len(diff.Parted) != 0 || len(diff.Renamed) != 0 {
+		t.Fatalf("DiffChannel() from now = %#v, want no changes", diff)
+	}
+
+	if diff = c.DiffChannel("#missing", since); diff != nil {
+		t.Fatalf("DiffChannel() of unknown channel = %#v, want nil", diff)
+	}
+}
+
+func TestMentions(t *testing.T) {
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test", AllowFlood: true})
+
+	c.RunHandlers(ParseEvent(":test!~test@local.int JOIN #channel"))
+	c.RunHandlers(ParseEvent(":dummy.int 353 test = #channel :test bob bob_ Alice[m]"))
+
+	text := "bob_: hi Bob, bobby and ALICE{M} (@bob.)"
+	want := []Mention{
+		{Nick: "bob_", Start: 0, End: 4},
+		{Nick: "bob", Start: 9, End: 12},
+		{Nick: "Alice[m]", Start: 24, End: 32},
+		{Nick: "bob", Start: 35, End: 38},
+	}
+
+	if got := c.Mentions("#channel", text); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Mentions() = %v, want %v", got, want)
+	}
+
+	if got := c.Mentions("#channel", "nobody here"); got != nil {
+		t.Fatalf("Mentions() without mentions = %v, want nil", got)
+	}
+	if got := c.Mentions("#missing", text); got != nil {
+		t.Fatalf("Mentions() for unknown channel = %v, want nil", got)
+	}
+}
+
+func TestStateStream(t *testing.T) {
+	var buf bytes.Buffer
+	var deltas []StateDelta
+	c := New(Config{
+		Server:     "dummy.int",
+		Nick:       "test",
+		User:       "test",
+		AllowFlood: true,
+		StateStream: &StateStream{
+			Writer: &buf,
+			Func:   func(c *Client, delta StateDelta) { deltas = append(deltas, delta) },
+		},
+	})
+
+	for _, raw := range []string{
+		":test!~test@local.int JOIN #channel",
+		":dummy.int 353 test = #channel :test bob",
+		":alice!~alice@host JOIN #channel",
+		":bob!~bob@host NICK robert",
+		":alice!~alice@host TOPIC #channel :new topic",
+		":alice!~alice@host MODE #channel +o robert",
+		":robert!~bob@host PART #channel",
+		":alice!~alice@host QUIT :bye",
+		":test!~test@local.int PART #channel",
+	} {
+		c.RunHandlers(ParseEvent(raw))
+	}
+
+	want := []StateDelta{
+		{Type: DeltaJoin, Channel: "#channel", Nick: "test"},
+		{Type: DeltaJoin, Channel: "#channel", Nick: "alice"},
+		{Type: DeltaNick, Channel: "#channel", Nick: "bob", NewNick: "robert"},
+		{Type: DeltaTopic, Channel: "#channel", Topic: "new topic"},
+		{Type: DeltaMode, Channel: "#channel", Modes: "+o robert"},
+		{Type: DeltaPart, Channel: "#channel", Nick: "robert"},
+		{Type: DeltaQuit, Channel: "#channel", Nick: "alice"},
+		{Type: DeltaPart, Channel: "#channel", Nick: "test"},
+	}
+
+	if len(deltas) != len(want) {
+		t.Fatalf("got %d deltas, want %d: %+v", len(deltas), len(want), deltas)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	for i := range want {
+		if deltas[i].Time.IsZero() {
+			t.Fatalf("delta %d has no time", i)
+		}
+		deltas[i].Time = time.Time{}
+
+		if !reflect.DeepEqual(deltas[i], want[i]) {
+			t.Fatalf("delta %d = %+v, want %+v", i, deltas[i], want[i])
+		}
+
+		var decoded StateDelta
+		if err := json.Unmarshal([]byte(lines[i]), &decoded); err != nil {
+			t.Fatalf("unable to decode streamed delta %q: %s", lines[i], err)
+		}
+		decoded.Time = time.Time{}
+
+		if !reflect.DeepEqual(decoded, want[i]) {
+			t.Fatalf("streamed delta %d = %+v, want %+v", i, decoded, want[i])
+		}
+	}
+}
+
+func benchmarkLargeChannelJoin(b *testing.B, users int) {
+	names := make([]*Event, 0, users/50+1)
+	whox := make([]*Event, 0, users)
+
+	var line []string
+	for i := 0; i < users; i++ {
+		nick := fmt.Sprintf("user%d", i)
+		line = append(line, nick)
+		if len(line) == 50 || i == users-1 {
+			names = append(names, ParseEvent(":dummy.int 353 test = #large :"+strings.Join(line, " ")))
+			line = nil
+		}
+
+		whox = append(whox, ParseEvent(fmt.Sprintf(":dummy.int 354 test 1 #large ~%s %s.host %s 0 :Real Name", nick, nick, nick)))
+	}
+	end := ParseEvent(":dummy.int 315 test #large :End of /WHO list.")
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		b.StopTimer()
+		c := New(Config{Server: "dummy.int", Nick: "test", User: "test", AllowFlood: true})
+		c.RunHandlers(ParseEvent(":test!~test@local.int JOIN #large"))
+		for _, e := range names {
+			c.RunHandlers(e)
+		}
+		b.StartTimer()
+
+		for _, e := range whox {
+			c.RunHandlers(e)
+		}
+		c.RunHandlers(end)
+	}
+}
+
+func BenchmarkLargeChannelJoin1k(b *testing.B)  { benchmarkLargeChannelJoin(b, 1000) }
+func BenchmarkLargeChannelJoin10k(b *testing.B) { benchmarkLargeChannelJoin(b, 10000) }
+
+func TestUserBanMask(t *testing.T) {
+	tests := []struct {
+		user  User
+		style BanMaskStyle
+		want  string
+	}{
+		{User{Nick: "nick", Ident: "~user", Host: "host.example.com"}, BanMaskHost, "*!*@host.example.com"},
+		{User{Nick: "nick", Ident: "~user", Host: "host.example.com"}, BanMaskIdentHost, "*!*user@host.example.com"},
+		{User{Nick: "nick", Ident: "user", Host: "host.example.com"}, BanMaskIdentDomain, "*!user@*.example.com"},
+		{User{Nick: "nick", Ident: "user", Host: "host.example.com"}, BanMaskDomain, "*!*@*.example.com"},
+		{User{Nick: "nick", Ident: "user", Host: "192.0.2.10"}, BanMaskDomain, "*!*@192.0.2.*"},
+		{User{Nick: "nick", Ident: "user", Host: "2001:db8::10"}, BanMaskDomain, "*!*@2001:db8::*"},
+		{User{Nick: "nick", Ident: "user", Host: "user/nick"}, BanMaskDomain, "*!*@user/nick"},
+		{User{Nick: "nick", Ident: "user", Host: "example.com"}, BanMaskDomain, "*!*@example.com"},
+		{User{Nick: "nick", Ident: "user", Host: "host.example.com"}, BanMaskNick, "nick!*@*"},
+		{User{Nick: "nick", Ident: "user"}, BanMaskHost, "nick!*@*"},
+	}
+
+	for _, tt := range tests {
+		got := tt.user.BanMask(tt.style)
+		if got != tt.want {
+			t.Errorf("BanMask(%d) of %s!%s@%s = %q, want %q", tt.style, tt.user.Nick, tt.user.Ident, tt.user.Host, got, tt.want)
+		}
+
+		if !Glob(tt.user.Nick+"!"+tt.user.Ident+"@"+tt.user.Host, got) {
+			t.Errorf("BanMask(%d) = %q, which doesn't match %s!%s@%s", tt.style, got, tt.user.Nick, tt.user.Ident, tt.user.Host)
+		}
+	}
+}
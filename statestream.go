@@ -0,0 +1,100 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Types of state deltas, see StateDelta.Type.
+const (
+	DeltaJoin  = "join"
+	DeltaPart  = "part"
+	DeltaQuit  = "quit"
+	DeltaNick  = "nick"
+	DeltaTopic = "topic"
+	DeltaMode  = "mode"
+)
+
+// StateDelta is a single change to the tracked channel state, see
+// StateStream.
+type StateDelta struct {
+	// Time is when the change was tracked.
+	Time time.Time `json:"time"`
+	// Type is the type of change, e.g. DeltaJoin or DeltaTopic.
+	Type string `json:"type"`
+	// Channel is the channel which changed.
+	Channel string `json:"channel"`
+	// Nick is the user which joined, parted (or was kicked), quit, or
+	// changed nickname (the old nickname), if applicable.
+	Nick string `json:"nick,omitempty"`
+	// NewNick is the new nickname, with DeltaNick.
+	NewNick string `json:"new_nick,omitempty"`
+	// Topic is the new topic, with DeltaTopic.
+	Topic string `json:"topic,omitempty"`
+	// Modes are the mode changes and their arguments (e.g. "+ov nick
+	// other"), with DeltaMode.
+	Modes string `json:"modes,omitempty"`
+}
+
+// StateStream streams changes to the tracked channel state (users joining,
+// parting, quitting and changing nickname, topic and mode changes) as they
+// happen, e.g. to feed a live dashboard rather than polling snapshots with
+// Client.Channels() and friends. See Config.StateStream. Tracking must be
+// enabled for this to work.
+type StateStream struct {
+	// Writer, if supplied, receives each change as a JSON object, followed
+	// by a newline.
+	Writer io.Writer
+	// Func, if supplied, is called with each change.
+	Func func(c *Client, delta StateDelta)
+
+	mu sync.Mutex
+}
+
+// addDelta queues a state change to be streamed once the state has been
+// unlocked, see state.notify(). This should be called with the state lock
+// held.
+func (s *state) addDelta(delta StateDelta) {
+	if !s.streamDeltas {
+		return
+	}
+
+	delta.Time = time.Now()
+	s.deltas = append(s.deltas, delta)
+}
+
+// flushDeltas streams the queued state changes, see Config.StateStream.
+func (c *Client) flushDeltas() {
+	stream := c.Config.StateStream
+	if stream == nil {
+		return
+	}
+
+	// Hold the stream lock while taking the deltas, so concurrent flushes
+	// don't reorder them.
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+
+	c.state.Lock()
+	deltas := c.state.deltas
+	c.state.deltas = nil
+	c.state.Unlock()
+
+	for i := 0; i < len(deltas); i++ {
+		if stream.Writer != nil {
+			if err := json.NewEncoder(stream.Writer).Encode(deltas[i]); err != nil {
+				c.logger.Warn("unable to write state delta", "error", err)
+			}
+		}
+
+		if stream.Func != nil {
+			stream.Func(c, deltas[i])
+		}
+	}
+}
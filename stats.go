@@ -0,0 +1,169 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"sync"
+	"time"
+)
+
+// Stats are counters of the activity of the client, which are kept across
+// reconnects. See Client.Stats().
+type Stats struct {
+	// BytesIn and BytesOut are the amount of bytes read from and written to
+	// the server, and LinesIn and LinesOut the amount of lines.
+	BytesIn  uint64 `json:"bytes_in"`
+	BytesOut uint64 `json:"bytes_out"`
+	LinesIn  uint64 `json:"lines_in"`
+	LinesOut uint64 `json:"lines_out"`
+	// Events is the amount of events dispatched to handlers, by command,
+	// including emulated events (e.g. CONNECTED).
+	Events map[string]uint64 `json:"events"`
+	// Connections is the amount of times the client has connected to a
+	// server, and Reconnects is the amount of those after the first.
+	Connections uint64 `json:"connections"`
+	Reconnects  uint64 `json:"reconnects"`
+	// Handlers are the execution times of handlers, by the command they were
+	// registered for (which may be ALL_EVENTS).
+	Handlers map[string]HandlerStats `json:"handlers"`
+}
+
+// HandlerStats are the execution times of handlers, see Stats.
+type HandlerStats struct {
+	// Calls is the amount of times handlers were executed.
+	Calls uint64 `json:"calls"`
+	// Total is how long they took in total, and Max how long the slowest
+	// took. Note that background handlers (e.g. Caller.AddBg()) return
+	// immediately.
+	Total time.Duration `json:"total"`
+	Max   time.Duration `json:"max"`
+}
+
+// MetricsHook receives the activity of the client as it happens (see
+// Config.Metrics), e.g. to update Prometheus metrics, without having to
+// wrap every handler. Methods are called synchronously from the goroutines
+// of the client, so they must be fast (e.g. only update counters), and safe
+// for concurrent use.
+type MetricsHook interface {
+	// LineRead is called for each line read from the server, with its
+	// length in bytes, including the line ending.
+	LineRead(bytes int)
+	// LineWritten is called for each line written to the server, with its
+	// length in bytes, including the line ending.
+	LineWritten(bytes int)
+	// EventDispatched is called for each event dispatched to handlers,
+	// including emulated events.
+	EventDispatched(command string)
+	// HandlerExecuted is called once a handler has been executed, with the
+	// command it was registered for, and how long it took.
+	HandlerExecuted(command string, duration time.Duration)
+	// Connected is called each time the client has connected to a server.
+	// reconnect is false for the first connection.
+	Connected(reconnect bool)
+}
+
+// stats collects the Stats of the client, and passes its activity on to
+// Config.Metrics.
+type stats struct {
+	mu    sync.Mutex
+	stats Stats
+	hook  MetricsHook
+}
+
+// newStats returns a new stats, with the optional hook.
+func newStats(hook MetricsHook) *stats {
+	return &stats{
+		stats: Stats{Events: make(map[string]uint64), Handlers: make(map[string]HandlerStats)},
+		hook:  hook,
+	}
+}
+
+// lineRead records a line of n bytes read from the server.
+func (s *stats) lineRead(n int) {
+	s.mu.Lock()
+	s.stats.BytesIn += uint64(n)
+	s.stats.LinesIn++
+	s.mu.Unlock()
+
+	if s.hook != nil {
+		s.hook.LineRead(n)
+	}
+}
+
+// lineWritten records a line of n bytes written to the server.
+func (s *stats) lineWritten(n int) {
+	s.mu.Lock()
+	s.stats.BytesOut += uint64(n)
+	s.stats.LinesOut++
+	s.mu.Unlock()
+
+	if s.hook != nil {
+		s.hook.LineWritten(n)
+	}
+}
+
+// eventDispatched records an event being dispatched to handlers.
+func (s *stats) eventDispatched(command string) {
+	s.mu.Lock()
+	s.stats.Events[command]++
+	s.mu.Unlock()
+
+	if s.hook != nil {
+		s.hook.EventDispatched(command)
+	}
+}
+
+// handlerExecuted records a handler registered for command being executed.
+func (s *stats) handlerExecuted(command string, d time.Duration) {
+	s.mu.Lock()
+	h := s.stats.Handlers[command]
+	h.Calls++
+	h.Total += d
+	if d > h.Max {
+		h.Max = d
+	}
+	s.stats.Handlers[command] = h
+	s.mu.Unlock()
+
+	if s.hook != nil {
+		s.hook.HandlerExecuted(command, d)
+	}
+}
+
+// connected records a connection to the server.
+func (s *stats) connected() {
+	s.mu.Lock()
+	reconnect := s.stats.Connections > 0
+	s.stats.Connections++
+	if reconnect {
+		s.stats.Reconnects++
+	}
+	s.mu.Unlock()
+
+	if s.hook != nil {
+		s.hook.Connected(reconnect)
+	}
+}
+
+// Stats returns counters of the activity of the client (bytes and lines
+// read and written, events by command, reconnects, and handler execution
+// times). See also Config.Metrics.
+func (c *Client) Stats() Stats {
+	c.stats.mu.Lock()
+	defer c.stats.mu.Unlock()
+
+	out := c.stats.stats
+	out.Events = make(map[string]uint64, len(c.stats.stats.Events))
+	for command, n := range c.stats.stats.Events {
+		out.Events[command] = n
+	}
+
+	out.Handlers = make(map[string]HandlerStats, len(c.stats.stats.Handlers))
+	for command, h := range c.stats.stats.Handlers {
+		out.Handlers[command] = h
+	}
+
+	return out
+}
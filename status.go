@@ -0,0 +1,82 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"strconv"
+	"sync"
+)
+
+// Status is the state of the connection of a client, see Client.Status().
+// A client goes through each of them in order, starting over at
+// StatusDisconnected for each connection.
+type Status int
+
+const (
+	// StatusDisconnected means the client isn't connected. This is the
+	// initial state.
+	StatusDisconnected Status = iota
+	// StatusConnecting means the client is connecting to the server.
+	StatusConnecting
+	// StatusRegistering means the client is connected, and is registering
+	// with the server (e.g. negotiating capabilities, and authenticating).
+	StatusRegistering
+	// StatusReady means the client has registered with the server (i.e.
+	// received RPL_WELCOME), and can send arbitrary commands.
+	StatusReady
+	// StatusClosing means the connection is being closed (either because
+	// Client.Close() was called, or the connection failed), and the client
+	// is cleaning up.
+	StatusClosing
+)
+
+// String returns the name of the status, e.g. "ready".
+func (s Status) String() string {
+	switch s {
+	case StatusDisconnected:
+		return "disconnected"
+	case StatusConnecting:
+		return "connecting"
+	case StatusRegistering:
+		return "registering"
+	case StatusReady:
+		return "ready"
+	case StatusClosing:
+		return "closing"
+	}
+
+	return "status(" + strconv.Itoa(int(s)) + ")"
+}
+
+// connStatus is the Status of a client.
+type connStatus struct {
+	mu     sync.Mutex
+	status Status
+}
+
+// Status returns the state of the connection of the client. Each change is
+// emitted as a STATUS_CHANGED event.
+func (c *Client) Status() Status {
+	c.status.mu.Lock()
+	defer c.status.mu.Unlock()
+
+	return c.status.status
+}
+
+// setStatus changes the status of the client, emitting STATUS_CHANGED if it
+// changed.
+func (c *Client) setStatus(status Status) {
+	c.status.mu.Lock()
+	old := c.status.status
+	c.status.status = status
+	c.status.mu.Unlock()
+
+	if old == status {
+		return
+	}
+
+	c.logger.Debug("status changed", "status", status, "old", old)
+	c.RunHandlers(&Event{Command: STATUS_CHANGED, Params: []string{status.String(), old.String()}})
+}
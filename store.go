@@ -0,0 +1,149 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// storeChannelKeys is the Store namespace for the keys used to join
+// channels, see Commands.JoinKey().
+const storeChannelKeys = "channel_keys"
+
+// Store is used to persist runtime lists (e.g. the keys of joined channels)
+// across restarts, see Config.Store. Each subsystem uses its own namespace,
+// and always loads or saves the whole namespace at once, so implementations
+// can be kept simple. Implementations must be safe for concurrent use.
+type Store interface {
+	// Load returns all key-values which were saved to namespace. If nothing
+	// has been saved to namespace, Load returns an empty (or nil) map, and
+	// a nil error.
+	Load(namespace string) (map[string]string, error)
+	// Save replaces all key-values stored in namespace with values.
+	Save(namespace string, values map[string]string) error
+}
+
+// FileStore is a Store which keeps all namespaces in a single JSON file.
+type FileStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileStore returns a new FileStore, which uses the file at path. The
+// file (but not its parent directory) is created on the first save.
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+// read reads all namespaces from the file. This should be called with
+// s.mu held.
+func (s *FileStore) read() (map[string]map[string]string, error) {
+	data := make(map[string]map[string]string)
+
+	b, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return data, nil
+		}
+		return nil, err
+	}
+
+	if err = json.Unmarshal(b, &data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// Load implements Store.
+func (s *FileStore) Load(namespace string) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+
+	return data[namespace], nil
+}
+
+// Save implements Store. The file is replaced atomically, so it won't be
+// left partially written if the process is interrupted.
+func (s *FileStore) Save(namespace string, values map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := s.read()
+	if err != nil {
+		return err
+	}
+
+	if len(values) == 0 {
+		delete(data, namespace)
+	} else {
+		data[namespace] = values
+	}
+
+	b, err := json.MarshalIndent(data, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err = tmp.Write(b); err == nil {
+		err = tmp.Sync()
+	}
+
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path)
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+
+	return err
+}
+
+// load loads namespace from the configured store (if any), logging any
+// errors.
+func (c *Client) load(namespace string) map[string]string {
+	if c.Config.Store == nil {
+		return nil
+	}
+
+	values, err := c.Config.Store.Load(namespace)
+	if err != nil {
+		c.logger.Warn("unable to load from store", "namespace", namespace, "error", err)
+		return nil
+	}
+
+	return values
+}
+
+// save saves values to namespace in the configured store (if any), logging
+// any errors.
+func (c *Client) save(namespace string, values map[string]string) {
+	if c.Config.Store == nil {
+		return
+	}
+
+	if err := c.Config.Store.Save(namespace, values); err != nil {
+		c.logger.Warn("unable to save to store", "namespace", namespace, "error", err)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "girc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := NewFileStore(filepath.Join(dir, "store.json"))
+
+	if values, err := store.Load("missing"); err != nil || len(values) != 0 {
+		t.Fatalf("FileStore.Load() with no file = %v, %v", values, err)
+	}
+
+	want := map[string]string{"a": "1", "b": "2"}
+	if err = store.Save("one", want); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Save("two", map[string]string{"c": "3"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new store using the same file should see the same values.
+	store = NewFileStore(filepath.Join(dir, "store.json"))
+	if values, err := store.Load("one"); err != nil || !reflect.DeepEqual(values, want) {
+		t.Fatalf("FileStore.Load() = %v, %v, want %v", values, err, want)
+	}
+
+	if err = store.Save("two", nil); err != nil {
+		t.Fatal(err)
+	}
+	if values, err := store.Load("two"); err != nil || len(values) != 0 {
+		t.Fatalf("FileStore.Load() after clearing = %v, %v", values, err)
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Fatalf("expected only the store file in %s, got %d files", dir, len(files))
+	}
+}
+
+func TestStoreChannelKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "girc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "store.json")
+
+	c := New(Config{Server: "dummy.int", Nick: "test", User: "test", Rejoin: true, Store: NewFileStore(path)})
+	c.Cmd.JoinKey("#secret", "hunter2")
+	<-c.tx
+	c.Cmd.JoinKey("#parted", "hunter3")
+	<-c.tx
+	c.RunHandlers(ParseEvent(":test!test@local.int PART #parted"))
+
+	// A client created later (e.g. after restarting) should remember the key,
+	// but not those of parted channels.
+	c = New(Config{Server: "dummy.int", Nick: "test", User: "test", Rejoin: true, Store: NewFileStore(path)})
+	c.Cmd.Join("#secret", "#public", "#parted")
+
+	for _, want := range []string{"JOIN #secret hunter2", "JOIN #public,#parted"} {
+		if got := (<-c.tx).event.String(); got != want {
+			t.Fatalf("Commands.Join() sent %q, want %q", got, want)
+		}
+	}
+
+	// Keys aren't remembered (nor loaded) if Rejoin is disabled.
+	c = New(Config{Server: "dummy.int", Nick: "test", User: "test", Store: NewFileStore(path)})
+	c.Cmd.JoinKey("#other", "hunter4")
+	<-c.tx
+	c.Cmd.Join("#secret", "#other")
+
+	if got, want := (<-c.tx).event.String(), "JOIN #secret,#other"; got != want {
+		t.Fatalf("Commands.Join() without Rejoin sent %q, want %q", got, want)
+	}
+}
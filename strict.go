@@ -0,0 +1,126 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrProtocolViolation is returned when an outbound event doesn't conform to
+// the protocol, and Config.Strict is enabled. The event isn't sent.
+type ErrProtocolViolation struct {
+	// Event is the event which was rejected.
+	Event *Event
+	// Reason describes the violation, e.g. "param 1 contains a space".
+	Reason string
+}
+
+func (e *ErrProtocolViolation) Error() string {
+	return fmt.Sprintf("protocol violation in %s: %s", e.Event.Command, e.Reason)
+}
+
+// validCommand returns true if cmd is a valid command, i.e. either letters
+// only, or a three digit numeric.
+func validCommand(cmd string) bool {
+	if cmd == "" {
+		return false
+	}
+
+	if isNumeric(cmd) {
+		return len(cmd) == 3
+	}
+
+	for i := 0; i < len(cmd); i++ {
+		if (cmd[i] < 'A' || cmd[i] > 'Z') && (cmd[i] < 'a' || cmd[i] > 'z') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// checkStrict validates e against the limits of the protocol, see
+// Config.Strict. Events which would otherwise be silently mangled when
+// written (e.g. by Event.Bytes()) are rejected with an ErrProtocolViolation.
+func (c *Client) checkStrict(e *Event) error {
+	violation := func(format string, args ...interface{}) error {
+		return &ErrProtocolViolation{Event: e.Copy(), Reason: fmt.Sprintf(format, args...)}
+	}
+
+	if !validCommand(e.Command) {
+		return violation("invalid command %q", e.Command)
+	}
+
+	for i := 0; i < len(e.Params); i++ {
+		switch {
+		case e.Params[i] == "":
+			return violation("param %d is empty", i+1)
+		case strings.ContainsAny(e.Params[i], "\x00\r\n"):
+			return violation("param %d contains NUL, CR or LF", i+1)
+		case strings.IndexByte(e.Params[i], eventSpace) > -1:
+			return violation("param %d contains a space", i+1)
+		case e.Params[i][0] == messagePrefix:
+			return violation("param %d starts with %q", i+1, messagePrefix)
+		}
+	}
+
+	if strings.ContainsAny(e.Trailing, "\x00\r\n") {
+		return violation("trailing contains NUL, CR or LF")
+	}
+
+	// Tags.Len() can't be used, as Tags.Bytes() silently drops the tags
+	// exceeding the limit.
+	tagsLength := 1
+	for name, value := range e.Tags {
+		tagsLength += len(name) + len(value) + 2
+
+		if !validTag(name) {
+			return violation("invalid tag name %q", name)
+		}
+
+		if value != "" && !validTagValue(value) {
+			return violation("invalid value for tag %q", name)
+		}
+	}
+
+	if tagsLength > maxTagLength {
+		return violation("tags too long: %d bytes, limit is %d", tagsLength, maxTagLength)
+	}
+
+	// The tags are limited separately (see above), so they don't count
+	// towards the length of the line.
+	untagged := *e
+	untagged.Tags = nil
+
+	if length := untagged.Len(); length > maxLength {
+		return violation("line too long: %d bytes, limit is %d", length, maxLength)
+	}
+
+	if c.Config.disableTracking {
+		return nil
+	}
+
+	c.state.RLock()
+	_, utf8only := c.state.serverOptions["UTF8ONLY"]
+	c.state.RUnlock()
+
+	if !utf8only {
+		return nil
+	}
+
+	for i := 0; i < len(e.Params); i++ {
+		if !utf8.ValidString(e.Params[i]) {
+			return violation("param %d is not valid UTF-8 (server requires UTF8ONLY)", i+1)
+		}
+	}
+
+	if !utf8.ValidString(e.Trailing) {
+		return violation("trailing is not valid UTF-8 (server requires UTF8ONLY)")
+	}
+
+	return nil
+}
@@ -0,0 +1,156 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ThrottleKey returns the key an event received by c is throttled by, see
+// Throttle().
+type ThrottleKey func(c *Client, e Event) string
+
+// ThrottleBySource throttles events per source nickname (or server name),
+// compared using the server's CASEMAPPING.
+func ThrottleBySource(c *Client, e Event) string {
+	if e.Source == nil {
+		return ""
+	}
+
+	return c.fold(e.Source.Name)
+}
+
+// ThrottleByTarget throttles events per target, i.e. the first param,
+// which is the channel or user for messages, compared using the server's
+// CASEMAPPING.
+func ThrottleByTarget(c *Client, e Event) string {
+	if len(e.Params) == 0 {
+		return ""
+	}
+
+	return c.fold(e.Params[0])
+}
+
+// Throttle wraps handler, so that it is executed at most limit times within
+// each window, per key. Invocations beyond that are suppressed until the
+// window has passed, which lets expensive handlers (e.g. database writes or
+// HTTP calls) survive message storms gracefully. key determines what events
+// are counted together (e.g. ThrottleBySource or ThrottleByTarget), and if
+// nil, all events share a single window.
+//
+// Once a window in which events were suppressed has passed, a THROTTLED
+// event is emitted, with name, the key and the amount of suppressed events.
+// If limit or window are not greater than 0, handler is returned as is.
+//
+//	c.Handlers.AddHandler(girc.PRIVMSG, girc.Throttle(
+//		"logger", 5, 10*time.Second, girc.ThrottleBySource,
+//		girc.HandlerFunc(func(c *girc.Client, e girc.Event) {
+//			// Write to the database.
+//		}),
+//	))
+func Throttle(name string, limit int, window time.Duration, key ThrottleKey, handler Handler) Handler {
+	if limit <= 0 || window <= 0 {
+		return handler
+	}
+
+	return &throttleHandler{
+		Handler: handler,
+		name:    name,
+		limit:   limit,
+		window:  window,
+		key:     key,
+		windows: map[string]*throttleWindow{},
+	}
+}
+
+// throttleHandler is a handler wrapped with Throttle().
+type throttleHandler struct {
+	Handler
+	name   string
+	limit  int
+	window time.Duration
+	key    ThrottleKey
+
+	mu      sync.Mutex
+	windows map[string]*throttleWindow
+	swept   time.Time
+}
+
+// throttleWindow tracks the invocations for a single key, starting at
+// start.
+type throttleWindow struct {
+	start      time.Time
+	count      int
+	suppressed int
+}
+
+// Execute executes the wrapped handler, unless the limit for the key of
+// event has been reached within the current window.
+func (h *throttleHandler) Execute(client *Client, event Event) {
+	var key string
+	if h.key != nil {
+		key = h.key(client, event)
+	}
+
+	now := time.Now()
+
+	h.mu.Lock()
+	h.sweep(now)
+
+	w, ok := h.windows[key]
+	if !ok || now.Sub(w.start) >= h.window {
+		w = &throttleWindow{start: now}
+		h.windows[key] = w
+	}
+
+	w.count++
+	if w.count <= h.limit {
+		h.mu.Unlock()
+		h.Handler.Execute(client, event)
+		return
+	}
+
+	w.suppressed++
+	if w.suppressed == 1 {
+		time.AfterFunc(h.window-now.Sub(w.start), func() {
+			h.summarize(client, key, w)
+		})
+	}
+	h.mu.Unlock()
+}
+
+// summarize emits a THROTTLED event for the passed window w.
+func (h *throttleHandler) summarize(client *Client, key string, w *throttleWindow) {
+	h.mu.Lock()
+	suppressed := w.suppressed
+	if h.windows[key] == w {
+		delete(h.windows, key)
+	}
+	h.mu.Unlock()
+
+	client.RunHandlers(&Event{
+		Command:  THROTTLED,
+		Params:   []string{h.name, key, strconv.Itoa(suppressed)},
+		Trailing: h.window.String(),
+	})
+}
+
+// sweep removes passed windows without suppressed events, at most once per
+// window, so keys which are no longer seen don't accumulate. Windows with
+// suppressed events are removed once summarized. h.mu must be locked.
+func (h *throttleHandler) sweep(now time.Time) {
+	if now.Sub(h.swept) < h.window {
+		return
+	}
+	h.swept = now
+
+	for key, w := range h.windows {
+		if w.suppressed == 0 && now.Sub(w.start) >= h.window {
+			delete(h.windows, key)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"sync"
+	"time"
+)
+
+// Outcomes of a handler in a HandlerTrace.
+const (
+	TraceDone     = "done"     // the handler returned
+	TracePanicked = "panicked" // the handler panicked, see Config.RecoverFunc
+	TraceSkipped  = "skipped"  // the handler wasn't executed, as it only receives live events (see Caller.AddLive())
+)
+
+// HandlerTrace is the record of a single handler processing an event, see
+// EventTrace.
+type HandlerTrace struct {
+	// CUID is the cuid of the handler.
+	CUID string `json:"cuid"`
+	// Command is the command the handler was registered for, which may be
+	// ALL_EVENTS.
+	Command string `json:"command"`
+	// Internal is true for the handlers used internally by the client
+	// (e.g. for tracking).
+	Internal bool `json:"internal"`
+	// Priority is the priority of the handler, see Caller.AddPriority().
+	Priority int `json:"priority"`
+	// Start is when the handler was executed, and Duration how long it took
+	// to return. Note that background handlers (e.g. Caller.AddBg()) return
+	// immediately.
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	// Outcome is one of TraceDone, TracePanicked or TraceSkipped.
+	Outcome string `json:"outcome"`
+}
+
+// EventTrace records the handlers which processed an event, in the order
+// they were executed (handlers with the same priority are executed
+// concurrently, so are ordered by when they returned). See
+// Config.TraceHandlers.
+type EventTrace struct {
+	// Seq is the sequence number of the event, starting at 1 for the first
+	// event dispatched by the client.
+	Seq uint64 `json:"seq"`
+	// MsgID is the "msgid" tag of the event, if any.
+	MsgID string `json:"msgid,omitempty"`
+	// Event is the event which was dispatched.
+	Event *Event `json:"event"`
+	// Time is when the event was dispatched.
+	Time time.Time `json:"time"`
+	// Dropped is true if middleware (see Caller.Use()) didn't pass the event
+	// on to the external handlers.
+	Dropped bool `json:"dropped"`
+	// Handlers are the handlers which processed the event.
+	Handlers []HandlerTrace `json:"handlers"`
+}
+
+// eventTrace is the trace of an event which is still being recorded.
+type eventTrace struct {
+	mu    sync.Mutex
+	trace EventTrace
+}
+
+// add records the handler trace h.
+func (t *eventTrace) add(h HandlerTrace) {
+	t.mu.Lock()
+	t.trace.Handlers = append(t.trace.Handlers, h)
+	t.mu.Unlock()
+}
+
+// copy returns a copy of the trace recorded so far.
+func (t *eventTrace) copy() EventTrace {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	out := t.trace
+	out.Event = t.trace.Event.Copy()
+	out.Handlers = append([]HandlerTrace(nil), t.trace.Handlers...)
+
+	return out
+}
+
+// traceLog keeps the traces of the most recent events.
+type traceLog struct {
+	mu     sync.RWMutex
+	seq    uint64
+	traces []*eventTrace
+	next   int
+}
+
+// start begins a trace for event, replacing the oldest trace once the log
+// is full.
+func (l *traceLog) start(event *Event) *eventTrace {
+	t := &eventTrace{trace: EventTrace{Event: event.Copy(), Time: time.Now()}}
+	t.trace.MsgID, _ = event.Tags.Get("msgid")
+
+	l.mu.Lock()
+	l.seq++
+	t.trace.Seq = l.seq
+	l.traces[l.next] = t
+	l.next = (l.next + 1) % len(l.traces)
+	l.mu.Unlock()
+
+	return t
+}
+
+// find returns the newest trace for which match returns true.
+func (l *traceLog) find(match func(t *eventTrace) bool) (trace EventTrace, ok bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	for i := 1; i <= len(l.traces); i++ {
+		t := l.traces[(l.next-i+len(l.traces))%len(l.traces)]
+		if t != nil && match(t) {
+			return t.copy(), true
+		}
+	}
+
+	return trace, false
+}
+
+// Trace returns the handler execution trace of the event with the given
+// sequence number (see EventTrace.Seq), if it is still retained. Tracing
+// must be enabled, see Config.TraceHandlers.
+func (c *Client) Trace(seq uint64) (trace EventTrace, ok bool) {
+	if c.traces == nil {
+		return trace, false
+	}
+
+	return c.traces.find(func(t *eventTrace) bool { return t.trace.Seq == seq })
+}
+
+// TraceMsgID is much like Client.Trace(), however returns the trace of the
+// most recent event with the given "msgid" tag.
+func (c *Client) TraceMsgID(msgid string) (trace EventTrace, ok bool) {
+	if c.traces == nil || msgid == "" {
+		return trace, false
+	}
+
+	return c.traces.find(func(t *eventTrace) bool { return t.trace.MsgID == msgid })
+}
+
+// Traces returns the handler execution traces of the most recent events,
+// oldest first. Tracing must be enabled, see Config.TraceHandlers.
+func (c *Client) Traces() []EventTrace {
+	if c.traces == nil {
+		return nil
+	}
+
+	c.traces.mu.RLock()
+	defer c.traces.mu.RUnlock()
+
+	var out []EventTrace
+	for i := 0; i < len(c.traces.traces); i++ {
+		if t := c.traces.traces[(c.traces.next+i)%len(c.traces.traces)]; t != nil {
+			out = append(out, t.copy())
+		}
+	}
+
+	return out
+}
+
+// Tracer traces the processing of events, from being read from the server
+// to being processed by their handlers (see Config.Tracer), e.g. to create
+// OpenTelemetry spans, so slow handlers can be found in production. Methods
+// are called from the goroutines of the client, so they must be safe for
+// concurrent use. For example:
+//
+//	type otelTracer struct{ tracer trace.Tracer }
+//
+//	func (t otelTracer) StartEvent(e *girc.Event, read time.Time) girc.EventSpan {
+//		_, span := t.tracer.Start(context.Background(), "irc "+e.Command, trace.WithTimestamp(read))
+//		return otelSpan{t.tracer, span}
+//	}
+//
+// See TraceFunc for simply receiving the timing of each event.
+type Tracer interface {
+	// StartEvent is called when event is dispatched to handlers. read is
+	// when it was read from the server (or emitted, for emulated events like
+	// CONNECTED), so the time since is how long it was queued. The returned
+	// span is ended once all handlers have processed the event.
+	StartEvent(event *Event, read time.Time) EventSpan
+}
+
+// EventSpan is the span of an event being dispatched, see Tracer.
+type EventSpan interface {
+	// Handler is called once a handler has processed the event (handlers
+	// with the same priority are executed concurrently).
+	Handler(h HandlerTrace)
+	// End is called once all handlers have processed the event. dropped is
+	// true if middleware (see Caller.Use()) didn't pass the event on to the
+	// external handlers.
+	End(dropped bool)
+}
+
+// EventTiming is the timing of an event being processed, see TraceFunc.
+type EventTiming struct {
+	// Event is the event which was dispatched.
+	Event *Event `json:"event"`
+	// Read is when the event was read from the server (or emitted, for
+	// emulated events), and Dispatched is when it was dispatched to
+	// handlers, after waiting to be processed.
+	Read       time.Time `json:"read"`
+	Dispatched time.Time `json:"dispatched"`
+	// Duration is how long it took for all handlers to process the event.
+	Duration time.Duration `json:"duration"`
+	// Dropped is true if middleware (see Caller.Use()) didn't pass the event
+	// on to the external handlers.
+	Dropped bool `json:"dropped"`
+	// Handlers are the handlers which processed the event.
+	Handlers []HandlerTrace `json:"handlers"`
+}
+
+// TraceFunc is a Tracer which calls the function with the timing of each
+// event, once it has been processed by all of its handlers, e.g. to log
+// slow handlers:
+//
+//	client.Config.Tracer = girc.TraceFunc(func(t girc.EventTiming) {
+//		for _, h := range t.Handlers {
+//			if h.Duration > time.Second {
+//				log.Printf("slow handler %s for %s: %s", h.CUID, t.Event.Command, h.Duration)
+//			}
+//		}
+//	})
+type TraceFunc func(t EventTiming)
+
+// StartEvent implements Tracer.
+func (f TraceFunc) StartEvent(event *Event, read time.Time) EventSpan {
+	return &funcSpan{fn: f, timing: EventTiming{Event: event.Copy(), Read: read, Dispatched: time.Now()}}
+}
+
+// funcSpan is the EventSpan of a TraceFunc.
+type funcSpan struct {
+	fn     TraceFunc
+	mu     sync.Mutex
+	timing EventTiming
+}
+
+// Handler implements EventSpan.
+func (s *funcSpan) Handler(h HandlerTrace) {
+	s.mu.Lock()
+	s.timing.Handlers = append(s.timing.Handlers, h)
+	s.mu.Unlock()
+}
+
+// End implements EventSpan.
+func (s *funcSpan) End(dropped bool) {
+	s.mu.Lock()
+	timing := s.timing
+	s.mu.Unlock()
+
+	timing.Duration = time.Since(timing.Dispatched)
+	timing.Dropped = dropped
+	s.fn(timing)
+}
+
+// traceHandler records the handler trace h, see Config.TraceHandlers and
+// Config.Tracer.
+func (e *Event) traceHandler(h HandlerTrace) {
+	if e.trace != nil {
+		e.trace.add(h)
+	}
+
+	if e.span != nil {
+		e.span.Handler(h)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// TruncatePolicy determines what happens to messages which are too long to
+// be relayed by the server in full, see Config.Truncate.
+type TruncatePolicy int
+
+const (
+	// TruncateNone sends messages as is, leaving it up to the server to
+	// truncate them, which may happen in the middle of a UTF-8 sequence.
+	TruncateNone TruncatePolicy = iota
+	// TruncateEllipsis cuts messages on a rune boundary, ending them with an
+	// ellipsis ("…").
+	TruncateEllipsis
+	// TruncateReject doesn't send messages which are too long, returning an
+	// ErrMessageTooLong instead (e.g. from Commands.Message()).
+	TruncateReject
+)
+
+// ellipsis is appended to messages truncated with TruncateEllipsis.
+const ellipsis = "…"
+
+// maxHostLength is the assumed length of our own host, if it is not known
+// yet.
+const maxHostLength = 63
+
+// ErrMessageTooLong is returned when a message is too long to be relayed by
+// the server in full, and Config.Truncate is TruncateReject.
+type ErrMessageTooLong struct {
+	// Target is the channel or user the message was sent to.
+	Target string
+	// Length is the length of the message, and Limit the maximum length,
+	// both in bytes.
+	Length int
+	Limit  int
+}
+
+func (e *ErrMessageTooLong) Error() string {
+	return fmt.Sprintf("message to %s too long: %d bytes, limit is %d", e.Target, e.Length, e.Limit)
+}
+
+// messageLimit returns the maximum length in bytes of the trailing text of
+// e, taking into account that the server prepends our own source (i.e.
+// ":nick!user@host ") when relaying it.
+func (c *Client) messageLimit(e *Event) int {
+	nick, user, host := c.Identity().Nick, c.Identity().User, ""
+	if !c.Config.disableTracking {
+		nick, user, host = c.GetNick(), c.GetIdent(), c.GetHost()
+	}
+
+	// Servers may prefix the ident with "~", and the host isn't known until
+	// we've joined a channel (or in some cases, at all).
+	prefix := len(nick) + len(user) + 5
+	if host == "" {
+		prefix += maxHostLength
+	} else {
+		prefix += len(host)
+	}
+
+	return maxLength - prefix - (&Event{Command: e.Command, Params: e.Params, EmptyTrailing: true}).Len()
+}
+
+// truncate applies Config.Truncate to e, if it's a PRIVMSG or NOTICE which
+// is too long to be relayed in full.
+func (c *Client) truncate(e *Event) error {
+	if c.Config.Truncate == TruncateNone || (e.Command != PRIVMSG && e.Command != NOTICE) || len(e.Params) == 0 {
+		return nil
+	}
+
+	limit := c.messageLimit(e)
+	if len(e.Trailing) <= limit {
+		return nil
+	}
+
+	if c.Config.Truncate == TruncateReject {
+		return &ErrMessageTooLong{Target: e.Params[0], Length: len(e.Trailing), Limit: limit}
+	}
+
+	// Keep CTCP messages (e.g. ACTION) intact.
+	var suffix string
+	text := e.Trailing
+	if len(text) > 1 && text[0] == ctcpDelim && text[len(text)-1] == ctcpDelim {
+		text, suffix = text[:len(text)-1], string(ctcpDelim)
+	}
+
+	room := limit - len(suffix)
+	if room > len(ellipsis) {
+		e.Trailing = cutRunes(text, room-len(ellipsis)) + ellipsis + suffix
+	} else {
+		e.Trailing = cutRunes(text, room) + suffix
+	}
+
+	return nil
+}
+
+// cutRunes cuts s to at most n bytes, without splitting a UTF-8 sequence.
+func cutRunes(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+
+	if n >= len(s) {
+		return s
+	}
+
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+
+	return s[:n]
+}
@@ -0,0 +1,104 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"net/url"
+	"strings"
+)
+
+// ExtractURLs returns the http(s) URLs found in text, in the order they
+// appear. IRC formatting codes (bold, colors, etc) are removed first, as
+// well as punctuation surrounding the URL (e.g. "(see http://x.org/a.)"
+// results in "http://x.org/a"). URLs without a scheme which start with
+// "www." are returned with an "http://" prefix.
+func ExtractURLs(text string) (urls []string) {
+	for _, word := range strings.Fields(stripFormatting(text)) {
+		word = trimURL(word)
+
+		lower := strings.ToLower(word)
+		if strings.HasPrefix(lower, "www.") {
+			word = "http://" + word
+		} else if !strings.HasPrefix(lower, "http://") && !strings.HasPrefix(lower, "https://") {
+			continue
+		}
+
+		u, err := url.Parse(word)
+		if err != nil || u.Host == "" {
+			continue
+		}
+
+		urls = append(urls, word)
+	}
+
+	return urls
+}
+
+// trimURL removes punctuation surrounding a URL which is likely part of the
+// sentence the URL is in, rather than the URL itself.
+func trimURL(word string) string {
+	// Anything before the scheme (e.g. "<", "(", or quotes).
+	if i := strings.Index(strings.ToLower(word), "http"); i > 0 {
+		word = word[i:]
+	} else if i := strings.Index(strings.ToLower(word), "www."); i > 0 {
+		word = word[i:]
+	}
+
+	for len(word) > 0 {
+		last := word[len(word)-1]
+
+		switch last {
+		case '.', ',', ';', ':', '!', '?', '\'', '"', '>':
+			word = word[:len(word)-1]
+			continue
+		case ')', ']', '}':
+			// Only trim closing brackets which are unbalanced, so URLs like
+			// wikipedia's "Foo_(bar)" are kept intact.
+			open := "("
+			if last == ']' {
+				open = "["
+			} else if last == '}' {
+				open = "{"
+			}
+
+			if strings.Count(word, open) < strings.Count(word, string(last)) {
+				word = word[:len(word)-1]
+				continue
+			}
+		}
+
+		break
+	}
+
+	return word
+}
+
+// stripFormatting removes all IRC formatting codes from text (see
+// StripFormat()), and CTCP delimiters.
+func stripFormatting(text string) string {
+	return strings.Replace(StripFormat(text), string(ctcpDelim), "", -1)
+}
+
+// handleURLs emits URL_SEEN events for PRIVMSGs which contain URLs. See
+// Config.ExtractURLs.
+func handleURLs(c *Client, e Event) {
+	if !c.Config.ExtractURLs || e.Source == nil || len(e.Params) == 0 {
+		return
+	}
+
+	urls := ExtractURLs(e.Trailing)
+	if len(urls) == 0 {
+		return
+	}
+
+	c.RunHandlers(&Event{
+		Source:   e.Source,
+		Tags:     e.Tags,
+		Command:  URL_SEEN,
+		Params:   append([]string{e.Params[0]}, urls...),
+		Trailing: stripFormatting(e.Trailing),
+		Replayed: e.Replayed,
+	})
+}
@@ -0,0 +1,142 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// WHOX query tokens which are reserved: whoxTrackingToken is used for the
+// builtin state tracking, and whoxWhoToken by Commands.Who(). Tokens
+// returned by Client.WhoxToken() never collide with these.
+const (
+	whoxTrackingToken = "1"
+	whoxWhoToken      = "2"
+)
+
+// whoxFields are the WHOX fields requested by Commands.WhoWait(). Note that
+// the server always responds with fields in the order defined by WHOX,
+// regardless of the order requested.
+const whoxFields = "%tcuhsnfar"
+
+// WhoReply is a single reply to a WHO query, see Commands.WhoWait().
+type WhoReply struct {
+	// Channel is the channel the reply is for, or "*" if the user isn't in
+	// a channel which is visible to us.
+	Channel string `json:"channel"`
+	// Nick is the nickname of the user.
+	Nick string `json:"nick"`
+	// Ident is the ident/username of the user.
+	Ident string `json:"ident"`
+	// Host is the visible host of the user.
+	Host string `json:"host"`
+	// Server is the server which the user is connected to.
+	Server string `json:"server"`
+	// Flags are the WHO flags of the user, e.g. "H" (here) or "G" (gone,
+	// i.e. away), followed by "*" if they are an IRC operator, and their
+	// channel prefixes (e.g. "@").
+	Flags string `json:"flags"`
+	// Account is the services account which the user is logged into, if
+	// any. This is always empty if the server doesn't support WHOX.
+	Account string `json:"account"`
+	// Name is the "realname" of the user.
+	Name string `json:"name"`
+}
+
+// Away returns true if the user is marked as away.
+func (w WhoReply) Away() bool {
+	return strings.HasPrefix(w.Flags, "G")
+}
+
+// WhoxToken returns a new WHOX query token (a number between 3 and 999),
+// which can be used for your own WHOX queries, so the replies can be told
+// apart from those of other queries (including girc's builtin tracking).
+// Tokens are reused after 997 calls.
+func (c *Client) WhoxToken() string {
+	n := atomic.AddUint32(&c.whoxToken, 1)
+	return strconv.Itoa(3 + int((n-1)%997))
+}
+
+// WhoWait sends a WHOX query for target (a channel, nick, or mask), and
+// waits for the server to respond (or ctx to be cancelled), returning the
+// replies. Replies are matched by their WHOX token (see Client.WhoxToken()),
+// so they can't be confused with those of other queries for the same target
+// (e.g. the builtin tracking). Servers which don't support WHOX respond with
+// regular WHO replies, in which case the account is unknown, and replies to
+// other queries for the same target are merged. This should not be called
+// from non-background handlers, as the response can't be processed until
+// they return.
+func (cmd *Commands) WhoWait(ctx context.Context, target string) ([]WhoReply, error) {
+	if !IsValidNick(target) && !IsValidChannel(target) && !IsValidUser(target) {
+		return nil, &ErrInvalidTarget{Target: target}
+	}
+
+	token := cmd.c.WhoxToken()
+
+	// RPL_ENDOFWHO doesn't include the token, so it can't be told apart from
+	// the end of other queries for the same target. The end of the replies is
+	// marked by a PING instead, see Commands.doFenced().
+	events, err := cmd.doFenced(ctx, &Event{Command: WHO, Params: []string{target, whoxFields + "," + token}}, nil,
+		func(e *Event) bool {
+			switch e.Command {
+			case RPL_WHOSPCRPL:
+				// me, token, channel, ident, host, server, nick, flags, account.
+				return len(e.Params) == 9 && e.Params[1] == token
+			case RPL_WHOREPLY:
+				// me, channel, ident, host, server, nick, flags, :hops realname.
+				// Regular WHO replies don't have a token, so at least ignore
+				// replies for other channels.
+				return len(e.Params) >= 7 && (!IsValidChannel(target) || cmd.c.Equal(e.Params[1], target))
+			}
+
+			return false
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var replies []WhoReply
+	seen := make(map[string]bool)
+	for _, e := range events {
+		switch e.Command {
+		case RPL_WHOSPCRPL:
+			reply := WhoReply{
+				Channel: e.Params[2], Ident: e.Params[3], Host: e.Params[4],
+				Server: e.Params[5], Nick: e.Params[6], Flags: e.Params[7],
+				Name: e.Trailing,
+			}
+
+			if e.Params[8] != "0" {
+				reply.Account = e.Params[8]
+			}
+
+			replies = append(replies, reply)
+		case RPL_WHOREPLY:
+			// Replies to other queries for the same target are duplicates.
+			key := cmd.c.fold(e.Params[1]) + " " + cmd.c.fold(e.Params[5])
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			reply := WhoReply{
+				Channel: e.Params[1], Ident: e.Params[2], Host: e.Params[3],
+				Server: e.Params[4], Nick: e.Params[5], Flags: e.Params[6],
+			}
+
+			if i := strings.IndexByte(e.Trailing, ' '); i > -1 {
+				reply.Name = e.Trailing[i+1:]
+			}
+
+			replies = append(replies, reply)
+		}
+	}
+
+	return replies, nil
+}
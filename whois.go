@@ -0,0 +1,124 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Whois is the response to a WHOIS query, see Commands.WhoisWait().
+type Whois struct {
+	// Nick is the nickname of the user.
+	Nick string `json:"nick"`
+	// Ident is the ident/username of the user.
+	Ident string `json:"ident"`
+	// Host is the visible host of the user.
+	Host string `json:"host"`
+	// Name is the "realname" of the user.
+	Name string `json:"name"`
+	// Server is the server which the user is connected to.
+	Server string `json:"server"`
+	// ServerInfo is the description of Server.
+	ServerInfo string `json:"server_info"`
+	// Channels are the channels which the user is in (and which are
+	// visible to us), including any prefixes (e.g. "@#channel") the server
+	// sent.
+	Channels []string `json:"channels"`
+	// Account is the services account which the user is logged into, if
+	// any.
+	Account string `json:"account"`
+	// Away is the away message of the user, if they are away.
+	Away string `json:"away"`
+	// Idle is how long the user has been idle, if the server supplied it.
+	Idle time.Duration `json:"idle"`
+	// SignOn is when the user connected, if the server supplied it.
+	SignOn time.Time `json:"sign_on"`
+	// Secure is true if the user is using a secure (TLS) connection.
+	Secure bool `json:"secure"`
+	// Oper is true if the user is an IRC operator.
+	Oper bool `json:"oper"`
+}
+
+// WhoisWait sends a WHOIS query for nick, and waits for the server to
+// respond (or ctx to be cancelled), returning the collected replies. If nick
+// doesn't exist, an ErrEvent is returned (usually with ERR_NOSUCHNICK). This should
+// not be called from non-background handlers, as the response can't be
+// processed until they return.
+func (cmd *Commands) WhoisWait(ctx context.Context, nick string) (*Whois, error) {
+	if !IsValidNick(nick) {
+		return nil, &ErrInvalidTarget{Target: nick}
+	}
+
+	// All replies have the nickname they're about as the second param.
+	forNick := func(e *Event) bool {
+		return len(e.Params) > 1 && cmd.c.Equal(e.Params[1], nick)
+	}
+
+	events, err := cmd.c.Do(ctx, &Event{Command: WHOIS, Params: []string{nick}},
+		func(e *Event) bool {
+			return (e.Command == ERR_NOSUCHNICK || e.Command == RPL_ENDOFWHOIS) && forNick(e)
+		},
+		forNick,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	whois := &Whois{Nick: nick}
+	var found bool
+
+	for _, e := range events {
+		switch e.Command {
+		case RPL_WHOISUSER:
+			found = true
+			whois.Nick = e.Params[1]
+			if len(e.Params) > 3 {
+				whois.Ident = e.Params[2]
+				whois.Host = e.Params[3]
+			}
+			whois.Name = e.Trailing
+		case RPL_WHOISSERVER:
+			if len(e.Params) > 2 {
+				whois.Server = e.Params[2]
+			}
+			whois.ServerInfo = e.Trailing
+		case RPL_WHOISOPERATOR:
+			whois.Oper = true
+		case RPL_WHOISIDLE:
+			if len(e.Params) > 2 {
+				if idle, err := strconv.ParseInt(e.Params[2], 10, 64); err == nil {
+					whois.Idle = time.Duration(idle) * time.Second
+				}
+			}
+
+			if len(e.Params) > 3 {
+				if ts, err := strconv.ParseInt(e.Params[3], 10, 64); err == nil {
+					whois.SignOn = time.Unix(ts, 0)
+				}
+			}
+		case RPL_WHOISCHANNELS:
+			whois.Channels = append(whois.Channels, strings.Fields(e.Trailing)...)
+		case RPL_WHOISACCOUNT:
+			if len(e.Params) > 2 {
+				whois.Account = e.Params[2]
+			}
+		case RPL_WHOISSECURE:
+			whois.Secure = true
+		case RPL_AWAY:
+			whois.Away = e.Trailing
+		case ERR_NOSUCHNICK:
+			return nil, &ErrEvent{Event: e}
+		case RPL_ENDOFWHOIS:
+			if !found {
+				return nil, &ErrEvent{Event: e}
+			}
+		}
+	}
+
+	return whois, nil
+}
@@ -0,0 +1,68 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"strconv"
+	"time"
+)
+
+// IsFromSelf checks to see if a PRIVMSG or NOTICE was sent by us. With
+// znc.in/self-message, this is the case for messages sent by other clients
+// attached to the same bouncer user. In that case, the first param is the
+// channel or user which the message was sent to, rather than the recipient
+// being us.
+func (c *Client) IsFromSelf(e Event) bool {
+	if e.Source == nil || (e.Command != PRIVMSG && e.Command != NOTICE) {
+		return false
+	}
+
+	return c.Equal(e.Source.Name, c.GetNick())
+}
+
+// Timestamp returns the time the event was originally sent by the server,
+// using the server-time "time" tag. This is useful for history which has
+// been replayed, e.g. via Commands.Playback(). ok is false if the tag is
+// missing or invalid.
+func (e *Event) Timestamp() (t time.Time, ok bool) {
+	ts, ok := e.Tags.Get("time")
+	if !ok {
+		return t, false
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return t, false
+	}
+
+	return t, true
+}
+
+// Playback requests history from ZNC's *playback module for buffer (a
+// channel or query name, or "*" for all buffers), which was sent after from,
+// and optionally before to. If from is zero, all history is requested. If to
+// is zero, there is no upper bound.
+//
+// When reattaching to the bouncer, use the Event.Timestamp() of the last
+// event that was seen as from, to only retrieve what was missed. Replayed
+// events are marked with Event.Replayed when the server supports batches.
+func (cmd *Commands) Playback(buffer string, from, to time.Time) {
+	params := "PLAY " + buffer + " " + zncTimestamp(from)
+	if !to.IsZero() {
+		params += " " + zncTimestamp(to)
+	}
+
+	cmd.c.Send(&Event{Command: PRIVMSG, Params: []string{"*playback"}, Trailing: params})
+}
+
+// zncTimestamp converts t into the fractional unix timestamp format used by
+// the ZNC *playback module.
+func zncTimestamp(t time.Time) string {
+	if t.IsZero() {
+		return "0"
+	}
+
+	return strconv.FormatFloat(float64(t.UnixNano())/float64(time.Second), 'f', 3, 64)
+}